@@ -0,0 +1,422 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"io/ioutil"
+	"net/http"
+	"numerisTask/models"
+	"numerisTask/render"
+	"strings"
+	"time"
+)
+
+type AccountMappingPayload struct {
+	SalesAccount      string `json:"sales_account" validate:"required"`
+	LateFeeAccount    string `json:"late_fee_account" validate:"required"`
+	DiscountAccount   string `json:"discount_account" validate:"required"`
+	ReceivableAccount string `json:"receivable_account" validate:"required"`
+	BankAccount       string `json:"bank_account" validate:"required"`
+	TaxAccount        string `json:"tax_account" validate:"required"`
+	TaxType           string `json:"tax_type" validate:"required"`
+	BadDebtAccount    string `json:"bad_debt_account" validate:"required"`
+}
+
+type AccountingExportPayload struct {
+	Target          string   `json:"target" validate:"required,oneof=quickbooks xero"`
+	Format          string   `json:"format,omitempty" validate:"omitempty,oneof=iif csv"`
+	Include         []string `json:"include,omitempty" validate:"dive,oneof=invoices customers payments credit_notes write_offs"`
+	From            string   `json:"from,omitempty" validate:"omitempty,datetime=2006-01-02"`
+	To              string   `json:"to,omitempty" validate:"omitempty,datetime=2006-01-02"`
+	IncludeExported bool     `json:"include_exported,omitempty"` // Export again items that already went out
+	DryRun          bool     `json:"dry_run,omitempty"`          // Only preview, nothing is marked as exported
+}
+
+// Invoices that went out to the customer, drafts and canceled ones are not booked
+var accountingStatuses = []models.Status{
+	models.CREATED, models.SENT, models.OVERDUE, models.PARTIALPAYMENT, models.FULLPAYMENT, models.CREDITED,
+	models.WRITTENOFF,
+}
+
+// accountingTarget reads the {target} of the url, quickbooks or xero
+func accountingTarget(writer http.ResponseWriter, request *http.Request) (models.AccountingTarget, bool) {
+	target := models.AccountingTarget(strings.ToUpper(chi.URLParam(request, "target")))
+	if target != models.QUICKBOOKS && target != models.XERO {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "target must be quickbooks or xero"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusNotFound)
+		writer.Write(jsonResponse)
+		return "", false
+	}
+	return target, true
+}
+
+// GET ACCOUNT MAPPING of the organization for quickbooks or xero, the software's default accounts until saved
+func GetAccountMapping(writer http.ResponseWriter, request *http.Request) {
+	target, ok := accountingTarget(writer, request)
+	if !ok {
+		return
+	}
+	mapping, err := models.GetAccountMapping(models.PlaceHolderUser.ID, target)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "account mapping could not be fetched"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	mappingJson, _ := json.Marshal(mapping)
+	writer.Write(mappingJson)
+}
+
+// SAVE ACCOUNT MAPPING, the accounts exported amounts are booked to: account names for QuickBooks, codes for Xero
+func SaveAccountMapping(writer http.ResponseWriter, request *http.Request) {
+	target, ok := accountingTarget(writer, request)
+	if !ok {
+		return
+	}
+
+	body, _ := ioutil.ReadAll(request.Body)
+	var payload AccountMappingPayload
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "account mapping body not valid"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return
+	}
+	//validating the playload
+	validate := validator.New()
+	err = validate.Struct(payload)
+	if err != nil {
+		validationError := err.(validator.ValidationErrors)
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": validationError.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	mapping, err := models.GetAccountMapping(models.PlaceHolderUser.ID, target)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "account mapping could not be fetched"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	mapping.SalesAccount = strings.TrimSpace(payload.SalesAccount)
+	mapping.LateFeeAccount = strings.TrimSpace(payload.LateFeeAccount)
+	mapping.DiscountAccount = strings.TrimSpace(payload.DiscountAccount)
+	mapping.ReceivableAccount = strings.TrimSpace(payload.ReceivableAccount)
+	mapping.BankAccount = strings.TrimSpace(payload.BankAccount)
+	mapping.TaxAccount = strings.TrimSpace(payload.TaxAccount)
+	mapping.TaxType = strings.TrimSpace(payload.TaxType)
+	mapping.BadDebtAccount = strings.TrimSpace(payload.BadDebtAccount)
+	if err = models.SaveAccountMapping(mapping); err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "account mapping could not be saved"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	mappingJson, _ := json.Marshal(mapping)
+	writer.Write(mappingJson)
+}
+
+// EXPORT TO ACCOUNTING SOFTWARE: QuickBooks (IIF for Desktop, CSV for Online) or Xero (CSV).
+// Invoices issued, payments and reversals, credit notes and write-offs made between from and to (inclusive,
+// everything by default), and the customers behind them. Items exported before are left out unless
+// include_exported, so nothing is imported twice. Several files come zipped together, the file is kept with
+// the export so it can be downloaded again.
+func ExportToAccounting(writer http.ResponseWriter, request *http.Request) {
+	body, _ := ioutil.ReadAll(request.Body)
+	var payload AccountingExportPayload
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "accounting export body not valid"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return
+	}
+	//validating the playload
+	validate := validator.New()
+	err = validate.Struct(payload)
+	if err != nil {
+		validationError := err.(validator.ValidationErrors)
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": validationError.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	target := models.AccountingTarget(strings.ToUpper(payload.Target))
+	format := payload.Format
+	if format == "" {
+		format = render.AccountingCSV
+	}
+	if target == models.XERO && format != render.AccountingCSV {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "xero only imports csv"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+	var from, to *time.Time
+	if payload.From != "" {
+		parsed, _ := time.Parse("2006-01-02", payload.From)
+		from = &parsed
+	}
+	if payload.To != "" {
+		parsed, _ := time.Parse("2006-01-02", payload.To)
+		to = &parsed
+	}
+	if from != nil && to != nil && to.Before(*from) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "to can not be before from"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+	include := payload.Include
+	if len(include) == 0 {
+		include = []string{"invoices", "customers", "payments", "credit_notes", "write_offs"}
+	}
+
+	mapping, err := models.GetAccountMapping(models.PlaceHolderUser.ID, target)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "account mapping could not be fetched"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	batch := render.AccountingBatch{Mapping: mapping, Currency: models.InvoiceCurrency()}
+	for _, table := range include {
+		switch table {
+		case "invoices":
+			batch.IncludeInvoices = true
+		case "customers":
+			batch.IncludeCustomers = true
+		case "payments":
+			batch.IncludePayments = true
+		case "credit_notes":
+			batch.IncludeCreditNotes = true
+		case "write_offs":
+			batch.IncludeWriteOffs = true
+		}
+	}
+	exported := map[models.ExportedItemType]map[string]bool{}
+	itemTypes := []models.ExportedItemType{
+		models.EXPORTEDINVOICE, models.EXPORTEDCUSTOMER, models.EXPORTEDPAYMENT, models.EXPORTEDREVERSAL,
+		models.EXPORTEDCREDITNOTE, models.EXPORTEDWRITEOFF,
+	}
+	for _, itemType := range itemTypes {
+		exported[itemType] = map[string]bool{}
+		if payload.IncludeExported {
+			continue
+		}
+		if exported[itemType], err = models.ExportedItemKeys(target, itemType); err != nil {
+			jsonResponse, _ := json.Marshal(map[string]string{"detail": "exported items could not be fetched"})
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusInternalServerError)
+			writer.Write(jsonResponse)
+			return
+		}
+	}
+	// Reversals only go out for payments exported before they were reversed
+	paymentsExportedAt, err := models.ExportedItemTimes(target, models.EXPORTEDPAYMENT)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "exported items could not be fetched"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	// Payments made in the range can be of invoices issued before it, every issued invoice is looked at
+	var items []models.ExportedItem
+	seenCustomers := map[string]bool{}
+	addCustomer := func(invoice *models.Invoice) {
+		var customer models.CustomerInfo
+		_ = json.Unmarshal(invoice.CustomerInfo, &customer)
+		key := models.CustomerKey(customer)
+		if !batch.IncludeCustomers || key == "" || seenCustomers[key] || exported[models.EXPORTEDCUSTOMER][key] {
+			return
+		}
+		seenCustomers[key] = true
+		batch.Customers = append(batch.Customers, customer)
+		items = append(items, models.ExportedItem{ItemType: models.EXPORTEDCUSTOMER, ItemKey: key})
+	}
+	params := models.InvoiceQueryParams{Limit: -1, Statuses: accountingStatuses}
+	err = models.EachAccountingInvoice(params, func(invoice *models.Invoice, creditNotes []models.CreditNote) error {
+		if models.InDateRange(invoice.IssueDate(), from, to) && batch.IncludeInvoices && !exported[models.EXPORTEDINVOICE][invoice.InvoiceID.String()] {
+			batch.Invoices = append(batch.Invoices, invoice)
+			items = append(items, models.ExportedItem{ItemType: models.EXPORTEDINVOICE, ItemKey: invoice.InvoiceID.String()})
+			addCustomer(invoice)
+		}
+		if batch.IncludePayments {
+			// A payment going out now goes less its reversals, they are not exported on their own
+			inBatch := map[string]bool{}
+			for _, payment := range models.AccountingPayments(invoice, from, to) {
+				if exported[models.EXPORTEDPAYMENT][payment.Key] {
+					continue
+				}
+				inBatch[payment.Key] = true
+				batch.Payments = append(batch.Payments, payment)
+				items = append(items, models.ExportedItem{ItemType: models.EXPORTEDPAYMENT, ItemKey: payment.Key})
+				addCustomer(invoice)
+			}
+			for _, reversal := range models.AccountingReversals(invoice, from, to, paymentsExportedAt) {
+				if exported[models.EXPORTEDREVERSAL][reversal.Key] || inBatch[reversal.Payment.ReversalOf.String()] {
+					continue
+				}
+				batch.Reversals = append(batch.Reversals, reversal)
+				items = append(items, models.ExportedItem{ItemType: models.EXPORTEDREVERSAL, ItemKey: reversal.Key})
+				addCustomer(invoice)
+			}
+		}
+		if batch.IncludeCreditNotes {
+			for _, credit := range models.AccountingCreditNotes(invoice, creditNotes, from, to) {
+				if exported[models.EXPORTEDCREDITNOTE][credit.Key] {
+					continue
+				}
+				batch.CreditNotes = append(batch.CreditNotes, credit)
+				items = append(items, models.ExportedItem{ItemType: models.EXPORTEDCREDITNOTE, ItemKey: credit.Key})
+				addCustomer(invoice)
+			}
+		}
+		if batch.IncludeWriteOffs {
+			writeOff := models.AccountingWriteOffOf(invoice, from, to)
+			if writeOff != nil && !exported[models.EXPORTEDWRITEOFF][writeOff.Key] {
+				batch.WriteOffs = append(batch.WriteOffs, *writeOff)
+				items = append(items, models.ExportedItem{ItemType: models.EXPORTEDWRITEOFF, ItemKey: writeOff.Key})
+				addCustomer(invoice)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "invoices could not be exported"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	var files []render.AccountingFile
+	switch {
+	case target == models.XERO:
+		files = render.XeroCSV(batch)
+	case format == render.AccountingIIF:
+		files = render.QuickBooksIIF(batch)
+	default:
+		files = render.QuickBooksCSV(batch)
+	}
+	now := time.Now()
+	data, fileName, contentType, err := render.AccountingArchive(target, files, now)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "accounting export could not be written"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	if !payload.DryRun {
+		export := models.AccountingExport{
+			ExportID:       uuid.New(),
+			Target:         target,
+			Format:         format,
+			From:           from,
+			To:             to,
+			InvoiceCount:   len(batch.Invoices),
+			CustomerCount:  len(batch.Customers),
+			PaymentCount:   len(batch.Payments),
+			ReversalCount:  len(batch.Reversals),
+			CreditCount:    len(batch.CreditNotes),
+			WriteOffCount:  len(batch.WriteOffs),
+			ExportedBy:     1, // Hard coded for proof of work
+			ExportedAgain:  payload.IncludeExported,
+			IncludedTables: strings.Join(include, ","),
+			FileName:       fileName,
+			ContentType:    contentType,
+			Data:           data,
+		}
+		if err = models.RecordAccountingExport(&export, items); err != nil {
+			jsonResponse, _ := json.Marshal(map[string]string{"detail": "accounting export could not be recorded"})
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusInternalServerError)
+			writer.Write(jsonResponse)
+			return
+		}
+		writer.Header().Set("X-Export-Id", export.ExportID.String())
+	}
+	writer.Header().Set("Content-Type", contentType)
+	writer.Header().Set("Content-Disposition", `attachment; filename="`+fileName+`"`)
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(data)
+}
+
+// DOWNLOAD ACCOUNTING EXPORT, the file of an export made before, as it went out
+func DownloadAccountingExport(writer http.ResponseWriter, request *http.Request) {
+	exportIdParam := chi.URLParam(request, "exportId")
+	if _, err := uuid.Parse(exportIdParam); err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "exportId is not a valid uuid"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return
+	}
+	export, err := models.GetAccountingExport(exportIdParam)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && len(export.Data) == 0) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "accounting export not found"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusNotFound)
+		writer.Write(jsonResponse)
+		return
+	}
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "accounting export could not be fetched"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.Header().Set("X-Export-Id", export.ExportID.String())
+	writer.Header().Set("Content-Type", export.ContentType)
+	writer.Header().Set("Content-Disposition", `attachment; filename="`+export.FileName+`"`)
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(export.Data)
+}
+
+// GET ACCOUNTING EXPORTS made so far, newest first
+func GetAccountingExports(writer http.ResponseWriter, request *http.Request) {
+	params, ok := parsePagination(writer, request)
+	if !ok {
+		return
+	}
+	exports, err := models.GetAccountingExports(params)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "accounting exports could not be listed"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	exportsJson, _ := json.Marshal(exports)
+	writer.Write(exportsJson)
+}
@@ -0,0 +1,234 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"io/ioutil"
+	"net/http"
+	"numerisTask/events"
+	"numerisTask/models"
+	"strings"
+)
+
+// An approval rule needs a minimum amount, a customer or both
+type ApprovalRulePayload struct {
+	Name          string  `json:"name" validate:"required"`
+	MinAmount     float64 `json:"min_amount,omitempty" validate:"required_without=CustomerEmail,gte=0"`
+	CustomerEmail string  `json:"customer_email,omitempty" validate:"omitempty,email"`
+	Active        *bool   `json:"active,omitempty"`
+}
+
+type ApprovalDecisionPayload struct {
+	Comment string `json:"comment"`
+}
+
+// GET APPROVAL RULES of the organization
+func GetApprovalRules(writer http.ResponseWriter, request *http.Request) {
+	rules, err := models.GetApprovalRules(models.PlaceHolderUser.ID)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "approval rules could not be listed"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	rulesJson, _ := json.Marshal(rules)
+	writer.Write(rulesJson)
+}
+
+// CREATE APPROVAL RULE, applies to invoices issued from now on and to invoices sent later
+func CreateApprovalRule(writer http.ResponseWriter, request *http.Request) {
+	rule := &models.ApprovalRule{RuleID: uuid.New(), OrganizationID: models.PlaceHolderUser.ID}
+	saveApprovalRule(writer, request, rule, http.StatusCreated)
+}
+
+// UPDATE APPROVAL RULE
+func UpdateApprovalRule(writer http.ResponseWriter, request *http.Request) {
+	rule, ok := findApprovalRule(writer, request)
+	if !ok {
+		return
+	}
+	saveApprovalRule(writer, request, rule, http.StatusOK)
+}
+
+// DELETE APPROVAL RULE, invoices already waiting for approval keep waiting
+func DeleteApprovalRule(writer http.ResponseWriter, request *http.Request) {
+	rule, ok := findApprovalRule(writer, request)
+	if !ok {
+		return
+	}
+	err := models.DeleteApprovalRule(rule)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "approval rule could not be deleted"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// APPROVE INVOICE, it can be sent afterwards
+func ApproveInvoice(writer http.ResponseWriter, request *http.Request) {
+	invoice, ok := findInvoice(writer, request)
+	if !ok {
+		return
+	}
+	payload, ok := approvalDecision(writer, request)
+	if !ok {
+		return
+	}
+
+	// Placeholder user standing in for the manager
+	invoice, previousStatus, err := models.ApproveInvoice(invoice.InvoiceID.String(), models.PlaceHolderUser.ID, payload.Comment)
+	if errors.Is(err, models.ErrInvalidTransition) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": err.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusConflict)
+		writer.Write(jsonResponse)
+		return
+	}
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "invoice could not be approved"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	if invoice.Status == models.OVERDUE {
+		events.Publish(models.OverdueEvent(invoice, previousStatus))
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	invoiceJson, _ := json.Marshal(invoice)
+	writer.Write(invoiceJson)
+}
+
+// REJECT INVOICE, it goes back to draft with the comment explaining what to change
+func RejectInvoice(writer http.ResponseWriter, request *http.Request) {
+	invoice, ok := findInvoice(writer, request)
+	if !ok {
+		return
+	}
+	payload, ok := approvalDecision(writer, request)
+	if !ok {
+		return
+	}
+	if strings.TrimSpace(payload.Comment) == "" {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "a comment is required to reject an invoice"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	invoice, err := models.RejectInvoice(invoice.InvoiceID.String(), payload.Comment)
+	if errors.Is(err, models.ErrInvalidTransition) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": err.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusConflict)
+		writer.Write(jsonResponse)
+		return
+	}
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "invoice could not be rejected"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	invoiceJson, _ := json.Marshal(invoice)
+	writer.Write(invoiceJson)
+}
+
+// approvalDecision reads the optional comment of an approval or rejection
+func approvalDecision(writer http.ResponseWriter, request *http.Request) (ApprovalDecisionPayload, bool) {
+	var payload ApprovalDecisionPayload
+	body, _ := ioutil.ReadAll(request.Body)
+	if len(body) > 0 {
+		err := json.Unmarshal(body, &payload)
+		if err != nil {
+			jsonResponse, _ := json.Marshal(map[string]string{"detail": "approval body not valid"})
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusUnprocessableEntity)
+			writer.Write(jsonResponse)
+			return payload, false
+		}
+	}
+	return payload, true
+}
+
+func saveApprovalRule(writer http.ResponseWriter, request *http.Request, rule *models.ApprovalRule, status int) {
+	body, _ := ioutil.ReadAll(request.Body)
+	var payload ApprovalRulePayload
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "approval rule body not valid"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return
+	}
+	//validating the playload
+	validate := validator.New()
+	err = validate.Struct(payload)
+	if err != nil {
+		validationError := err.(validator.ValidationErrors)
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": validationError.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	rule.Name = payload.Name
+	rule.MinAmount = payload.MinAmount
+	rule.CustomerEmail = strings.ToLower(strings.TrimSpace(payload.CustomerEmail))
+	rule.Active = true
+	if payload.Active != nil {
+		rule.Active = *payload.Active
+	}
+	err = models.SaveApprovalRule(rule)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "approval rule save error"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	ruleJson, _ := json.Marshal(rule)
+	writer.Write(ruleJson)
+}
+
+func findApprovalRule(writer http.ResponseWriter, request *http.Request) (*models.ApprovalRule, bool) {
+	ruleIdParam := chi.URLParam(request, "ruleId")
+	_, err := uuid.Parse(ruleIdParam)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "ruleId is not a valid uuid"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return nil, false
+	}
+
+	rule, err := models.GetApprovalRuleByID(ruleIdParam)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "approval rule not found"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusNotFound)
+		writer.Write(jsonResponse)
+		return nil, false
+	}
+	return rule, true
+}
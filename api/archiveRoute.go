@@ -0,0 +1,199 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"io/ioutil"
+	"net/http"
+	"numerisTask/models"
+)
+
+type VoidInvoicePayload struct {
+	Reason string `json:"reason" validate:"required"`
+}
+
+type WriteOffInvoicePayload struct {
+	Reason string `json:"reason" validate:"required"`
+}
+
+// VOID INVOICE, cancels an issued invoice that has no payments
+func VoidInvoice(writer http.ResponseWriter, request *http.Request) {
+	invoice, ok := findInvoice(writer, request)
+	if !ok {
+		return
+	}
+
+	body, _ := ioutil.ReadAll(request.Body)
+	var payload VoidInvoicePayload
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "void body not valid"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return
+	}
+	//validating the playload
+	validate := validator.New()
+	err = validate.Struct(payload)
+	if err != nil {
+		validationError := err.(validator.ValidationErrors)
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": validationError.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	invoice, err = models.VoidInvoice(invoice.InvoiceID.String(), payload.Reason)
+	if errors.Is(err, models.ErrVoidWithPayments) || errors.Is(err, models.ErrInvalidTransition) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": err.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusConflict)
+		writer.Write(jsonResponse)
+		return
+	}
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "invoice could not be voided"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	invoiceJson, _ := json.Marshal(invoice)
+	writer.Write(invoiceJson)
+}
+
+// WRITE OFF INVOICE, gives up on the unpaid balance of an issued invoice as a bad debt
+func WriteOffInvoice(writer http.ResponseWriter, request *http.Request) {
+	invoice, ok := findInvoice(writer, request)
+	if !ok {
+		return
+	}
+
+	body, _ := ioutil.ReadAll(request.Body)
+	var payload WriteOffInvoicePayload
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "write-off body not valid"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return
+	}
+	//validating the playload
+	validate := validator.New()
+	err = validate.Struct(payload)
+	if err != nil {
+		validationError := err.(validator.ValidationErrors)
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": validationError.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	invoice, err = models.WriteOffInvoice(invoice.InvoiceID.String(), payload.Reason)
+	if errors.Is(err, models.ErrInvalidTransition) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": err.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusConflict)
+		writer.Write(jsonResponse)
+		return
+	}
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "invoice could not be written off"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	invoiceJson, _ := json.Marshal(invoice)
+	writer.Write(invoiceJson)
+}
+
+// DELETE INVOICE, drafts only. Deleted invoices go to the archive
+func DeleteInvoice(writer http.ResponseWriter, request *http.Request) {
+	invoice, ok := findInvoice(writer, request)
+	if !ok {
+		return
+	}
+	err := models.DeleteDraftInvoice(invoice.InvoiceID.String())
+	if errors.Is(err, models.ErrNotDraft) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": err.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusConflict)
+		writer.Write(jsonResponse)
+		return
+	}
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "invoice could not be deleted"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// GET ARCHIVED INVOICES, the deleted ones
+func GetArchivedInvoices(writer http.ResponseWriter, request *http.Request) {
+	params, ok := parsePagination(writer, request)
+	if !ok {
+		return
+	}
+	invoices, err := models.GetArchivedInvoices(params)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "archived invoices could not be listed"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	invoiceJson, _ := json.Marshal(invoices)
+	writer.Write(invoiceJson)
+}
+
+// RESTORE INVOICE from the archive
+func RestoreInvoice(writer http.ResponseWriter, request *http.Request) {
+	invoiceIdParam := chi.URLParam(request, "invoiceId")
+	_, err := uuid.Parse(invoiceIdParam)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "invoiceId is not a valid uuid"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return
+	}
+	invoice, err := models.RestoreInvoice(invoiceIdParam)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "archived invoice not found"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusNotFound)
+		writer.Write(jsonResponse)
+		return
+	}
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "invoice could not be restored"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	invoiceJson, _ := json.Marshal(invoice)
+	writer.Write(invoiceJson)
+}
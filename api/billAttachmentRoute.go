@@ -0,0 +1,175 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"io"
+	"mime"
+	"net/http"
+	"numerisTask/models"
+	"path/filepath"
+	"strconv"
+)
+
+// Largest attachment accepted, a scanned bill or receipt
+const maxBillAttachmentSize = 10 << 20
+
+// ADD BILL ATTACHMENT, a scan, receipt or delivery note sent as multipart/form-data in file
+func AddBillAttachment(writer http.ResponseWriter, request *http.Request) {
+	bill, ok := findBill(writer, request)
+	if !ok {
+		return
+	}
+	err := request.ParseMultipartForm(32 << 20)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "attachment body not valid, send the file as multipart/form-data"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return
+	}
+	file, header, err := request.FormFile("file")
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "file is required"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+	defer file.Close()
+	if header.Size > maxBillAttachmentSize {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "file is larger than 10MB"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusRequestEntityTooLarge)
+		writer.Write(jsonResponse)
+		return
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "file could not be read"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	// The type the client sent, guessed from the extension or the content otherwise
+	contentType := header.Header.Get("Content-Type")
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = mime.TypeByExtension(filepath.Ext(header.Filename))
+	}
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+
+	attachment := models.BillAttachment{
+		AttachmentID: uuid.New(),
+		BillID:       bill.BillID,
+		FileName:     filepath.Base(header.Filename),
+		ContentType:  contentType,
+		Size:         len(data),
+		Data:         data,
+	}
+	err = models.AddBillAttachment(&attachment)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "attachment could not be saved"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusCreated)
+	attachmentJson, _ := json.Marshal(attachment)
+	writer.Write(attachmentJson)
+}
+
+// GET BILL ATTACHMENTS, the files of the bill without their content
+func GetBillAttachments(writer http.ResponseWriter, request *http.Request) {
+	bill, ok := findBill(writer, request)
+	if !ok {
+		return
+	}
+	attachments, err := models.GetBillAttachments(bill.BillID)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "attachments could not be listed"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	attachmentsJson, _ := json.Marshal(attachments)
+	writer.Write(attachmentsJson)
+}
+
+// GET BILL ATTACHMENT, downloads the file
+func GetBillAttachment(writer http.ResponseWriter, request *http.Request) {
+	bill, ok := findBill(writer, request)
+	if !ok {
+		return
+	}
+	attachmentId, ok := billAttachmentId(writer, request)
+	if !ok {
+		return
+	}
+	attachment, err := models.GetBillAttachment(bill.BillID, attachmentId)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "attachment not found"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusNotFound)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.Header().Set("Content-Type", attachment.ContentType)
+	writer.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(attachment.FileName))
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(attachment.Data)
+}
+
+// DELETE BILL ATTACHMENT
+func DeleteBillAttachment(writer http.ResponseWriter, request *http.Request) {
+	bill, ok := findBill(writer, request)
+	if !ok {
+		return
+	}
+	attachmentId, ok := billAttachmentId(writer, request)
+	if !ok {
+		return
+	}
+	err := models.DeleteBillAttachment(bill.BillID, attachmentId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "attachment not found"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusNotFound)
+		writer.Write(jsonResponse)
+		return
+	}
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "attachment could not be deleted"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// billAttachmentId reads the attachment id in the URL, writing the error response if it is not a uuid
+func billAttachmentId(writer http.ResponseWriter, request *http.Request) (string, bool) {
+	attachmentIdParam := chi.URLParam(request, "attachmentId")
+	_, err := uuid.Parse(attachmentIdParam)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "attachmentId is not a valid uuid"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return "", false
+	}
+	return attachmentIdParam, true
+}
@@ -0,0 +1,634 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"numerisTask/einvoice"
+	"numerisTask/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Largest e-invoice accepted, they are a few kilobytes
+const maxEInvoiceSize = 10 << 20
+
+// How far ahead the due-soon list looks by default
+const defaultDueSoonDays = 14
+
+// BillItemPayload is an item of a bill entered by hand, its amount is worked out
+type BillItemPayload struct {
+	Name          string  `json:"name" validate:"required"`
+	Quantity      float64 `json:"quantity" validate:"gt=0"`
+	UnitPrice     float64 `json:"unit_price" validate:"gte=0"`
+	TaxPercentage float64 `json:"tax_percentage,omitempty" validate:"omitempty,gte=0,lte=100"`
+}
+
+// CreateBillPayload shares the item and discount model of CreateInvoicePayload, for a bill that came on paper or by email
+type CreateBillPayload struct {
+	SupplierBillNumber string              `json:"supplier_bill_number" validate:"required"`
+	SupplierInfo       models.SupplierInfo `json:"supplier_info" validate:"required"`
+	IssueDate          string              `json:"issue_date" validate:"required,datetime=2006-01-02"`
+	DueDate            string              `json:"due_date,omitempty" validate:"omitempty,datetime=2006-01-02"`
+	Currency           string              `json:"currency,omitempty" validate:"omitempty,len=3,uppercase"` // Defaults to INVOICE_CURRENCY
+	Description        string              `json:"description,omitempty"`
+	PaymentTerms       string              `json:"payment_terms,omitempty"`
+	Items              []BillItemPayload   `json:"items" validate:"required,min=1,dive"`
+	IsDiscount         bool                `json:"is_discount,omitempty"`
+	DiscountPercentage float64             `json:"discount_percentage,omitempty" validate:"omitempty,gte=0,lte=100"`
+	Note               string              `json:"note,omitempty"`
+}
+
+type UpdateBillPayload struct {
+	SupplierBillNumber *string              `json:"supplier_bill_number,omitempty" validate:"omitempty,min=1"`
+	SupplierInfo       *models.SupplierInfo `json:"supplier_info,omitempty"`
+	IssueDate          *string              `json:"issue_date,omitempty" validate:"omitempty,datetime=2006-01-02"`
+	DueDate            *string              `json:"due_date,omitempty" validate:"omitempty,datetime=2006-01-02"`
+	Currency           *string              `json:"currency,omitempty" validate:"omitempty,len=3,uppercase"`
+	Description        *string              `json:"description,omitempty"`
+	PaymentTerms       *string              `json:"payment_terms,omitempty"`
+	Items              *[]BillItemPayload   `json:"items,omitempty" validate:"omitempty,min=1,dive"`
+	IsDiscount         *bool                `json:"is_discount,omitempty"`
+	DiscountPercentage *float64             `json:"discount_percentage,omitempty" validate:"omitempty,gte=0,lte=100"`
+	Note               *string              `json:"note,omitempty"`
+}
+
+type PayBillPayload struct {
+	Amount   float64 `json:"amount" validate:"gt=0"`
+	DatePaid string  `json:"date_paid,omitempty" validate:"omitempty,datetime=2006-01-02"` // Defaults to now
+	Note     string  `json:"note,omitempty"`                                               // Eg: the transfer reference
+}
+
+// billItems turns the items of a payload into bill items
+func billItems(payload []BillItemPayload) []models.BillItem {
+	items := make([]models.BillItem, 0, len(payload))
+	for _, item := range payload {
+		items = append(items, models.BillItem{
+			Name:          item.Name,
+			Quantity:      item.Quantity,
+			UnitPrice:     item.UnitPrice,
+			TaxPercentage: item.TaxPercentage,
+		})
+	}
+	return items
+}
+
+// CREATE BILL entered by hand, waiting for approval
+func CreateBill(writer http.ResponseWriter, request *http.Request) {
+	body, _ := ioutil.ReadAll(request.Body)
+	var payload CreateBillPayload
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "bill body not valid"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return
+	}
+	//validating the playload
+	validate := validator.New()
+	err = validate.Struct(payload)
+	if err != nil {
+		validationError := err.(validator.ValidationErrors)
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": validationError.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	issueDate, _ := time.Parse("2006-01-02", payload.IssueDate)
+	var dueDate *time.Time
+	if payload.DueDate != "" {
+		parsed, _ := time.Parse("2006-01-02", payload.DueDate)
+		if parsed.Before(issueDate) {
+			jsonResponse, _ := json.Marshal(map[string]string{"detail": "due_date can not be before the issue_date"})
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write(jsonResponse)
+			return
+		}
+		dueDate = &parsed
+	}
+	currency := payload.Currency
+	if currency == "" {
+		currency = einvoice.Currency()
+	}
+	supplierInfoJSON, _ := json.Marshal(payload.SupplierInfo)
+
+	bill := models.Bill{
+		BillID:             uuid.New(),
+		SupplierBillNumber: payload.SupplierBillNumber,
+		SupplierInfo:       supplierInfoJSON,
+		IssueDate:          issueDate,
+		DueDate:            dueDate,
+		Currency:           currency,
+		Description:        payload.Description,
+		PaymentTerms:       payload.PaymentTerms,
+		IsDiscount:         payload.IsDiscount,
+		DiscountPercentage: payload.DiscountPercentage,
+		Status:             models.BILLRECEIVED,
+		PaymentHistory:     json.RawMessage(`[]`),
+		Note:               payload.Note,
+		CreatedBy:          1, // Hard coded for proof of work
+	}
+	models.CalculateBillTotals(&bill, billItems(payload.Items))
+	models.AppendBillHistory(&bill, models.BillHistory{
+		Action:     models.BILLRECEIVED,
+		ActionDate: time.Now(),
+	})
+
+	err = models.CreateBill(&bill)
+	if errors.Is(err, models.ErrBillAlreadyReceived) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": err.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusConflict)
+		writer.Write(jsonResponse)
+		return
+	}
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "bill could not be saved"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusCreated)
+	billJson, _ := json.Marshal(bill)
+	writer.Write(billJson)
+}
+
+// UPDATE BILL entered by hand, while it waits for approval or after it was rejected (it goes back for approval)
+func UpdateBill(writer http.ResponseWriter, request *http.Request) {
+	bill, ok := findBill(writer, request)
+	if !ok {
+		return
+	}
+
+	body, _ := ioutil.ReadAll(request.Body)
+	var payload UpdateBillPayload
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "bill body not valid"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return
+	}
+	//validating the playload
+	validate := validator.New()
+	err = validate.Struct(payload)
+	if err != nil {
+		validationError := err.(validator.ValidationErrors)
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": validationError.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	issueDate := bill.IssueDate
+	if payload.IssueDate != nil {
+		issueDate, _ = time.Parse("2006-01-02", *payload.IssueDate)
+	}
+	dueDate := bill.DueDate
+	if payload.DueDate != nil {
+		parsed, _ := time.Parse("2006-01-02", *payload.DueDate)
+		dueDate = &parsed
+	}
+	if dueDate != nil && dueDate.Before(issueDate) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "due_date can not be before the issue_date"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	bill, err = models.UpdateBill(bill.BillID.String(), func(bill *models.Bill) {
+		bill.IssueDate = issueDate
+		bill.DueDate = dueDate
+		if payload.SupplierBillNumber != nil {
+			bill.SupplierBillNumber = *payload.SupplierBillNumber
+		}
+		if payload.SupplierInfo != nil {
+			bill.SupplierInfo, _ = json.Marshal(*payload.SupplierInfo)
+		}
+		if payload.Currency != nil {
+			bill.Currency = *payload.Currency
+		}
+		if payload.Description != nil {
+			bill.Description = *payload.Description
+		}
+		if payload.PaymentTerms != nil {
+			bill.PaymentTerms = *payload.PaymentTerms
+		}
+		if payload.IsDiscount != nil {
+			bill.IsDiscount = *payload.IsDiscount
+		}
+		if payload.DiscountPercentage != nil {
+			bill.DiscountPercentage = *payload.DiscountPercentage
+		}
+		if payload.Note != nil {
+			bill.Note = *payload.Note
+		}
+		var items []models.BillItem
+		_ = json.Unmarshal(bill.Items, &items)
+		if payload.Items != nil {
+			items = billItems(*payload.Items)
+		}
+		models.CalculateBillTotals(bill, items)
+	})
+	if errors.Is(err, models.ErrBillImported) || errors.Is(err, models.ErrBillNotEditable) ||
+		errors.Is(err, models.ErrBillAlreadyReceived) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": err.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusConflict)
+		writer.Write(jsonResponse)
+		return
+	}
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "bill update error"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	billJson, _ := json.Marshal(bill)
+	writer.Write(billJson)
+}
+
+// DELETE BILL, only when nothing was paid out on it
+func DeleteBill(writer http.ResponseWriter, request *http.Request) {
+	bill, ok := findBill(writer, request)
+	if !ok {
+		return
+	}
+	err := models.DeleteBill(bill.BillID.String())
+	if errors.Is(err, models.ErrBillHasPayments) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": err.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusConflict)
+		writer.Write(jsonResponse)
+		return
+	}
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "bill could not be deleted"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// PAY BILL, records a payout (in part or in full) on an approved bill
+func PayBill(writer http.ResponseWriter, request *http.Request) {
+	bill, ok := findBill(writer, request)
+	if !ok {
+		return
+	}
+
+	body, _ := ioutil.ReadAll(request.Body)
+	var payload PayBillPayload
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "payment body not valid"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return
+	}
+	//validating the playload
+	validate := validator.New()
+	err = validate.Struct(payload)
+	if err != nil {
+		validationError := err.(validator.ValidationErrors)
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": validationError.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	paidAt := time.Now()
+	if payload.DatePaid != "" {
+		paidAt, _ = time.Parse("2006-01-02", payload.DatePaid)
+	}
+	if paidAt.After(time.Now()) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "date_paid can not be in the future"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	bill, err = models.PayBill(bill.BillID.String(), payload.Amount, paidAt, payload.Note)
+	if errors.Is(err, models.ErrBillNotPayable) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": err.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusConflict)
+		writer.Write(jsonResponse)
+		return
+	}
+	if errors.Is(err, models.ErrOverpayment) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "amount is greater than the outstanding amount"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "payment could not be recorded"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	billJson, _ := json.Marshal(bill)
+	writer.Write(billJson)
+}
+
+// GET BILLS DUE SOON, the open bills due in the next ?days= (14 by default) and the overdue ones, to plan payouts
+func GetBillsDueSoon(writer http.ResponseWriter, request *http.Request) {
+	days := defaultDueSoonDays
+	if daysParam := request.URL.Query().Get("days"); daysParam != "" {
+		parsed, err := strconv.Atoi(daysParam)
+		if err != nil || parsed < 0 {
+			jsonResponse, _ := json.Marshal(map[string]string{"detail": "days must be a number of days, eg: 14"})
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write(jsonResponse)
+			return
+		}
+		days = parsed
+	}
+
+	dueSoon, err := models.GetBillsDueSoon(time.Now().AddDate(0, 0, days))
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": fmt.Sprintf("bills due in %d days could not be listed", days)})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	dueSoonJson, _ := json.Marshal(dueSoon)
+	writer.Write(dueSoonJson)
+}
+
+// IMPORT BILL from a supplier's UBL or CII e-invoice, sent as multipart/form-data in file.
+// The totals have to add up; the bill is stored waiting for approval with the original XML kept.
+func ImportBill(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseMultipartForm(32 << 20)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "import body not valid, send the XML as multipart/form-data"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return
+	}
+	file, _, err := request.FormFile("file")
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "file is required"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+	defer file.Close()
+	document, err := io.ReadAll(io.LimitReader(file, maxEInvoiceSize))
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "file could not be read"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	received, err := einvoice.ReadEInvoice(document)
+	if errors.Is(err, einvoice.ErrNotEInvoice) || errors.Is(err, einvoice.ErrCreditNote) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": err.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return
+	}
+	if problems := received.Check(); len(problems) > 0 {
+		jsonResponse, _ := json.Marshal(map[string]interface{}{"detail": "the e-invoice is incomplete or its totals do not add up", "problems": problems})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	bill := billFromEInvoice(received, document)
+	err = models.CreateBill(&bill)
+	if errors.Is(err, models.ErrBillAlreadyReceived) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": err.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusConflict)
+		writer.Write(jsonResponse)
+		return
+	}
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "bill could not be saved"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusCreated)
+	billJson, _ := json.Marshal(bill)
+	writer.Write(billJson)
+}
+
+// billFromEInvoice maps a received e-invoice onto a bill, the amounts as the supplier stated them
+func billFromEInvoice(received *einvoice.ReceivedInvoice, document []byte) models.Bill {
+	items := make([]models.BillItem, 0, len(received.Lines))
+	for _, line := range received.Lines {
+		items = append(items, models.BillItem{
+			Name:          line.Name,
+			Quantity:      line.Quantity,
+			UnitPrice:     line.UnitPrice,
+			Amount:        line.Amount,
+			TaxCategory:   line.TaxCategory,
+			TaxPercentage: line.TaxPercentage,
+		})
+	}
+	itemsJSON, _ := json.Marshal(items)
+	supplierInfoJSON, _ := json.Marshal(models.SupplierInfo{
+		Name:        received.Supplier.Name,
+		Email:       received.Supplier.Email,
+		PhoneNumber: received.Supplier.PhoneNumber,
+		TaxID:       received.Supplier.TaxID,
+		Country:     received.Supplier.Country,
+		BankAccount: received.BankAccount,
+	})
+
+	bill := models.Bill{
+		BillID:             uuid.New(),
+		SupplierBillNumber: received.Number,
+		SupplierInfo:       supplierInfoJSON,
+		IssueDate:          received.IssueDate,
+		DueDate:            received.DueDate,
+		Currency:           received.Currency,
+		Description:        strings.Join(received.Notes, "\n"),
+		PaymentTerms:       received.PaymentTerms,
+		Items:              itemsJSON,
+		Subtotal:           received.LineTotal,
+		DiscountAmount:     received.Allowances,
+		ChargesAmount:      received.Charges,
+		TaxAmount:          received.TaxTotal,
+		Amount:             received.TaxInclusive + received.Rounding,
+		PrepaidAmount:      received.Prepaid,
+		OutstandingAmount:  received.Payable,
+		Status:             models.BILLRECEIVED,
+		PaymentHistory:     json.RawMessage(`[]`),
+		SourceFormat:       received.Format,
+		SourceDocument:     string(document),
+		CreatedBy:          1, // Hard coded for proof of work
+	}
+	models.AppendBillHistory(&bill, models.BillHistory{
+		Action:     models.BILLIMPORTED,
+		ActionDate: time.Now(),
+		Note:       "from a " + received.Format + " e-invoice",
+	})
+	return bill
+}
+
+// GET BILLS, newest first
+func GetBills(writer http.ResponseWriter, request *http.Request) {
+	params, ok := parsePagination(writer, request)
+	if !ok {
+		return
+	}
+	bills, _ := models.GetBills(params)
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	billsJson, _ := json.Marshal(bills)
+	writer.Write(billsJson)
+}
+
+// GET BILL By BillID
+func GetBillByBillId(writer http.ResponseWriter, request *http.Request) {
+	bill, ok := findBill(writer, request)
+	if !ok {
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	billJson, _ := json.Marshal(bill)
+	writer.Write(billJson)
+}
+
+// GET BILL DOCUMENT, the e-invoice XML the bill was imported from
+func GetBillDocument(writer http.ResponseWriter, request *http.Request) {
+	bill, ok := findBill(writer, request)
+	if !ok {
+		return
+	}
+	if bill.SourceDocument == "" {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "the bill was not imported from an e-invoice"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusNotFound)
+		writer.Write(jsonResponse)
+		return
+	}
+	// The XML came from outside, it is downloaded as it is and never rendered by the browser
+	writer.Header().Set("Content-Type", "application/xml")
+	writer.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote("bill-"+bill.BillID.String()+".xml"))
+	writer.Header().Set("X-Content-Type-Options", "nosniff")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write([]byte(bill.SourceDocument))
+}
+
+// APPROVE BILL, it can be paid afterwards
+func ApproveBill(writer http.ResponseWriter, request *http.Request) {
+	decideBill(writer, request, true)
+}
+
+// REJECT BILL, the comment telling the supplier what is wrong is required
+func RejectBill(writer http.ResponseWriter, request *http.Request) {
+	decideBill(writer, request, false)
+}
+
+func decideBill(writer http.ResponseWriter, request *http.Request, approve bool) {
+	bill, ok := findBill(writer, request)
+	if !ok {
+		return
+	}
+	payload, ok := approvalDecision(writer, request)
+	if !ok {
+		return
+	}
+	if !approve && strings.TrimSpace(payload.Comment) == "" {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "a comment is required to reject a bill"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	// Placeholder user standing in for the manager
+	bill, err := models.DecideBill(bill.BillID.String(), approve, models.PlaceHolderUser.ID, payload.Comment)
+	if errors.Is(err, models.ErrBillNotReceived) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": err.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusConflict)
+		writer.Write(jsonResponse)
+		return
+	}
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "bill could not be updated"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	billJson, _ := json.Marshal(bill)
+	writer.Write(billJson)
+}
+
+// findBill loads the bill in the URL, writing the error response if it can't
+func findBill(writer http.ResponseWriter, request *http.Request) (*models.Bill, bool) {
+	billIdParam := chi.URLParam(request, "billId")
+	_, err := uuid.Parse(billIdParam)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "billId is not a valid uuid"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return nil, false
+	}
+
+	bill, err := models.GetBillByID(billIdParam)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "bill not found"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusNotFound)
+		writer.Write(jsonResponse)
+		return nil, false
+	}
+	return bill, true
+}
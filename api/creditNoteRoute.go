@@ -0,0 +1,192 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"io/ioutil"
+	"net/http"
+	"numerisTask/models"
+	"numerisTask/render"
+)
+
+type CreateCreditNotePayload struct {
+	Reason string        `json:"reason" validate:"required"`
+	Items  []models.Item `json:"items" validate:"required,min=1,dive"`
+}
+
+// CREATE CREDIT NOTE against an issued invoice
+func CreateCreditNote(writer http.ResponseWriter, request *http.Request) {
+	invoiceIdParam := chi.URLParam(request, "invoiceId")
+	invoiceId, err := uuid.Parse(invoiceIdParam)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "invoiceId is not a valid uuid"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	body, _ := ioutil.ReadAll(request.Body)
+	var payload CreateCreditNotePayload
+	err = json.Unmarshal(body, &payload)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "credit note body not valid"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return
+	}
+	//validating the playload
+	validate := validator.New()
+	err = validate.Struct(payload)
+	if err != nil {
+		validationError := err.(validator.ValidationErrors)
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": validationError.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	invoice, err := models.GetInvoiceByID(invoiceIdParam)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "invoice not found"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusNotFound)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	// Credited items get the same discount the invoice gave
+	amount := models.CalculateItemsTotal(payload.Items, invoice.IsDiscount, invoice.DiscountPercentage)
+	if amount <= 0 {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "credit note amount must be greater than zero"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+	itemsJSON, _ := json.Marshal(payload.Items)
+
+	creditNote := models.CreditNote{
+		CreditNoteID: uuid.New(),
+		InvoiceID:    invoiceId,
+		Reason:       payload.Reason,
+		Items:        itemsJSON,
+		Amount:       amount,
+		// Hard coded for proof of work
+		CreatedBy: 1,
+	}
+	// The invoice status is checked under the row lock, so a void or write-off can't slip in between
+	_, err = models.IssueCreditNote(&creditNote)
+	if errors.Is(err, models.ErrInvoiceNotCreditable) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": err.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusConflict)
+		writer.Write(jsonResponse)
+		return
+	}
+	if errors.Is(err, models.ErrCreditExceedsOutstanding) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": err.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "credit note creation error"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusCreated)
+	creditNoteJson, _ := json.Marshal(creditNote)
+	writer.Write(creditNoteJson)
+}
+
+// GET CREDIT NOTES issued against an invoice
+func GetInvoiceCreditNotes(writer http.ResponseWriter, request *http.Request) {
+	invoiceIdParam := chi.URLParam(request, "invoiceId")
+	_, err := uuid.Parse(invoiceIdParam)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "invoiceId is not a valid uuid"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	creditNotes, err := models.GetCreditNotesByInvoiceID(invoiceIdParam)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "credit notes could not be fetched"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	creditNotesJson, _ := json.Marshal(creditNotes)
+	writer.Write(creditNotesJson)
+}
+
+// GET CREDIT NOTE By CreditNoteID
+func GetCreditNoteByCreditNoteId(writer http.ResponseWriter, request *http.Request) {
+	creditNote, ok := findCreditNote(writer, request)
+	if !ok {
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	creditNoteJson, _ := json.Marshal(creditNote)
+	writer.Write(creditNoteJson)
+}
+
+// GET CREDIT NOTE PDF
+func GetCreditNotePDF(writer http.ResponseWriter, request *http.Request) {
+	creditNote, ok := findCreditNote(writer, request)
+	if !ok {
+		return
+	}
+	document, err := render.CreditNotePDF(creditNote)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "credit note could not be rendered"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/pdf")
+	writer.Header().Set("Content-Disposition", "inline; filename=\""+creditNote.CreditNoteNumber+".pdf\"")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(document)
+}
+
+// findCreditNote loads the credit note in the URL, writing the error response if it can't
+func findCreditNote(writer http.ResponseWriter, request *http.Request) (*models.CreditNote, bool) {
+	creditNoteIdParam := chi.URLParam(request, "creditNoteId")
+	_, err := uuid.Parse(creditNoteIdParam)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "creditNoteId is not a valid uuid"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return nil, false
+	}
+
+	creditNote, err := models.GetCreditNoteByID(creditNoteIdParam)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "credit note not found"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusNotFound)
+		writer.Write(jsonResponse)
+		return nil, false
+	}
+	return creditNote, true
+}
@@ -0,0 +1,255 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"io/ioutil"
+	"net/http"
+	"numerisTask/models"
+	"time"
+)
+
+type DunningStepPayload struct {
+	Name         string                  `json:"name" validate:"required"`
+	Action       models.DunningAction    `json:"action" validate:"required,oneof=FRIENDLY_REMINDER FIRM_REMINDER FINAL_NOTICE LATE_FEE HAND_OVER"`
+	DaysAfterDue int                     `json:"days_after_due" validate:"gte=0"`
+	Channels     []models.DunningChannel `json:"channels,omitempty" validate:"dive,oneof=EMAIL SMS"`
+	Subject      string                  `json:"subject,omitempty"`
+	Template     string                  `json:"template,omitempty"`
+	FeeType      models.LateFeeType      `json:"fee_type,omitempty" validate:"required_if=Action LATE_FEE,omitempty,oneof=FLAT PERCENTAGE"`
+	FeeAmount    float64                 `json:"fee_amount,omitempty" validate:"required_if=Action LATE_FEE,gte=0"`
+}
+
+type DunningSequencePayload struct {
+	Name   string               `json:"name,omitempty"`
+	Active *bool                `json:"active,omitempty"`
+	Steps  []DunningStepPayload `json:"steps" validate:"required,min=1,dive"`
+}
+
+type DisputeInvoicePayload struct {
+	Reason string `json:"reason" validate:"required"`
+}
+
+type ResolveDisputePayload struct {
+	Note string `json:"note,omitempty"`
+}
+
+// GET DUNNING SEQUENCE of the organization
+func GetDunningSequence(writer http.ResponseWriter, request *http.Request) {
+	sequence, err := models.GetDunningSequence(models.PlaceHolderUser.ID)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "dunning sequence not found"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusNotFound)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	sequenceJson, _ := json.Marshal(sequence)
+	writer.Write(sequenceJson)
+}
+
+// SAVE DUNNING SEQUENCE of the organization, the steps taken once invoices are past due. Steps are ordered by
+// days after the due date; invoices already part way through carry on from the step number they are on.
+func SaveDunningSequence(writer http.ResponseWriter, request *http.Request) {
+	body, _ := ioutil.ReadAll(request.Body)
+	var payload DunningSequencePayload
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "dunning sequence body not valid"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return
+	}
+	//validating the playload
+	validate := validator.New()
+	err = validate.Struct(payload)
+	if err != nil {
+		validationError := err.(validator.ValidationErrors)
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": validationError.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	steps := make([]models.DunningStep, 0, len(payload.Steps))
+	for _, step := range payload.Steps {
+		steps = append(steps, models.DunningStep{
+			Name:         step.Name,
+			Action:       step.Action,
+			DaysAfterDue: step.DaysAfterDue,
+			Channels:     step.Channels,
+			Subject:      step.Subject,
+			Template:     step.Template,
+			FeeType:      step.FeeType,
+			FeeAmount:    step.FeeAmount,
+		})
+	}
+	steps = models.OrderDunningSteps(steps)
+	if err = models.ValidateDunningSteps(steps); err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": err.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	sequence, err := models.GetDunningSequence(models.PlaceHolderUser.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		sequence = &models.DunningSequence{SequenceID: uuid.New(), OrganizationID: models.PlaceHolderUser.ID, Active: true}
+	} else if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "dunning sequence could not be fetched"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	sequence.Name = payload.Name
+	if payload.Active != nil {
+		sequence.Active = *payload.Active
+	}
+	sequence.Steps, _ = json.Marshal(steps)
+	err = models.SaveDunningSequence(sequence)
+	if errors.Is(err, models.ErrLateFeeConflict) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": err.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusConflict)
+		writer.Write(jsonResponse)
+		return
+	}
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "dunning sequence could not be saved"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	sequenceJson, _ := json.Marshal(sequence)
+	writer.Write(sequenceJson)
+}
+
+// GET DUNNING OVERVIEW, the invoices past due (longest overdue first) with the step each is on and the next one
+func GetDunningOverview(writer http.ResponseWriter, request *http.Request) {
+	params, ok := parsePagination(writer, request)
+	if !ok {
+		return
+	}
+	statuses, err := models.GetDunningOverview(params, time.Now())
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "dunning overview could not be fetched"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	statusesJson, _ := json.Marshal(statuses)
+	writer.Write(statusesJson)
+}
+
+// GET INVOICE DUNNING, where the invoice stands in the sequence and the steps taken so far
+func GetInvoiceDunning(writer http.ResponseWriter, request *http.Request) {
+	invoice, ok := findInvoice(writer, request)
+	if !ok {
+		return
+	}
+	status, err := models.GetDunningStatus(invoice, time.Now())
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "invoice dunning could not be fetched"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	statusJson, _ := json.Marshal(status)
+	writer.Write(statusJson)
+}
+
+// DISPUTE INVOICE, the customer contests it: dunning is paused until the dispute is resolved
+func DisputeInvoice(writer http.ResponseWriter, request *http.Request) {
+	invoice, ok := findInvoice(writer, request)
+	if !ok {
+		return
+	}
+
+	body, _ := ioutil.ReadAll(request.Body)
+	var payload DisputeInvoicePayload
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "dispute body not valid"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return
+	}
+	//validating the playload
+	validate := validator.New()
+	err = validate.Struct(payload)
+	if err != nil {
+		validationError := err.(validator.ValidationErrors)
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": validationError.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	dunning, err := models.DisputeInvoice(invoice.InvoiceID.String(), payload.Reason)
+	writeDispute(writer, dunning, err)
+}
+
+// RESOLVE DISPUTE of an invoice, dunning picks up where it was
+func ResolveDispute(writer http.ResponseWriter, request *http.Request) {
+	invoice, ok := findInvoice(writer, request)
+	if !ok {
+		return
+	}
+
+	// The note is optional, so is the body
+	var payload ResolveDisputePayload
+	body, _ := ioutil.ReadAll(request.Body)
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &payload); err != nil {
+			jsonResponse, _ := json.Marshal(map[string]string{"detail": "resolve dispute body not valid"})
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusUnprocessableEntity)
+			writer.Write(jsonResponse)
+			return
+		}
+	}
+
+	dunning, err := models.ResolveDispute(invoice.InvoiceID.String(), payload.Note)
+	writeDispute(writer, dunning, err)
+}
+
+func writeDispute(writer http.ResponseWriter, dunning *models.InvoiceDunning, err error) {
+	if errors.Is(err, models.ErrInvoiceDisputed) || errors.Is(err, models.ErrInvoiceNotDisputed) || errors.Is(err, models.ErrInvoiceNotDisputable) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": err.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusConflict)
+		writer.Write(jsonResponse)
+		return
+	}
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "invoice dispute could not be updated"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	dunningJson, _ := json.Marshal(dunning)
+	writer.Write(dunningJson)
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"numerisTask/einvoice"
+	"numerisTask/models"
+	"numerisTask/render"
+	"time"
+)
+
+// GET INVOICE UBL, the invoice as a UBL 2.1 / Peppol BIS Billing 3.0 e-invoice (?revision= for a snapshot).
+// The document is checked against the business rules before it goes out, a broken one is never returned.
+func GetInvoiceUBL(writer http.ResponseWriter, request *http.Request) {
+	invoice, ok := eInvoiceable(writer, request)
+	if !ok {
+		return
+	}
+	document, err := einvoice.UBL(invoice, models.PlaceHolderUser)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "e-invoice could not be generated"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	if problems := einvoice.ValidateUBL(document); len(problems) > 0 {
+		jsonResponse, _ := json.Marshal(map[string]interface{}{"detail": "the e-invoice does not pass validation", "problems": problems})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/xml")
+	writer.Header().Set("Content-Disposition", "attachment; filename=\"invoice-"+*invoice.InvoiceNumber+".xml\"")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(document)
+}
+
+// GET INVOICE FACTUR-X, the invoice PDF as a PDF/A-3 with the Cross Industry Invoice XML (EN 16931 profile)
+// embedded, readable by people and accounts payable software alike (?revision= for a snapshot).
+func GetInvoiceFacturX(writer http.ResponseWriter, request *http.Request) {
+	invoice, ok := eInvoiceable(writer, request)
+	if !ok {
+		return
+	}
+	cii, err := einvoice.CII(invoice, models.PlaceHolderUser)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "e-invoice could not be generated"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	document, err := render.FacturXPDF(invoice, cii, time.Now())
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "invoice could not be rendered"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/pdf")
+	writer.Header().Set("Content-Disposition", "attachment; filename=\"invoice-"+*invoice.InvoiceNumber+".pdf\"")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(document)
+}
+
+// eInvoiceable loads the invoice (or the requested revision) for an e-invoice, drafts have no number to carry yet
+func eInvoiceable(writer http.ResponseWriter, request *http.Request) (*models.Invoice, bool) {
+	invoice, ok := findInvoice(writer, request)
+	if !ok {
+		return nil, false
+	}
+	invoice, ok = requestedRevision(writer, request, invoice)
+	if !ok {
+		return nil, false
+	}
+	if invoice.InvoiceNumber == nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "a draft invoice has to be finalized before it can be e-invoiced"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusConflict)
+		writer.Write(jsonResponse)
+		return nil, false
+	}
+	return invoice, true
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"numerisTask/models"
+	"numerisTask/render"
+	"strings"
+	"time"
+)
+
+// EXPORT INVOICES as CSV or XLSX (?format=csv|xlsx), with the filters of the invoice list.
+// ?include=items,payments flattens line items and payments into sheets of their own (CSV: files zipped together).
+// Everything is exported unless a limit is given.
+func ExportInvoices(writer http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+	format := strings.ToLower(query.Get("format"))
+	if format == "" {
+		format = render.ExportCSV
+	}
+	if format != render.ExportCSV && format != render.ExportXLSX {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "format must be csv or xlsx"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+	var includeItems, includePayments bool
+	if include := query.Get("include"); include != "" {
+		for _, table := range strings.Split(include, ",") {
+			switch strings.TrimSpace(table) {
+			case "items":
+				includeItems = true
+			case "payments":
+				includePayments = true
+			default:
+				jsonResponse, _ := json.Marshal(map[string]string{"detail": "include takes items and payments"})
+				writer.Header().Set("Content-Type", "application/json")
+				writer.WriteHeader(http.StatusBadRequest)
+				writer.Write(jsonResponse)
+				return
+			}
+		}
+	}
+
+	params, ok := parsePagination(writer, request)
+	if !ok {
+		return
+	}
+	if query.Get("limit") == "" {
+		params.Limit = -1
+	}
+	if !parseInvoiceFilters(writer, request, &params) {
+		return
+	}
+
+	fileName, contentType := render.ExportFileName(format, includeItems, includePayments, time.Now())
+	writer.Header().Set("Content-Type", contentType)
+	writer.Header().Set("Content-Disposition", `attachment; filename="`+fileName+`"`)
+	writer.WriteHeader(http.StatusOK)
+	// One snapshot for every pass, so the items and payments belong to the invoices listed
+	err := models.SnapshotInvoices(params, func(each func(fn func(invoice *models.Invoice) error) error) error {
+		return render.ExportInvoices(writer, format, includeItems, includePayments, each)
+	})
+	if err != nil {
+		// The rows already went out, all that is left is to stop
+		log.Printf("Error exporting invoices: %v", err)
+	}
+}
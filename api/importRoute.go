@@ -0,0 +1,449 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"io"
+	"net/http"
+	"numerisTask/models"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fields a CSV column can be mapped to. Rows sharing a reference (or an invoice number) are the lines of one
+// invoice: invoice fields come from its first row, every row adds an item.
+var (
+	importInvoiceFields = []string{"reference", "invoice_number", "customer_name", "customer_email", "customer_phone",
+		"issue_date", "due_date", "description", "note", "discount_percentage", "paid_amount", "paid_date"}
+	importItemFields     = []string{"item_name", "item_quantity", "item_unit_price"}
+	requiredImportFields = []string{"customer_name", "customer_email", "due_date", "item_name", "item_quantity", "item_unit_price"}
+)
+
+type ImportRowError struct {
+	Row    int    `json:"row"` // Line in the file, the header is line 1
+	Field  string `json:"field,omitempty"`
+	Detail string `json:"detail"`
+}
+
+type ImportedInvoice struct {
+	Reference     string        `json:"reference"`
+	Rows          []int         `json:"rows"`
+	InvoiceID     *uuid.UUID    `json:"invoice_id,omitempty"`     // Left out on a dry run
+	InvoiceNumber *string       `json:"invoice_number,omitempty"` // Assigned on import when the file has none
+	Amount        float64       `json:"amount"`
+	Status        models.Status `json:"status"`
+}
+
+type ImportInvoicesResponse struct {
+	DryRun   bool              `json:"dry_run"`
+	Rows     int               `json:"rows"`
+	Imported int               `json:"imported"` // Always 0 on a dry run
+	Invoices []ImportedInvoice `json:"invoices"` // The invoices without errors
+	Errors   []ImportRowError  `json:"errors"`
+}
+
+// importGroup is the rows of one invoice in the file
+type importGroup struct {
+	reference string
+	rows      []int
+	values    []map[string]string
+}
+
+// IMPORT INVOICES from a CSV file sent as multipart/form-data:
+// file is the CSV, mapping (optional) a JSON object of field to column header, the columns are named after
+// the fields otherwise, and dry_run=true only validates. Historic due dates and payments made before the
+// import are accepted. Invoices without errors are imported together in one transaction, the others are
+// reported per row.
+func ImportInvoices(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseMultipartForm(32 << 20)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "import body not valid, send the CSV as multipart/form-data"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return
+	}
+	file, _, err := request.FormFile("file")
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "file is required"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+	defer file.Close()
+
+	dryRun := false
+	if value := request.FormValue("dry_run"); value != "" {
+		dryRun, err = strconv.ParseBool(value)
+		if err != nil {
+			jsonResponse, _ := json.Marshal(map[string]string{"detail": "dry_run must be true or false"})
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write(jsonResponse)
+			return
+		}
+	}
+	mapping, ok := importMapping(writer, request)
+	if !ok {
+		return
+	}
+
+	groups, rowCount, ok := readImportFile(writer, file, mapping)
+	if !ok {
+		return
+	}
+
+	response := ImportInvoicesResponse{DryRun: dryRun, Rows: rowCount, Invoices: []ImportedInvoice{}, Errors: []ImportRowError{}}
+	var invoices []models.Invoice
+	var imported []ImportedInvoice
+	numbers := map[string]int{}
+	now := time.Now()
+	for _, group := range groups {
+		invoice, rowErrors := buildImportedInvoice(group, now)
+		if invoice != nil && invoice.InvoiceNumber != nil {
+			if row, ok := numbers[*invoice.InvoiceNumber]; ok {
+				rowErrors = append(rowErrors, ImportRowError{Row: group.rows[0], Field: "invoice_number", Detail: fmt.Sprintf("is also used on row %d", row)})
+			}
+			numbers[*invoice.InvoiceNumber] = group.rows[0]
+		}
+		if len(rowErrors) > 0 {
+			response.Errors = append(response.Errors, rowErrors...)
+			continue
+		}
+		invoices = append(invoices, *invoice)
+		imported = append(imported, ImportedInvoice{Reference: group.reference, Rows: group.rows})
+	}
+
+	// Numbers already in use are only known to the database
+	var fileNumbers []string
+	for number := range numbers {
+		fileNumbers = append(fileNumbers, number)
+	}
+	existing, err := models.ExistingInvoiceNumbers(fileNumbers)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "invoice numbers could not be checked"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	var valid []models.Invoice
+	for i, invoice := range invoices {
+		if invoice.InvoiceNumber != nil && existing[*invoice.InvoiceNumber] {
+			response.Errors = append(response.Errors, ImportRowError{Row: imported[i].Rows[0], Field: "invoice_number", Detail: "is already used by another invoice"})
+			continue
+		}
+		valid = append(valid, invoice)
+		response.Invoices = append(response.Invoices, imported[i])
+	}
+
+	if !dryRun && len(valid) > 0 {
+		err = models.ImportInvoices(valid)
+		if err != nil {
+			jsonResponse, _ := json.Marshal(map[string]string{"detail": "invoices could not be imported, nothing was saved"})
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusInternalServerError)
+			writer.Write(jsonResponse)
+			return
+		}
+		response.Imported = len(valid)
+	}
+	for i := range valid {
+		if !dryRun {
+			response.Invoices[i].InvoiceID = &valid[i].InvoiceID
+		}
+		response.Invoices[i].InvoiceNumber = valid[i].InvoiceNumber
+		response.Invoices[i].Amount = valid[i].Amount
+		response.Invoices[i].Status = valid[i].Status
+	}
+
+	sort.SliceStable(response.Errors, func(i, j int) bool { return response.Errors[i].Row < response.Errors[j].Row })
+	status := http.StatusOK
+	if response.Imported > 0 {
+		status = http.StatusCreated
+	} else if !dryRun && len(response.Errors) > 0 {
+		status = http.StatusBadRequest
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	responseJson, _ := json.Marshal(response)
+	writer.Write(responseJson)
+}
+
+// importMapping reads the field to column mapping, every field maps to the column of the same name by default
+func importMapping(writer http.ResponseWriter, request *http.Request) (map[string]string, bool) {
+	mapping := map[string]string{}
+	for _, field := range append(append([]string{}, importInvoiceFields...), importItemFields...) {
+		mapping[field] = field
+	}
+	value := request.FormValue("mapping")
+	if value == "" {
+		return mapping, true
+	}
+
+	var custom map[string]string
+	err := json.Unmarshal([]byte(value), &custom)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "mapping must be a JSON object of field to column"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return nil, false
+	}
+	for field, column := range custom {
+		if _, ok := mapping[field]; !ok {
+			jsonResponse, _ := json.Marshal(map[string]string{"detail": fmt.Sprintf("%s is not an import field", field)})
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write(jsonResponse)
+			return nil, false
+		}
+		mapping[field] = column
+	}
+	return mapping, true
+}
+
+// readImportFile reads the CSV rows into invoices, in the order they first appear in the file
+func readImportFile(writer http.ResponseWriter, file io.Reader, mapping map[string]string) ([]*importGroup, int, bool) {
+	reader := csv.NewReader(file)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "file is not a CSV with a header row"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return nil, 0, false
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+
+	// Only mapped columns the file has are read, the required ones have to be there
+	fields := map[string]int{}
+	for field, column := range mapping {
+		if index, ok := columns[strings.ToLower(strings.TrimSpace(column))]; ok {
+			fields[field] = index
+		}
+	}
+	for _, field := range requiredImportFields {
+		if _, ok := fields[field]; !ok {
+			jsonResponse, _ := json.Marshal(map[string]string{"detail": fmt.Sprintf("the file has no %s column for %s", mapping[field], field)})
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write(jsonResponse)
+			return nil, 0, false
+		}
+	}
+
+	var groups []*importGroup
+	byReference := map[string]*importGroup{}
+	rowCount := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			jsonResponse, _ := json.Marshal(map[string]string{"detail": "file is not a valid CSV: " + err.Error()})
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write(jsonResponse)
+			return nil, 0, false
+		}
+		line, _ := reader.FieldPos(0)
+
+		values := map[string]string{}
+		empty := true
+		for field, index := range fields {
+			if index < len(record) {
+				values[field] = strings.TrimSpace(record[index])
+				empty = empty && values[field] == ""
+			}
+		}
+		if empty {
+			continue
+		}
+		rowCount++
+
+		reference := values["reference"]
+		if reference == "" {
+			reference = values["invoice_number"]
+		}
+		if reference == "" {
+			reference = fmt.Sprintf("row %d", line)
+		}
+		group, ok := byReference[reference]
+		if !ok {
+			group = &importGroup{reference: reference}
+			byReference[reference] = group
+			groups = append(groups, group)
+		}
+		group.rows = append(group.rows, line)
+		group.values = append(group.values, values)
+	}
+	if rowCount == 0 {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "the file has no rows to import"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return nil, 0, false
+	}
+	return groups, rowCount, true
+}
+
+// buildImportedInvoice validates the rows of an invoice and makes the invoice, as sent on its issue date
+// and with the payment made before the import applied
+func buildImportedInvoice(group *importGroup, now time.Time) (*models.Invoice, []ImportRowError) {
+	var rowErrors []ImportRowError
+	first := group.values[0]
+	firstRow := group.rows[0]
+	addError := func(row int, field string, detail string) {
+		rowErrors = append(rowErrors, ImportRowError{Row: row, Field: field, Detail: detail})
+	}
+	parseDate := func(field string) *time.Time {
+		if first[field] == "" {
+			return nil
+		}
+		date, err := time.Parse("2006-01-02", first[field])
+		if err != nil {
+			addError(firstRow, field, "must be a date format, eg: 2006-01-02")
+			return nil
+		}
+		return &date
+	}
+	parseAmount := func(field string) float64 {
+		if first[field] == "" {
+			return 0
+		}
+		amount, err := strconv.ParseFloat(first[field], 64)
+		if err != nil || amount < 0 {
+			addError(firstRow, field, "must be a positive number")
+			return 0
+		}
+		return amount
+	}
+
+	// Invoice fields are given once, the other rows of the invoice may repeat them
+	for i := 1; i < len(group.values); i++ {
+		for _, field := range importInvoiceFields {
+			if value := group.values[i][field]; value != "" && value != first[field] {
+				addError(group.rows[i], field, fmt.Sprintf("differs from row %d of the same invoice", firstRow))
+			}
+		}
+	}
+
+	customerInfo := models.CustomerInfo{Name: first["customer_name"], Email: first["customer_email"], PhoneNumber: first["customer_phone"]}
+	if customerInfo.Name == "" {
+		addError(firstRow, "customer_name", "is required")
+	}
+	if customerInfo.Email == "" {
+		addError(firstRow, "customer_email", "is required")
+	} else if validator.New().Var(customerInfo.Email, "email") != nil {
+		addError(firstRow, "customer_email", "is not a valid email")
+	}
+
+	dueDate := parseDate("due_date")
+	if dueDate == nil && first["due_date"] == "" {
+		addError(firstRow, "due_date", "is required")
+	}
+	// Without an issue date historic invoices count as issued on their due date
+	issuedAt := parseDate("issue_date")
+	if issuedAt == nil && dueDate != nil && dueDate.Before(now) {
+		issued := *dueDate
+		issuedAt = &issued
+	} else if issuedAt == nil {
+		issuedAt = &now
+	}
+	if dueDate != nil && dueDate.Before(time.Date(issuedAt.Year(), issuedAt.Month(), issuedAt.Day(), 0, 0, 0, 0, dueDate.Location())) {
+		addError(firstRow, "due_date", "can not be before the issue date")
+	}
+
+	var items []models.Item
+	var totalAmount float64
+	for i, values := range group.values {
+		item := models.Item{Name: values["item_name"]}
+		if item.Name == "" {
+			addError(group.rows[i], "item_name", "is required")
+		}
+		quantity, err := strconv.Atoi(values["item_quantity"])
+		if err != nil || quantity <= 0 {
+			addError(group.rows[i], "item_quantity", "must be a whole number above 0")
+		}
+		unitPrice, err := strconv.ParseFloat(values["item_unit_price"], 64)
+		if err != nil || unitPrice < 0 {
+			addError(group.rows[i], "item_unit_price", "must be a positive number")
+		}
+		item.Quantity = quantity
+		item.UnitPrice = unitPrice
+		items = append(items, item)
+		totalAmount += float64(quantity) * unitPrice
+	}
+
+	discountPercentage := parseAmount("discount_percentage")
+	if discountPercentage > 100 {
+		addError(firstRow, "discount_percentage", "can not be more than 100")
+	}
+	paidAmount := parseAmount("paid_amount")
+	paidAt := parseDate("paid_date")
+	if paidAt == nil {
+		paidAt = issuedAt
+	} else if paidAt.After(now) {
+		addError(firstRow, "paid_date", "can not be in the future")
+	}
+	if len(rowErrors) > 0 {
+		return nil, rowErrors
+	}
+
+	// Apply discount if applicable
+	if discountPercentage > 0 {
+		totalAmount -= ((totalAmount * discountPercentage) / 100)
+	}
+	itemsJSON, _ := json.Marshal(items)
+	customerInfoJSON, _ := json.Marshal(customerInfo)
+	invoiceHistoryJSON, _ := json.Marshal([]models.InvoiceHistory{
+		{Action: models.SENT, ActionDate: *issuedAt},
+		{Action: models.IMPORTED, ActionDate: now, Note: "Imported from CSV"},
+	})
+
+	invoice := models.Invoice{
+		InvoiceID:          uuid.New(),
+		DueDate:            dueDate,
+		IssuedAt:           issuedAt,
+		SentAt:             issuedAt,
+		Description:        first["description"],
+		Note:               first["note"],
+		Amount:             totalAmount,
+		Status:             models.SENT,
+		Items:              itemsJSON,
+		Reminders:          json.RawMessage("[]"),
+		CustomerInfo:       customerInfoJSON,
+		IsDiscount:         discountPercentage > 0,
+		DiscountPercentage: discountPercentage,
+		// Hard coded for proof of work
+		CreatedBy:      1,
+		InvoiceHistory: invoiceHistoryJSON,
+	}
+	if first["invoice_number"] != "" {
+		number := first["invoice_number"]
+		invoice.InvoiceNumber = &number
+	}
+	models.RecalculateOutstanding(&invoice)
+	if paidAmount > 0 {
+		err := models.ApplyPayment(&invoice, paidAmount, *paidAt)
+		if errors.Is(err, models.ErrOverpayment) {
+			return nil, []ImportRowError{{Row: firstRow, Field: "paid_amount", Detail: "is greater than the invoice total"}}
+		}
+	}
+	models.RefreshOverdueStatus(&invoice, now)
+	return &invoice, nil
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-playground/validator/v10"
+	"io/ioutil"
+	"net/http"
+	"numerisTask/events"
+	"numerisTask/models"
+	"time"
+)
+
+type InstallmentPayload struct {
+	Amount  float64 `json:"amount" validate:"gt=0"`
+	DueDate string  `json:"due_date" validate:"required,datetime=2006-01-02"`
+}
+
+type SetInstallmentsPayload struct {
+	Installments []InstallmentPayload `json:"installments" validate:"required,min=2,dive"`
+}
+
+// GET INSTALLMENTS of an invoice
+func GetInvoiceInstallments(writer http.ResponseWriter, request *http.Request) {
+	invoice, ok := findInvoice(writer, request)
+	if !ok {
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	installmentsJson, _ := json.Marshal(models.Installments(invoice))
+	writer.Write(installmentsJson)
+}
+
+// SET INSTALLMENTS, replaces the installment plan of an invoice
+func SetInvoiceInstallments(writer http.ResponseWriter, request *http.Request) {
+	invoice, ok := findInvoice(writer, request)
+	if !ok {
+		return
+	}
+
+	body, _ := ioutil.ReadAll(request.Body)
+	var payload SetInstallmentsPayload
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "installments body not valid"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return
+	}
+	//validating the playload
+	validate := validator.New()
+	err = validate.Struct(payload)
+	if err != nil {
+		validationError := err.(validator.ValidationErrors)
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": validationError.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	if invoice.Status == models.CANCELED || invoice.OutstandingAmount <= 0 {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "installments can only be set on unpaid invoices"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusConflict)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	// Due dates have to be in the future and in order
+	installments := make([]models.Installment, len(payload.Installments))
+	previous := time.Now()
+	for i, installment := range payload.Installments {
+		dueDate, _ := time.Parse("2006-01-02", installment.DueDate)
+		if !dueDate.After(previous) {
+			jsonResponse, _ := json.Marshal(map[string]string{"detail": "installment due dates must be in the future and in ascending order"})
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write(jsonResponse)
+			return
+		}
+		previous = dueDate
+		installments[i] = models.Installment{
+			Amount:  installment.Amount,
+			DueDate: dueDate,
+		}
+	}
+
+	previousStatus := invoice.Status
+	invoice, err = models.SetInstallments(invoice.InvoiceID.String(), installments)
+	if errors.Is(err, models.ErrInstallmentsTotal) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": err.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "installments could not be saved"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	if invoice.Status != previousStatus && (invoice.Status == models.OVERDUE || previousStatus == models.OVERDUE) {
+		events.Publish(models.OverdueEvent(invoice, previousStatus))
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	invoiceJson, _ := json.Marshal(invoice)
+	writer.Write(invoiceJson)
+}
+
+// REMOVE INSTALLMENTS, the invoice keeps the last installment due date
+func RemoveInvoiceInstallments(writer http.ResponseWriter, request *http.Request) {
+	invoice, ok := findInvoice(writer, request)
+	if !ok {
+		return
+	}
+	if invoice.HasInstallments {
+		_, err := models.SetInstallments(invoice.InvoiceID.String(), nil)
+		if err != nil {
+			jsonResponse, _ := json.Marshal(map[string]string{"detail": "installments could not be removed"})
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusInternalServerError)
+			writer.Write(jsonResponse)
+			return
+		}
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
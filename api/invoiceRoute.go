@@ -2,44 +2,106 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 	"io/ioutil"
 	"net/http"
+	"numerisTask/events"
+	"numerisTask/mailer"
 	"numerisTask/models"
+	"numerisTask/render"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
+// Drafts (status DRAFT) can leave out the due date, items, customer and reminders, they are checked when finalizing
 type CreateInvoicePayload struct {
-	DueDate            string              `json:"due_date" validate:"required,datetime=2006-01-02"`
+	DueDate            string              `json:"due_date" validate:"required_unless=Status DRAFT,omitempty,datetime=2006-01-02"`
 	Description        string              `json:"description,omitempty"`
-	Status             models.Status       `json:"status"`
-	Items              []models.Item       `json:"items" validate:"required,dive"`
-	CustomerInfo       models.CustomerInfo `json:"customer_info" validate:"required"`
+	Status             models.Status       `json:"status" validate:"omitempty,oneof=DRAFT CREATED"`
+	Items              []models.Item       `json:"items" validate:"required_unless=Status DRAFT,dive"`
+	CustomerInfo       models.CustomerInfo `json:"customer_info"` // Name and email, unless a draft
 	IsDiscount         bool                `json:"is_discount,omitempty"`
 	DiscountPercentage float64             `json:"discount_percentage,omitempty" validate:"omitempty,gte=0,lte=100"`
-	Reminder           []models.Reminder   `json:"reminder" validate:"required,dive"`
+	Reminder           []models.Reminder   `json:"reminder" validate:"required_unless=Status DRAFT,dive"`
+	// Optional, eg: {"percentage": 2, "days": 10} for 2/10
+	EarlyPaymentDiscount *models.EarlyPaymentTerms `json:"early_payment_discount,omitempty" validate:"omitempty"`
 }
 
 type UpdateInvoicePayload struct {
 	DueDate            *string              `json:"due_date,omitempty" validate:"omitempty,datetime=2006-01-02"`
 	Description        *string              `json:"description,omitempty"`
 	Amount             *float64             `json:"amount,omitempty" validate:"omitempty,gt=0"`
-	Status             *models.Status       `json:"status,omitempty" validate:"omitempty,oneof=paid unpaid draft overdue"`
+	Status             *models.Status       `json:"status,omitempty" validate:"omitempty,oneof=DRAFT CREATED SENT CANCELED"` // Payment statuses and OVERDUE are worked out, not set
 	Items              *[]models.Item       `json:"items,omitempty" validate:"omitempty,dive"`
-	CustomerInfo       *models.CustomerInfo `json:"customer_info,omitempty" validate:"omitempty,dive"`
+	CustomerInfo       *models.CustomerInfo `json:"customer_info,omitempty" validate:"omitempty"`
+	Reminders          *[]models.Reminder   `json:"reminders,omitempty" validate:"omitempty,dive"` // Drafts only, set when creating otherwise
 	IsDiscount         *bool                `json:"is_discount,omitempty"`
 	DiscountPercentage *float64             `json:"discount_percentage,omitempty" validate:"omitempty,gte=0,lte=100"`
 	PaidAmount         *float64             `json:"paid_amount,omitempty" validate:"omitempty,gt=0"`
 	Note               *string              `json:"note" validate:"omitempty"`
 	IsSettled          *bool                `json:"is_settled,omitempty"`
 	IsShared           *bool                `json:"is_shared,omitempty"`
+	// Replaces the early payment discount terms, counted from the issue date
+	EarlyPaymentDiscount *models.EarlyPaymentTerms `json:"early_payment_discount,omitempty" validate:"omitempty"`
+	// Removes the early payment discount terms
+	RemoveEarlyPaymentDiscount *bool `json:"remove_early_payment_discount,omitempty"`
+}
+
+// fields lists the invoice fields the update changes, checked against the invoice state
+func (payload UpdateInvoicePayload) fields() []string {
+	var fields []string
+	set := map[string]bool{
+		"due_date":               payload.DueDate != nil,
+		"description":            payload.Description != nil,
+		"amount":                 payload.Amount != nil,
+		"status":                 payload.Status != nil,
+		"items":                  payload.Items != nil,
+		"customer_info":          payload.CustomerInfo != nil,
+		"reminders":              payload.Reminders != nil,
+		"is_discount":            payload.IsDiscount != nil,
+		"discount_percentage":    payload.DiscountPercentage != nil,
+		"paid_amount":            payload.PaidAmount != nil,
+		"note":                   payload.Note != nil,
+		"is_settled":             payload.IsSettled != nil,
+		"is_shared":              payload.IsShared != nil,
+		"early_payment_discount": payload.EarlyPaymentDiscount != nil || payload.RemoveEarlyPaymentDiscount != nil,
+	}
+	for field, isSet := range set {
+		if isSet {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return fields
 }
 
 // GET INVOICES Listed IN DESC Order By DEFAULT
 func GetInvoices(writer http.ResponseWriter, request *http.Request) {
+	params, ok := parsePagination(writer, request)
+	if !ok {
+		return
+	}
+	if !parseInvoiceFilters(writer, request, &params) {
+		return
+	}
+	invoices, _ := models.GetInvoices(params)
+	// Respond with JSON
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	invoiceJson, _ := json.Marshal(invoices)
+	writer.Write(invoiceJson)
+
+}
+
+// parsePagination reads limit and offset from the query, writing the error response if they are not numbers
+func parsePagination(writer http.ResponseWriter, request *http.Request) (models.InvoiceQueryParams, bool) {
 	var errResponse map[string]string
 	// Extract query parameters
 	limitStr := request.URL.Query().Get("limit")
@@ -57,7 +119,7 @@ func GetInvoices(writer http.ResponseWriter, request *http.Request) {
 			writer.Header().Set("Content-Type", "application/json")
 			writer.WriteHeader(http.StatusBadRequest)
 			writer.Write(jsonResponse)
-			return
+			return models.InvoiceQueryParams{}, false
 		}
 	}
 
@@ -71,17 +133,47 @@ func GetInvoices(writer http.ResponseWriter, request *http.Request) {
 			writer.Header().Set("Content-Type", "application/json")
 			writer.WriteHeader(http.StatusBadRequest)
 			writer.Write(jsonResponse)
-			return
+			return models.InvoiceQueryParams{}, false
 		}
 	}
-	params := models.InvoiceQueryParams{limit, offset}
-	invoices, _ := models.GetInvoices(params)
-	// Respond with JSON
-	writer.Header().Set("Content-Type", "application/json")
-	writer.WriteHeader(http.StatusOK)
-	invoiceJson, _ := json.Marshal(invoices)
-	writer.Write(invoiceJson)
+	return models.InvoiceQueryParams{Limit: limit, Offset: offset}, true
+}
 
+// parseInvoiceFilters reads the list filters from the query: status (comma separated), customer (email),
+// issued_from and issued_to (dates, inclusive)
+func parseInvoiceFilters(writer http.ResponseWriter, request *http.Request, params *models.InvoiceQueryParams) bool {
+	query := request.URL.Query()
+	if statuses := query.Get("status"); statuses != "" {
+		validate := validator.New()
+		for _, status := range strings.Split(statuses, ",") {
+			status = strings.TrimSpace(status)
+			if validate.Var(status, "oneof=DRAFT PENDING_APPROVAL CREATED SENT OVERDUE PARTIAL_PAYMENT FULL_PAYMENT CREDITED CANCELED WRITTEN_OFF") != nil {
+				jsonResponse, _ := json.Marshal(map[string]string{"detail": fmt.Sprintf("%s is not an invoice status", status)})
+				writer.Header().Set("Content-Type", "application/json")
+				writer.WriteHeader(http.StatusBadRequest)
+				writer.Write(jsonResponse)
+				return false
+			}
+			params.Statuses = append(params.Statuses, models.Status(status))
+		}
+	}
+	params.Customer = strings.TrimSpace(query.Get("customer"))
+
+	for name, date := range map[string]**time.Time{"issued_from": &params.IssuedFrom, "issued_to": &params.IssuedTo} {
+		if query.Get(name) == "" {
+			continue
+		}
+		parsed, err := time.Parse("2006-01-02", query.Get(name))
+		if err != nil {
+			jsonResponse, _ := json.Marshal(map[string]string{"detail": name + " must be a date format, eg: 2006-01-02"})
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write(jsonResponse)
+			return false
+		}
+		*date = &parsed
+	}
+	return true
 }
 
 // GET INVOICE By InvoiceID
@@ -139,25 +231,34 @@ func CreateInvoice(writer http.ResponseWriter, request *http.Request) {
 
 	}
 
+	status := models.CREATED
+	if payload.Status == models.DRAFT {
+		status = models.DRAFT
+	}
+
 	//Check to see that DueDate is in the future
 	//If due date is today should it be stored. what does that mean for the reminders
-	dueDate, err := time.Parse("2006-01-02", payload.DueDate)
-	if err != nil {
-		jsonResponse, _ := json.Marshal(map[string]string{"detail": "due_date must be a date format, eg: 2006-01-02"})
-		writer.Header().Set("Content-Type", "application/json")
-		writer.WriteHeader(http.StatusBadRequest)
-		writer.Write(jsonResponse)
-		return
-	}
 	today := time.Now()
+	var dueDate *time.Time
+	if payload.DueDate != "" {
+		parsed, err := time.Parse("2006-01-02", payload.DueDate)
+		if err != nil {
+			jsonResponse, _ := json.Marshal(map[string]string{"detail": "due_date must be a date format, eg: 2006-01-02"})
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write(jsonResponse)
+			return
+		}
+		// A draft may keep a due date that has passed, it is checked again when finalizing
+		if status != models.DRAFT && (today.After(parsed) || today.Equal(parsed)) {
+			jsonResponse, _ := json.Marshal(map[string]string{"detail": "due_date can not be today or be in the past"})
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write(jsonResponse)
+			return
 
-	if today.After(dueDate) || today.Equal(dueDate) {
-		jsonResponse, _ := json.Marshal(map[string]string{"detail": "due_date can not be today or be in the past"})
-		writer.Header().Set("Content-Type", "application/json")
-		writer.WriteHeader(http.StatusBadRequest)
-		writer.Write(jsonResponse)
-		return
-
+		}
+		dueDate = &parsed
 	}
 
 	// Calculate the total amount by iterating over the items
@@ -173,10 +274,14 @@ func CreateInvoice(writer http.ResponseWriter, request *http.Request) {
 
 	// Marshal CustomerInfo into JSON
 	customerInfoJSON, _ := json.Marshal(payload.CustomerInfo)
+	remindersJSON, _ := json.Marshal(payload.Reminder)
+	if payload.Reminder == nil {
+		remindersJSON = json.RawMessage("[]")
+	}
 
 	// Marshal InvoiceHistory into JSON
 	invoiceHistory := []models.InvoiceHistory{{
-		Action:     models.CREATED,
+		Action:     status,
 		ActionDate: time.Now(),
 	}}
 	invoiceHistoryJSON, _ := json.Marshal(invoiceHistory)
@@ -188,8 +293,9 @@ func CreateInvoice(writer http.ResponseWriter, request *http.Request) {
 		DueDate:            dueDate,
 		Description:        payload.Description,
 		Amount:             totalAmount,
-		Status:             models.CREATED,
+		Status:             status,
 		Items:              itemsJSON,
+		Reminders:          remindersJSON,
 		CustomerInfo:       customerInfoJSON,
 		IsDiscount:         payload.IsDiscount,
 		DiscountPercentage: payload.DiscountPercentage,
@@ -198,8 +304,30 @@ func CreateInvoice(writer http.ResponseWriter, request *http.Request) {
 		OutstandingAmount: totalAmount, // Set outstanding amount to total initially
 		InvoiceHistory:    invoiceHistoryJSON,
 	}
+	models.RecalculateOutstanding(&invoice)
+	if payload.EarlyPaymentDiscount != nil {
+		models.SetEarlyPaymentTerms(&invoice, payload.EarlyPaymentDiscount, today)
+		if dueDate != nil && !invoice.EarlyPaymentDeadline.Before(*dueDate) {
+			jsonResponse, _ := json.Marshal(map[string]string{"detail": "early_payment_discount days must end before the due_date"})
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write(jsonResponse)
+			return
+		}
+	}
 
-	err = models.CreateInvoice(invoice)
+	// Drafts are checked when finalized, anything else has to be complete now
+	if status != models.DRAFT {
+		if err = models.CheckIssuable(&invoice, time.Now()); err != nil {
+			jsonResponse, _ := json.Marshal(map[string]string{"detail": err.Error()})
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write(jsonResponse)
+			return
+		}
+	}
+
+	err = models.CreateInvoice(&invoice)
 
 	if err != nil {
 		jsonResponse, _ := json.Marshal(map[string]string{"detail": "invoice creation error"})
@@ -216,6 +344,13 @@ func CreateInvoice(writer http.ResponseWriter, request *http.Request) {
 
 }
 
+// updateError is an edit the request asks for that can't be made, answered with a 400 and the message
+type updateError string
+
+func (err updateError) Error() string {
+	return string(err)
+}
+
 // UPDATE INVOICE
 func UpdateInvoice(writer http.ResponseWriter, request *http.Request) {
 
@@ -257,66 +392,39 @@ func UpdateInvoice(writer http.ResponseWriter, request *http.Request) {
 
 	}
 
-	oldInvoice, err := models.GetInvoiceByID(invoiceIdParam)
-
-	if err != nil {
-		jsonResponse, _ := json.Marshal(map[string]string{"detail": "invoice not found"})
-		writer.Header().Set("Content-Type", "application/json")
-		writer.WriteHeader(http.StatusNotFound)
-		writer.Write(jsonResponse)
-		return
-
-	}
-
-	if invoicePayload.DueDate != nil {
-		dueDate, err := time.Parse("2006-01-02", *invoicePayload.DueDate)
-
-		if oldInvoice.DueDate != dueDate {
-			today := time.Now()
-			if err != nil {
-				jsonResponse, _ := json.Marshal(map[string]string{"detail": "due_date must be a date format, eg: 2006-01-02"})
-				writer.Header().Set("Content-Type", "application/json")
-				writer.WriteHeader(http.StatusBadRequest)
-				writer.Write(jsonResponse)
-				return
-			}
-			if today.After(dueDate) || today.Equal(dueDate) {
-				jsonResponse, _ := json.Marshal(map[string]string{"detail": "due_date can not be today or be in the past"})
-				writer.Header().Set("Content-Type", "application/json")
-				writer.WriteHeader(http.StatusBadRequest)
-				writer.Write(jsonResponse)
-				return
-
-			}
-			oldInvoice.DueDate = dueDate
+	// The edits are made on the invoice locked for the update, so a payment or a job can't slip in between
+	var previousStatus models.Status
+	var statusChanged bool
+	updatedInvoice, err := models.UpdateInvoice(invoiceIdParam, func(oldInvoice *models.Invoice) error {
+		// What can change depends on the invoice state, and on the state the update moves it to
+		var targetStatus models.Status
+		if invoicePayload.Status != nil {
+			targetStatus = *invoicePayload.Status
+		}
+		err := models.CheckInvoiceEdit(oldInvoice, invoicePayload.fields(), targetStatus)
+		if err != nil {
+			return err
 		}
 
-	}
-	if invoicePayload.Status != nil {
-		if oldInvoice.Status != *invoicePayload.Status {
-			oldInvoice.Status = *invoicePayload.Status
+		if invoicePayload.DueDate != nil {
+			dueDate, err := time.Parse("2006-01-02", *invoicePayload.DueDate)
 
-			// Marshal InvoiceHistory into JSON
-			newInvoiceHistory := models.InvoiceHistory{
-				Action:     *invoicePayload.Status,
-				ActionDate: time.Now(),
+			if oldInvoice.DueDate == nil || !oldInvoice.DueDate.Equal(dueDate) {
+				today := time.Now()
+				if err != nil {
+					return updateError("due_date must be a date format, eg: 2006-01-02")
+				}
+				if today.After(dueDate) || today.Equal(dueDate) {
+					return updateError("due_date can not be today or be in the past")
+				}
+				oldInvoice.DueDate = &dueDate
 			}
-			// Unmarshal old history into a slice
-			var existingHistory []models.InvoiceHistory
-			_ = json.Unmarshal(oldInvoice.InvoiceHistory, &existingHistory)
-
-			existingHistory = append(existingHistory, newInvoiceHistory)
-
-			invoiceHistoryJSON, _ := json.Marshal(existingHistory)
-			oldInvoice.InvoiceHistory = invoiceHistoryJSON
 
 		}
 
-	}
-
-	// Calculate the total amount by iterating over the items
-	var totalAmount float64
-	if oldInvoice.Status != models.PARTIALPAYMENT || oldInvoice.Status != models.FULLPAYMENT {
+		// Calculate the total amount by iterating over the items
+		// The state machine only lets items change before the invoice is sent, adjustments after that go through credit notes
+		var totalAmount float64
 		if invoicePayload.Items != nil {
 
 			for _, item := range *invoicePayload.Items {
@@ -340,75 +448,95 @@ func UpdateInvoice(writer http.ResponseWriter, request *http.Request) {
 				totalAmount -= ((totalAmount * discountPercentage) / 100)
 			}
 			oldInvoice.Amount = totalAmount
+			models.RecalculateOutstanding(oldInvoice)
 
 			itemsJSON, _ := json.Marshal(*invoicePayload.Items)
 
 			oldInvoice.Items = itemsJSON
 
 		}
-	}
-	if invoicePayload.CustomerInfo != nil {
-		// Marshal CustomerInfo into JSON
-		customerInfoJSON, _ := json.Marshal(*invoicePayload.CustomerInfo)
-		oldInvoice.CustomerInfo = customerInfoJSON
-	}
-
-	if invoicePayload.PaidAmount != nil && oldInvoice.OutstandingAmount > 0 {
-
-		var PaymentHistory []models.PaymentHistory
-
-		_ = json.Unmarshal(oldInvoice.PaymentHistory, &PaymentHistory)
-		if *invoicePayload.PaidAmount > oldInvoice.OutstandingAmount {
-			jsonResponse, _ := json.Marshal(map[string]string{"detail": "paid_amount is greater than the outstanding amount"})
-			writer.Header().Set("Content-Type", "application/json")
-			writer.WriteHeader(http.StatusBadRequest)
-			writer.Write(jsonResponse)
-			return
+		if invoicePayload.CustomerInfo != nil {
+			// Marshal CustomerInfo into JSON
+			customerInfoJSON, _ := json.Marshal(*invoicePayload.CustomerInfo)
+			oldInvoice.CustomerInfo = customerInfoJSON
 		}
-		oldInvoice.OutstandingAmount -= *invoicePayload.PaidAmount
-		PaymentHistory = append(PaymentHistory, models.PaymentHistory{
-			AmountPaid:    *invoicePayload.PaidAmount,
-			AmountBalance: oldInvoice.OutstandingAmount,
-			DatePaid:      time.Now(),
-		})
-
-		oldInvoice.PaymentHistory, _ = json.Marshal(PaymentHistory)
-		var action models.Status
-		if oldInvoice.OutstandingAmount == 0 {
-			action = models.FULLPAYMENT
-		} else if oldInvoice.OutstandingAmount > 0 {
-			action = models.PARTIALPAYMENT
+		if invoicePayload.Reminders != nil {
+			remindersJSON, _ := json.Marshal(*invoicePayload.Reminders)
+			oldInvoice.Reminders = remindersJSON
 		}
-		// Marshal InvoiceHistory into JSON
-		newInvoiceHistory := models.InvoiceHistory{
-			Action:     action,
-			ActionDate: time.Now(),
+
+		// A new total or customer can fall under an approval rule, the invoice then waits for approval before it is sent
+		_, err = models.RequestApproval(oldInvoice)
+		if err != nil {
+			return err
+		}
+		if invoicePayload.Status != nil {
+			err = models.TransitionInvoice(oldInvoice, *invoicePayload.Status)
+			if err != nil {
+				return err
+			}
 		}
-		oldInvoice.Status = action
-		// Unmarshal old history into a slice
-		var existingHistory []models.InvoiceHistory
-		_ = json.Unmarshal(oldInvoice.InvoiceHistory, &existingHistory)
 
-		existingHistory = append(existingHistory, newInvoiceHistory)
+		if invoicePayload.RemoveEarlyPaymentDiscount != nil && *invoicePayload.RemoveEarlyPaymentDiscount {
+			models.SetEarlyPaymentTerms(oldInvoice, nil, oldInvoice.IssueDate())
+		} else if invoicePayload.EarlyPaymentDiscount != nil {
+			models.SetEarlyPaymentTerms(oldInvoice, invoicePayload.EarlyPaymentDiscount, oldInvoice.IssueDate())
+			if oldInvoice.DueDate != nil && !oldInvoice.EarlyPaymentDeadline.Before(*oldInvoice.DueDate) {
+				return updateError("early_payment_discount days must end before the due_date")
+			}
+		}
 
-		invoiceHistoryJSON, _ := json.Marshal(existingHistory)
-		oldInvoice.InvoiceHistory = invoiceHistoryJSON
+		if invoicePayload.PaidAmount != nil && oldInvoice.OutstandingAmount > 0 {
+			err = models.ApplyPayment(oldInvoice, *invoicePayload.PaidAmount, time.Now())
+			if err != nil {
+				return updateError(err.Error())
+			}
+		}
 
-	}
+		if invoicePayload.IsSettled != nil {
+			oldInvoice.IsSettled = *invoicePayload.IsSettled
 
-	if invoicePayload.IsSettled != nil {
-		oldInvoice.IsSettled = *invoicePayload.IsSettled
+		}
+		if invoicePayload.IsShared != nil && *invoicePayload.IsShared != oldInvoice.IsShared {
+			if *invoicePayload.IsShared {
+				err = models.ShareInvoice(oldInvoice, nil)
+				if err != nil {
+					return err
+				}
+			} else {
+				models.RevokeInvoiceShare(oldInvoice)
+			}
+		}
+		if invoicePayload.Note != nil {
+			oldInvoice.Note = *invoicePayload.Note
+		}
+		// An extended due date takes the invoice out of OVERDUE
+		previousStatus, statusChanged = models.RefreshOverdueStatus(oldInvoice, time.Now())
+		return nil
+	})
 
+	var invalidUpdate updateError
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "invoice not found"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusNotFound)
+		writer.Write(jsonResponse)
+		return
 	}
-	if invoicePayload.IsShared != nil {
-		oldInvoice.IsShared = *invoicePayload.IsShared
+	if errors.Is(err, models.ErrFieldNotEditable) || errors.Is(err, models.ErrInvalidTransition) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": err.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusConflict)
+		writer.Write(jsonResponse)
+		return
 	}
-	if invoicePayload.Note != nil {
-		oldInvoice.Note = *invoicePayload.Note
+	if errors.As(err, &invalidUpdate) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": invalidUpdate.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
 	}
-	updatedInvoice := *oldInvoice
-	err = models.UpdateInvoice(updatedInvoice)
-
 	if err != nil {
 		jsonResponse, _ := json.Marshal(map[string]string{"detail": "invoice update error"})
 		writer.Header().Set("Content-Type", "application/json")
@@ -416,6 +544,9 @@ func UpdateInvoice(writer http.ResponseWriter, request *http.Request) {
 		writer.Write(jsonResponse)
 		return
 	}
+	if statusChanged {
+		events.Publish(models.OverdueEvent(updatedInvoice, previousStatus))
+	}
 
 	writer.Header().Set("Content-Type", "application/json")
 	writer.WriteHeader(http.StatusOK)
@@ -434,3 +565,261 @@ func GetInvoiceDashBoard(writer http.ResponseWriter, request *http.Request) {
 	writer.Write(invoiceJson)
 
 }
+
+// GET INVOICE PDF, of a past revision with ?revision=
+func GetInvoicePDF(writer http.ResponseWriter, request *http.Request) {
+	invoice, ok := findInvoice(writer, request)
+	if !ok {
+		return
+	}
+	invoice, ok = requestedRevision(writer, request, invoice)
+	if !ok {
+		return
+	}
+	document, err := render.InvoicePDF(invoice)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "invoice could not be rendered"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/pdf")
+	writer.Header().Set("Content-Disposition", "inline; filename=\"invoice-"+invoice.InvoiceID.String()+".pdf\"")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(document)
+}
+
+// findInvoice loads the invoice in the URL, writing the error response if it can't
+func findInvoice(writer http.ResponseWriter, request *http.Request) (*models.Invoice, bool) {
+	invoiceIdParam := chi.URLParam(request, "invoiceId")
+	_, err := uuid.Parse(invoiceIdParam)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "invoiceId is not a valid uuid"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return nil, false
+	}
+
+	invoice, err := models.GetInvoiceByID(invoiceIdParam)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "invoice not found"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusNotFound)
+		writer.Write(jsonResponse)
+		return nil, false
+	}
+	return invoice, true
+}
+
+// SEND INVOICE to the customer by email
+func SendInvoice(writer http.ResponseWriter, request *http.Request) {
+	invoice, ok := findInvoice(writer, request)
+	if !ok {
+		return
+	}
+	if invoice.Status == models.DRAFT || invoice.Status == models.CANCELED {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": fmt.Sprintf("a %s invoice can not be sent", invoice.Status)})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusConflict)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	// Rules added since the invoice was issued apply too, it is held for approval instead of going out
+	invoice, err := models.UpdateInvoice(invoice.InvoiceID.String(), func(invoice *models.Invoice) error {
+		_, err := models.RequestApproval(invoice)
+		return err
+	})
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "approval rules could not be checked"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	if invoice.Status == models.PENDINGAPPROVAL {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "the invoice needs to be approved before it can be sent"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusConflict)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	err = mailer.SendInvoice(invoice)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "invoice could not be sent: " + err.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadGateway)
+		writer.Write(jsonResponse)
+		return
+	}
+	err = models.MarkInvoiceSent(invoice)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "invoice update error"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	invoiceJson, _ := json.Marshal(invoice)
+	writer.Write(invoiceJson)
+}
+
+type InvoiceTransitionsResponse struct {
+	Status         models.Status       `json:"status"`
+	Transitions    []models.Transition `json:"transitions"`
+	EditableFields []string            `json:"editable_fields"`
+}
+
+// GET INVOICE TRANSITIONS, the statuses the invoice can move to next and what can still be edited
+func GetInvoiceTransitions(writer http.ResponseWriter, request *http.Request) {
+	invoice, ok := findInvoice(writer, request)
+	if !ok {
+		return
+	}
+	response := InvoiceTransitionsResponse{
+		Status:         invoice.Status,
+		Transitions:    models.InvoiceTransitions(invoice),
+		EditableFields: models.EditableFields(invoice),
+	}
+	if response.Transitions == nil {
+		response.Transitions = []models.Transition{}
+	}
+	if response.EditableFields == nil {
+		response.EditableFields = []string{}
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	transitionsJson, _ := json.Marshal(response)
+	writer.Write(transitionsJson)
+}
+
+// FINALIZE INVOICE, issues a draft once everything needed is filled in
+func FinalizeInvoice(writer http.ResponseWriter, request *http.Request) {
+	invoice, ok := findInvoice(writer, request)
+	if !ok {
+		return
+	}
+	invoice, err := models.FinalizeInvoice(invoice.InvoiceID.String())
+	if errors.Is(err, models.ErrInvalidTransition) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": err.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusConflict)
+		writer.Write(jsonResponse)
+		return
+	}
+	if errors.Is(err, models.ErrIncompleteInvoice) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": err.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "invoice could not be finalized"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	invoiceJson, _ := json.Marshal(invoice)
+	writer.Write(invoiceJson)
+}
+
+// Everything is optional, left out fields are copied from the original invoice
+type DuplicateInvoicePayload struct {
+	DueDate            *string              `json:"due_date,omitempty" validate:"omitempty,datetime=2006-01-02"`
+	Description        *string              `json:"description,omitempty"`
+	Note               *string              `json:"note,omitempty"`
+	CustomerInfo       *models.CustomerInfo `json:"customer_info,omitempty" validate:"omitempty"`
+	Reminders          *[]models.Reminder   `json:"reminders,omitempty" validate:"omitempty,dive"` // Drafts only, set when creating otherwise
+	Items              *[]models.Item       `json:"items,omitempty" validate:"omitempty,dive"`
+	Reminder           *[]models.Reminder   `json:"reminder,omitempty" validate:"omitempty,dive"`
+	IsDiscount         *bool                `json:"is_discount,omitempty"`
+	DiscountPercentage *float64             `json:"discount_percentage,omitempty" validate:"omitempty,gte=0,lte=100"`
+}
+
+// DUPLICATE INVOICE, a new draft copied from an invoice
+func DuplicateInvoice(writer http.ResponseWriter, request *http.Request) {
+	invoice, ok := findInvoice(writer, request)
+	if !ok {
+		return
+	}
+
+	var payload DuplicateInvoicePayload
+	body, _ := ioutil.ReadAll(request.Body)
+	if len(body) > 0 {
+		err := json.Unmarshal(body, &payload)
+		if err != nil {
+			jsonResponse, _ := json.Marshal(map[string]string{"detail": "duplicate body not valid"})
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusUnprocessableEntity)
+			writer.Write(jsonResponse)
+			return
+		}
+	}
+	//validating the playload
+	validate := validator.New()
+	err := validate.Struct(payload)
+	if err != nil {
+		validationError := err.(validator.ValidationErrors)
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": validationError.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	duplicate := invoice.Duplicate(time.Now())
+	if payload.DueDate != nil {
+		dueDate, _ := time.Parse("2006-01-02", *payload.DueDate)
+		duplicate.DueDate = &dueDate
+	}
+	if payload.Description != nil {
+		duplicate.Description = *payload.Description
+	}
+	if payload.Note != nil {
+		duplicate.Note = *payload.Note
+	}
+	if payload.CustomerInfo != nil {
+		duplicate.CustomerInfo, _ = json.Marshal(*payload.CustomerInfo)
+	}
+	if payload.Reminder != nil {
+		duplicate.Reminders, _ = json.Marshal(*payload.Reminder)
+	}
+	if payload.IsDiscount != nil {
+		duplicate.IsDiscount = *payload.IsDiscount
+	}
+	if payload.DiscountPercentage != nil {
+		duplicate.DiscountPercentage = *payload.DiscountPercentage
+	}
+	if payload.Items != nil {
+		duplicate.Items, _ = json.Marshal(*payload.Items)
+	}
+	var items []models.Item
+	_ = json.Unmarshal(duplicate.Items, &items)
+	duplicate.Amount = models.CalculateItemsTotal(items, duplicate.IsDiscount, duplicate.DiscountPercentage)
+	models.RecalculateOutstanding(&duplicate)
+
+	err = models.CreateInvoice(&duplicate)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "invoice could not be duplicated"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusCreated)
+	invoiceJson, _ := json.Marshal(duplicate)
+	writer.Write(invoiceJson)
+}
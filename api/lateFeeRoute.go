@@ -0,0 +1,225 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"io/ioutil"
+	"net/http"
+	"numerisTask/models"
+)
+
+type LateFeePolicyPayload struct {
+	Type      models.LateFeeType `json:"type" validate:"required,oneof=FLAT PERCENTAGE DAILY_INTEREST MONTHLY_INTEREST"`
+	Amount    float64            `json:"amount" validate:"gt=0"` // Flat amount, or the rate in percent
+	Cap       float64            `json:"cap,omitempty" validate:"gte=0"`
+	GraceDays int                `json:"grace_days,omitempty" validate:"gte=0"`
+	Active    *bool              `json:"active,omitempty"`
+}
+
+type WaiveLateFeePayload struct {
+	Reason string `json:"reason" validate:"required"`
+}
+
+// GET LATE FEE POLICY of the organization
+func GetLateFeePolicy(writer http.ResponseWriter, request *http.Request) {
+	policy, err := models.GetOrganizationLateFeePolicy(models.PlaceHolderUser.ID)
+	writeLateFeePolicy(writer, policy, err)
+}
+
+// SAVE LATE FEE POLICY of the organization, applies to every invoice without its own policy
+func SaveLateFeePolicy(writer http.ResponseWriter, request *http.Request) {
+	policy, err := models.GetOrganizationLateFeePolicy(models.PlaceHolderUser.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		policy = &models.LateFeePolicy{PolicyID: uuid.New(), OrganizationID: models.PlaceHolderUser.ID}
+	} else if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "late fee policy could not be fetched"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	saveLateFeePolicy(writer, request, policy)
+}
+
+// GET LATE FEE POLICY set on an invoice
+func GetInvoiceLateFeePolicy(writer http.ResponseWriter, request *http.Request) {
+	invoice, ok := findInvoice(writer, request)
+	if !ok {
+		return
+	}
+	policy, err := models.GetInvoiceLateFeePolicy(invoice.InvoiceID)
+	writeLateFeePolicy(writer, policy, err)
+}
+
+// SAVE LATE FEE POLICY of an invoice, overriding the organization policy
+func SaveInvoiceLateFeePolicy(writer http.ResponseWriter, request *http.Request) {
+	invoice, ok := findInvoice(writer, request)
+	if !ok {
+		return
+	}
+	policy, err := models.GetInvoiceLateFeePolicy(invoice.InvoiceID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		policy = &models.LateFeePolicy{PolicyID: uuid.New(), OrganizationID: invoice.CreatedBy, InvoiceID: &invoice.InvoiceID}
+	} else if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "late fee policy could not be fetched"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	saveLateFeePolicy(writer, request, policy)
+}
+
+// WAIVE LATE FEE, the entry stays on the invoice marked as waived
+func WaiveLateFee(writer http.ResponseWriter, request *http.Request) {
+	invoiceIdParam := chi.URLParam(request, "invoiceId")
+	_, err := uuid.Parse(invoiceIdParam)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "invoiceId is not a valid uuid"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return
+	}
+	feeId, err := uuid.Parse(chi.URLParam(request, "feeId"))
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "feeId is not a valid uuid"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	body, _ := ioutil.ReadAll(request.Body)
+	var payload WaiveLateFeePayload
+	err = json.Unmarshal(body, &payload)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "waive body not valid"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return
+	}
+	//validating the playload
+	validate := validator.New()
+	err = validate.Struct(payload)
+	if err != nil {
+		validationError := err.(validator.ValidationErrors)
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": validationError.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	// Placeholder user standing in for the accountant
+	invoice, err := models.WaiveLateFee(invoiceIdParam, feeId, models.PlaceHolderUser.ID, payload.Reason)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "invoice not found"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusNotFound)
+		writer.Write(jsonResponse)
+		return
+	}
+	if errors.Is(err, models.ErrLateFeeNotFound) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": err.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusNotFound)
+		writer.Write(jsonResponse)
+		return
+	}
+	if errors.Is(err, models.ErrLateFeeNotWaivable) || errors.Is(err, models.ErrInvalidTransition) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": err.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusConflict)
+		writer.Write(jsonResponse)
+		return
+	}
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "late fee waive error"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	invoiceJson, _ := json.Marshal(invoice)
+	writer.Write(invoiceJson)
+}
+
+// saveLateFeePolicy fills the policy from the request body and saves it
+func saveLateFeePolicy(writer http.ResponseWriter, request *http.Request, policy *models.LateFeePolicy) {
+	body, _ := ioutil.ReadAll(request.Body)
+	var payload LateFeePolicyPayload
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "late fee policy body not valid"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return
+	}
+	//validating the playload
+	validate := validator.New()
+	err = validate.Struct(payload)
+	if err != nil {
+		validationError := err.(validator.ValidationErrors)
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": validationError.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+	if payload.Type != models.FLATFEE && payload.Amount > 100 {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "amount is a rate in percent and can not be more than 100"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	policy.Type = payload.Type
+	policy.Amount = payload.Amount
+	policy.Cap = payload.Cap
+	policy.GraceDays = payload.GraceDays
+	policy.Active = true
+	if payload.Active != nil {
+		policy.Active = *payload.Active
+	}
+	err = models.SaveLateFeePolicy(policy)
+	if errors.Is(err, models.ErrLateFeeConflict) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": err.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusConflict)
+		writer.Write(jsonResponse)
+		return
+	}
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "late fee policy save error"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	writeLateFeePolicy(writer, policy, nil)
+}
+
+func writeLateFeePolicy(writer http.ResponseWriter, policy *models.LateFeePolicy, err error) {
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "late fee policy not found"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusNotFound)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	policyJson, _ := json.Marshal(policy)
+	writer.Write(policyJson)
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"net/http"
+	"numerisTask/models"
+	"time"
+)
+
+// GET JOURNAL ENTRIES, newest first. Filtered by ?invoice_id=, ?bill_id= and ?source= (eg: PAYMENT)
+func GetJournalEntries(writer http.ResponseWriter, request *http.Request) {
+	pagination, ok := parsePagination(writer, request)
+	if !ok {
+		return
+	}
+	params := models.JournalQueryParams{
+		Limit:  pagination.Limit,
+		Offset: pagination.Offset,
+		Source: models.JournalSource(request.URL.Query().Get("source")),
+	}
+	for name, id := range map[string]**uuid.UUID{"invoice_id": &params.InvoiceID, "bill_id": &params.BillID} {
+		if request.URL.Query().Get(name) == "" {
+			continue
+		}
+		parsed, err := uuid.Parse(request.URL.Query().Get(name))
+		if err != nil {
+			jsonResponse, _ := json.Marshal(map[string]string{"detail": name + " is not a valid uuid"})
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write(jsonResponse)
+			return
+		}
+		*id = &parsed
+	}
+
+	entries, err := models.GetJournalEntries(params)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "journal entries could not be listed"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	entriesJson, _ := json.Marshal(entries)
+	writer.Write(entriesJson)
+}
+
+// GET CHART OF ACCOUNTS the ledger posts to
+func GetChartOfAccounts(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	accountsJson, _ := json.Marshal(models.ChartOfAccounts)
+	writer.Write(accountsJson)
+}
+
+// GET TRIAL BALANCE per currency, up to the end of ?as_of= (today by default). Debits equal credits when balanced.
+func GetTrialBalance(writer http.ResponseWriter, request *http.Request) {
+	asOf := time.Now()
+	if asOfParam := request.URL.Query().Get("as_of"); asOfParam != "" {
+		parsed, err := time.Parse("2006-01-02", asOfParam)
+		if err != nil {
+			jsonResponse, _ := json.Marshal(map[string]string{"detail": "as_of must be a date format, eg: 2006-01-02"})
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write(jsonResponse)
+			return
+		}
+		asOf = parsed.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+
+	trialBalances, err := models.GetTrialBalance(asOf)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "trial balance could not be worked out"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	trialBalancesJson, _ := json.Marshal(trialBalances)
+	writer.Write(trialBalancesJson)
+}
+
+// GET INVOICE LEDGER, the entries posted for the invoice and the balance the customer owes according to them
+func GetInvoiceLedger(writer http.ResponseWriter, request *http.Request) {
+	invoice, ok := findInvoice(writer, request)
+	if !ok {
+		return
+	}
+	ledger, err := models.GetInvoiceLedger(invoice)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "invoice ledger could not be listed"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	ledgerJson, _ := json.Marshal(ledger)
+	writer.Write(ledgerJson)
+}
@@ -0,0 +1,384 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"io/ioutil"
+	"net/http"
+	"numerisTask/mailer"
+	"numerisTask/models"
+	"time"
+)
+
+// CreateQuotePayload shares the item and discount model of CreateInvoicePayload
+type CreateQuotePayload struct {
+	ExpiryDate         string              `json:"expiry_date" validate:"required,datetime=2006-01-02"`
+	Description        string              `json:"description,omitempty"`
+	Items              []models.Item       `json:"items" validate:"required,dive"`
+	CustomerInfo       models.CustomerInfo `json:"customer_info" validate:"required"`
+	IsDiscount         bool                `json:"is_discount,omitempty"`
+	DiscountPercentage float64             `json:"discount_percentage,omitempty" validate:"omitempty,gte=0,lte=100"`
+	Note               string              `json:"note,omitempty"`
+}
+
+type UpdateQuotePayload struct {
+	ExpiryDate         *string              `json:"expiry_date,omitempty" validate:"omitempty,datetime=2006-01-02"`
+	Description        *string              `json:"description,omitempty"`
+	Items              *[]models.Item       `json:"items,omitempty" validate:"omitempty,dive"`
+	CustomerInfo       *models.CustomerInfo `json:"customer_info,omitempty"`
+	IsDiscount         *bool                `json:"is_discount,omitempty"`
+	DiscountPercentage *float64             `json:"discount_percentage,omitempty" validate:"omitempty,gte=0,lte=100"`
+	Note               *string              `json:"note,omitempty"`
+}
+
+type ConvertQuotePayload struct {
+	DueDate  string            `json:"due_date" validate:"required,datetime=2006-01-02"`
+	Reminder []models.Reminder `json:"reminder" validate:"dive"`
+}
+
+// CREATE QUOTE
+func CreateQuote(writer http.ResponseWriter, request *http.Request) {
+	body, _ := ioutil.ReadAll(request.Body)
+	var payload CreateQuotePayload
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "quote body not valid"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return
+	}
+	//validating the playload
+	validate := validator.New()
+	err = validate.Struct(payload)
+	if err != nil {
+		validationError := err.(validator.ValidationErrors)
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": validationError.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	expiryDate, _ := time.Parse("2006-01-02", payload.ExpiryDate)
+	if time.Now().After(expiryDate) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "expiry_date can not be today or be in the past"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	itemsJSON, _ := json.Marshal(payload.Items)
+	customerInfoJSON, _ := json.Marshal(payload.CustomerInfo)
+	quote := models.Quote{
+		QuoteID:            uuid.New(),
+		ExpiryDate:         expiryDate,
+		Description:        payload.Description,
+		Amount:             models.CalculateItemsTotal(payload.Items, payload.IsDiscount, payload.DiscountPercentage),
+		Status:             models.QUOTEDRAFT,
+		Items:              itemsJSON,
+		CustomerInfo:       customerInfoJSON,
+		IsDiscount:         payload.IsDiscount,
+		DiscountPercentage: payload.DiscountPercentage,
+		Note:               payload.Note,
+		// Hard coded for proof of work
+		CreatedBy: 1,
+	}
+	models.AppendQuoteHistory(&quote, models.QuoteHistory{Action: models.QUOTEDRAFT, ActionDate: time.Now()})
+
+	err = models.CreateQuote(&quote)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "quote creation error"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusCreated)
+	quoteJson, _ := json.Marshal(quote)
+	writer.Write(quoteJson)
+}
+
+// GET QUOTES Listed IN DESC Order
+func GetQuotes(writer http.ResponseWriter, request *http.Request) {
+	params, ok := parsePagination(writer, request)
+	if !ok {
+		return
+	}
+	quotes, _ := models.GetQuotes(params)
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	quotesJson, _ := json.Marshal(quotes)
+	writer.Write(quotesJson)
+}
+
+// GET QUOTE By QuoteID
+func GetQuoteByQuoteId(writer http.ResponseWriter, request *http.Request) {
+	quote, ok := findQuote(writer, request)
+	if !ok {
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	quoteJson, _ := json.Marshal(quote)
+	writer.Write(quoteJson)
+}
+
+// UPDATE QUOTE, only while it is a draft
+func UpdateQuote(writer http.ResponseWriter, request *http.Request) {
+	quote, ok := findQuote(writer, request)
+	if !ok {
+		return
+	}
+
+	body, _ := ioutil.ReadAll(request.Body)
+	var payload UpdateQuotePayload
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "quote body not valid"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return
+	}
+	//validating the playload
+	validate := validator.New()
+	err = validate.Struct(payload)
+	if err != nil {
+		validationError := err.(validator.ValidationErrors)
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": validationError.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	if quote.Status != models.QUOTEDRAFT {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": fmt.Sprintf("a %s quote can not be edited", quote.Status)})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusConflict)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	if payload.ExpiryDate != nil {
+		expiryDate, _ := time.Parse("2006-01-02", *payload.ExpiryDate)
+		if time.Now().After(expiryDate) {
+			jsonResponse, _ := json.Marshal(map[string]string{"detail": "expiry_date can not be today or be in the past"})
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write(jsonResponse)
+			return
+		}
+		quote.ExpiryDate = expiryDate
+	}
+	if payload.Description != nil {
+		quote.Description = *payload.Description
+	}
+	if payload.Note != nil {
+		quote.Note = *payload.Note
+	}
+	if payload.CustomerInfo != nil {
+		quote.CustomerInfo, _ = json.Marshal(*payload.CustomerInfo)
+	}
+	if payload.IsDiscount != nil {
+		quote.IsDiscount = *payload.IsDiscount
+	}
+	if payload.DiscountPercentage != nil {
+		quote.DiscountPercentage = *payload.DiscountPercentage
+	}
+	if payload.Items != nil {
+		quote.Items, _ = json.Marshal(*payload.Items)
+	}
+	var items []models.Item
+	_ = json.Unmarshal(quote.Items, &items)
+	quote.Amount = models.CalculateItemsTotal(items, quote.IsDiscount, quote.DiscountPercentage)
+
+	err = models.UpdateQuote(quote)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "quote update error"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	quoteJson, _ := json.Marshal(quote)
+	writer.Write(quoteJson)
+}
+
+// SEND QUOTE to the customer by email
+func SendQuote(writer http.ResponseWriter, request *http.Request) {
+	quote, ok := findQuote(writer, request)
+	if !ok {
+		return
+	}
+	if !quoteStatusAllowed(writer, quote, models.QUOTESENT, models.QUOTEDRAFT, models.QUOTESENT) {
+		return
+	}
+	err := mailer.SendQuote(quote)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "quote could not be sent: " + err.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadGateway)
+		writer.Write(jsonResponse)
+		return
+	}
+	saveQuoteStatus(writer, quote, models.QUOTESENT)
+}
+
+// ACCEPT QUOTE on behalf of the customer
+func AcceptQuote(writer http.ResponseWriter, request *http.Request) {
+	quote, ok := findQuote(writer, request)
+	if !ok {
+		return
+	}
+	if !quoteStatusAllowed(writer, quote, models.QUOTEACCEPTED, models.QUOTESENT) {
+		return
+	}
+	saveQuoteStatus(writer, quote, models.QUOTEACCEPTED)
+}
+
+// DECLINE QUOTE on behalf of the customer
+func DeclineQuote(writer http.ResponseWriter, request *http.Request) {
+	quote, ok := findQuote(writer, request)
+	if !ok {
+		return
+	}
+	if !quoteStatusAllowed(writer, quote, models.QUOTEDECLINED, models.QUOTESENT) {
+		return
+	}
+	saveQuoteStatus(writer, quote, models.QUOTEDECLINED)
+}
+
+// CONVERT QUOTE into an invoice, once accepted
+func ConvertQuote(writer http.ResponseWriter, request *http.Request) {
+	quote, ok := findQuote(writer, request)
+	if !ok {
+		return
+	}
+
+	body, _ := ioutil.ReadAll(request.Body)
+	var payload ConvertQuotePayload
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "convert body not valid"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return
+	}
+	//validating the playload
+	validate := validator.New()
+	err = validate.Struct(payload)
+	if err != nil {
+		validationError := err.(validator.ValidationErrors)
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": validationError.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+	dueDate, _ := time.Parse("2006-01-02", payload.DueDate)
+	if time.Now().After(dueDate) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "due_date can not be today or be in the past"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+	remindersJSON, _ := json.Marshal(payload.Reminder)
+	if payload.Reminder == nil {
+		remindersJSON = []byte("[]")
+	}
+
+	invoice, _, err := models.ConvertQuote(quote.QuoteID.String(), dueDate, remindersJSON)
+	if errors.Is(err, models.ErrQuoteNotAccepted) || errors.Is(err, models.ErrQuoteAlreadyConverted) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": err.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusConflict)
+		writer.Write(jsonResponse)
+		return
+	}
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "quote conversion error"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusCreated)
+	invoiceJson, _ := json.Marshal(invoice)
+	writer.Write(invoiceJson)
+}
+
+// quoteStatusAllowed checks the quote can move to status from where it is, expiring it on the way
+// if nobody answered in time. It writes the 409 response when the move is not allowed.
+func quoteStatusAllowed(writer http.ResponseWriter, quote *models.Quote, status models.QuoteStatus, from ...models.QuoteStatus) bool {
+	if quote.IsExpired(time.Now()) {
+		quote.Status = models.QUOTEEXPIRED
+		models.AppendQuoteHistory(quote, models.QuoteHistory{Action: models.QUOTEEXPIRED, ActionDate: time.Now()})
+		_ = models.UpdateQuote(quote)
+	}
+	for _, current := range from {
+		if quote.Status == current {
+			return true
+		}
+	}
+	jsonResponse, _ := json.Marshal(map[string]string{"detail": fmt.Sprintf("quote is %s and can not be moved to %s", quote.Status, status)})
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusConflict)
+	writer.Write(jsonResponse)
+	return false
+}
+
+// saveQuoteStatus records the new status in the quote and its history and writes the response
+func saveQuoteStatus(writer http.ResponseWriter, quote *models.Quote, status models.QuoteStatus) {
+	quote.Status = status
+	models.AppendQuoteHistory(quote, models.QuoteHistory{Action: status, ActionDate: time.Now()})
+	err := models.UpdateQuote(quote)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "quote update error"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	quoteJson, _ := json.Marshal(quote)
+	writer.Write(quoteJson)
+}
+
+// findQuote loads the quote in the URL, writing the error response if it can't
+func findQuote(writer http.ResponseWriter, request *http.Request) (*models.Quote, bool) {
+	quoteIdParam := chi.URLParam(request, "quoteId")
+	_, err := uuid.Parse(quoteIdParam)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "quoteId is not a valid uuid"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return nil, false
+	}
+
+	quote, err := models.GetQuoteByID(quoteIdParam)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "quote not found"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusNotFound)
+		writer.Write(jsonResponse)
+		return nil, false
+	}
+	return quote, true
+}
@@ -0,0 +1,244 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"io/ioutil"
+	"net/http"
+	"numerisTask/models"
+	"time"
+)
+
+type CreateRecurringInvoicePayload struct {
+	Description        string              `json:"description,omitempty"`
+	Items              []models.Item       `json:"items" validate:"required,dive"`
+	CustomerInfo       models.CustomerInfo `json:"customer_info" validate:"required"`
+	IsDiscount         bool                `json:"is_discount,omitempty"`
+	DiscountPercentage float64             `json:"discount_percentage,omitempty" validate:"omitempty,gte=0,lte=100"`
+	Reminder           []models.Reminder   `json:"reminder" validate:"dive"`
+	Note               string              `json:"note,omitempty"`
+	Frequency          models.Frequency    `json:"frequency" validate:"required,oneof=WEEKLY MONTHLY QUARTERLY"`
+	Interval           int                 `json:"interval,omitempty" validate:"omitempty,gte=1"`
+	DayOfMonth         int                 `json:"day_of_month,omitempty" validate:"omitempty,gte=1,lte=31"`
+	StartDate          string              `json:"start_date" validate:"required,datetime=2006-01-02"`
+	EndDate            *string             `json:"end_date,omitempty" validate:"omitempty,datetime=2006-01-02"`
+	Occurrences        *int                `json:"occurrences,omitempty" validate:"omitempty,gte=1"`
+	PaymentTermsDays   int                 `json:"payment_terms_days" validate:"gte=0"`
+	AutoSend           bool                `json:"auto_send,omitempty"`
+}
+
+// CREATE RECURRING INVOICE
+func CreateRecurringInvoice(writer http.ResponseWriter, request *http.Request) {
+	body, _ := ioutil.ReadAll(request.Body)
+	var payload CreateRecurringInvoicePayload
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "recurring invoice body not valid"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return
+	}
+	//validating the playload
+	validate := validator.New()
+	err = validate.Struct(payload)
+	if err != nil {
+		validationError := err.(validator.ValidationErrors)
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": validationError.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+	if payload.DayOfMonth != 0 && payload.Frequency == models.WEEKLY {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "day_of_month only applies to MONTHLY and QUARTERLY schedules"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	startDate, _ := time.Parse("2006-01-02", payload.StartDate)
+	today := time.Now().Truncate(24 * time.Hour)
+	if startDate.Before(today) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "start_date can not be in the past"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+	var endDate *time.Time
+	if payload.EndDate != nil {
+		parsed, _ := time.Parse("2006-01-02", *payload.EndDate)
+		if parsed.Before(startDate) {
+			jsonResponse, _ := json.Marshal(map[string]string{"detail": "end_date can not be before start_date"})
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write(jsonResponse)
+			return
+		}
+		endDate = &parsed
+	}
+	interval := payload.Interval
+	if interval == 0 {
+		interval = 1
+	}
+
+	itemsJSON, _ := json.Marshal(payload.Items)
+	customerInfoJSON, _ := json.Marshal(payload.CustomerInfo)
+	remindersJSON, _ := json.Marshal(payload.Reminder)
+	if payload.Reminder == nil {
+		remindersJSON = []byte("[]")
+	}
+
+	recurringInvoice := models.RecurringInvoice{
+		RecurringInvoiceID: uuid.New(),
+		Description:        payload.Description,
+		Items:              itemsJSON,
+		CustomerInfo:       customerInfoJSON,
+		IsDiscount:         payload.IsDiscount,
+		DiscountPercentage: payload.DiscountPercentage,
+		Reminders:          remindersJSON,
+		Note:               payload.Note,
+		Frequency:          payload.Frequency,
+		Interval:           interval,
+		DayOfMonth:         payload.DayOfMonth,
+		StartDate:          startDate,
+		EndDate:            endDate,
+		MaxOccurrences:     payload.Occurrences,
+		PaymentTermsDays:   payload.PaymentTermsDays,
+		AutoSend:           payload.AutoSend,
+		Status:             models.RECURRINGACTIVE,
+		// Hard coded for proof of work
+		CreatedBy: 1,
+	}
+	recurringInvoice.NextRunAt = recurringInvoice.FirstRun()
+
+	err = models.CreateRecurringInvoice(&recurringInvoice)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "recurring invoice creation error"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusCreated)
+	recurringInvoiceJson, _ := json.Marshal(recurringInvoice)
+	writer.Write(recurringInvoiceJson)
+}
+
+// GET RECURRING INVOICES Listed IN DESC Order
+func GetRecurringInvoices(writer http.ResponseWriter, request *http.Request) {
+	params, ok := parsePagination(writer, request)
+	if !ok {
+		return
+	}
+	recurringInvoices, _ := models.GetRecurringInvoices(params)
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	recurringInvoicesJson, _ := json.Marshal(recurringInvoices)
+	writer.Write(recurringInvoicesJson)
+}
+
+// GET RECURRING INVOICE By RecurringInvoiceID
+func GetRecurringInvoiceByRecurringInvoiceId(writer http.ResponseWriter, request *http.Request) {
+	recurringInvoice, ok := findRecurringInvoice(writer, request)
+	if !ok {
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	recurringInvoiceJson, _ := json.Marshal(recurringInvoice)
+	writer.Write(recurringInvoiceJson)
+}
+
+// GET INVOICES generated by a recurring invoice
+func GetRecurringInvoiceInvoices(writer http.ResponseWriter, request *http.Request) {
+	recurringInvoice, ok := findRecurringInvoice(writer, request)
+	if !ok {
+		return
+	}
+	invoices, _ := models.GetInvoicesByRecurringInvoiceID(recurringInvoice.RecurringInvoiceID.String())
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	invoicesJson, _ := json.Marshal(invoices)
+	writer.Write(invoicesJson)
+}
+
+// PAUSE RECURRING INVOICE
+func PauseRecurringInvoice(writer http.ResponseWriter, request *http.Request) {
+	changeRecurringInvoiceStatus(writer, request, models.RECURRINGPAUSED, models.RECURRINGACTIVE)
+}
+
+// RESUME RECURRING INVOICE
+func ResumeRecurringInvoice(writer http.ResponseWriter, request *http.Request) {
+	changeRecurringInvoiceStatus(writer, request, models.RECURRINGACTIVE, models.RECURRINGPAUSED)
+}
+
+// CANCEL RECURRING INVOICE, no more invoices are generated
+func CancelRecurringInvoice(writer http.ResponseWriter, request *http.Request) {
+	changeRecurringInvoiceStatus(writer, request, models.RECURRINGCANCELED, models.RECURRINGACTIVE, models.RECURRINGPAUSED)
+}
+
+// changeRecurringInvoiceStatus moves the schedule to status when it currently is in one of from
+func changeRecurringInvoiceStatus(writer http.ResponseWriter, request *http.Request, status models.RecurringStatus, from ...models.RecurringStatus) {
+	recurringInvoice, ok := findRecurringInvoice(writer, request)
+	if !ok {
+		return
+	}
+
+	allowed := false
+	for _, current := range from {
+		if recurringInvoice.Status == current {
+			allowed = true
+		}
+	}
+	if !allowed {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": fmt.Sprintf("recurring invoice is %s and can not be moved to %s", recurringInvoice.Status, status)})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusConflict)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	err := models.UpdateRecurringInvoiceStatus(recurringInvoice, status)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "recurring invoice update error"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	recurringInvoiceJson, _ := json.Marshal(recurringInvoice)
+	writer.Write(recurringInvoiceJson)
+}
+
+// findRecurringInvoice loads the recurring invoice in the URL, writing the error response if it can't
+func findRecurringInvoice(writer http.ResponseWriter, request *http.Request) (*models.RecurringInvoice, bool) {
+	recurringInvoiceIdParam := chi.URLParam(request, "recurringInvoiceId")
+	_, err := uuid.Parse(recurringInvoiceIdParam)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "recurringInvoiceId is not a valid uuid"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return nil, false
+	}
+
+	recurringInvoice, err := models.GetRecurringInvoiceByID(recurringInvoiceIdParam)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "recurring invoice not found"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusNotFound)
+		writer.Write(jsonResponse)
+		return nil, false
+	}
+	return recurringInvoice, true
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"numerisTask/models"
+	"strconv"
+)
+
+// GET INVOICE REVISIONS, every change made after the invoice was sent
+func GetInvoiceRevisions(writer http.ResponseWriter, request *http.Request) {
+	invoice, ok := findInvoice(writer, request)
+	if !ok {
+		return
+	}
+	revisions, err := models.GetInvoiceRevisions(invoice.InvoiceID.String())
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "revisions could not be listed"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	revisionsJson, _ := json.Marshal(revisions)
+	writer.Write(revisionsJson)
+}
+
+// GET INVOICE REVISION, the invoice as it was at that revision
+func GetInvoiceRevision(writer http.ResponseWriter, request *http.Request) {
+	invoice, ok := findInvoice(writer, request)
+	if !ok {
+		return
+	}
+	number, err := strconv.Atoi(chi.URLParam(request, "revision"))
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "revision must be a number"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+	revision, ok := findRevision(writer, invoice, number)
+	if !ok {
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	invoiceJson, _ := json.Marshal(revision)
+	writer.Write(invoiceJson)
+}
+
+// DIFF INVOICE REVISIONS, ?from=1&to=2 lists the fields that changed between the two
+func DiffInvoiceRevisions(writer http.ResponseWriter, request *http.Request) {
+	invoice, ok := findInvoice(writer, request)
+	if !ok {
+		return
+	}
+	from, fromErr := strconv.Atoi(request.URL.Query().Get("from"))
+	to, toErr := strconv.Atoi(request.URL.Query().Get("to"))
+	if fromErr != nil || toErr != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "from and to must be revision numbers"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+	changes, err := models.DiffInvoiceRevisions(invoice.InvoiceID.String(), from, to)
+	if errors.Is(err, models.ErrRevisionNotFound) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": err.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusNotFound)
+		writer.Write(jsonResponse)
+		return
+	}
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "revisions could not be compared"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	changesJson, _ := json.Marshal(changes)
+	writer.Write(changesJson)
+}
+
+// findRevision loads a revision of the invoice, writing the error response if there is none
+func findRevision(writer http.ResponseWriter, invoice *models.Invoice, number int) (*models.Invoice, bool) {
+	revision, err := models.GetInvoiceRevision(invoice.InvoiceID.String(), number)
+	if errors.Is(err, models.ErrRevisionNotFound) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": err.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusNotFound)
+		writer.Write(jsonResponse)
+		return nil, false
+	}
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "revision could not be loaded"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return nil, false
+	}
+	return revision, true
+}
+
+// requestedRevision swaps the invoice for the revision in the ?revision= query, if there is one
+func requestedRevision(writer http.ResponseWriter, request *http.Request, invoice *models.Invoice) (*models.Invoice, bool) {
+	value := request.URL.Query().Get("revision")
+	if value == "" {
+		return invoice, true
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "revision must be a number"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return nil, false
+	}
+	return findRevision(writer, invoice, number)
+}
@@ -0,0 +1,262 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"numerisTask/models"
+	"numerisTask/render"
+	"os"
+	"time"
+)
+
+type ShareInvoicePayload struct {
+	ExpiresAt *string `json:"expires_at,omitempty" validate:"omitempty,datetime=2006-01-02"` // Optional, the link works until the end of the day before
+}
+
+type ShareInvoiceResponse struct {
+	ShareToken string     `json:"share_token"`
+	ShareURL   string     `json:"share_url"`
+	ViewURL    string     `json:"view_url"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+// PublicInvoice is the read-only subset of an invoice customers see through a shared link
+type PublicInvoice struct {
+	InvoiceID          uuid.UUID       `json:"invoice_id"`
+	InvoiceNumber      *string         `json:"invoice_number"`
+	IssuedAt           time.Time       `json:"issued_at"`
+	DueDate            *time.Time      `json:"due_date"`
+	Description        string          `json:"description"`
+	Status             models.Status   `json:"status"`
+	Items              json.RawMessage `json:"items"`
+	CustomerInfo       json.RawMessage `json:"customer_info"`
+	IsDiscount         bool            `json:"is_discount"`
+	DiscountPercentage float64         `json:"discount_percentage"`
+	Amount             float64         `json:"amount"`
+	LateFees           float64         `json:"late_fees"`
+	CreditedAmount     float64         `json:"credited_amount"`
+	AmountPaid         float64         `json:"amount_paid"`
+	OutstandingAmount  float64         `json:"outstanding_amount"`
+	// Only while the early payment discount can still be had
+	EarlyPaymentDeadline *time.Time            `json:"early_payment_deadline,omitempty"`
+	EarlyPaymentAmount   *float64              `json:"early_payment_amount,omitempty"`
+	Installments         []models.Installment  `json:"installments,omitempty"`
+	Note                 string                `json:"note"`
+	Sender               string                `json:"sender"`
+	BankDetail           models.UserBankDetail `json:"bank_detail"`
+}
+
+// shareLinks builds the public URLs from PUBLIC_URL, or from the request when it is not set
+func shareLinks(request *http.Request, invoice *models.Invoice) ShareInvoiceResponse {
+	baseURL := os.Getenv("PUBLIC_URL")
+	if baseURL == "" {
+		scheme := "http"
+		if request.TLS != nil {
+			scheme = "https"
+		}
+		baseURL = fmt.Sprintf("%s://%s", scheme, request.Host)
+	}
+	shareURL := fmt.Sprintf("%s/api/v1/public/invoices/%s", baseURL, *invoice.ShareToken)
+	return ShareInvoiceResponse{
+		ShareToken: *invoice.ShareToken,
+		ShareURL:   shareURL,
+		ViewURL:    shareURL + "/view",
+		ExpiresAt:  invoice.ShareExpiresAt,
+	}
+}
+
+// SHARE INVOICE, a new link every time so older links stop working
+func ShareInvoice(writer http.ResponseWriter, request *http.Request) {
+	invoice, ok := findInvoice(writer, request)
+	if !ok {
+		return
+	}
+	if invoice.Status == models.DRAFT {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "a draft invoice can not be shared, finalize it first"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusConflict)
+		writer.Write(jsonResponse)
+		return
+	}
+	if invoice.Status == models.CANCELED {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "a voided invoice can not be shared"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusConflict)
+		writer.Write(jsonResponse)
+		return
+	}
+	if invoice.Status == models.PENDINGAPPROVAL {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "an invoice waiting for approval can not be shared"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusConflict)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	var payload ShareInvoicePayload
+	body, _ := ioutil.ReadAll(request.Body)
+	if len(body) > 0 {
+		err := json.Unmarshal(body, &payload)
+		if err != nil {
+			jsonResponse, _ := json.Marshal(map[string]string{"detail": "share body not valid"})
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusUnprocessableEntity)
+			writer.Write(jsonResponse)
+			return
+		}
+	}
+	//validating the playload
+	validate := validator.New()
+	err := validate.Struct(payload)
+	if err != nil {
+		validationError := err.(validator.ValidationErrors)
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": validationError.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	var expiresAt *time.Time
+	if payload.ExpiresAt != nil {
+		parsed, _ := time.Parse("2006-01-02", *payload.ExpiresAt)
+		if time.Now().After(parsed) {
+			jsonResponse, _ := json.Marshal(map[string]string{"detail": "expires_at can not be today or be in the past"})
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write(jsonResponse)
+			return
+		}
+		expiresAt = &parsed
+	}
+
+	invoice, err = models.UpdateInvoice(invoice.InvoiceID.String(), func(invoice *models.Invoice) error {
+		return models.ShareInvoice(invoice, expiresAt)
+	})
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "share link could not be created"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	shareJson, _ := json.Marshal(shareLinks(request, invoice))
+	writer.Write(shareJson)
+}
+
+// REVOKE INVOICE SHARE, same as setting is_shared to false
+func RevokeInvoiceShare(writer http.ResponseWriter, request *http.Request) {
+	invoice, ok := findInvoice(writer, request)
+	if !ok {
+		return
+	}
+	if invoice.IsShared {
+		_, err := models.UpdateInvoice(invoice.InvoiceID.String(), func(invoice *models.Invoice) error {
+			if invoice.IsShared {
+				models.RevokeInvoiceShare(invoice)
+			}
+			return nil
+		})
+		if err != nil {
+			jsonResponse, _ := json.Marshal(map[string]string{"detail": "invoice update error"})
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusInternalServerError)
+			writer.Write(jsonResponse)
+			return
+		}
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// GET PUBLIC INVOICE, unauthenticated JSON view through a shared link, of a past revision with ?revision=
+func GetPublicInvoice(writer http.ResponseWriter, request *http.Request) {
+	invoice, ok := findSharedInvoice(writer, request)
+	if !ok {
+		return
+	}
+	invoice, ok = requestedRevision(writer, request, invoice)
+	if !ok {
+		return
+	}
+	user := models.PlaceHolderUser
+	publicInvoice := PublicInvoice{
+		InvoiceID:          invoice.InvoiceID,
+		InvoiceNumber:      invoice.InvoiceNumber,
+		IssuedAt:           invoice.IssueDate(),
+		DueDate:            invoice.DueDate,
+		Description:        invoice.Description,
+		Status:             invoice.Status,
+		Items:              invoice.Items,
+		CustomerInfo:       invoice.CustomerInfo,
+		IsDiscount:         invoice.IsDiscount,
+		DiscountPercentage: invoice.DiscountPercentage,
+		Amount:             invoice.Amount,
+		LateFees:           invoice.FeesAmount,
+		CreditedAmount:     invoice.CreditedAmount,
+		AmountPaid:         models.NetPaid(invoice),
+		OutstandingAmount:  invoice.OutstandingAmount,
+		Installments:       models.Installments(invoice),
+		Note:               invoice.Note,
+		Sender:             user.Name,
+		BankDetail:         user.BankDetail,
+	}
+	if amount, ok := models.EarlyPaymentAmount(invoice, time.Now()); ok {
+		publicInvoice.EarlyPaymentDeadline = invoice.EarlyPaymentDeadline
+		publicInvoice.EarlyPaymentAmount = &amount
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.WriteHeader(http.StatusOK)
+	invoiceJson, _ := json.Marshal(publicInvoice)
+	writer.Write(invoiceJson)
+}
+
+// GET PUBLIC INVOICE VIEW, unauthenticated HTML page through a shared link, of a past revision with ?revision=
+func GetPublicInvoiceView(writer http.ResponseWriter, request *http.Request) {
+	invoice, ok := findSharedInvoice(writer, request)
+	if !ok {
+		return
+	}
+	invoice, ok = requestedRevision(writer, request, invoice)
+	if !ok {
+		return
+	}
+	page, err := render.InvoiceHTML(invoice)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "invoice could not be rendered"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(page)
+}
+
+// findSharedInvoice loads the invoice behind a share token and records the view.
+// Unknown, revoked and expired tokens all get the same 404.
+func findSharedInvoice(writer http.ResponseWriter, request *http.Request) (*models.Invoice, bool) {
+	invoice, err := models.GetSharedInvoice(chi.URLParam(request, "shareToken"))
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "invoice not found"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusNotFound)
+		writer.Write(jsonResponse)
+		return nil, false
+	}
+	if err = models.RecordInvoiceView(invoice); err != nil {
+		log.Printf("Error recording view of invoice %s: %v", invoice.InvoiceID, err)
+	}
+	return invoice, true
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-playground/validator/v10"
+	"io/ioutil"
+	"net/http"
+	"numerisTask/mailer"
+	"numerisTask/models"
+	"numerisTask/render"
+	"strings"
+	"time"
+)
+
+type SendStatementPayload struct {
+	Customer string `json:"customer" validate:"required,email"`
+	From     string `json:"from,omitempty" validate:"omitempty,datetime=2006-01-02"`
+	To       string `json:"to,omitempty" validate:"omitempty,datetime=2006-01-02"`
+}
+
+// customerStatement builds the statement of the customer for the period, writing the error response if it can't.
+// The period runs to the end of to (today by default) from the start of from (the first of that month by default).
+func customerStatement(writer http.ResponseWriter, customer string, fromParam string, toParam string) (*models.Statement, bool) {
+	now := time.Now()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	var from time.Time
+	for _, param := range []struct {
+		value string
+		date  *time.Time
+	}{{fromParam, &from}, {toParam, &to}} {
+		if param.value == "" {
+			continue
+		}
+		parsed, err := time.Parse("2006-01-02", param.value)
+		if err != nil {
+			jsonResponse, _ := json.Marshal(map[string]string{"detail": "from and to must be a date format, eg: 2006-01-02"})
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write(jsonResponse)
+			return nil, false
+		}
+		*param.date = parsed
+	}
+	if fromParam == "" {
+		from = time.Date(to.Year(), to.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	if to.Before(from) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "to can not be before from"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return nil, false
+	}
+
+	statement, err := models.GetCustomerStatement(customer, from, to)
+	if errors.Is(err, models.ErrCustomerNotFound) {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "customer not found"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusNotFound)
+		writer.Write(jsonResponse)
+		return nil, false
+	}
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "statement could not be built"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return nil, false
+	}
+	return statement, true
+}
+
+// GET CUSTOMER STATEMENT of account, ?customer= (email) for the period ?from= ?to=: opening balance, every invoice,
+// credit and payment with the running balance, closing balance and aging. ?format=json|html|pdf, json by default.
+func GetCustomerStatement(writer http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+	format := strings.ToLower(query.Get("format"))
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "html" && format != "pdf" {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "format must be json, html or pdf"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+	if query.Get("customer") == "" {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "customer email is required"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+	statement, ok := customerStatement(writer, query.Get("customer"), query.Get("from"), query.Get("to"))
+	if !ok {
+		return
+	}
+
+	switch format {
+	case "html":
+		page, err := render.StatementHTML(statement)
+		if err != nil {
+			jsonResponse, _ := json.Marshal(map[string]string{"detail": "statement could not be rendered"})
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusInternalServerError)
+			writer.Write(jsonResponse)
+			return
+		}
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		writer.WriteHeader(http.StatusOK)
+		writer.Write(page)
+	case "pdf":
+		document, err := render.StatementPDF(statement)
+		if err != nil {
+			jsonResponse, _ := json.Marshal(map[string]string{"detail": "statement could not be rendered"})
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusInternalServerError)
+			writer.Write(jsonResponse)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/pdf")
+		writer.Header().Set("Content-Disposition", "inline; filename=\"statement-"+statement.To.Format("2006-01-02")+".pdf\"")
+		writer.WriteHeader(http.StatusOK)
+		writer.Write(document)
+	default:
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusOK)
+		statementJson, _ := json.Marshal(statement)
+		writer.Write(statementJson)
+	}
+}
+
+// SEND CUSTOMER STATEMENT of account by email, the PDF attached
+func SendCustomerStatement(writer http.ResponseWriter, request *http.Request) {
+	body, _ := ioutil.ReadAll(request.Body)
+	var payload SendStatementPayload
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "statement body not valid"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		writer.Write(jsonResponse)
+		return
+	}
+	//validating the playload
+	validate := validator.New()
+	err = validate.Struct(payload)
+	if err != nil {
+		validationError := err.(validator.ValidationErrors)
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": validationError.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	statement, ok := customerStatement(writer, payload.Customer, payload.From, payload.To)
+	if !ok {
+		return
+	}
+	document, err := render.StatementPDF(statement)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "statement could not be rendered"})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(jsonResponse)
+		return
+	}
+	// The statement goes to the address asked for, the customer's latest one may differ in case only
+	statement.Customer.Email = payload.Customer
+	err = mailer.SendStatement(statement, document)
+	if err != nil {
+		jsonResponse, _ := json.Marshal(map[string]string{"detail": "statement could not be sent: " + err.Error()})
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadGateway)
+		writer.Write(jsonResponse)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	statementJson, _ := json.Marshal(statement)
+	writer.Write(statementJson)
+}
@@ -0,0 +1,306 @@
+package einvoice
+
+import (
+	"encoding/xml"
+	"fmt"
+	"numerisTask/models"
+	"time"
+)
+
+const (
+	ciiRSMNamespace = "urn:un:unece:uncefact:data:standard:CrossIndustryInvoice:100"
+	ciiRAMNamespace = "urn:un:unece:uncefact:data:standard:ReusableAggregateBusinessInformationEntity:100"
+	ciiUDTNamespace = "urn:un:unece:uncefact:data:standard:UnqualifiedDataType:100"
+	// Factur-X / ZUGFeRD EN 16931 (comfort) profile
+	en16931Guideline = "urn:cen.eu:en16931:2017"
+)
+
+// CII elements (UN/CEFACT D16B), in the order the schema wants them
+type ciiInvoice struct {
+	XMLName                     xml.Name       `xml:"rsm:CrossIndustryInvoice"`
+	RSMNamespace                string         `xml:"xmlns:rsm,attr"`
+	RAMNamespace                string         `xml:"xmlns:ram,attr"`
+	UDTNamespace                string         `xml:"xmlns:udt,attr"`
+	ExchangedDocumentContext    ciiContext     `xml:"rsm:ExchangedDocumentContext"`
+	ExchangedDocument           ciiDocument    `xml:"rsm:ExchangedDocument"`
+	SupplyChainTradeTransaction ciiTransaction `xml:"rsm:SupplyChainTradeTransaction"`
+}
+
+type ciiContext struct {
+	GuidelineID string `xml:"ram:GuidelineSpecifiedDocumentContextParameter>ram:ID"`
+}
+
+type ciiDocument struct {
+	ID            string    `xml:"ram:ID"`
+	TypeCode      string    `xml:"ram:TypeCode"`
+	IssueDateTime ciiDate   `xml:"ram:IssueDateTime"`
+	IncludedNotes []ciiNote `xml:"ram:IncludedNote"`
+}
+
+type ciiDate struct {
+	DateTimeString ciiDateString `xml:"udt:DateTimeString"`
+}
+
+type ciiDateString struct {
+	Format string `xml:"format,attr"`
+	Value  string `xml:",chardata"`
+}
+
+type ciiNote struct {
+	Content string `xml:"ram:Content"`
+}
+
+type ciiTransaction struct {
+	LineItems  []ciiLineItem       `xml:"ram:IncludedSupplyChainTradeLineItem"`
+	Agreement  ciiAgreement        `xml:"ram:ApplicableHeaderTradeAgreement"`
+	Delivery   struct{}            `xml:"ram:ApplicableHeaderTradeDelivery"`
+	Settlement ciiHeaderSettlement `xml:"ram:ApplicableHeaderTradeSettlement"`
+}
+
+type ciiLineItem struct {
+	LineID     string            `xml:"ram:AssociatedDocumentLineDocument>ram:LineID"`
+	Name       string            `xml:"ram:SpecifiedTradeProduct>ram:Name"`
+	NetPrice   string            `xml:"ram:SpecifiedLineTradeAgreement>ram:NetPriceProductTradePrice>ram:ChargeAmount"`
+	Quantity   ciiQuantity       `xml:"ram:SpecifiedLineTradeDelivery>ram:BilledQuantity"`
+	Settlement ciiLineSettlement `xml:"ram:SpecifiedLineTradeSettlement"`
+}
+
+type ciiQuantity struct {
+	UnitCode string `xml:"unitCode,attr"`
+	Value    int    `xml:",chardata"`
+}
+
+type ciiLineSettlement struct {
+	Tax             ciiTax `xml:"ram:ApplicableTradeTax"`
+	LineTotalAmount string `xml:"ram:SpecifiedTradeSettlementLineMonetarySummation>ram:LineTotalAmount"`
+}
+
+type ciiAgreement struct {
+	BuyerReference string   `xml:"ram:BuyerReference"`
+	Seller         ciiParty `xml:"ram:SellerTradeParty"`
+	Buyer          ciiParty `xml:"ram:BuyerTradeParty"`
+}
+
+type ciiParty struct {
+	Name    string          `xml:"ram:Name"`
+	Contact *ciiContact     `xml:"ram:DefinedTradeContact,omitempty"`
+	Country string          `xml:"ram:PostalTradeAddress>ram:CountryID"`
+	URI     ciiSchemedValue `xml:"ram:URIUniversalCommunication>ram:URIID"`
+}
+
+// ciiContact has pointers, empty a>b paths would still write the outer element
+type ciiContact struct {
+	Telephone *ciiTelephone `xml:"ram:TelephoneUniversalCommunication,omitempty"`
+	Email     *ciiEmail     `xml:"ram:EmailURIUniversalCommunication,omitempty"`
+}
+
+type ciiTelephone struct {
+	CompleteNumber string `xml:"ram:CompleteNumber"`
+}
+
+type ciiEmail struct {
+	URIID string `xml:"ram:URIID"`
+}
+
+func ciiContactOf(telephone string, email string) *ciiContact {
+	contact := &ciiContact{}
+	if telephone != "" {
+		contact.Telephone = &ciiTelephone{CompleteNumber: telephone}
+	}
+	if email != "" {
+		contact.Email = &ciiEmail{URIID: email}
+	}
+	if contact.Telephone == nil && contact.Email == nil {
+		return nil
+	}
+	return contact
+}
+
+type ciiSchemedValue struct {
+	SchemeID string `xml:"schemeID,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type ciiHeaderSettlement struct {
+	PaymentReference    string               `xml:"ram:PaymentReference"`
+	InvoiceCurrencyCode string               `xml:"ram:InvoiceCurrencyCode"`
+	PaymentMeans        *ciiPaymentMeans     `xml:"ram:SpecifiedTradeSettlementPaymentMeans,omitempty"`
+	Tax                 ciiTax               `xml:"ram:ApplicableTradeTax"`
+	AllowanceCharges    []ciiAllowance       `xml:"ram:SpecifiedTradeAllowanceCharge"`
+	PaymentTerms        *ciiPaymentTerms     `xml:"ram:SpecifiedTradePaymentTerms,omitempty"`
+	MonetarySummation   ciiMonetarySummation `xml:"ram:SpecifiedTradeSettlementHeaderMonetarySummation"`
+}
+
+type ciiPaymentMeans struct {
+	TypeCode      string `xml:"ram:TypeCode"`
+	AccountName   string `xml:"ram:PayeePartyCreditorFinancialAccount>ram:AccountName,omitempty"`
+	ProprietaryID string `xml:"ram:PayeePartyCreditorFinancialAccount>ram:ProprietaryID"`
+}
+
+// ciiTax is the tax category of a line or allowance, the header breakdown also has the amounts
+type ciiTax struct {
+	CalculatedAmount string `xml:"ram:CalculatedAmount,omitempty"`
+	TypeCode         string `xml:"ram:TypeCode"`
+	ExemptionReason  string `xml:"ram:ExemptionReason,omitempty"`
+	BasisAmount      string `xml:"ram:BasisAmount,omitempty"`
+	CategoryCode     string `xml:"ram:CategoryCode"`
+}
+
+type ciiAllowance struct {
+	ChargeIndicator    bool   `xml:"ram:ChargeIndicator>udt:Indicator"`
+	CalculationPercent string `xml:"ram:CalculationPercent,omitempty"`
+	BasisAmount        string `xml:"ram:BasisAmount,omitempty"`
+	ActualAmount       string `xml:"ram:ActualAmount"`
+	ReasonCode         string `xml:"ram:ReasonCode,omitempty"`
+	Reason             string `xml:"ram:Reason"`
+	Tax                ciiTax `xml:"ram:CategoryTradeTax"`
+}
+
+type ciiPaymentTerms struct {
+	Description string   `xml:"ram:Description,omitempty"`
+	DueDate     *ciiDate `xml:"ram:DueDateDateTime,omitempty"`
+}
+
+type ciiMonetarySummation struct {
+	LineTotalAmount      string    `xml:"ram:LineTotalAmount"`
+	ChargeTotalAmount    string    `xml:"ram:ChargeTotalAmount"`
+	AllowanceTotalAmount string    `xml:"ram:AllowanceTotalAmount"`
+	TaxBasisTotalAmount  string    `xml:"ram:TaxBasisTotalAmount"`
+	TaxTotalAmount       ublAmount `xml:"ram:TaxTotalAmount"`
+	RoundingAmount       string    `xml:"ram:RoundingAmount,omitempty"`
+	GrandTotalAmount     string    `xml:"ram:GrandTotalAmount"`
+	TotalPrepaidAmount   string    `xml:"ram:TotalPrepaidAmount,omitempty"`
+	DuePayableAmount     string    `xml:"ram:DuePayableAmount"`
+}
+
+// ciiDateOf is a date in the 102 format, eg: 20060102
+func ciiDateOf(date time.Time) ciiDate {
+	return ciiDate{DateTimeString: ciiDateString{Format: "102", Value: date.Format("20060102")}}
+}
+
+// CII turns an issued invoice into a UN/CEFACT Cross Industry Invoice with the EN 16931 profile, the XML of
+// Factur-X and ZUGFeRD. It carries the same parties, lines and totals as the UBL document.
+func CII(invoice *models.Invoice, seller models.User) ([]byte, error) {
+	if invoice.InvoiceNumber == nil {
+		return nil, fmt.Errorf("a %s invoice has no invoice number yet", invoice.Status)
+	}
+	currency := Currency()
+	totals := totalsOf(invoice)
+	lineTax := ciiTax{TypeCode: "VAT", CategoryCode: taxCategory}
+
+	document := ciiInvoice{
+		RSMNamespace:             ciiRSMNamespace,
+		RAMNamespace:             ciiRAMNamespace,
+		UDTNamespace:             ciiUDTNamespace,
+		ExchangedDocumentContext: ciiContext{GuidelineID: en16931Guideline},
+		ExchangedDocument: ciiDocument{
+			ID:            *invoice.InvoiceNumber,
+			TypeCode:      "380", // Commercial invoice
+			IssueDateTime: ciiDateOf(invoice.IssueDate()),
+		},
+	}
+	for _, note := range []string{invoice.Description, invoice.Note} {
+		if note != "" {
+			document.ExchangedDocument.IncludedNotes = append(document.ExchangedDocument.IncludedNotes, ciiNote{Content: note})
+		}
+	}
+
+	transaction := &document.SupplyChainTradeTransaction
+	for i, line := range totals.Lines {
+		transaction.LineItems = append(transaction.LineItems, ciiLineItem{
+			LineID:     fmt.Sprint(i + 1),
+			Name:       line.Name,
+			NetPrice:   formatAmount(line.UnitPrice),
+			Quantity:   ciiQuantity{UnitCode: "C62", Value: line.Quantity},
+			Settlement: ciiLineSettlement{Tax: lineTax, LineTotalAmount: formatAmount(line.Amount)},
+		})
+	}
+	// Customers have no reference of their own, the invoice number stands in for it
+	transaction.Agreement = ciiAgreement{
+		BuyerReference: *invoice.InvoiceNumber,
+		Seller: ciiParty{
+			Name:    seller.Name,
+			Contact: ciiContactOf("", seller.Email),
+			Country: SellerCountry(),
+			URI:     ciiSchemedValue{SchemeID: "EM", Value: seller.Email},
+		},
+		Buyer: ciiParty{
+			Name:    totals.Customer.Name,
+			Contact: ciiContactOf(totals.Customer.PhoneNumber, totals.Customer.Email),
+			Country: SellerCountry(),
+			URI:     ciiSchemedValue{SchemeID: "EM", Value: totals.Customer.Email},
+		},
+	}
+
+	settlement := ciiHeaderSettlement{
+		PaymentReference:    *invoice.InvoiceNumber,
+		InvoiceCurrencyCode: currency,
+		Tax: ciiTax{
+			CalculatedAmount: formatAmount(0),
+			TypeCode:         "VAT",
+			ExemptionReason:  taxExemptionReason,
+			BasisAmount:      formatAmount(totals.TaxExclusive),
+			CategoryCode:     taxCategory,
+		},
+		MonetarySummation: ciiMonetarySummation{
+			LineTotalAmount:      formatAmount(totals.LineTotal),
+			ChargeTotalAmount:    formatAmount(totals.Charges),
+			AllowanceTotalAmount: formatAmount(totals.Allowances),
+			TaxBasisTotalAmount:  formatAmount(totals.TaxExclusive),
+			TaxTotalAmount:       ublAmount{CurrencyID: currency, Value: formatAmount(0)},
+			GrandTotalAmount:     formatAmount(totals.TaxExclusive),
+			DuePayableAmount:     formatAmount(totals.Payable),
+		},
+	}
+	// The bank code is not a BIC, only the account goes in
+	if seller.BankDetail.AccountNumber != "" {
+		settlement.PaymentMeans = &ciiPaymentMeans{
+			TypeCode:      "30", // Credit transfer
+			AccountName:   seller.Name,
+			ProprietaryID: seller.BankDetail.AccountNumber,
+		}
+	}
+	if totals.Allowances > 0 {
+		settlement.AllowanceCharges = append(settlement.AllowanceCharges, ciiAllowance{
+			ChargeIndicator:    false,
+			CalculationPercent: fmt.Sprint(invoice.DiscountPercentage),
+			BasisAmount:        formatAmount(totals.LineTotal),
+			ActualAmount:       formatAmount(totals.Allowances),
+			ReasonCode:         "95", // Discount
+			Reason:             "Discount",
+			Tax:                lineTax,
+		})
+	}
+	if totals.Charges > 0 {
+		settlement.AllowanceCharges = append(settlement.AllowanceCharges, ciiAllowance{
+			ChargeIndicator: true,
+			ActualAmount:    formatAmount(totals.Charges),
+			Reason:          "Late payment fees",
+			Tax:             lineTax,
+		})
+	}
+	if invoice.DueDate != nil || invoice.PaymentTerms() > 0 {
+		terms := &ciiPaymentTerms{}
+		if days := invoice.PaymentTerms(); days > 0 {
+			terms.Description = fmt.Sprintf("Net %d days", days)
+		}
+		if invoice.DueDate != nil {
+			due := ciiDateOf(*invoice.DueDate)
+			terms.DueDate = &due
+		}
+		settlement.PaymentTerms = terms
+	}
+	if totals.Rounding != 0 {
+		settlement.MonetarySummation.RoundingAmount = formatAmount(totals.Rounding)
+	}
+	if totals.Prepaid != 0 {
+		settlement.MonetarySummation.TotalPrepaidAmount = formatAmount(totals.Prepaid)
+	}
+	transaction.Settlement = settlement
+
+	body, err := xml.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
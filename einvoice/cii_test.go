@@ -0,0 +1,77 @@
+package einvoice
+
+import (
+	"encoding/json"
+	"numerisTask/models"
+	"testing"
+)
+
+func TestCIIMatchesUBL(t *testing.T) {
+	items := []models.Item{{Name: "Design work", Quantity: 3, UnitPrice: 150.5}, {Name: "Hosting", Quantity: 1, UnitPrice: 20}}
+	tests := []struct {
+		name   string
+		modify func(invoice *models.Invoice)
+	}{
+		{"plain", func(invoice *models.Invoice) {}},
+		{"description and note", func(invoice *models.Invoice) {
+			invoice.Description = "March retainer"
+			invoice.Note = "Thank you for your business"
+		}},
+		{"discount", func(invoice *models.Invoice) {
+			*invoice = *testInvoice(items, 12.5)
+		}},
+		{"late fee", func(invoice *models.Invoice) {
+			invoice.FeesAmount = 25
+			models.RecalculateOutstanding(invoice)
+		}},
+		{"partly paid", func(invoice *models.Invoice) {
+			invoice.PaymentHistory = json.RawMessage(`[{"payment_id":"6f1f4c1e-8d0a-4bb4-9a55-2f1d0c3e9a10","type":"PAYMENT","amount_paid":100,"date_paid":"2026-03-10T00:00:00Z"}]`)
+			invoice.Status = models.PARTIALPAYMENT
+			models.RecalculateOutstanding(invoice)
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			invoice := testInvoice(items, 0)
+			test.modify(invoice)
+			ciiDocument, err := CII(invoice, testSeller)
+			if err != nil {
+				t.Fatalf("CII: %v", err)
+			}
+			cii, err := ReadEInvoice(ciiDocument)
+			if err != nil {
+				t.Fatalf("reading the CII back: %v", err)
+			}
+			if problems := cii.Check(); len(problems) > 0 {
+				t.Fatalf("expected no problems, got %v\n%s", problems, ciiDocument)
+			}
+
+			ublDocument, err := UBL(invoice, testSeller)
+			if err != nil {
+				t.Fatalf("UBL: %v", err)
+			}
+			ubl, err := ReadEInvoice(ublDocument)
+			if err != nil {
+				t.Fatalf("reading the UBL back: %v", err)
+			}
+			for _, total := range []struct {
+				name     string
+				cii, ubl float64
+			}{
+				{"line total", cii.LineTotal, ubl.LineTotal},
+				{"allowances", cii.Allowances, ubl.Allowances},
+				{"charges", cii.Charges, ubl.Charges},
+				{"tax exclusive", cii.TaxExclusive, ubl.TaxExclusive},
+				{"prepaid", cii.Prepaid, ubl.Prepaid},
+				{"payable", cii.Payable, ubl.Payable},
+			} {
+				if total.cii != total.ubl {
+					t.Errorf("%s: CII says %.2f, UBL says %.2f", total.name, total.cii, total.ubl)
+				}
+			}
+			if cii.Number != ubl.Number || len(cii.Lines) != len(ubl.Lines) {
+				t.Errorf("CII has invoice %s with %d lines, UBL has %s with %d", cii.Number, len(cii.Lines), ubl.Number, len(ubl.Lines))
+			}
+		})
+	}
+}
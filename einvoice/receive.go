@@ -0,0 +1,406 @@
+package einvoice
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FormatUBL = "UBL"
+	FormatCII = "CII"
+)
+
+var (
+	ErrNotEInvoice = errors.New("the document is not a UBL or CII invoice")
+	ErrCreditNote  = errors.New("credit notes can not be received as bills")
+)
+
+// ReceivedParty is the supplier or the buyer of a received invoice
+type ReceivedParty struct {
+	Name        string
+	Email       string
+	PhoneNumber string
+	TaxID       string
+	Country     string
+}
+
+// ReceivedLine is an invoice line, quantities can be fractional (hours, kilograms)
+type ReceivedLine struct {
+	Name          string
+	Quantity      float64
+	UnitPrice     float64 // Net price of one unit
+	Allowances    float64 // Line level discounts
+	Charges       float64
+	Amount        float64 // Net line amount as stated on the document
+	TaxCategory   string
+	TaxPercentage float64
+}
+
+// ReceivedTax is the VAT breakdown of one category and rate
+type ReceivedTax struct {
+	Category      string
+	Percentage    float64
+	TaxableAmount float64
+	TaxAmount     float64
+}
+
+// ReceivedInvoice is an incoming UBL or CII invoice read into one shape, with the totals as stated on the
+// document. Check tells whether they add up.
+type ReceivedInvoice struct {
+	Format       string
+	Number       string
+	IssueDate    time.Time
+	DueDate      *time.Time
+	Currency     string
+	Notes        []string
+	PaymentTerms string
+	BankAccount  string
+	Supplier     ReceivedParty
+	Buyer        ReceivedParty
+	Lines        []ReceivedLine
+	Taxes        []ReceivedTax
+	// Document level
+	Allowances   float64
+	Charges      float64
+	LineTotal    float64
+	TaxExclusive float64
+	TaxTotal     float64
+	TaxInclusive float64
+	Prepaid      float64
+	Rounding     float64
+	Payable      float64
+
+	problems []Problem // Values that could not be read
+}
+
+// reader reads values off the parsed document, noting the ones that are not numbers or dates
+type reader struct {
+	received *ReceivedInvoice
+}
+
+func (r reader) fail(rule string, format string, args ...interface{}) {
+	r.received.problems = append(r.received.problems, Problem{Rule: rule, Message: fmt.Sprintf(format, args...)})
+}
+
+func (r reader) amount(node *xmlNode, path ...string) float64 {
+	value := node.text(path...)
+	if value == "" {
+		return 0
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		r.fail("XML", "%s is not a number", strings.Join(path, "/"))
+		return 0
+	}
+	return parsed
+}
+
+func (r reader) date(layout string, node *xmlNode, path ...string) *time.Time {
+	value := node.text(path...)
+	if value == "" {
+		return nil
+	}
+	parsed, err := time.Parse(layout, value)
+	if err != nil {
+		r.fail("XML", "%s is not a date", strings.Join(path, "/"))
+		return nil
+	}
+	return &parsed
+}
+
+// ReadEInvoice reads a UBL 2.1 or UN/CEFACT CII invoice, telling them apart by the root element
+func ReadEInvoice(document []byte) (*ReceivedInvoice, error) {
+	var root xmlNode
+	decoder := xml.NewDecoder(bytes.NewReader(document))
+	if err := decoder.Decode(&root); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotEInvoice, err)
+	}
+	switch root.XMLName.Local {
+	case "Invoice":
+		return readUBL(&root), nil
+	case "CreditNote":
+		return nil, ErrCreditNote
+	case "CrossIndustryInvoice":
+		if code := root.text("ExchangedDocument", "TypeCode"); code == "381" {
+			return nil, ErrCreditNote
+		}
+		return readCII(&root), nil
+	}
+	return nil, ErrNotEInvoice
+}
+
+func readUBL(root *xmlNode) *ReceivedInvoice {
+	received := &ReceivedInvoice{Format: FormatUBL}
+	r := reader{received: received}
+	received.Number = root.text("ID")
+	if issued := r.date("2006-01-02", root, "IssueDate"); issued != nil {
+		received.IssueDate = *issued
+	}
+	received.DueDate = r.date("2006-01-02", root, "DueDate")
+	received.Currency = root.text("DocumentCurrencyCode")
+	for _, note := range root.all("Note") {
+		received.Notes = append(received.Notes, strings.TrimSpace(note.Content))
+	}
+	received.PaymentTerms = root.text("PaymentTerms", "Note")
+	received.BankAccount = root.text("PaymentMeans", "PayeeFinancialAccount", "ID")
+	received.Supplier = readUBLParty(root, "AccountingSupplierParty")
+	received.Buyer = readUBLParty(root, "AccountingCustomerParty")
+
+	for _, line := range root.all("InvoiceLine") {
+		received.Lines = append(received.Lines, ReceivedLine{
+			Name:          line.text("Item", "Name"),
+			Quantity:      r.amount(line, "InvoicedQuantity"),
+			UnitPrice:     ublUnitPrice(r, line),
+			Allowances:    ublAllowances(r, line, false),
+			Charges:       ublAllowances(r, line, true),
+			Amount:        r.amount(line, "LineExtensionAmount"),
+			TaxCategory:   line.text("Item", "ClassifiedTaxCategory", "ID"),
+			TaxPercentage: r.amount(line, "Item", "ClassifiedTaxCategory", "Percent"),
+		})
+	}
+	received.Allowances = ublAllowances(r, root, false)
+	received.Charges = ublAllowances(r, root, true)
+	// There is a second tax total when the tax is also given in another currency, it has no breakdown
+	for _, taxTotal := range root.all("TaxTotal") {
+		if amounts := taxTotal.all("TaxAmount"); len(amounts) > 0 && amounts[0].attr("currencyID") != "" && amounts[0].attr("currencyID") != received.Currency {
+			continue
+		}
+		received.TaxTotal += r.amount(taxTotal, "TaxAmount")
+		for _, subtotal := range taxTotal.all("TaxSubtotal") {
+			received.Taxes = append(received.Taxes, ReceivedTax{
+				Category:      subtotal.text("TaxCategory", "ID"),
+				Percentage:    r.amount(subtotal, "TaxCategory", "Percent"),
+				TaxableAmount: r.amount(subtotal, "TaxableAmount"),
+				TaxAmount:     r.amount(subtotal, "TaxAmount"),
+			})
+		}
+	}
+	received.LineTotal = r.amount(root, "LegalMonetaryTotal", "LineExtensionAmount")
+	received.TaxExclusive = r.amount(root, "LegalMonetaryTotal", "TaxExclusiveAmount")
+	received.TaxInclusive = r.amount(root, "LegalMonetaryTotal", "TaxInclusiveAmount")
+	received.Prepaid = r.amount(root, "LegalMonetaryTotal", "PrepaidAmount")
+	received.Rounding = r.amount(root, "LegalMonetaryTotal", "PayableRoundingAmount")
+	received.Payable = r.amount(root, "LegalMonetaryTotal", "PayableAmount")
+	return received
+}
+
+func readUBLParty(root *xmlNode, role string) ReceivedParty {
+	party := root.all(role, "Party")
+	if len(party) == 0 {
+		return ReceivedParty{}
+	}
+	received := ReceivedParty{
+		Name:        party[0].text("PartyLegalEntity", "RegistrationName"),
+		Email:       party[0].text("Contact", "ElectronicMail"),
+		PhoneNumber: party[0].text("Contact", "Telephone"),
+		TaxID:       party[0].text("PartyTaxScheme", "CompanyID"),
+		Country:     party[0].text("PostalAddress", "Country", "IdentificationCode"),
+	}
+	if received.Name == "" {
+		received.Name = party[0].text("PartyName", "Name")
+	}
+	if endpoints := party[0].all("EndpointID"); received.Email == "" && len(endpoints) > 0 && endpoints[0].attr("schemeID") == "EM" {
+		received.Email = strings.TrimSpace(endpoints[0].Content)
+	}
+	return received
+}
+
+// ublUnitPrice is the price of one unit, UBL prices can be for a base quantity, eg: per 100
+func ublUnitPrice(r reader, line *xmlNode) float64 {
+	price := r.amount(line, "Price", "PriceAmount")
+	if base := r.amount(line, "Price", "BaseQuantity"); base != 0 {
+		return price / base
+	}
+	return price
+}
+
+func ublAllowances(r reader, node *xmlNode, charges bool) float64 {
+	var total float64
+	for _, allowance := range node.all("AllowanceCharge") {
+		if (allowance.text("ChargeIndicator") == "true") == charges {
+			total += r.amount(allowance, "Amount")
+		}
+	}
+	return total
+}
+
+func readCII(root *xmlNode) *ReceivedInvoice {
+	received := &ReceivedInvoice{Format: FormatCII}
+	r := reader{received: received}
+	received.Number = root.text("ExchangedDocument", "ID")
+	if issued := r.date("20060102", root, "ExchangedDocument", "IssueDateTime", "DateTimeString"); issued != nil {
+		received.IssueDate = *issued
+	}
+	for _, note := range root.all("ExchangedDocument", "IncludedNote") {
+		received.Notes = append(received.Notes, note.text("Content"))
+	}
+
+	transactions := root.all("SupplyChainTradeTransaction")
+	if len(transactions) == 0 {
+		return received
+	}
+	transaction := transactions[0]
+	for _, line := range transaction.all("IncludedSupplyChainTradeLineItem") {
+		price := r.amount(line, "SpecifiedLineTradeAgreement", "NetPriceProductTradePrice", "ChargeAmount")
+		if base := r.amount(line, "SpecifiedLineTradeAgreement", "NetPriceProductTradePrice", "BasisQuantity"); base != 0 {
+			price /= base
+		}
+		settlement := line.all("SpecifiedLineTradeSettlement")
+		received.Lines = append(received.Lines, ReceivedLine{
+			Name:          line.text("SpecifiedTradeProduct", "Name"),
+			Quantity:      r.amount(line, "SpecifiedLineTradeDelivery", "BilledQuantity"),
+			UnitPrice:     price,
+			Allowances:    ciiAllowances(r, settlement, false),
+			Charges:       ciiAllowances(r, settlement, true),
+			Amount:        r.amount(line, "SpecifiedLineTradeSettlement", "SpecifiedTradeSettlementLineMonetarySummation", "LineTotalAmount"),
+			TaxCategory:   line.text("SpecifiedLineTradeSettlement", "ApplicableTradeTax", "CategoryCode"),
+			TaxPercentage: r.amount(line, "SpecifiedLineTradeSettlement", "ApplicableTradeTax", "RateApplicablePercent"),
+		})
+	}
+
+	if sellers := transaction.all("ApplicableHeaderTradeAgreement", "SellerTradeParty"); len(sellers) > 0 {
+		received.Supplier = readCIIParty(sellers[0])
+	}
+	if buyers := transaction.all("ApplicableHeaderTradeAgreement", "BuyerTradeParty"); len(buyers) > 0 {
+		received.Buyer = readCIIParty(buyers[0])
+	}
+
+	settlements := transaction.all("ApplicableHeaderTradeSettlement")
+	if len(settlements) == 0 {
+		return received
+	}
+	settlement := settlements[0]
+	received.Currency = settlement.text("InvoiceCurrencyCode")
+	received.BankAccount = settlement.text("SpecifiedTradeSettlementPaymentMeans", "PayeePartyCreditorFinancialAccount", "IBANID")
+	if received.BankAccount == "" {
+		received.BankAccount = settlement.text("SpecifiedTradeSettlementPaymentMeans", "PayeePartyCreditorFinancialAccount", "ProprietaryID")
+	}
+	received.PaymentTerms = settlement.text("SpecifiedTradePaymentTerms", "Description")
+	received.DueDate = r.date("20060102", settlement, "SpecifiedTradePaymentTerms", "DueDateDateTime", "DateTimeString")
+	for _, tax := range settlement.all("ApplicableTradeTax") {
+		received.Taxes = append(received.Taxes, ReceivedTax{
+			Category:      tax.text("CategoryCode"),
+			Percentage:    r.amount(tax, "RateApplicablePercent"),
+			TaxableAmount: r.amount(tax, "BasisAmount"),
+			TaxAmount:     r.amount(tax, "CalculatedAmount"),
+		})
+	}
+	received.Allowances = ciiAllowances(r, settlements, false)
+	received.Charges = ciiAllowances(r, settlements, true)
+
+	summations := settlement.all("SpecifiedTradeSettlementHeaderMonetarySummation")
+	if len(summations) == 0 {
+		return received
+	}
+	summation := summations[0]
+	received.LineTotal = r.amount(summation, "LineTotalAmount")
+	received.TaxExclusive = r.amount(summation, "TaxBasisTotalAmount")
+	// The tax can be given twice, in the invoice currency and in the tax currency
+	for _, taxTotal := range summation.all("TaxTotalAmount") {
+		if id := taxTotal.attr("currencyID"); id == "" || id == received.Currency {
+			total, err := strconv.ParseFloat(strings.TrimSpace(taxTotal.Content), 64)
+			if err != nil {
+				r.fail("XML", "TaxTotalAmount is not a number")
+			}
+			received.TaxTotal = total
+			break
+		}
+	}
+	received.TaxInclusive = r.amount(summation, "GrandTotalAmount")
+	received.Prepaid = r.amount(summation, "TotalPrepaidAmount")
+	received.Rounding = r.amount(summation, "RoundingAmount")
+	received.Payable = r.amount(summation, "DuePayableAmount")
+	return received
+}
+
+func readCIIParty(party *xmlNode) ReceivedParty {
+	received := ReceivedParty{
+		Name:        party.text("Name"),
+		Email:       party.text("DefinedTradeContact", "EmailURIUniversalCommunication", "URIID"),
+		PhoneNumber: party.text("DefinedTradeContact", "TelephoneUniversalCommunication", "CompleteNumber"),
+		TaxID:       party.text("SpecifiedTaxRegistration", "ID"),
+		Country:     party.text("PostalTradeAddress", "CountryID"),
+	}
+	if uris := party.all("URIUniversalCommunication", "URIID"); received.Email == "" && len(uris) > 0 && uris[0].attr("schemeID") == "EM" {
+		received.Email = strings.TrimSpace(uris[0].Content)
+	}
+	return received
+}
+
+func ciiAllowances(r reader, settlements []*xmlNode, charges bool) float64 {
+	var total float64
+	for _, settlement := range settlements {
+		for _, allowance := range settlement.all("SpecifiedTradeAllowanceCharge") {
+			if (allowance.text("ChargeIndicator", "Indicator") == "true") == charges {
+				total += r.amount(allowance, "ActualAmount")
+			}
+		}
+	}
+	return total
+}
+
+// Check lists what is missing and the totals that do not add up, with the EN 16931 rule they break.
+// Amounts may be a cent apart from rounding.
+func (received *ReceivedInvoice) Check() []Problem {
+	problems := append([]Problem{}, received.problems...)
+	fail := func(rule string, format string, args ...interface{}) {
+		problems = append(problems, Problem{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+	same := func(a float64, b float64) bool {
+		return math.Abs(a-b) < 0.015
+	}
+
+	if received.Number == "" {
+		fail("BR-02", "the invoice number is missing")
+	}
+	if received.IssueDate.IsZero() {
+		fail("BR-03", "the issue date is missing")
+	}
+	if received.Currency == "" {
+		fail("BR-05", "the currency is missing")
+	}
+	if received.Supplier.Name == "" {
+		fail("BR-06", "the supplier name is missing")
+	}
+	if len(received.Lines) == 0 {
+		fail("BR-16", "the invoice has no lines")
+	}
+
+	var lineTotal float64
+	for i, line := range received.Lines {
+		expected := line.Quantity*line.UnitPrice - line.Allowances + line.Charges
+		if !same(expected, line.Amount) {
+			fail("LINE", "line %d: %g x %.2f comes to %.2f, the line says %.2f", i+1, line.Quantity, line.UnitPrice, expected, line.Amount)
+		}
+		lineTotal += line.Amount
+	}
+	if !same(lineTotal, received.LineTotal) {
+		fail("BR-CO-10", "the lines add up to %.2f, the line total says %.2f", lineTotal, received.LineTotal)
+	}
+	if expected := received.LineTotal - received.Allowances + received.Charges; !same(expected, received.TaxExclusive) {
+		fail("BR-CO-13", "line total less allowances plus charges is %.2f, the total without tax says %.2f", expected, received.TaxExclusive)
+	}
+	var taxTotal float64
+	for _, tax := range received.Taxes {
+		taxTotal += tax.TaxAmount
+		if expected := tax.TaxableAmount * tax.Percentage / 100; !same(expected, tax.TaxAmount) {
+			fail("BR-CO-17", "%s tax at %g%% of %.2f is %.2f, the breakdown says %.2f", tax.Category, tax.Percentage, tax.TaxableAmount, expected, tax.TaxAmount)
+		}
+	}
+	if !same(taxTotal, received.TaxTotal) {
+		fail("BR-CO-14", "the tax breakdown adds up to %.2f, the tax total says %.2f", taxTotal, received.TaxTotal)
+	}
+	if expected := received.TaxExclusive + received.TaxTotal; !same(expected, received.TaxInclusive) {
+		fail("BR-CO-15", "the total without tax plus tax is %.2f, the total with tax says %.2f", expected, received.TaxInclusive)
+	}
+	if expected := received.TaxInclusive - received.Prepaid + received.Rounding; !same(expected, received.Payable) {
+		fail("BR-CO-16", "the total less prepaid plus rounding is %.2f, the amount due says %.2f", expected, received.Payable)
+	}
+	return problems
+}
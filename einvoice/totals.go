@@ -0,0 +1,54 @@
+package einvoice
+
+import (
+	"encoding/json"
+	"math"
+	"numerisTask/models"
+)
+
+// invoiceLine is an item with its rounded amount
+type invoiceLine struct {
+	Name      string
+	Quantity  int
+	UnitPrice float64
+	Amount    float64
+}
+
+// invoiceTotals are the figures every e-invoice format carries, worked out once so UBL and CII agree.
+// Lines are rounded one by one as the rules want, the stored total isn't: the difference is the rounding.
+type invoiceTotals struct {
+	Customer     models.CustomerInfo
+	Lines        []invoiceLine
+	LineTotal    float64
+	Allowances   float64 // The invoice discount
+	Charges      float64 // Late fees not waived
+	TaxExclusive float64 // No VAT, also the tax inclusive amount
+	Prepaid      float64 // Payments, credit notes and the early payment discount taken
+	Rounding     float64
+	Payable      float64
+}
+
+func totalsOf(invoice *models.Invoice) invoiceTotals {
+	var totals invoiceTotals
+	_ = json.Unmarshal(invoice.CustomerInfo, &totals.Customer)
+	var items []models.Item
+	_ = json.Unmarshal(invoice.Items, &items)
+
+	for _, item := range items {
+		amount := roundMoney(float64(item.Quantity) * item.UnitPrice)
+		totals.LineTotal += amount
+		totals.Lines = append(totals.Lines, invoiceLine{Name: item.Name, Quantity: item.Quantity, UnitPrice: item.UnitPrice, Amount: amount})
+	}
+	totals.LineTotal = roundMoney(totals.LineTotal)
+	if invoice.IsDiscount && invoice.DiscountPercentage > 0 {
+		totals.Allowances = roundMoney(totals.LineTotal * invoice.DiscountPercentage / 100)
+	}
+	if invoice.FeesAmount > 0 {
+		totals.Charges = roundMoney(invoice.FeesAmount)
+	}
+	totals.TaxExclusive = roundMoney(totals.LineTotal - totals.Allowances + totals.Charges)
+	totals.Prepaid = roundMoney(models.NetPaid(invoice) + invoice.CreditedAmount + invoice.EarlyPaymentDiscountTaken)
+	totals.Payable = roundMoney(math.Max(invoice.OutstandingAmount, 0))
+	totals.Rounding = roundMoney(totals.Payable - totals.TaxExclusive + totals.Prepaid)
+	return totals
+}
@@ -0,0 +1,337 @@
+package einvoice
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"numerisTask/models"
+	"os"
+	"strings"
+)
+
+const (
+	ublInvoiceNamespace = "urn:oasis:names:specification:ubl:schema:xsd:Invoice-2"
+	ublCACNamespace     = "urn:oasis:names:specification:ubl:schema:xsd:CommonAggregateComponents-2"
+	ublCBCNamespace     = "urn:oasis:names:specification:ubl:schema:xsd:CommonBasicComponents-2"
+	peppolCustomization = "urn:cen.eu:en16931:2017#compliant#urn:fdc:peppol.eu:2017:poacc:billing:3.0"
+	peppolProfile       = "urn:fdc:peppol.eu:2017:poacc:billing:01:1.0"
+	// Invoices carry no VAT: every amount is outside the scope of VAT (category O)
+	taxCategory        = "O"
+	taxExemptionReason = "Not subject to VAT"
+)
+
+// Currency of the invoice amounts, INVOICE_CURRENCY (ISO 4217), NGN by default
+func Currency() string {
+	return models.InvoiceCurrency()
+}
+
+// SellerCountry is the country of the sender, SELLER_COUNTRY (ISO 3166-1 alpha-2), NG by default.
+// Customers are taken to be in the same country, they have no address.
+func SellerCountry() string {
+	if country := os.Getenv("SELLER_COUNTRY"); country != "" {
+		return strings.ToUpper(country)
+	}
+	return "NG"
+}
+
+// UBL elements, in the order the schema wants them
+type ublInvoice struct {
+	XMLName                 xml.Name         `xml:"Invoice"`
+	Namespace               string           `xml:"xmlns,attr"`
+	CACNamespace            string           `xml:"xmlns:cac,attr"`
+	CBCNamespace            string           `xml:"xmlns:cbc,attr"`
+	CustomizationID         string           `xml:"cbc:CustomizationID"`
+	ProfileID               string           `xml:"cbc:ProfileID"`
+	ID                      string           `xml:"cbc:ID"`
+	IssueDate               string           `xml:"cbc:IssueDate"`
+	DueDate                 string           `xml:"cbc:DueDate,omitempty"`
+	InvoiceTypeCode         string           `xml:"cbc:InvoiceTypeCode"`
+	Note                    string           `xml:"cbc:Note,omitempty"`
+	DocumentCurrencyCode    string           `xml:"cbc:DocumentCurrencyCode"`
+	BuyerReference          string           `xml:"cbc:BuyerReference"`
+	AccountingSupplierParty ublPartyWrapper  `xml:"cac:AccountingSupplierParty"`
+	AccountingCustomerParty ublPartyWrapper  `xml:"cac:AccountingCustomerParty"`
+	PaymentMeans            *ublPaymentMeans `xml:"cac:PaymentMeans,omitempty"`
+	PaymentTerms            *ublNote         `xml:"cac:PaymentTerms,omitempty"`
+	AllowanceCharges        []ublAllowance   `xml:"cac:AllowanceCharge"`
+	TaxTotal                ublTaxTotal      `xml:"cac:TaxTotal"`
+	LegalMonetaryTotal      ublMonetaryTotal `xml:"cac:LegalMonetaryTotal"`
+	InvoiceLines            []ublInvoiceLine `xml:"cac:InvoiceLine"`
+}
+
+type ublAmount struct {
+	CurrencyID string `xml:"currencyID,attr"`
+	Value      string `xml:",chardata"`
+}
+
+type ublIdentifier struct {
+	SchemeID string `xml:"schemeID,attr,omitempty"`
+	Value    string `xml:",chardata"`
+}
+
+type ublNote struct {
+	Note string `xml:"cbc:Note"`
+}
+
+type ublPartyWrapper struct {
+	Party ublParty `xml:"cac:Party"`
+}
+
+type ublParty struct {
+	EndpointID       ublIdentifier    `xml:"cbc:EndpointID"`
+	PartyName        ublPartyName     `xml:"cac:PartyName"`
+	PostalAddress    ublPostalAddress `xml:"cac:PostalAddress"`
+	PartyLegalEntity ublLegalEntity   `xml:"cac:PartyLegalEntity"`
+	Contact          *ublContact      `xml:"cac:Contact,omitempty"`
+}
+
+type ublPartyName struct {
+	Name string `xml:"cbc:Name"`
+}
+
+type ublPostalAddress struct {
+	Country ublCountry `xml:"cac:Country"`
+}
+
+type ublCountry struct {
+	IdentificationCode string `xml:"cbc:IdentificationCode"`
+}
+
+type ublLegalEntity struct {
+	RegistrationName string `xml:"cbc:RegistrationName"`
+}
+
+type ublContact struct {
+	Telephone      string `xml:"cbc:Telephone,omitempty"`
+	ElectronicMail string `xml:"cbc:ElectronicMail,omitempty"`
+}
+
+type ublPaymentMeans struct {
+	PaymentMeansCode      string              `xml:"cbc:PaymentMeansCode"`
+	PaymentID             string              `xml:"cbc:PaymentID"`
+	PayeeFinancialAccount ublFinancialAccount `xml:"cac:PayeeFinancialAccount"`
+}
+
+type ublFinancialAccount struct {
+	ID                         string     `xml:"cbc:ID"`
+	Name                       string     `xml:"cbc:Name,omitempty"`
+	FinancialInstitutionBranch *ublBranch `xml:"cac:FinancialInstitutionBranch,omitempty"`
+}
+
+type ublBranch struct {
+	ID string `xml:"cbc:ID"`
+}
+
+type ublAllowance struct {
+	ChargeIndicator           bool           `xml:"cbc:ChargeIndicator"`
+	AllowanceChargeReasonCode string         `xml:"cbc:AllowanceChargeReasonCode,omitempty"`
+	AllowanceChargeReason     string         `xml:"cbc:AllowanceChargeReason"`
+	MultiplierFactorNumeric   string         `xml:"cbc:MultiplierFactorNumeric,omitempty"`
+	Amount                    ublAmount      `xml:"cbc:Amount"`
+	BaseAmount                *ublAmount     `xml:"cbc:BaseAmount,omitempty"`
+	TaxCategory               ublTaxCategory `xml:"cac:TaxCategory"`
+}
+
+type ublTaxScheme struct {
+	ID string `xml:"cbc:ID"`
+}
+
+type ublTaxCategory struct {
+	ID                 string       `xml:"cbc:ID"`
+	TaxExemptionReason string       `xml:"cbc:TaxExemptionReason,omitempty"`
+	TaxScheme          ublTaxScheme `xml:"cac:TaxScheme"`
+}
+
+type ublTaxTotal struct {
+	TaxAmount   ublAmount      `xml:"cbc:TaxAmount"`
+	TaxSubtotal ublTaxSubtotal `xml:"cac:TaxSubtotal"`
+}
+
+type ublTaxSubtotal struct {
+	TaxableAmount ublAmount      `xml:"cbc:TaxableAmount"`
+	TaxAmount     ublAmount      `xml:"cbc:TaxAmount"`
+	TaxCategory   ublTaxCategory `xml:"cac:TaxCategory"`
+}
+
+type ublMonetaryTotal struct {
+	LineExtensionAmount   ublAmount  `xml:"cbc:LineExtensionAmount"`
+	TaxExclusiveAmount    ublAmount  `xml:"cbc:TaxExclusiveAmount"`
+	TaxInclusiveAmount    ublAmount  `xml:"cbc:TaxInclusiveAmount"`
+	AllowanceTotalAmount  *ublAmount `xml:"cbc:AllowanceTotalAmount,omitempty"`
+	ChargeTotalAmount     *ublAmount `xml:"cbc:ChargeTotalAmount,omitempty"`
+	PrepaidAmount         *ublAmount `xml:"cbc:PrepaidAmount,omitempty"`
+	PayableRoundingAmount *ublAmount `xml:"cbc:PayableRoundingAmount,omitempty"`
+	PayableAmount         ublAmount  `xml:"cbc:PayableAmount"`
+}
+
+type ublInvoiceLine struct {
+	ID                  string      `xml:"cbc:ID"`
+	InvoicedQuantity    ublQuantity `xml:"cbc:InvoicedQuantity"`
+	LineExtensionAmount ublAmount   `xml:"cbc:LineExtensionAmount"`
+	Item                ublItem     `xml:"cac:Item"`
+	Price               ublPrice    `xml:"cac:Price"`
+}
+
+type ublQuantity struct {
+	UnitCode string `xml:"unitCode,attr"`
+	Value    int    `xml:",chardata"`
+}
+
+type ublItem struct {
+	Name                  string         `xml:"cbc:Name"`
+	ClassifiedTaxCategory ublTaxCategory `xml:"cac:ClassifiedTaxCategory"`
+}
+
+type ublPrice struct {
+	PriceAmount ublAmount `xml:"cbc:PriceAmount"`
+}
+
+func roundMoney(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+func formatAmount(amount float64) string {
+	return fmt.Sprintf("%.2f", roundMoney(amount))
+}
+
+// UBL turns an issued invoice into a UBL 2.1 invoice following Peppol BIS Billing 3.0, the sender being
+// the seller. Late fees are charges, the discount an allowance. The payable amount is what is outstanding:
+// payments, credit notes and the early payment discount taken count as prepaid.
+func UBL(invoice *models.Invoice, seller models.User) ([]byte, error) {
+	if invoice.InvoiceNumber == nil {
+		return nil, fmt.Errorf("a %s invoice has no invoice number yet", invoice.Status)
+	}
+	currency := Currency()
+	amount := func(value float64) ublAmount {
+		return ublAmount{CurrencyID: currency, Value: formatAmount(value)}
+	}
+	category := ublTaxCategory{ID: taxCategory, TaxScheme: ublTaxScheme{ID: "VAT"}}
+	exemptCategory := category
+	exemptCategory.TaxExemptionReason = taxExemptionReason
+	totals := totalsOf(invoice)
+	customerInfo := totals.Customer
+
+	document := ublInvoice{
+		Namespace:            ublInvoiceNamespace,
+		CACNamespace:         ublCACNamespace,
+		CBCNamespace:         ublCBCNamespace,
+		CustomizationID:      peppolCustomization,
+		ProfileID:            peppolProfile,
+		ID:                   *invoice.InvoiceNumber,
+		IssueDate:            invoice.IssueDate().Format("2006-01-02"),
+		InvoiceTypeCode:      "380", // Commercial invoice
+		DocumentCurrencyCode: currency,
+		// Customers have no reference of their own, the invoice number stands in for it
+		BuyerReference: *invoice.InvoiceNumber,
+		AccountingSupplierParty: ublPartyWrapper{Party: ublParty{
+			EndpointID:       ublIdentifier{SchemeID: "EM", Value: seller.Email},
+			PartyName:        ublPartyName{Name: seller.Name},
+			PostalAddress:    ublPostalAddress{Country: ublCountry{IdentificationCode: SellerCountry()}},
+			PartyLegalEntity: ublLegalEntity{RegistrationName: seller.Name},
+			Contact:          &ublContact{ElectronicMail: seller.Email},
+		}},
+		AccountingCustomerParty: ublPartyWrapper{Party: ublParty{
+			EndpointID:       ublIdentifier{SchemeID: "EM", Value: customerInfo.Email},
+			PartyName:        ublPartyName{Name: customerInfo.Name},
+			PostalAddress:    ublPostalAddress{Country: ublCountry{IdentificationCode: SellerCountry()}},
+			PartyLegalEntity: ublLegalEntity{RegistrationName: customerInfo.Name},
+			Contact:          &ublContact{Telephone: customerInfo.PhoneNumber, ElectronicMail: customerInfo.Email},
+		}},
+	}
+	if invoice.DueDate != nil {
+		document.DueDate = invoice.DueDate.Format("2006-01-02")
+	}
+	// Peppol allows a single note on the document (PEPPOL-EN16931-R002), the description and the note share it
+	var notes []string
+	for _, note := range []string{invoice.Description, invoice.Note} {
+		if note != "" {
+			notes = append(notes, note)
+		}
+	}
+	document.Note = strings.Join(notes, "\n")
+	if seller.BankDetail.AccountNumber != "" {
+		document.PaymentMeans = &ublPaymentMeans{
+			PaymentMeansCode: "30", // Credit transfer
+			PaymentID:        *invoice.InvoiceNumber,
+			PayeeFinancialAccount: ublFinancialAccount{
+				ID:   seller.BankDetail.AccountNumber,
+				Name: seller.Name,
+			},
+		}
+		if seller.BankDetail.BankCode != "" {
+			document.PaymentMeans.PayeeFinancialAccount.FinancialInstitutionBranch = &ublBranch{ID: seller.BankDetail.BankCode}
+		}
+	}
+	if terms := invoice.PaymentTerms(); terms > 0 {
+		document.PaymentTerms = &ublNote{Note: fmt.Sprintf("Net %d days", terms)}
+	}
+
+	for i, line := range totals.Lines {
+		document.InvoiceLines = append(document.InvoiceLines, ublInvoiceLine{
+			ID:                  fmt.Sprint(i + 1),
+			InvoicedQuantity:    ublQuantity{UnitCode: "C62", Value: line.Quantity}, // C62 is "one", a unit
+			LineExtensionAmount: amount(line.Amount),
+			Item:                ublItem{Name: line.Name, ClassifiedTaxCategory: category},
+			Price:               ublPrice{PriceAmount: amount(line.UnitPrice)},
+		})
+	}
+
+	if totals.Allowances > 0 {
+		base := amount(totals.LineTotal)
+		document.AllowanceCharges = append(document.AllowanceCharges, ublAllowance{
+			ChargeIndicator:           false,
+			AllowanceChargeReasonCode: "95", // Discount
+			AllowanceChargeReason:     "Discount",
+			MultiplierFactorNumeric:   fmt.Sprint(invoice.DiscountPercentage),
+			Amount:                    amount(totals.Allowances),
+			BaseAmount:                &base,
+			TaxCategory:               category,
+		})
+	}
+	if totals.Charges > 0 {
+		document.AllowanceCharges = append(document.AllowanceCharges, ublAllowance{
+			ChargeIndicator:       true,
+			AllowanceChargeReason: "Late payment fees",
+			Amount:                amount(totals.Charges),
+			TaxCategory:           category,
+		})
+	}
+
+	document.TaxTotal = ublTaxTotal{
+		TaxAmount: amount(0),
+		TaxSubtotal: ublTaxSubtotal{
+			TaxableAmount: amount(totals.TaxExclusive),
+			TaxAmount:     amount(0),
+			TaxCategory:   exemptCategory,
+		},
+	}
+	monetaryTotal := ublMonetaryTotal{
+		LineExtensionAmount: amount(totals.LineTotal),
+		TaxExclusiveAmount:  amount(totals.TaxExclusive),
+		TaxInclusiveAmount:  amount(totals.TaxExclusive),
+		PayableAmount:       amount(totals.Payable),
+	}
+	if totals.Allowances > 0 {
+		total := amount(totals.Allowances)
+		monetaryTotal.AllowanceTotalAmount = &total
+	}
+	if totals.Charges > 0 {
+		total := amount(totals.Charges)
+		monetaryTotal.ChargeTotalAmount = &total
+	}
+	if totals.Prepaid != 0 {
+		paid := amount(totals.Prepaid)
+		monetaryTotal.PrepaidAmount = &paid
+	}
+	if totals.Rounding != 0 {
+		value := amount(totals.Rounding)
+		monetaryTotal.PayableRoundingAmount = &value
+	}
+	document.LegalMonetaryTotal = monetaryTotal
+
+	body, err := xml.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
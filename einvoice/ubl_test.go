@@ -0,0 +1,149 @@
+package einvoice
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"numerisTask/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSeller = models.User{
+	ID:    1,
+	Name:  "Acme Studio",
+	Email: "billing@acme.test",
+	BankDetail: models.UserBankDetail{
+		AccountNumber: "014563892",
+		BankCode:      "bc_acme",
+		BankName:      "Acme Bank",
+	},
+}
+
+// testInvoice is an issued invoice with the items, the amount worked out the way CreateInvoice does
+func testInvoice(items []models.Item, discount float64) *models.Invoice {
+	number := "INV-000042"
+	issued := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	due := issued.AddDate(0, 0, 30)
+	var amount float64
+	for _, item := range items {
+		amount += float64(item.Quantity) * item.UnitPrice
+	}
+	amount -= amount * discount / 100
+	itemsJSON, _ := json.Marshal(items)
+	customerJSON, _ := json.Marshal(models.CustomerInfo{Name: "Globex", Email: "ap@globex.test", PhoneNumber: "+2348000000000"})
+	invoice := &models.Invoice{
+		InvoiceID:          uuid.New(),
+		InvoiceNumber:      &number,
+		IssuedAt:           &issued,
+		DueDate:            &due,
+		Status:             models.SENT,
+		Amount:             amount,
+		Items:              itemsJSON,
+		CustomerInfo:       customerJSON,
+		PaymentHistory:     json.RawMessage(`[]`),
+		IsDiscount:         discount > 0,
+		DiscountPercentage: discount,
+	}
+	models.RecalculateOutstanding(invoice)
+	return invoice
+}
+
+func TestUBLPassesValidation(t *testing.T) {
+	items := []models.Item{{Name: "Design work", Quantity: 3, UnitPrice: 150.5}, {Name: "Hosting", Quantity: 1, UnitPrice: 20}}
+	tests := []struct {
+		name   string
+		seller models.User
+		modify func(invoice *models.Invoice)
+	}{
+		{"plain", testSeller, func(invoice *models.Invoice) {}},
+		{"description and note", testSeller, func(invoice *models.Invoice) {
+			invoice.Description = "March retainer"
+			invoice.Note = "Thank you for your business"
+		}},
+		{"discount", testSeller, func(invoice *models.Invoice) {
+			*invoice = *testInvoice(items, 12.5)
+		}},
+		{"late fee", testSeller, func(invoice *models.Invoice) {
+			invoice.FeesAmount = 25
+			models.RecalculateOutstanding(invoice)
+		}},
+		{"partly paid", testSeller, func(invoice *models.Invoice) {
+			invoice.PaymentHistory, _ = json.Marshal([]models.PaymentHistory{
+				{PaymentID: uuid.New(), Type: models.PAYMENT, AmountPaid: 100, DatePaid: time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)},
+			})
+			invoice.Status = models.PARTIALPAYMENT
+			models.RecalculateOutstanding(invoice)
+		}},
+		{"credited", testSeller, func(invoice *models.Invoice) {
+			invoice.CreditedAmount = 50
+			models.RecalculateOutstanding(invoice)
+		}},
+		{"no bank details", models.User{ID: 1, Name: "Acme Studio", Email: "billing@acme.test"}, func(invoice *models.Invoice) {}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			invoice := testInvoice(items, 0)
+			test.modify(invoice)
+			document, err := UBL(invoice, test.seller)
+			if err != nil {
+				t.Fatalf("UBL: %v", err)
+			}
+			if problems := ValidateUBL(document); len(problems) > 0 {
+				t.Fatalf("expected no problems, got %v\n%s", problems, document)
+			}
+		})
+	}
+}
+
+func TestUBLJoinsNotes(t *testing.T) {
+	invoice := testInvoice([]models.Item{{Name: "Design work", Quantity: 1, UnitPrice: 100}}, 0)
+	invoice.Description = "March retainer"
+	invoice.Note = "Thank you for your business"
+	document, err := UBL(invoice, testSeller)
+	if err != nil {
+		t.Fatalf("UBL: %v", err)
+	}
+	if !strings.Contains(string(document), "<cbc:Note>March retainer&#xA;Thank you for your business</cbc:Note>") {
+		t.Fatalf("expected the description and the note in one Note, got\n%s", document)
+	}
+}
+
+func TestValidateUBLRejects(t *testing.T) {
+	invoice := testInvoice([]models.Item{{Name: "Design work", Quantity: 2, UnitPrice: 100}}, 0)
+	invoice.Note = "Thank you"
+	valid, err := UBL(invoice, testSeller)
+	if err != nil {
+		t.Fatalf("UBL: %v", err)
+	}
+	tests := []struct {
+		name string
+		old  string
+		new  string
+		rule string
+	}{
+		{"two notes", "<cbc:Note>Thank you</cbc:Note>", "<cbc:Note>Thank you</cbc:Note><cbc:Note>Again</cbc:Note>", "PEPPOL-EN16931-R002"},
+		{"due date after type code", "<cbc:DueDate>2026-04-01</cbc:DueDate>\n  <cbc:InvoiceTypeCode>380</cbc:InvoiceTypeCode>", "<cbc:InvoiceTypeCode>380</cbc:InvoiceTypeCode>\n  <cbc:DueDate>2026-04-01</cbc:DueDate>", "UBL"},
+		{"unknown element", "<cbc:BuyerReference>", "<cbc:Reference>x</cbc:Reference><cbc:BuyerReference>", "UBL"},
+		{"wrong customization", peppolCustomization, "urn:cen.eu:en16931:2017", "PEPPOL-EN16931-R004"},
+		{"line total", "<cbc:LineExtensionAmount currencyID=\"NGN\">200.00</cbc:LineExtensionAmount>\n    <cbc:TaxExclusiveAmount", "<cbc:LineExtensionAmount currencyID=\"NGN\">210.00</cbc:LineExtensionAmount>\n    <cbc:TaxExclusiveAmount", "BR-CO-10"},
+		{"no lines", "<cac:InvoiceLine>", "<cac:Ignored>", "BR-16"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if !strings.Contains(string(valid), test.old) {
+				t.Fatalf("the document has no %q to change", test.old)
+			}
+			document := strings.Replace(string(valid), test.old, test.new, 1)
+			if test.name == "no lines" {
+				document = strings.Replace(document, "</cac:InvoiceLine>", "</cac:Ignored>", 1)
+			}
+			for _, problem := range ValidateUBL([]byte(document)) {
+				if problem.Rule == test.rule {
+					return
+				}
+			}
+			t.Fatalf("expected a %s problem, got %v", test.rule, ValidateUBL([]byte(document)))
+		})
+	}
+}
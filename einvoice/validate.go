@@ -0,0 +1,365 @@
+package einvoice
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Problem is a rule the document breaks, by its EN 16931 (BR-) or Peppol (PEPPOL-) rule id
+type Problem struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// xmlNode is a parsed element, looked up by local names so any UBL prefixes work
+type xmlNode struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Content  string     `xml:",chardata"`
+	Children []xmlNode  `xml:",any"`
+}
+
+// all returns the elements at the path below the node
+func (n *xmlNode) all(path ...string) []*xmlNode {
+	nodes := []*xmlNode{n}
+	for _, name := range path {
+		var next []*xmlNode
+		for _, node := range nodes {
+			for i := range node.Children {
+				if node.Children[i].XMLName.Local == name {
+					next = append(next, &node.Children[i])
+				}
+			}
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+// text is the trimmed content of the first element at the path, empty when there is none
+func (n *xmlNode) text(path ...string) string {
+	nodes := n.all(path...)
+	if len(nodes) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(nodes[0].Content)
+}
+
+func (n *xmlNode) attr(name string) string {
+	for _, attr := range n.Attrs {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// UNTDID 1001 codes Peppol allows on an invoice
+var invoiceTypeCodes = map[string]bool{"71": true, "80": true, "82": true, "84": true, "102": true, "218": true,
+	"219": true, "326": true, "380": true, "383": true, "384": true, "386": true, "388": true, "389": true,
+	"390": true, "393": true, "394": true, "395": true, "456": true, "527": true, "575": true, "623": true,
+	"780": true, "817": true, "870": true, "875": true, "876": true, "877": true}
+
+// ublSequences are the child elements of the UBL 2.1 aggregates the documents use, in the order the schema
+// wants them. The Invoice list is complete, anything else at the top of the document is not UBL.
+var ublSequences = map[string][]string{
+	"Invoice": {"UBLExtensions", "UBLVersionID", "CustomizationID", "ProfileID", "ProfileExecutionID", "ID",
+		"CopyIndicator", "UUID", "IssueDate", "IssueTime", "DueDate", "InvoiceTypeCode", "Note", "TaxPointDate",
+		"DocumentCurrencyCode", "TaxCurrencyCode", "PricingCurrencyCode", "PaymentCurrencyCode",
+		"PaymentAlternativeCurrencyCode", "AccountingCostCode", "AccountingCost", "LineCountNumeric", "BuyerReference",
+		"InvoicePeriod", "OrderReference", "BillingReference", "DespatchDocumentReference", "ReceiptDocumentReference",
+		"StatementDocumentReference", "OriginatorDocumentReference", "ContractDocumentReference",
+		"AdditionalDocumentReference", "ProjectReference", "Signature", "AccountingSupplierParty",
+		"AccountingCustomerParty", "PayeeParty", "BuyerCustomerParty", "SellerSupplierParty", "TaxRepresentativeParty",
+		"Delivery", "DeliveryTerms", "PaymentMeans", "PaymentTerms", "PrepaidPayment", "AllowanceCharge",
+		"TaxExchangeRate", "PricingExchangeRate", "PaymentExchangeRate", "PaymentAlternativeExchangeRate", "TaxTotal",
+		"WithholdingTaxTotal", "LegalMonetaryTotal", "InvoiceLine"},
+	"Party": {"MarkCareIndicator", "MarkAttentionIndicator", "WebsiteURI", "LogoReferenceID", "EndpointID",
+		"IndustryClassificationCode", "PartyIdentification", "PartyName", "Language", "PostalAddress",
+		"PhysicalLocation", "PartyTaxScheme", "PartyLegalEntity", "Contact", "Person", "AgentParty",
+		"ServiceProviderParty", "PowerOfAttorney", "FinancialAccount"},
+	"Contact":               {"ID", "Name", "Telephone", "Telefax", "ElectronicMail", "Note", "OtherCommunication"},
+	"PaymentMeans":          {"ID", "PaymentMeansCode", "PaymentDueDate", "PaymentChannelCode", "InstructionID", "InstructionNote", "PaymentID", "CardAccount", "PayerFinancialAccount", "PayeeFinancialAccount", "CreditAccount", "PaymentMandate", "TradeFinancing"},
+	"PayeeFinancialAccount": {"ID", "Name", "AliasName", "AccountTypeCode", "AccountFormatCode", "CurrencyCode", "PaymentNote", "FinancialInstitutionBranch", "Country"},
+	"AllowanceCharge":       {"ID", "ChargeIndicator", "AllowanceChargeReasonCode", "AllowanceChargeReason", "MultiplierFactorNumeric", "PrepaidIndicator", "SequenceNumeric", "Amount", "BaseAmount", "AccountingCostCode", "AccountingCost", "PerUnitAmount", "TaxCategory", "TaxTotal", "PaymentMeans"},
+	"TaxTotal":              {"TaxAmount", "RoundingAmount", "TaxEvidenceIndicator", "TaxIncludedIndicator", "TaxSubtotal"},
+	"TaxSubtotal":           {"TaxableAmount", "TaxAmount", "CalculationSequenceNumeric", "TransactionCurrencyTaxAmount", "Percent", "BaseUnitMeasure", "PerUnitAmount", "TierRange", "TierRatePercent", "TaxCategory"},
+	"TaxCategory":           {"ID", "Name", "Percent", "BaseUnitMeasure", "PerUnitAmount", "TaxExemptionReasonCode", "TaxExemptionReason", "TierRange", "TierRatePercent", "TaxScheme"},
+	"ClassifiedTaxCategory": {"ID", "Name", "Percent", "BaseUnitMeasure", "PerUnitAmount", "TaxExemptionReasonCode", "TaxExemptionReason", "TierRange", "TierRatePercent", "TaxScheme"},
+	"LegalMonetaryTotal":    {"LineExtensionAmount", "TaxExclusiveAmount", "TaxInclusiveAmount", "AllowanceTotalAmount", "ChargeTotalAmount", "PrepaidAmount", "PayableRoundingAmount", "PayableAmount", "PayableAlternativeAmount"},
+	"InvoiceLine": {"ID", "UUID", "Note", "InvoicedQuantity", "LineExtensionAmount", "TaxPointDate", "AccountingCostCode",
+		"AccountingCost", "PaymentPurposeCode", "FreeOfChargeIndicator", "InvoicePeriod", "OrderLineReference",
+		"DespatchLineReference", "ReceiptLineReference", "BillingReference", "DocumentReference", "PricingReference",
+		"OriginatorParty", "Delivery", "PaymentTerms", "AllowanceCharge", "TaxTotal", "WithholdingTaxTotal", "Item",
+		"Price", "DeliveryTerms", "SubInvoiceLine", "ItemPriceExtension"},
+}
+
+// checkOrder reports the children of the node that are out of the schema order, going down the aggregates
+// there is a sequence for. Unknown children are only reported on the Invoice itself.
+func checkOrder(node *xmlNode, fail func(rule string, format string, args ...interface{})) {
+	if sequence, ok := ublSequences[node.XMLName.Local]; ok {
+		positions := map[string]int{}
+		for i, name := range sequence {
+			positions[name] = i
+		}
+		last := -1
+		for _, child := range node.Children {
+			position, known := positions[child.XMLName.Local]
+			if !known {
+				if node.XMLName.Local == "Invoice" {
+					fail("UBL", "%s is not an element of a UBL invoice", child.XMLName.Local)
+				}
+				continue
+			}
+			if position < last {
+				fail("UBL", "%s is out of order in %s, the schema wants it before %s", child.XMLName.Local, node.XMLName.Local, sequence[last])
+			}
+			if position > last {
+				last = position
+			}
+		}
+	}
+	for i := range node.Children {
+		checkOrder(&node.Children[i], fail)
+	}
+}
+
+var (
+	isoDate      = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+	countryCode  = regexp.MustCompile(`^[A-Z]{2}$`)
+)
+
+// ValidateUBL checks a UBL invoice against the UBL 2.1 structure and the EN 16931 and Peppol BIS Billing 3.0
+// rules that apply to invoices without VAT: element order, mandatory elements, code formats and the totals. It is a
+// local check of the rules, not the full schema and Schematron run. An empty list means the document passed.
+func ValidateUBL(document []byte) []Problem {
+	var root xmlNode
+	decoder := xml.NewDecoder(bytes.NewReader(document))
+	if err := decoder.Decode(&root); err != nil {
+		return []Problem{{Rule: "XML", Message: "document is not well formed: " + err.Error()}}
+	}
+	var problems []Problem
+	fail := func(rule string, format string, args ...interface{}) {
+		problems = append(problems, Problem{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+	if root.XMLName.Local != "Invoice" || root.XMLName.Space != ublInvoiceNamespace {
+		fail("UBL", "root element must be Invoice in the %s namespace", ublInvoiceNamespace)
+		return problems
+	}
+	checkOrder(&root, fail)
+
+	required := []struct {
+		rule string
+		path []string
+	}{
+		{"BR-01", []string{"CustomizationID"}},
+		{"BR-02", []string{"ID"}},
+		{"BR-03", []string{"IssueDate"}},
+		{"BR-04", []string{"InvoiceTypeCode"}},
+		{"BR-05", []string{"DocumentCurrencyCode"}},
+		{"BR-06", []string{"AccountingSupplierParty", "Party", "PartyLegalEntity", "RegistrationName"}},
+		{"BR-07", []string{"AccountingCustomerParty", "Party", "PartyLegalEntity", "RegistrationName"}},
+		{"BR-09", []string{"AccountingSupplierParty", "Party", "PostalAddress", "Country", "IdentificationCode"}},
+		{"BR-11", []string{"AccountingCustomerParty", "Party", "PostalAddress", "Country", "IdentificationCode"}},
+		{"BR-12", []string{"LegalMonetaryTotal", "LineExtensionAmount"}},
+		{"BR-13", []string{"LegalMonetaryTotal", "TaxExclusiveAmount"}},
+		{"BR-14", []string{"LegalMonetaryTotal", "TaxInclusiveAmount"}},
+		{"BR-15", []string{"LegalMonetaryTotal", "PayableAmount"}},
+		{"PEPPOL-EN16931-R001", []string{"ProfileID"}},
+		{"PEPPOL-EN16931-R020", []string{"AccountingSupplierParty", "Party", "EndpointID"}},
+		{"PEPPOL-EN16931-R010", []string{"AccountingCustomerParty", "Party", "EndpointID"}},
+	}
+	for _, element := range required {
+		if root.text(element.path...) == "" {
+			fail(element.rule, "%s is required", strings.Join(element.path, "/"))
+		}
+	}
+
+	if id := root.text("CustomizationID"); id != "" && id != peppolCustomization {
+		fail("PEPPOL-EN16931-R004", "CustomizationID must be %s", peppolCustomization)
+	}
+	if profile := root.text("ProfileID"); profile != "" && profile != peppolProfile {
+		fail("PEPPOL-EN16931-R007", "ProfileID must be %s", peppolProfile)
+	}
+	if len(root.all("Note")) > 1 {
+		fail("PEPPOL-EN16931-R002", "no more than one Note is allowed on document level")
+	}
+	if root.text("BuyerReference") == "" && root.text("OrderReference", "ID") == "" {
+		fail("PEPPOL-EN16931-R003", "BuyerReference or OrderReference/ID is required")
+	}
+	for _, date := range []string{"IssueDate", "DueDate"} {
+		if value := root.text(date); value != "" && !isoDate.MatchString(value) {
+			fail("UBL", "%s must be a date format, eg: 2006-01-02", date)
+		}
+	}
+	if code := root.text("InvoiceTypeCode"); code != "" && !invoiceTypeCodes[code] {
+		fail("BR-CL-01", "InvoiceTypeCode %s is not an invoice type code", code)
+	}
+	currency := root.text("DocumentCurrencyCode")
+	if currency != "" && !currencyCode.MatchString(currency) {
+		fail("BR-CL-04", "DocumentCurrencyCode must be an ISO 4217 code")
+	}
+	for _, party := range []string{"AccountingSupplierParty", "AccountingCustomerParty"} {
+		if code := root.text(party, "Party", "PostalAddress", "Country", "IdentificationCode"); code != "" && !countryCode.MatchString(code) {
+			fail("BR-CL-14", "%s country must be an ISO 3166-1 alpha-2 code", party)
+		}
+		if endpoints := root.all(party, "Party", "EndpointID"); len(endpoints) > 0 && endpoints[0].attr("schemeID") == "" {
+			fail("PEPPOL-EN16931-R020", "%s EndpointID needs a schemeID", party)
+		}
+	}
+
+	// Every amount is in the document currency, the tax can also be in the tax currency
+	taxCurrency := root.text("TaxCurrencyCode")
+	var amounts func(node *xmlNode)
+	amounts = func(node *xmlNode) {
+		id := node.attr("currencyID")
+		if id != "" && id != currency && !(node.XMLName.Local == "TaxAmount" && id == taxCurrency) {
+			fail("BR-CL-03", "%s is in %s, the document is in %s", node.XMLName.Local, id, currency)
+		}
+		for i := range node.Children {
+			amounts(&node.Children[i])
+		}
+	}
+	amounts(&root)
+
+	number := func(node *xmlNode, path ...string) (float64, bool) {
+		value := node.text(path...)
+		if value == "" {
+			return 0, false
+		}
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			fail("UBL", "%s is not a number", strings.Join(path, "/"))
+			return 0, false
+		}
+		return parsed, true
+	}
+	same := func(a float64, b float64) bool {
+		return math.Abs(a-b) < 0.005
+	}
+
+	lines := root.all("InvoiceLine")
+	if len(lines) == 0 {
+		fail("BR-16", "an invoice needs at least one InvoiceLine")
+	}
+	var lineTotal float64
+	for i, line := range lines {
+		position := i + 1
+		if line.text("ID") == "" {
+			fail("BR-21", "line %d needs an ID", position)
+		}
+		quantity, ok := number(line, "InvoicedQuantity")
+		if !ok {
+			fail("BR-22", "line %d needs an InvoicedQuantity", position)
+		}
+		if quantities := line.all("InvoicedQuantity"); len(quantities) > 0 && quantities[0].attr("unitCode") == "" {
+			fail("BR-23", "line %d InvoicedQuantity needs a unitCode", position)
+		}
+		lineAmount, ok := number(line, "LineExtensionAmount")
+		if !ok {
+			fail("BR-24", "line %d needs a LineExtensionAmount", position)
+		}
+		lineTotal += lineAmount
+		if line.text("Item", "Name") == "" {
+			fail("BR-25", "line %d needs an Item/Name", position)
+		}
+		price, ok := number(line, "Price", "PriceAmount")
+		if !ok {
+			fail("BR-26", "line %d needs a Price/PriceAmount", position)
+		} else if price < 0 {
+			fail("BR-27", "line %d price can not be negative", position)
+		}
+		if line.text("Item", "ClassifiedTaxCategory", "ID") == "" {
+			fail("BR-CO-04", "line %d needs an Item/ClassifiedTaxCategory/ID", position)
+		}
+		if !same(lineAmount, math.Round(quantity*price*100)/100) {
+			fail("PEPPOL-EN16931-R120", "line %d LineExtensionAmount must be quantity times price", position)
+		}
+	}
+
+	var allowances, charges float64
+	for i, allowance := range root.all("AllowanceCharge") {
+		amount, ok := number(allowance, "Amount")
+		if !ok {
+			fail("BR-31", "allowance or charge %d needs an Amount", i+1)
+		}
+		if allowance.text("TaxCategory", "ID") == "" {
+			fail("BR-32", "allowance or charge %d needs a TaxCategory/ID", i+1)
+		}
+		if allowance.text("AllowanceChargeReason") == "" && allowance.text("AllowanceChargeReasonCode") == "" {
+			fail("BR-33", "allowance or charge %d needs a reason or a reason code", i+1)
+		}
+		if factor, ok := number(allowance, "MultiplierFactorNumeric"); ok {
+			if base, ok := number(allowance, "BaseAmount"); ok && !same(amount, math.Round(base*factor)/100) {
+				fail("PEPPOL-EN16931-R040", "allowance or charge %d Amount must be BaseAmount times MultiplierFactorNumeric / 100", i+1)
+			}
+		}
+		if allowance.text("ChargeIndicator") == "true" {
+			charges += amount
+		} else {
+			allowances += amount
+		}
+	}
+
+	total := func(name string) float64 {
+		value, _ := number(&root, "LegalMonetaryTotal", name)
+		return value
+	}
+	if !same(total("LineExtensionAmount"), lineTotal) {
+		fail("BR-CO-10", "LineExtensionAmount must be the sum of the line amounts")
+	}
+	if !same(total("AllowanceTotalAmount"), allowances) {
+		fail("BR-CO-11", "AllowanceTotalAmount must be the sum of the document allowances")
+	}
+	if !same(total("ChargeTotalAmount"), charges) {
+		fail("BR-CO-12", "ChargeTotalAmount must be the sum of the document charges")
+	}
+	taxExclusive := total("TaxExclusiveAmount")
+	if !same(taxExclusive, total("LineExtensionAmount")-total("AllowanceTotalAmount")+total("ChargeTotalAmount")) {
+		fail("BR-CO-13", "TaxExclusiveAmount must be the line amounts less allowances plus charges")
+	}
+
+	taxTotals := root.all("TaxTotal")
+	var taxAmount float64
+	if len(taxTotals) == 0 {
+		fail("BR-CO-18", "a TaxTotal with a TaxSubtotal is required")
+	}
+	for _, taxTotal := range taxTotals {
+		amount, _ := number(taxTotal, "TaxAmount")
+		taxAmount += amount
+		for _, subtotal := range taxTotal.all("TaxSubtotal") {
+			category := subtotal.text("TaxCategory", "ID")
+			if category == "" {
+				fail("BR-CO-18", "TaxSubtotal needs a TaxCategory/ID")
+			}
+			if category == "O" {
+				if subtotalTax, _ := number(subtotal, "TaxAmount"); subtotalTax != 0 {
+					fail("BR-O-09", "TaxAmount of a category O subtotal must be 0")
+				}
+				if subtotal.text("TaxCategory", "TaxExemptionReason") == "" && subtotal.text("TaxCategory", "TaxExemptionReasonCode") == "" {
+					fail("BR-O-10", "a category O subtotal needs an exemption reason")
+				}
+				if root.text("AccountingSupplierParty", "Party", "PartyTaxScheme", "CompanyID") != "" {
+					fail("BR-O-02", "a seller VAT identifier is not allowed with category O")
+				}
+			}
+		}
+	}
+	if !same(total("TaxInclusiveAmount"), taxExclusive+taxAmount) {
+		fail("BR-CO-15", "TaxInclusiveAmount must be TaxExclusiveAmount plus the tax")
+	}
+	payable := total("PayableAmount")
+	if !same(payable, total("TaxInclusiveAmount")-total("PrepaidAmount")+total("PayableRoundingAmount")) {
+		fail("BR-CO-16", "PayableAmount must be TaxInclusiveAmount less PrepaidAmount plus PayableRoundingAmount")
+	}
+	if payable > 0 && root.text("DueDate") == "" && root.text("PaymentTerms", "Note") == "" {
+		fail("BR-CO-25", "a positive PayableAmount needs a DueDate or PaymentTerms")
+	}
+	return problems
+}
@@ -0,0 +1,53 @@
+package events
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	InvoiceOverdue        = "invoice.overdue"         // Unpaid past its due date
+	InvoiceOverdueCleared = "invoice.overdue_cleared" // Due date extended, no longer overdue
+)
+
+// Event is something that happened to a document that other parts of the app can react to
+type Event struct {
+	Type       string                 `json:"type"`
+	Subject    string                 `json:"subject"` // ID of the document the event is about
+	OccurredAt time.Time              `json:"occurred_at"`
+	Data       map[string]interface{} `json:"data"`
+}
+
+// Handler reacts to an event, an error is only logged
+type Handler func(event Event) error
+
+var (
+	lock     sync.RWMutex
+	handlers = map[string][]Handler{}
+)
+
+// Subscribe registers a handler for an event type
+func Subscribe(eventType string, handler Handler) {
+	lock.Lock()
+	defer lock.Unlock()
+	handlers[eventType] = append(handlers[eventType], handler)
+}
+
+// Publish logs the event and hands it to the handlers of its type, in the order they subscribed
+func Publish(event Event) {
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now()
+	}
+	log.Printf("EVENT %s subject=%s data=%v", event.Type, event.Subject, event.Data)
+
+	lock.RLock()
+	subscribed := handlers[event.Type]
+	lock.RUnlock()
+	for _, handler := range subscribed {
+		if err := handler(event); err != nil {
+			log.Printf("Error handling event %s for %s: %v", event.Type, event.Subject, err)
+		}
+	}
+}
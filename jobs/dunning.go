@@ -0,0 +1,31 @@
+package jobs
+
+import (
+	"log"
+	"numerisTask/mailer"
+	"numerisTask/models"
+	"time"
+)
+
+func init() {
+	register(Job{Name: "dunning", Run: RunDunning})
+}
+
+// RunDunning takes the dunning steps that came due on past due invoices, messaging customers on the step channels
+func RunDunning(now time.Time) error {
+	ids, err := models.DunningInvoiceIDs(now)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		taken, err := models.AdvanceDunning(id, now, mailer.SendDunningMessage)
+		if err != nil {
+			log.Printf("Error advancing dunning of invoice %s: %v", id, err)
+			continue
+		}
+		for _, step := range taken {
+			log.Printf("Dunning step %d (%s) taken on invoice %s, sent by %v", step.Step, step.Action, id, step.SentBy)
+		}
+	}
+	return nil
+}
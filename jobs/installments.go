@@ -0,0 +1,30 @@
+package jobs
+
+import (
+	"log"
+	"numerisTask/mailer"
+	"numerisTask/models"
+	"time"
+)
+
+// installmentReminderWindow is how long before its due date an installment reminder goes out
+const installmentReminderWindow = 3 * 24 * time.Hour
+
+func init() {
+	register(Job{Name: "installments", Run: TrackInstallments})
+}
+
+// TrackInstallments updates the installment statuses and emails reminders and overdue notices
+func TrackInstallments(now time.Time) error {
+	ids, err := models.InstallmentInvoiceIDs()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		err = models.UpdateInstallmentTracking(id, now, installmentReminderWindow, mailer.SendInstallmentReminder)
+		if err != nil {
+			log.Printf("Error tracking installments of invoice %s: %v", id, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package jobs
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// Job is one piece of background work, run on every tick with the tick time
+type Job struct {
+	Name string
+	Run  func(now time.Time) error
+}
+
+var registered []Job
+
+func register(job Job) {
+	registered = append(registered, job)
+}
+
+// RunAll runs every registered job once, logging failures so one job cannot stop the others
+func RunAll(now time.Time) {
+	for _, job := range registered {
+		if err := job.Run(now); err != nil {
+			log.Printf("JOB %s failed: %v", job.Name, err)
+		}
+	}
+}
+
+// Start runs the jobs now and then every JOBS_INTERVAL (a Go duration, default 1h) in the background
+func Start() {
+	interval := time.Hour
+	if value := os.Getenv("JOBS_INTERVAL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			log.Printf("Invalid JOBS_INTERVAL %q, using %s", value, interval)
+		} else {
+			interval = parsed
+		}
+	}
+
+	go func() {
+		RunAll(time.Now())
+		ticker := time.NewTicker(interval)
+		for now := range ticker.C {
+			RunAll(now)
+		}
+	}()
+}
@@ -0,0 +1,30 @@
+package jobs
+
+import (
+	"log"
+	"numerisTask/models"
+	"time"
+)
+
+func init() {
+	register(Job{Name: "late fees", Run: ApplyLateFees})
+}
+
+// ApplyLateFees charges the late fee policies of every overdue invoice
+func ApplyLateFees(now time.Time) error {
+	ids, err := models.OverdueInvoiceIDs(now)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		fee, err := models.ApplyLateFee(id, now)
+		if err != nil {
+			log.Printf("Error applying late fee to invoice %s: %v", id, err)
+			continue
+		}
+		if fee != nil {
+			log.Printf("Applied late fee of %.2f to invoice %s", fee.Amount, id)
+		}
+	}
+	return nil
+}
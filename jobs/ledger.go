@@ -0,0 +1,15 @@
+package jobs
+
+import (
+	"numerisTask/models"
+	"time"
+)
+
+func init() {
+	register(Job{Name: "ledger catch-up", Run: PostMissingLedgerEntries})
+}
+
+// PostMissingLedgerEntries posts the invoices and bills from before the ledger existed, it does nothing after that
+func PostMissingLedgerEntries(now time.Time) error {
+	return models.PostMissingLedgerEntries()
+}
@@ -0,0 +1,31 @@
+package jobs
+
+import (
+	"log"
+	"numerisTask/events"
+	"numerisTask/models"
+	"time"
+)
+
+func init() {
+	register(Job{Name: "overdue invoices", Run: UpdateOverdueInvoices})
+}
+
+// UpdateOverdueInvoices moves unpaid invoices past their due date to OVERDUE, and back when the due date was extended
+func UpdateOverdueInvoices(now time.Time) error {
+	ids, err := models.OverdueCheckInvoiceIDs(now)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		invoice, previous, changed, err := models.UpdateOverdueStatus(id, now)
+		if err != nil {
+			log.Printf("Error updating overdue status of invoice %s: %v", id, err)
+			continue
+		}
+		if changed {
+			events.Publish(models.OverdueEvent(invoice, previous))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package jobs
+
+import (
+	"log"
+	"numerisTask/models"
+	"time"
+)
+
+func init() {
+	register(Job{Name: "expire quotes", Run: ExpireQuotes})
+}
+
+// ExpireQuotes moves quotes nobody answered before their expiry date to EXPIRED
+func ExpireQuotes(now time.Time) error {
+	expired, err := models.ExpireQuotes(now)
+	if expired > 0 {
+		log.Printf("Expired %d quotes", expired)
+	}
+	return err
+}
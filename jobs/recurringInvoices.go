@@ -0,0 +1,46 @@
+package jobs
+
+import (
+	"log"
+	"numerisTask/mailer"
+	"numerisTask/models"
+	"time"
+)
+
+func init() {
+	register(Job{Name: "recurring invoices", Run: GenerateRecurringInvoices})
+}
+
+// GenerateRecurringInvoices creates the invoices of every schedule with a run due, catching up on
+// runs missed while the server was down, and sends them when the schedule says so
+func GenerateRecurringInvoices(now time.Time) error {
+	ids, err := models.DueRecurringInvoiceIDs(now)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		for {
+			invoice, recurringInvoice, err := models.GenerateRecurringInvoice(id, now)
+			if err != nil {
+				log.Printf("Error generating invoice for recurring invoice %s: %v", id, err)
+				break
+			}
+			if invoice == nil {
+				break
+			}
+			log.Printf("Generated invoice %s from recurring invoice %s", invoice.InvoiceID, id)
+
+			// Invoices held for approval are sent by hand once approved
+			if recurringInvoice.AutoSend && invoice.Status == models.PENDINGAPPROVAL {
+				log.Printf("Invoice %s is waiting for approval, not sending it", invoice.InvoiceID)
+			} else if recurringInvoice.AutoSend {
+				if err = mailer.SendInvoice(invoice); err != nil {
+					log.Printf("Error sending invoice %s: %v", invoice.InvoiceID, err)
+				} else if err = models.MarkInvoiceSent(invoice); err != nil {
+					log.Printf("Error marking invoice %s sent: %v", invoice.InvoiceID, err)
+				}
+			}
+		}
+	}
+	return nil
+}
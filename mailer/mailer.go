@@ -0,0 +1,235 @@
+package mailer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"numerisTask/models"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNoRecipient     = errors.New("customer has no email address")
+	ErrNoPhoneNumber   = errors.New("customer has no phone number")
+	ErrUnknownChannel  = errors.New("unknown channel")
+	ErrSMSNotDelivered = errors.New("sms gateway did not accept the message")
+)
+
+// Attachment is a file sent along with a message
+type Attachment struct {
+	FileName    string
+	ContentType string
+	Data        []byte
+}
+
+// Send emails a plain text message through the SMTP server in the environment.
+// Without SMTP_HOST the message is only logged, which is what local development gets.
+func Send(to string, subject string, body string, attachments ...Attachment) error {
+	if to == "" {
+		return ErrNoRecipient
+	}
+	host := os.Getenv("SMTP_HOST")
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		from = models.PlaceHolderUser.Email
+	}
+	if host == "" {
+		log.Printf("MAIL (not sent, SMTP_HOST not set) to=%s subject=%q attachments=%d\n%s", to, subject, len(attachments), body)
+		return nil
+	}
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+
+	var auth smtp.Auth
+	if username := os.Getenv("SMTP_USERNAME"); username != "" {
+		auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+	}
+	headers := []string{
+		"From: " + from,
+		"To: " + to,
+		"Subject: " + subject,
+		"MIME-Version: 1.0",
+	}
+	var message string
+	if len(attachments) == 0 {
+		message = strings.Join(append(headers,
+			"Content-Type: text/plain; charset=\"utf-8\"",
+			"",
+			body,
+		), "\r\n")
+	} else {
+		message = strings.Join(headers, "\r\n") + "\r\n" + multipartBody(body, attachments)
+	}
+	return smtp.SendMail(fmt.Sprintf("%s:%s", host, port), auth, from, []string{to}, []byte(message))
+}
+
+// multipartBody puts the text and the base64 encoded attachments in a multipart/mixed body, headers included
+func multipartBody(body string, attachments []Attachment) string {
+	var out bytes.Buffer
+	parts := multipart.NewWriter(&out)
+	text, _ := parts.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=\"utf-8\""}})
+	text.Write([]byte(body))
+	for _, attachment := range attachments {
+		part, _ := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {attachment.ContentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.FileName})},
+		})
+		encoded := base64.StdEncoding.EncodeToString(attachment.Data)
+		// Lines of a message are kept under 78 characters
+		for len(encoded) > 76 {
+			part.Write([]byte(encoded[:76] + "\r\n"))
+			encoded = encoded[76:]
+		}
+		part.Write([]byte(encoded + "\r\n"))
+	}
+	parts.Close()
+	return "Content-Type: multipart/mixed; boundary=" + parts.Boundary() + "\r\n\r\n" + out.String()
+}
+
+// SendInvoice emails the invoice summary and payment details to the customer
+func SendInvoice(invoice *models.Invoice) error {
+	user := models.PlaceHolderUser
+	var customerInfo models.CustomerInfo
+	_ = json.Unmarshal(invoice.CustomerInfo, &customerInfo)
+
+	subject := fmt.Sprintf("Invoice from %s", user.Name)
+	body := fmt.Sprintf(
+		"Hello %s,\n\nPlease find below the invoice %s.\n\nAmount due: %.2f\nDue date: %s\n\n%s\n\nPay to %s, account number %s (%s).\n\nThank you,\n%s",
+		customerInfo.Name,
+		invoice.Number(),
+		invoice.OutstandingAmount,
+		invoice.DueDate.Format("2006-01-02"),
+		invoice.Description,
+		user.BankDetail.BankName,
+		user.BankDetail.AccountNumber,
+		user.BankDetail.BankCode,
+		user.Name,
+	)
+	return Send(customerInfo.Email, subject, body)
+}
+
+// SendQuote emails the quote summary to the customer
+func SendQuote(quote *models.Quote) error {
+	user := models.PlaceHolderUser
+	var customerInfo models.CustomerInfo
+	_ = json.Unmarshal(quote.CustomerInfo, &customerInfo)
+
+	subject := fmt.Sprintf("Quote %s from %s", quote.QuoteNumber, user.Name)
+	body := fmt.Sprintf(
+		"Hello %s,\n\nPlease find below our quote %s.\n\nAmount: %.2f\nValid until: %s\n\n%s\n\nThank you,\n%s",
+		customerInfo.Name,
+		quote.QuoteNumber,
+		quote.Amount,
+		quote.ExpiryDate.Format("2006-01-02"),
+		quote.Description,
+		user.Name,
+	)
+	return Send(customerInfo.Email, subject, body)
+}
+
+// SendInstallmentReminder emails the customer about an installment coming due, or one that was missed
+func SendInstallmentReminder(invoice *models.Invoice, installment models.Installment) error {
+	user := models.PlaceHolderUser
+	var customerInfo models.CustomerInfo
+	_ = json.Unmarshal(invoice.CustomerInfo, &customerInfo)
+
+	subject := fmt.Sprintf("Installment %d of invoice from %s is due", installment.Sequence, user.Name)
+	intro := "This is a reminder that the installment below is due soon."
+	if installment.Status == models.INSTALLMENTOVERDUE {
+		subject = fmt.Sprintf("Installment %d of invoice from %s is overdue", installment.Sequence, user.Name)
+		intro = "The installment below was not paid by its due date."
+	}
+	body := fmt.Sprintf(
+		"Hello %s,\n\n%s\n\nInvoice: %s\nInstallment: %d\nAmount due: %.2f\nDue date: %s\n\nPay to %s, account number %s (%s).\n\nThank you,\n%s",
+		customerInfo.Name,
+		intro,
+		invoice.Number(),
+		installment.Sequence,
+		installment.Amount-installment.AmountPaid,
+		installment.DueDate.Format("2006-01-02"),
+		user.BankDetail.BankName,
+		user.BankDetail.AccountNumber,
+		user.BankDetail.BankCode,
+		user.Name,
+	)
+	return Send(customerInfo.Email, subject, body)
+}
+
+// SendStatement emails the statement of account to the customer, the PDF attached
+func SendStatement(statement *models.Statement, document []byte) error {
+	user := models.PlaceHolderUser
+	subject := fmt.Sprintf("Statement of account from %s", user.Name)
+	body := fmt.Sprintf(
+		"Hello %s,\n\nPlease find attached your statement of account from %s to %s.\n\nOpening balance: %.2f\nCharges: %.2f\nCredits and payments: %.2f\nClosing balance: %.2f %s\n",
+		statement.Customer.Name,
+		statement.From.Format("2006-01-02"),
+		statement.To.Format("2006-01-02"),
+		statement.OpeningBalance,
+		statement.TotalDebit,
+		statement.TotalCredit,
+		statement.ClosingBalance,
+		statement.Currency,
+	)
+	if overdue := statement.ClosingBalance - statement.Aging.Current; overdue > 0 {
+		body += fmt.Sprintf("Of which overdue: %.2f\n", overdue)
+	}
+	if statement.ClosingBalance > 0 {
+		body += fmt.Sprintf("\nPay to %s, account number %s (%s).\n", user.BankDetail.BankName, user.BankDetail.AccountNumber, user.BankDetail.BankCode)
+	}
+	body += fmt.Sprintf("\nThank you,\n%s", user.Name)
+	return Send(statement.Customer.Email, subject, body, Attachment{
+		FileName:    "statement-" + statement.To.Format("2006-01-02") + ".pdf",
+		ContentType: "application/pdf",
+		Data:        document,
+	})
+}
+
+// SendSMS texts the message through the gateway at SMS_WEBHOOK_URL, which gets a JSON {"to", "body"} POST.
+// Without SMS_WEBHOOK_URL the message is only logged, like mail without SMTP_HOST.
+func SendSMS(to string, body string) error {
+	if to == "" {
+		return ErrNoPhoneNumber
+	}
+	webhook := os.Getenv("SMS_WEBHOOK_URL")
+	if webhook == "" {
+		log.Printf("SMS (not sent, SMS_WEBHOOK_URL not set) to=%s\n%s", to, body)
+		return nil
+	}
+	payload, _ := json.Marshal(map[string]string{"to": to, "body": body})
+	client := http.Client{Timeout: 10 * time.Second}
+	response, err := client.Post(webhook, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("%w: %s", ErrSMSNotDelivered, response.Status)
+	}
+	return nil
+}
+
+// SendDunningMessage sends the message of a dunning step to the customer on the channel
+func SendDunningMessage(invoice *models.Invoice, channel models.DunningChannel, message models.DunningMessage) error {
+	var customerInfo models.CustomerInfo
+	_ = json.Unmarshal(invoice.CustomerInfo, &customerInfo)
+	switch channel {
+	case models.DUNNINGEMAIL:
+		return Send(customerInfo.Email, message.Subject, message.Body)
+	case models.DUNNINGSMS:
+		return SendSMS(customerInfo.PhoneNumber, message.Body)
+	}
+	return ErrUnknownChannel
+}
@@ -9,6 +9,7 @@ import (
 	"log"
 	"net/http"
 	"numerisTask/api"
+	"numerisTask/jobs"
 	"numerisTask/models"
 	"os"
 )
@@ -19,8 +20,120 @@ func registerAPI(router *chi.Mux) {
 		apiRouter.Get("/", api.GetInvoices)
 		apiRouter.Get("/{invoiceId}", api.GetInvoiceByInvoiceId)
 		apiRouter.Get("/dashboard", api.GetInvoiceDashBoard)
+		apiRouter.Get("/archived", api.GetArchivedInvoices)
+		apiRouter.Get("/export", api.ExportInvoices)
 		apiRouter.Post("/", api.CreateInvoice)
+		apiRouter.Post("/import", api.ImportInvoices)
 		apiRouter.Patch("/{invoiceId}", api.UpdateInvoice)
+		apiRouter.Delete("/{invoiceId}", api.DeleteInvoice)
+		apiRouter.Post("/{invoiceId}/void", api.VoidInvoice)
+		apiRouter.Post("/{invoiceId}/restore", api.RestoreInvoice)
+		apiRouter.Post("/{invoiceId}/duplicate", api.DuplicateInvoice)
+		apiRouter.Get("/{invoiceId}/pdf", api.GetInvoicePDF)
+		apiRouter.Get("/{invoiceId}/ubl", api.GetInvoiceUBL)
+		apiRouter.Get("/{invoiceId}/facturx", api.GetInvoiceFacturX)
+		apiRouter.Get("/{invoiceId}/transitions", api.GetInvoiceTransitions)
+		apiRouter.Get("/{invoiceId}/revisions", api.GetInvoiceRevisions)
+		apiRouter.Get("/{invoiceId}/revisions/diff", api.DiffInvoiceRevisions)
+		apiRouter.Get("/{invoiceId}/revisions/{revision}", api.GetInvoiceRevision)
+		apiRouter.Post("/{invoiceId}/finalize", api.FinalizeInvoice)
+		apiRouter.Post("/{invoiceId}/approve", api.ApproveInvoice)
+		apiRouter.Post("/{invoiceId}/reject", api.RejectInvoice)
+		apiRouter.Post("/{invoiceId}/send", api.SendInvoice)
+		apiRouter.Post("/{invoiceId}/share", api.ShareInvoice)
+		apiRouter.Delete("/{invoiceId}/share", api.RevokeInvoiceShare)
+		apiRouter.Get("/{invoiceId}/late-fee-policy", api.GetInvoiceLateFeePolicy)
+		apiRouter.Put("/{invoiceId}/late-fee-policy", api.SaveInvoiceLateFeePolicy)
+		apiRouter.Post("/{invoiceId}/late-fees/{feeId}/waive", api.WaiveLateFee)
+		apiRouter.Post("/{invoiceId}/payments/{paymentId}/reverse", api.ReversePayment)
+		apiRouter.Post("/{invoiceId}/write-off", api.WriteOffInvoice)
+		apiRouter.Get("/{invoiceId}/ledger", api.GetInvoiceLedger)
+		apiRouter.Get("/{invoiceId}/dunning", api.GetInvoiceDunning)
+		apiRouter.Post("/{invoiceId}/dispute", api.DisputeInvoice)
+		apiRouter.Post("/{invoiceId}/dispute/resolve", api.ResolveDispute)
+		apiRouter.Get("/{invoiceId}/installments", api.GetInvoiceInstallments)
+		apiRouter.Put("/{invoiceId}/installments", api.SetInvoiceInstallments)
+		apiRouter.Delete("/{invoiceId}/installments", api.RemoveInvoiceInstallments)
+
+		//Credit Notes against an invoice
+		apiRouter.Post("/{invoiceId}/credit-notes", api.CreateCreditNote)
+		apiRouter.Get("/{invoiceId}/credit-notes", api.GetInvoiceCreditNotes)
+	})
+	router.Route("/api/v1/credit-notes", func(apiRouter chi.Router) {
+		apiRouter.Get("/{creditNoteId}", api.GetCreditNoteByCreditNoteId)
+		apiRouter.Get("/{creditNoteId}/pdf", api.GetCreditNotePDF)
+	})
+	router.Route("/api/v1/recurring-invoices", func(apiRouter chi.Router) {
+		apiRouter.Get("/", api.GetRecurringInvoices)
+		apiRouter.Post("/", api.CreateRecurringInvoice)
+		apiRouter.Get("/{recurringInvoiceId}", api.GetRecurringInvoiceByRecurringInvoiceId)
+		apiRouter.Get("/{recurringInvoiceId}/invoices", api.GetRecurringInvoiceInvoices)
+		apiRouter.Post("/{recurringInvoiceId}/pause", api.PauseRecurringInvoice)
+		apiRouter.Post("/{recurringInvoiceId}/resume", api.ResumeRecurringInvoice)
+		apiRouter.Post("/{recurringInvoiceId}/cancel", api.CancelRecurringInvoice)
+	})
+	router.Route("/api/v1/quotes", func(apiRouter chi.Router) {
+		apiRouter.Get("/", api.GetQuotes)
+		apiRouter.Post("/", api.CreateQuote)
+		apiRouter.Get("/{quoteId}", api.GetQuoteByQuoteId)
+		apiRouter.Patch("/{quoteId}", api.UpdateQuote)
+		apiRouter.Post("/{quoteId}/send", api.SendQuote)
+		apiRouter.Post("/{quoteId}/accept", api.AcceptQuote)
+		apiRouter.Post("/{quoteId}/decline", api.DeclineQuote)
+		apiRouter.Post("/{quoteId}/convert", api.ConvertQuote)
+	})
+	router.Route("/api/v1/late-fee-policy", func(apiRouter chi.Router) {
+		apiRouter.Get("/", api.GetLateFeePolicy)
+		apiRouter.Put("/", api.SaveLateFeePolicy)
+	})
+	router.Route("/api/v1/approval-rules", func(apiRouter chi.Router) {
+		apiRouter.Get("/", api.GetApprovalRules)
+		apiRouter.Post("/", api.CreateApprovalRule)
+		apiRouter.Put("/{ruleId}", api.UpdateApprovalRule)
+		apiRouter.Delete("/{ruleId}", api.DeleteApprovalRule)
+	})
+	router.Route("/api/v1/bills", func(apiRouter chi.Router) {
+		apiRouter.Get("/", api.GetBills)
+		apiRouter.Post("/", api.CreateBill)
+		apiRouter.Post("/import", api.ImportBill)
+		apiRouter.Get("/due-soon", api.GetBillsDueSoon)
+		apiRouter.Get("/{billId}", api.GetBillByBillId)
+		apiRouter.Put("/{billId}", api.UpdateBill)
+		apiRouter.Delete("/{billId}", api.DeleteBill)
+		apiRouter.Get("/{billId}/document", api.GetBillDocument)
+		apiRouter.Post("/{billId}/approve", api.ApproveBill)
+		apiRouter.Post("/{billId}/reject", api.RejectBill)
+		apiRouter.Post("/{billId}/payments", api.PayBill)
+		apiRouter.Get("/{billId}/attachments", api.GetBillAttachments)
+		apiRouter.Post("/{billId}/attachments", api.AddBillAttachment)
+		apiRouter.Get("/{billId}/attachments/{attachmentId}", api.GetBillAttachment)
+		apiRouter.Delete("/{billId}/attachments/{attachmentId}", api.DeleteBillAttachment)
+	})
+	router.Route("/api/v1/ledger", func(apiRouter chi.Router) {
+		apiRouter.Get("/accounts", api.GetChartOfAccounts)
+		apiRouter.Get("/entries", api.GetJournalEntries)
+		apiRouter.Get("/trial-balance", api.GetTrialBalance)
+	})
+	router.Route("/api/v1/dunning", func(apiRouter chi.Router) {
+		apiRouter.Get("/sequence", api.GetDunningSequence)
+		apiRouter.Put("/sequence", api.SaveDunningSequence)
+		apiRouter.Get("/invoices", api.GetDunningOverview)
+	})
+	router.Route("/api/v1/statements", func(apiRouter chi.Router) {
+		apiRouter.Get("/", api.GetCustomerStatement)
+		apiRouter.Post("/send", api.SendCustomerStatement)
+	})
+	router.Route("/api/v1/accounting", func(apiRouter chi.Router) {
+		apiRouter.Get("/mappings/{target}", api.GetAccountMapping)
+		apiRouter.Put("/mappings/{target}", api.SaveAccountMapping)
+		apiRouter.Get("/exports", api.GetAccountingExports)
+		apiRouter.Post("/exports", api.ExportToAccounting)
+		apiRouter.Get("/exports/{exportId}/file", api.DownloadAccountingExport)
+	})
+	//Public, unauthenticated views behind a share link
+	router.Route("/api/v1/public/invoices", func(apiRouter chi.Router) {
+		apiRouter.Get("/{shareToken}", api.GetPublicInvoice)
+		apiRouter.Get("/{shareToken}/view", api.GetPublicInvoiceView)
 	})
 	router.Route("/api/v1/dummy-auth", func(apiRouter chi.Router) {
 
@@ -49,13 +162,16 @@ func main() {
 		log.Fatal("Error connecting to database ...")
 	}
 
+	//BACKGROUND JOBS (recurring invoices, ...)
+	jobs.Start()
+
 	//Base Router
 	router := chi.NewRouter()
 	//Router Middleware mount LOGGER
 	router.Use(middleware.Logger)
 	router.Use(middleware.RequestID)
 	router.Use(middleware.RealIP)
-	router.Use(middleware.AllowContentType("application/json"))
+	router.Use(middleware.AllowContentType("application/json", "multipart/form-data"))
 
 	//NOTFOUND HANDLER
 	router.NotFound(func(writer http.ResponseWriter, request *http.Request) {
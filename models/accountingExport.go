@@ -0,0 +1,328 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+	"time"
+)
+
+// AccountingTarget is the accounting software an export is made for
+type AccountingTarget string
+
+const (
+	QUICKBOOKS AccountingTarget = "QUICKBOOKS"
+	XERO       AccountingTarget = "XERO"
+)
+
+// ExportedItemType
+type ExportedItemType string
+
+const (
+	EXPORTEDINVOICE    ExportedItemType = "INVOICE"
+	EXPORTEDCUSTOMER   ExportedItemType = "CUSTOMER"
+	EXPORTEDPAYMENT    ExportedItemType = "PAYMENT"
+	EXPORTEDREVERSAL   ExportedItemType = "REVERSAL"
+	EXPORTEDCREDITNOTE ExportedItemType = "CREDIT_NOTE"
+	EXPORTEDWRITEOFF   ExportedItemType = "WRITE_OFF"
+)
+
+// ACCOUNT MAPPING, the accounts of the organization's chart of accounts in the accounting software the exported
+// amounts go to: account names for QuickBooks, account codes for Xero.
+type AccountMapping struct {
+	gorm.Model
+	OrganizationID    int              `gorm:"uniqueIndex:idx_account_mapping;not null" json:"organization_id"`
+	Target            AccountingTarget `gorm:"uniqueIndex:idx_account_mapping;not null" json:"target"`
+	SalesAccount      string           `gorm:"not null" json:"sales_account"`
+	LateFeeAccount    string           `gorm:"not null" json:"late_fee_account"`
+	DiscountAccount   string           `gorm:"not null" json:"discount_account"`
+	ReceivableAccount string           `gorm:"not null" json:"receivable_account"`
+	BankAccount       string           `gorm:"not null" json:"bank_account"`
+	TaxAccount        string           `gorm:"not null" json:"tax_account"`
+	TaxType           string           `gorm:"not null" json:"tax_type"` // Tax code of the lines, invoices carry no tax
+	BadDebtAccount    string           `gorm:"not null;default:''" json:"bad_debt_account"`
+}
+
+// ACCOUNTING EXPORT, a record of an export and the date range it covered
+type AccountingExport struct {
+	gorm.Model
+	ExportID       uuid.UUID        `gorm:"type:uuid;uniqueIndex;not null" json:"export_id"`
+	Target         AccountingTarget `gorm:"not null;index" json:"target"`
+	Format         string           `gorm:"not null" json:"format"`
+	From           *time.Time       `json:"from"`
+	To             *time.Time       `json:"to"`
+	InvoiceCount   int              `gorm:"default:0" json:"invoice_count"`
+	CustomerCount  int              `gorm:"default:0" json:"customer_count"`
+	PaymentCount   int              `gorm:"default:0" json:"payment_count"`
+	ReversalCount  int              `gorm:"default:0" json:"reversal_count"`
+	CreditCount    int              `gorm:"default:0" json:"credit_note_count"`
+	WriteOffCount  int              `gorm:"default:0" json:"write_off_count"`
+	ExportedBy     int              `gorm:"not null" json:"exported_by"`
+	ExportedAgain  bool             `gorm:"default:false" json:"exported_again"` // Items exported before were included
+	IncludedTables string           `json:"included_tables"`                     // eg: invoices,customers,payments
+	// The file that went out, kept so the export can be downloaded again
+	FileName    string `json:"file_name"`
+	ContentType string `json:"-"`
+	Data        []byte `gorm:"type:bytea" json:"-"`
+}
+
+// EXPORTED ITEM, an invoice, customer, payment, reversal, credit note or write-off that went to the accounting software. Items are only
+// exported once per target unless asked for again, so the bookkeeper never imports them twice.
+type ExportedItem struct {
+	ID         uint             `gorm:"primaryKey" json:"-"`
+	Target     AccountingTarget `gorm:"uniqueIndex:idx_exported_item;not null" json:"target"`
+	ItemType   ExportedItemType `gorm:"uniqueIndex:idx_exported_item;not null" json:"item_type"`
+	ItemKey    string           `gorm:"uniqueIndex:idx_exported_item;not null" json:"item_key"`
+	ExportID   uuid.UUID        `gorm:"type:uuid;index;not null" json:"export_id"`
+	ExportedAt time.Time        `gorm:"not null" json:"exported_at"`
+}
+
+// AccountingPayment is a payment or a reversal of an invoice as it is exported
+type AccountingPayment struct {
+	Key     string
+	Invoice *Invoice
+	Payment PaymentHistory
+	// A payment goes out less what was reversed of it by then, a reversal in full
+	Amount float64
+}
+
+// AccountingCreditNote is a credit note against an invoice as it is exported
+type AccountingCreditNote struct {
+	Key        string
+	Invoice    *Invoice
+	CreditNote CreditNote
+}
+
+// AccountingWriteOff is the balance of an invoice written off as a bad debt, as it is exported
+type AccountingWriteOff struct {
+	Key     string
+	Invoice *Invoice
+	Amount  float64
+	Date    time.Time
+}
+
+// defaultAccountMappings follow the default charts of accounts of each software
+var defaultAccountMappings = map[AccountingTarget]AccountMapping{
+	QUICKBOOKS: {
+		SalesAccount:      "Sales",
+		LateFeeAccount:    "Late Fee Income",
+		DiscountAccount:   "Discounts Given",
+		ReceivableAccount: "Accounts Receivable",
+		BankAccount:       "Undeposited Funds",
+		TaxAccount:        "Sales Tax Payable",
+		TaxType:           "Non",
+		BadDebtAccount:    "Bad Debts",
+	},
+	XERO: {
+		SalesAccount:      "200",
+		LateFeeAccount:    "260",
+		DiscountAccount:   "200", // Xero takes the discount off the line
+		ReceivableAccount: "610",
+		BankAccount:       "090",
+		TaxAccount:        "820",
+		TaxType:           "Tax Exempt",
+		BadDebtAccount:    "429", // Xero's default chart has no bad debts account, General Expenses
+	},
+}
+
+// GetAccountMapping returns the organization's mapping for the target, the default one if it has none
+func GetAccountMapping(organizationID int, target AccountingTarget) (*AccountMapping, error) {
+	var mapping AccountMapping
+	err := db.Where("organization_id = ? AND target = ?", organizationID, target).First(&mapping).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		mapping = defaultAccountMappings[target]
+		mapping.OrganizationID = organizationID
+		mapping.Target = target
+		return &mapping, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Mappings saved before write-offs were exported
+	if mapping.BadDebtAccount == "" {
+		mapping.BadDebtAccount = defaultAccountMappings[target].BadDebtAccount
+	}
+	return &mapping, nil
+}
+
+// SaveAccountMapping creates or replaces a mapping
+func SaveAccountMapping(mapping *AccountMapping) error {
+	return db.Save(mapping).Error
+}
+
+// CustomerKey tells customers apart by email, by name for those without one
+func CustomerKey(customer CustomerInfo) string {
+	if customer.Email != "" {
+		return strings.ToLower(strings.TrimSpace(customer.Email))
+	}
+	return strings.ToLower(strings.TrimSpace(customer.Name))
+}
+
+// InDateRange tells whether the date falls between from and to, both days included and either open
+func InDateRange(date time.Time, from *time.Time, to *time.Time) bool {
+	return (from == nil || !date.Before(*from)) && (to == nil || date.Before(to.AddDate(0, 0, 1)))
+}
+
+// EachAccountingInvoice hands fn every invoice of the list with its credit notes, all read in one read-only
+// REPEATABLE READ transaction so the payments, reversals and credit notes of an export agree with each other
+func EachAccountingInvoice(params InvoiceQueryParams, fn func(invoice *Invoice, creditNotes []CreditNote) error) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var creditNotes []CreditNote
+		if err := tx.Order("id asc").Find(&creditNotes).Error; err != nil {
+			return err
+		}
+		byInvoice := map[uuid.UUID][]CreditNote{}
+		for _, creditNote := range creditNotes {
+			byInvoice[creditNote.InvoiceID] = append(byInvoice[creditNote.InvoiceID], creditNote)
+		}
+		return eachInvoice(tx, params, func(invoice *Invoice) error {
+			return fn(invoice, byInvoice[invoice.InvoiceID])
+		})
+	}, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+}
+
+// AccountingPayments lists the payments of the invoice made in the date range, less what was reversed of them
+func AccountingPayments(invoice *Invoice, from *time.Time, to *time.Time) []AccountingPayment {
+	var payments []AccountingPayment
+	for _, payment := range Payments(invoice) {
+		if payment.Type != PAYMENT || !InDateRange(payment.DatePaid, from, to) {
+			continue
+		}
+		amount := roundMoney(payment.AmountPaid - payment.AmountReversed)
+		if amount <= 0 {
+			continue
+		}
+		payments = append(payments, AccountingPayment{
+			Key:     payment.PaymentID.String(),
+			Invoice: invoice,
+			Payment: payment,
+			Amount:  amount,
+		})
+	}
+	return payments
+}
+
+// AccountingReversals lists the reversals of the invoice made in the date range of payments that went out in an
+// earlier export (paymentsExportedAt, by payment key) before they were reversed. Reversals of payments that went
+// out later were already taken off them.
+func AccountingReversals(invoice *Invoice, from *time.Time, to *time.Time, paymentsExportedAt map[string]time.Time) []AccountingPayment {
+	var reversals []AccountingPayment
+	for _, payment := range Payments(invoice) {
+		if payment.Type != REVERSAL || payment.ReversalOf == nil || !InDateRange(payment.DatePaid, from, to) {
+			continue
+		}
+		exportedAt, exported := paymentsExportedAt[payment.ReversalOf.String()]
+		if !exported || !exportedAt.Before(payment.DatePaid) {
+			continue
+		}
+		reversals = append(reversals, AccountingPayment{
+			Key:     payment.PaymentID.String(),
+			Invoice: invoice,
+			Payment: payment,
+			Amount:  roundMoney(payment.AmountPaid),
+		})
+	}
+	return reversals
+}
+
+// AccountingCreditNotes lists the credit notes of the invoice issued in the date range
+func AccountingCreditNotes(invoice *Invoice, creditNotes []CreditNote, from *time.Time, to *time.Time) []AccountingCreditNote {
+	var credits []AccountingCreditNote
+	for _, creditNote := range creditNotes {
+		if creditNote.InvoiceID != invoice.InvoiceID || !InDateRange(creditNote.CreatedAt, from, to) {
+			continue
+		}
+		credits = append(credits, AccountingCreditNote{
+			Key:        creditNote.CreditNoteID.String(),
+			Invoice:    invoice,
+			CreditNote: creditNote,
+		})
+	}
+	return credits
+}
+
+// AccountingWriteOffOf is the write-off of the invoice when it was written off in the date range, nil otherwise
+func AccountingWriteOffOf(invoice *Invoice, from *time.Time, to *time.Time) *AccountingWriteOff {
+	if invoice.WrittenOffAt == nil || roundMoney(invoice.WrittenOffAmount) <= 0 || !InDateRange(*invoice.WrittenOffAt, from, to) {
+		return nil
+	}
+	return &AccountingWriteOff{
+		Key:     invoice.InvoiceID.String(),
+		Invoice: invoice,
+		Amount:  roundMoney(invoice.WrittenOffAmount),
+		Date:    *invoice.WrittenOffAt,
+	}
+}
+
+// ExportedItemKeys returns the keys of the items of the type already exported to the target
+func ExportedItemKeys(target AccountingTarget, itemType ExportedItemType) (map[string]bool, error) {
+	var keys []string
+	err := db.Model(&ExportedItem{}).
+		Where("target = ? AND item_type = ?", target, itemType).
+		Pluck("item_key", &keys).Error
+	if err != nil {
+		return nil, err
+	}
+	exported := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		exported[key] = true
+	}
+	return exported, nil
+}
+
+// ExportedItemTimes returns when each item of the type first went out to the target, by key
+func ExportedItemTimes(target AccountingTarget, itemType ExportedItemType) (map[string]time.Time, error) {
+	var items []ExportedItem
+	err := db.Select("item_key", "exported_at").
+		Where("target = ? AND item_type = ?", target, itemType).
+		Find(&items).Error
+	if err != nil {
+		return nil, err
+	}
+	exportedAt := make(map[string]time.Time, len(items))
+	for _, item := range items {
+		exportedAt[item.ItemKey] = item.ExportedAt
+	}
+	return exportedAt, nil
+}
+
+// RecordAccountingExport stores the export with its file and marks its items as exported, in one transaction
+func RecordAccountingExport(export *AccountingExport, items []ExportedItem) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(export).Error; err != nil {
+			return err
+		}
+		if len(items) == 0 {
+			return nil
+		}
+		for i := range items {
+			items[i].Target = export.Target
+			items[i].ExportID = export.ExportID
+			items[i].ExportedAt = export.CreatedAt
+		}
+		// Items exported again keep the export they first went out with
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(items, 500).Error
+	})
+}
+
+// GetAccountingExports lists the exports made, newest first
+func GetAccountingExports(params InvoiceQueryParams) ([]AccountingExport, error) {
+	exports := []AccountingExport{}
+	err := db.Omit("data").Limit(params.Limit).Offset(params.Offset).Order("created_at desc").Find(&exports).Error
+	if err != nil {
+		return nil, err
+	}
+	return exports, nil
+}
+
+// GetAccountingExport returns an export with its file
+func GetAccountingExport(exportID string) (*AccountingExport, error) {
+	var export AccountingExport
+	if err := db.Where("export_id = ?", exportID).First(&export).Error; err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
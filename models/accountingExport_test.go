@@ -0,0 +1,52 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func TestAccountingReversals(t *testing.T) {
+	paid := time.Date(2026, 5, 4, 0, 0, 0, 0, time.UTC)
+	reversed := time.Date(2026, 5, 20, 0, 0, 0, 0, time.UTC)
+	paymentID := uuid.MustParse("11111111-1111-1111-1111-111111111111")
+	reversalID := uuid.MustParse("22222222-2222-2222-2222-222222222222")
+	invoice := &Invoice{InvoiceID: uuid.New(), Status: PARTIALPAYMENT, Amount: 500}
+	invoice.PaymentHistory, _ = json.Marshal([]PaymentHistory{
+		{PaymentID: paymentID, Type: PAYMENT, AmountPaid: 300, AmountReversed: 100, DatePaid: paid},
+		{PaymentID: reversalID, Type: REVERSAL, AmountPaid: 100, DatePaid: reversed, ReversalOf: &paymentID},
+	})
+	from := time.Date(2026, 5, 10, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 5, 15, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		exportedAt map[string]time.Time
+		from, to   *time.Time
+		expected   bool
+	}{
+		{"payment not exported", map[string]time.Time{}, nil, nil, false},
+		{"payment exported before the reversal", map[string]time.Time{paymentID.String(): paid.AddDate(0, 0, 1)}, nil, nil, true},
+		{"payment exported after the reversal, net of it", map[string]time.Time{paymentID.String(): reversed.AddDate(0, 0, 1)}, nil, nil, false},
+		{"reversal out of the range", map[string]time.Time{paymentID.String(): paid.AddDate(0, 0, 1)}, &from, &to, false},
+		{"reversal on the last day of the range", map[string]time.Time{paymentID.String(): paid.AddDate(0, 0, 1)}, &from, &reversed, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reversals := AccountingReversals(invoice, test.from, test.to, test.exportedAt)
+			if (len(reversals) == 1) != test.expected {
+				t.Fatalf("expected the reversal exported: %v, got %v", test.expected, reversals)
+			}
+			if test.expected && (reversals[0].Key != reversalID.String() || reversals[0].Amount != 100) {
+				t.Errorf("expected reversal %s of 100.00, got %s of %.2f", reversalID, reversals[0].Key, reversals[0].Amount)
+			}
+		})
+	}
+
+	// The payment goes out less what was reversed of it, keyed by its id
+	payments := AccountingPayments(invoice, nil, nil)
+	if len(payments) != 1 || payments[0].Key != paymentID.String() || payments[0].Amount != 200 {
+		t.Fatalf("expected payment %s of 200.00, got %v", paymentID, payments)
+	}
+}
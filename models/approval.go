@@ -0,0 +1,204 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+	"time"
+)
+
+var ErrApprovalRuleNotFound = errors.New("approval rule not found")
+
+// APPROVAL RULE, invoices matching an active rule wait for a manager before they can be sent.
+// A rule with both a minimum amount and a customer only matches invoices to that customer from that amount.
+type ApprovalRule struct {
+	gorm.Model
+	RuleID         uuid.UUID `gorm:"type:uuid;uniqueIndex;not null" json:"rule_id"`
+	OrganizationID int       `gorm:"index;not null" json:"organization_id"`
+	Name           string    `gorm:"not null" json:"name"`
+	MinAmount      float64   `gorm:"default:0" json:"min_amount"` // Invoices from this total up, 0 for any amount
+	CustomerEmail  string    `json:"customer_email"`              // Invoices to this customer, empty for any customer
+	Active         bool      `gorm:"not null" json:"active"`      // No default so a rule can be created turned off
+}
+
+// matches tells if the rule applies to the invoice
+func (rule *ApprovalRule) matches(invoice *Invoice) bool {
+	if !rule.Active {
+		return false
+	}
+	if rule.MinAmount > 0 && invoice.Amount < rule.MinAmount {
+		return false
+	}
+	if rule.CustomerEmail != "" && approvalCustomer(invoice) != strings.ToLower(strings.TrimSpace(rule.CustomerEmail)) {
+		return false
+	}
+	return true
+}
+
+// approvalCustomer is the customer email approvals are given for, compared regardless of case
+func approvalCustomer(invoice *Invoice) string {
+	var customerInfo CustomerInfo
+	_ = json.Unmarshal(invoice.CustomerInfo, &customerInfo)
+	return strings.ToLower(strings.TrimSpace(customerInfo.Email))
+}
+
+// GetApprovalRules lists the approval rules of an organization, oldest first
+func GetApprovalRules(organizationID int) ([]ApprovalRule, error) {
+	var rules []ApprovalRule
+	err := db.Where("organization_id = ?", organizationID).Order("created_at asc").Find(&rules).Error
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// GetApprovalRuleByID retrieves an approval rule by its RuleID
+func GetApprovalRuleByID(id string) (*ApprovalRule, error) {
+	var rule ApprovalRule
+	err := db.Where("rule_id = ?", id).First(&rule).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrApprovalRuleNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// SaveApprovalRule creates or replaces an approval rule
+func SaveApprovalRule(rule *ApprovalRule) error {
+	return db.Save(rule).Error
+}
+
+// DeleteApprovalRule removes an approval rule, invoices already waiting for approval keep waiting
+func DeleteApprovalRule(rule *ApprovalRule) error {
+	return db.Delete(rule).Error
+}
+
+// requestApproval moves an issued, unsent invoice into PENDING_APPROVAL when a rule asks for it.
+// The caller saves the invoice. It returns whether the invoice now waits for approval.
+func requestApproval(tx *gorm.DB, invoice *Invoice) (bool, error) {
+	if invoice.Status != CREATED {
+		return false, nil
+	}
+	var rules []ApprovalRule
+	err := tx.Where("organization_id = ? AND active = ?", invoice.CreatedBy, true).Order("created_at asc").Find(&rules).Error
+	if err != nil {
+		return false, err
+	}
+	return holdForApproval(invoice, rules, time.Now()), nil
+}
+
+// holdForApproval moves the invoice into PENDING_APPROVAL under the first of the rules it needs approval under.
+// An approval holds as long as the invoice total doesn't go above the approved amount and the customer stays the same.
+func holdForApproval(invoice *Invoice, rules []ApprovalRule, now time.Time) bool {
+	if invoice.Status != CREATED {
+		return false
+	}
+	if invoice.ApprovedAt != nil && invoice.Amount <= invoice.ApprovedAmount && approvalCustomer(invoice) == invoice.ApprovedCustomer {
+		return false
+	}
+	for _, rule := range rules {
+		if !rule.matches(invoice) {
+			continue
+		}
+		invoice.Status = PENDINGAPPROVAL
+		AppendInvoiceHistory(invoice, InvoiceHistory{
+			Action:     PENDINGAPPROVAL,
+			ActionDate: now,
+			Reference:  rule.RuleID.String(),
+			Note:       fmt.Sprintf("Needs approval under rule %s", rule.Name),
+		})
+		return true
+	}
+	return false
+}
+
+// RequestApproval is requestApproval for invoices being edited or sent outside of a transaction
+func RequestApproval(invoice *Invoice) (bool, error) {
+	return requestApproval(db, invoice)
+}
+
+// ApproveInvoice lets an invoice waiting for approval be sent, the comment goes in the history
+func ApproveInvoice(invoiceID string, approvedBy int, comment string) (*Invoice, Status, error) {
+	var invoice Invoice
+	var previous Status
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("invoice_id = ?", invoiceID).
+			First(&invoice).Error
+		if err != nil {
+			return err
+		}
+		if invoice.Status != PENDINGAPPROVAL {
+			return fmt.Errorf("%w: a %s invoice can not be approved", ErrInvalidTransition, invoice.Status)
+		}
+
+		now := time.Now()
+		previous = invoice.Status
+		invoice.Status = CREATED
+		invoice.ApprovedAt = &now
+		invoice.ApprovedBy = approvedBy
+		invoice.ApprovedAmount = invoice.Amount
+		invoice.ApprovedCustomer = approvalCustomer(&invoice)
+		AppendInvoiceHistory(&invoice, InvoiceHistory{
+			Action:     APPROVED,
+			ActionDate: now,
+			Note:       comment,
+		})
+		// The due date may have passed while it waited
+		RefreshOverdueStatus(&invoice, now)
+		return tx.Model(&invoice).
+			Select("status", "approved_at", "approved_by", "approved_amount", "approved_customer", "installments", "invoice_history").
+			Updates(&invoice).Error
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return &invoice, previous, nil
+}
+
+// RejectInvoice sends an invoice waiting for approval back to draft with the reason, it keeps its invoice number
+// and goes through approval again when finalized
+func RejectInvoice(invoiceID string, comment string) (*Invoice, error) {
+	var invoice Invoice
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("invoice_id = ?", invoiceID).
+			First(&invoice).Error
+		if err != nil {
+			return err
+		}
+		if invoice.Status != PENDINGAPPROVAL {
+			return fmt.Errorf("%w: a %s invoice can not be rejected", ErrInvalidTransition, invoice.Status)
+		}
+
+		invoice.Status = DRAFT
+		invoice.ApprovedAt = nil
+		invoice.ApprovedBy = 0
+		invoice.ApprovedAmount = 0
+		invoice.ApprovedCustomer = ""
+		RecalculateOutstanding(&invoice)
+		AppendInvoiceHistory(&invoice, InvoiceHistory{
+			Action:     REJECTED,
+			ActionDate: time.Now(),
+			Note:       comment,
+		})
+		err = tx.Model(&invoice).
+			Select("status", "approved_at", "approved_by", "approved_amount", "approved_customer", "outstanding_amount", "invoice_history").
+			Updates(&invoice).Error
+		if err != nil {
+			return err
+		}
+		// Back to draft, the invoice is taken off the ledger until it is issued again
+		return syncInvoiceLedger(tx, &invoice)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
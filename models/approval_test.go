@@ -0,0 +1,61 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func TestHoldForApproval(t *testing.T) {
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	customer, _ := json.Marshal(CustomerInfo{Name: "Acme", Email: "Billing@Acme.com"})
+	items, _ := json.Marshal([]Item{{Name: "Support", Quantity: 1, UnitPrice: 5000}})
+	recurring := &RecurringInvoice{RecurringInvoiceID: uuid.New(), Items: items, CustomerInfo: customer, PaymentTermsDays: 30}
+	quote := &Quote{QuoteID: uuid.New(), QuoteNumber: "QUO-000001", Amount: 5000, Items: items, CustomerInfo: customer}
+	approvedAt := now.AddDate(0, 0, -1)
+
+	bigInvoices := ApprovalRule{RuleID: uuid.New(), Name: "Big invoices", MinAmount: 1000, Active: true}
+	acme := ApprovalRule{RuleID: uuid.New(), Name: "Acme", CustomerEmail: "billing@acme.com", Active: true}
+	tests := []struct {
+		name     string
+		invoice  Invoice
+		rules    []ApprovalRule
+		expected Status
+	}{
+		{name: "recurring invoice over the minimum", invoice: recurring.BuildInvoice(now), rules: []ApprovalRule{bigInvoices}, expected: PENDINGAPPROVAL},
+		{name: "converted quote over the minimum", invoice: quote.BuildInvoice(now, nil, now), rules: []ApprovalRule{bigInvoices}, expected: PENDINGAPPROVAL},
+		{name: "customer rule regardless of case", invoice: recurring.BuildInvoice(now), rules: []ApprovalRule{acme}, expected: PENDINGAPPROVAL},
+		{name: "under the minimum", invoice: recurring.BuildInvoice(now), rules: []ApprovalRule{{MinAmount: 10000, Active: true}}, expected: CREATED},
+		{name: "rule turned off", invoice: recurring.BuildInvoice(now), rules: []ApprovalRule{{MinAmount: 1000}}, expected: CREATED},
+		{name: "no rules", invoice: quote.BuildInvoice(now, nil, now), expected: CREATED},
+		{
+			name: "already approved for the amount and customer",
+			invoice: func() Invoice {
+				invoice := recurring.BuildInvoice(now)
+				invoice.ApprovedAt, invoice.ApprovedAmount, invoice.ApprovedCustomer = &approvedAt, 5000, "billing@acme.com"
+				return invoice
+			}(),
+			rules:    []ApprovalRule{bigInvoices},
+			expected: CREATED,
+		},
+		{
+			name: "approved for another customer",
+			invoice: func() Invoice {
+				invoice := recurring.BuildInvoice(now)
+				invoice.ApprovedAt, invoice.ApprovedAmount, invoice.ApprovedCustomer = &approvedAt, 5000, "someone@else.com"
+				return invoice
+			}(),
+			rules:    []ApprovalRule{acme},
+			expected: PENDINGAPPROVAL,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			held := holdForApproval(&test.invoice, test.rules, now)
+			if test.invoice.Status != test.expected || held != (test.expected == PENDINGAPPROVAL) {
+				t.Errorf("expected %s, got %s (held %v)", test.expected, test.invoice.Status, held)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package models
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+var (
+	ErrVoidWithPayments = errors.New("an invoice with payments applied can not be voided, reverse the payments first")
+	ErrNotDraft         = errors.New("only draft invoices can be deleted, void issued invoices instead")
+)
+
+// VoidInvoice cancels an issued invoice nobody paid anything on, keeping the reason
+func VoidInvoice(invoiceID string, reason string) (*Invoice, error) {
+	var invoice Invoice
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("invoice_id = ?", invoiceID).
+			First(&invoice).Error
+		if err != nil {
+			return err
+		}
+		if NetPaid(&invoice) > 0 {
+			return ErrVoidWithPayments
+		}
+		if err = checkTransition(&invoice, CANCELED); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		invoice.Status = CANCELED
+		invoice.VoidedAt = &now
+		invoice.VoidReason = reason
+		invoice.OutstandingAmount = 0
+		AppendInvoiceHistory(&invoice, InvoiceHistory{
+			Action:     CANCELED,
+			ActionDate: now,
+			Note:       reason,
+		})
+		err = tx.Model(&invoice).
+			Select("status", "voided_at", "void_reason", "outstanding_amount", "invoice_history").
+			Updates(&invoice).Error
+		if err != nil {
+			return err
+		}
+		if err = syncInvoiceLedger(tx, &invoice); err != nil {
+			return err
+		}
+		return recordRevision(tx, &invoice)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+// DeleteDraftInvoice soft deletes a draft, it can be restored from the archive
+func DeleteDraftInvoice(invoiceID string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var invoice Invoice
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("invoice_id = ?", invoiceID).
+			First(&invoice).Error
+		if err != nil {
+			return err
+		}
+		if invoice.Status != DRAFT {
+			return ErrNotDraft
+		}
+		AppendInvoiceHistory(&invoice, InvoiceHistory{
+			Action:     DELETED,
+			ActionDate: time.Now(),
+		})
+		err = tx.Model(&invoice).Select("invoice_history").Updates(&invoice).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&invoice).Error
+	})
+}
+
+// GetArchivedInvoices lists the deleted invoices, most recently deleted first
+func GetArchivedInvoices(params InvoiceQueryParams) ([]Invoice, error) {
+	var invoices []Invoice
+	err := db.Unscoped().
+		Where("deleted_at IS NOT NULL").
+		Limit(params.Limit).Offset(params.Offset).
+		Order("deleted_at desc").
+		Find(&invoices).Error
+	if err != nil {
+		return nil, err
+	}
+	return invoices, nil
+}
+
+// RestoreInvoice brings a deleted invoice back out of the archive
+func RestoreInvoice(invoiceID string) (*Invoice, error) {
+	var invoice Invoice
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Unscoped().
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("invoice_id = ? AND deleted_at IS NOT NULL", invoiceID).
+			First(&invoice).Error
+		if err != nil {
+			return err
+		}
+		invoice.DeletedAt = gorm.DeletedAt{}
+		AppendInvoiceHistory(&invoice, InvoiceHistory{
+			Action:     RESTORED,
+			ActionDate: time.Now(),
+		})
+		return tx.Unscoped().Model(&invoice).
+			Select("deleted_at", "invoice_history").
+			Updates(&invoice).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
@@ -0,0 +1,454 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+var (
+	ErrBillAlreadyReceived = errors.New("a bill with this number was already received from the supplier")
+	ErrBillNotReceived     = errors.New("only bills waiting for approval can be approved or rejected")
+	ErrBillNotEditable     = errors.New("only bills waiting for approval or rejected can be edited")
+	ErrBillImported        = errors.New("an imported bill follows its e-invoice and can not be edited")
+	ErrBillNotPayable      = errors.New("only approved bills can be paid")
+	ErrBillHasPayments     = errors.New("a bill with payouts can not be deleted")
+)
+
+// BillStatus
+type BillStatus string
+
+const (
+	BILLRECEIVED BillStatus = "RECEIVED" // Waiting for approval
+	BILLAPPROVED BillStatus = "APPROVED" // Can be paid
+	BILLREJECTED BillStatus = "REJECTED"
+	// Paid out in part or in full
+	BILLPARTIALPAYMENT BillStatus = "PARTIAL_PAYMENT"
+	BILLPAID           BillStatus = "PAID"
+	// History only actions
+	BILLIMPORTED BillStatus = "IMPORTED"
+	BILLUPDATED  BillStatus = "UPDATED"
+	BILLDELETED  BillStatus = "DELETED"
+)
+
+// Bills we still have to pay something on
+var openBillStatuses = []BillStatus{BILLRECEIVED, BILLAPPROVED, BILLPARTIALPAYMENT}
+
+// SUPPLIER INFO, who sent the bill
+type SupplierInfo struct {
+	Name        string `json:"name"`
+	Email       string `json:"email"`
+	PhoneNumber string `json:"phone_number"`
+	TaxID       string `json:"tax_id"`
+	Country     string `json:"country"`
+	BankAccount string `json:"bank_account"`
+}
+
+// BILL ITEM, a line of a bill. Suppliers bill fractions (hours, kilograms) and charge tax per line
+type BillItem struct {
+	Name          string  `json:"name"`
+	Quantity      float64 `json:"quantity"`
+	UnitPrice     float64 `json:"unit_price"`
+	Amount        float64 `json:"amount"` // Net of line discounts
+	TaxCategory   string  `json:"tax_category,omitempty"`
+	TaxPercentage float64 `json:"tax_percentage"`
+}
+
+type BillHistory struct {
+	Action     BillStatus `json:"action"`
+	ActionDate time.Time  `json:"action_date"`
+	Note       string     `json:"note,omitempty"`
+}
+
+// BILL, an invoice received from a supplier: money we owe. Amounts are in the currency of the bill
+type Bill struct {
+	gorm.Model
+	BillID             uuid.UUID       `gorm:"type:uuid;uniqueIndex;not null" json:"bill_id"`
+	SupplierBillNumber string          `gorm:"index;not null" json:"supplier_bill_number"` // The supplier's invoice number
+	SupplierInfo       json.RawMessage `gorm:"type:jsonb;default:'{}';not null" json:"supplier_info"`
+	IssueDate          time.Time       `gorm:"not null" json:"issue_date"`
+	DueDate            *time.Time      `json:"due_date"`
+	Currency           string          `gorm:"not null" json:"currency"`
+	Description        string          `json:"description"`
+	PaymentTerms       string          `json:"payment_terms"`
+	Items              json.RawMessage `gorm:"type:jsonb;default:'[]';not null" json:"items"`
+	Subtotal           float64         `gorm:"not null" json:"subtotal"` // Sum of the items
+	DiscountAmount     float64         `gorm:"default:0" json:"discount_amount"`
+	ChargesAmount      float64         `gorm:"default:0" json:"charges_amount"`
+	TaxAmount          float64         `gorm:"default:0" json:"tax_amount"`
+	IsDiscount         bool            `gorm:"default:false" json:"is_discount"`
+	DiscountPercentage float64         `gorm:"default:0" json:"discount_percentage"`
+	Amount             float64         `gorm:"not null" json:"amount"` // Total with tax
+	PrepaidAmount      float64         `gorm:"default:0" json:"prepaid_amount"`
+	OutstandingAmount  float64         `gorm:"not null" json:"outstanding_amount"`
+	Status             BillStatus      `gorm:"not null;index" json:"status"`
+	PaymentHistory     json.RawMessage `gorm:"type:jsonb;default:'[]';not null" json:"payment_history"`
+	BillHistory        json.RawMessage `gorm:"type:jsonb;default:'[]';not null" json:"bill_history"`
+	Note               string          `json:"note"`
+	SourceFormat       string          `json:"source_format"` // UBL or CII when imported from an e-invoice
+	SourceDocument     string          `gorm:"type:text" json:"-"`
+	ApprovedAt         *time.Time      `json:"approved_at"`
+	ApprovedBy         int             `gorm:"default:0" json:"approved_by"`
+	CreatedBy          int             `gorm:"not null" json:"created_by"`
+}
+
+// BILL ATTACHMENT, a scan of the paper bill, a receipt or a delivery note
+type BillAttachment struct {
+	gorm.Model
+	AttachmentID uuid.UUID `gorm:"type:uuid;uniqueIndex;not null" json:"attachment_id"`
+	BillID       uuid.UUID `gorm:"type:uuid;index;not null" json:"bill_id"`
+	FileName     string    `gorm:"not null" json:"file_name"`
+	ContentType  string    `gorm:"not null" json:"content_type"`
+	Size         int       `gorm:"not null" json:"size"`
+	Data         []byte    `gorm:"type:bytea;not null" json:"-"`
+}
+
+// CurrencyTotal adds up bills of one currency, they are never converted
+type CurrencyTotal struct {
+	Currency string  `json:"currency"`
+	Amount   float64 `json:"amount"`
+	Count    int     `json:"count"`
+}
+
+// BILLS DUE SOON, what has to be paid out until a date, overdue bills included
+type BillsDueSoon struct {
+	Until  time.Time       `json:"until"`
+	Totals []CurrencyTotal `json:"totals"`
+	Bills  []Bill          `json:"bills"`
+}
+
+// AppendBillHistory adds an entry to the bill history JSON
+func AppendBillHistory(bill *Bill, entry BillHistory) {
+	var existingHistory []BillHistory
+	_ = json.Unmarshal(bill.BillHistory, &existingHistory)
+
+	existingHistory = append(existingHistory, entry)
+
+	bill.BillHistory, _ = json.Marshal(existingHistory)
+}
+
+// BillPayments returns the payouts made on the bill
+func BillPayments(bill *Bill) []PaymentHistory {
+	var payments []PaymentHistory
+	_ = json.Unmarshal(bill.PaymentHistory, &payments)
+	return payments
+}
+
+// BillNetPaid is what has been paid out on the bill
+func BillNetPaid(bill *Bill) float64 {
+	var netPaid float64
+	for _, payment := range BillPayments(bill) {
+		if payment.Type == PAYMENT {
+			netPaid += payment.AmountPaid
+		} else {
+			netPaid -= payment.AmountPaid
+		}
+	}
+	return netPaid
+}
+
+// CalculateBillTotals works out the line amounts, the discount, the tax and the total of a bill entered by hand.
+// The discount applies to every line alike, so the tax is charged on the discounted line amounts.
+func CalculateBillTotals(bill *Bill, items []BillItem) {
+	var subtotal, tax float64
+	for i := range items {
+		items[i].Amount = roundMoney(items[i].Quantity * items[i].UnitPrice)
+		subtotal += items[i].Amount
+	}
+	var discountPercentage float64
+	if bill.IsDiscount {
+		discountPercentage = bill.DiscountPercentage
+	}
+	for _, item := range items {
+		tax += item.Amount * (1 - discountPercentage/100) * item.TaxPercentage / 100
+	}
+
+	bill.Items, _ = json.Marshal(items)
+	bill.Subtotal = roundMoney(subtotal)
+	bill.DiscountAmount = roundMoney(subtotal * discountPercentage / 100)
+	bill.TaxAmount = roundMoney(tax)
+	bill.Amount = roundMoney(bill.Subtotal - bill.DiscountAmount + bill.ChargesAmount + bill.TaxAmount)
+	bill.OutstandingAmount = roundMoney(bill.Amount - bill.PrepaidAmount - BillNetPaid(bill))
+}
+
+// billSupplierNumberIndex keeps a supplier from having two live bills with the same number, the supplier
+// being told apart by name regardless of case. Gorm can't declare an index on an expression.
+const billSupplierNumberIndex = `CREATE UNIQUE INDEX IF NOT EXISTS idx_bills_supplier_number
+	ON bills (LOWER(supplier_info->>'name'), supplier_bill_number) WHERE deleted_at IS NULL`
+
+// billNumberError turns the unique index violation into ErrBillAlreadyReceived
+func billNumberError(err error) error {
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ErrBillAlreadyReceived
+	}
+	return err
+}
+
+// CreateBill stores a bill, unless the supplier already sent one with the same number
+func CreateBill(bill *Bill) error {
+	return billNumberError(db.Create(bill).Error)
+}
+
+// UpdateBill saves the edits made to a bill waiting for approval. Editing a rejected bill submits it again.
+// The edits are applied by edit on the locked bill, which carries the totals worked out afterwards.
+func UpdateBill(billID string, edit func(bill *Bill)) (*Bill, error) {
+	var bill Bill
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("bill_id = ?", billID).
+			First(&bill).Error
+		if err != nil {
+			return err
+		}
+		if bill.SourceFormat != "" {
+			return ErrBillImported
+		}
+		if bill.Status != BILLRECEIVED && bill.Status != BILLREJECTED {
+			return fmt.Errorf("%w: the bill is %s", ErrBillNotEditable, bill.Status)
+		}
+
+		edit(&bill)
+
+		entry := BillHistory{Action: BILLUPDATED, ActionDate: time.Now()}
+		if bill.Status == BILLREJECTED {
+			bill.Status = BILLRECEIVED
+			entry.Note = "submitted again for approval"
+		}
+		AppendBillHistory(&bill, entry)
+		err = tx.Model(&bill).
+			Select("supplier_bill_number", "supplier_info", "issue_date", "due_date", "currency", "description",
+				"payment_terms", "items", "subtotal", "is_discount", "discount_percentage", "discount_amount",
+				"tax_amount", "amount", "outstanding_amount", "status", "note", "bill_history").
+			Updates(&bill).Error
+		return billNumberError(err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &bill, nil
+}
+
+// DeleteBill soft deletes a bill nothing was paid out on, with its attachments
+func DeleteBill(billID string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var bill Bill
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("bill_id = ?", billID).
+			First(&bill).Error
+		if err != nil {
+			return err
+		}
+		if len(BillPayments(&bill)) > 0 {
+			return ErrBillHasPayments
+		}
+		AppendBillHistory(&bill, BillHistory{Action: BILLDELETED, ActionDate: time.Now()})
+		err = tx.Model(&bill).Select("bill_history").Updates(&bill).Error
+		if err != nil {
+			return err
+		}
+		if bill.Status == BILLAPPROVED {
+			if err = reverseBill(tx, &bill); err != nil {
+				return err
+			}
+		}
+		err = tx.Where("bill_id = ?", bill.BillID).Delete(&BillAttachment{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&bill).Error
+	})
+}
+
+// PayBill records a payout on an approved bill and moves it to PARTIAL_PAYMENT or PAID
+func PayBill(billID string, amount float64, paidAt time.Time, note string) (*Bill, error) {
+	var bill Bill
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("bill_id = ?", billID).
+			First(&bill).Error
+		if err != nil {
+			return err
+		}
+		if bill.Status != BILLAPPROVED && bill.Status != BILLPARTIALPAYMENT {
+			return fmt.Errorf("%w: the bill is %s", ErrBillNotPayable, bill.Status)
+		}
+		if amount > bill.OutstandingAmount {
+			return ErrOverpayment
+		}
+
+		bill.OutstandingAmount = roundMoney(bill.OutstandingAmount - amount)
+		payment := PaymentHistory{
+			PaymentID:     uuid.New(),
+			Type:          PAYMENT,
+			AmountPaid:    amount,
+			AmountBalance: bill.OutstandingAmount,
+			DatePaid:      paidAt,
+			Reason:        note,
+		}
+		bill.PaymentHistory, _ = json.Marshal(append(BillPayments(&bill), payment))
+
+		bill.Status = BILLPARTIALPAYMENT
+		if bill.OutstandingAmount == 0 {
+			bill.Status = BILLPAID
+		}
+		AppendBillHistory(&bill, BillHistory{Action: bill.Status, ActionDate: paidAt, Note: note})
+		err = tx.Model(&bill).Select("outstanding_amount", "payment_history", "status", "bill_history").Updates(&bill).Error
+		if err != nil {
+			return err
+		}
+		return postBillPayment(tx, &bill, payment)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &bill, nil
+}
+
+// GetBillByID retrieves a bill by its BillID
+func GetBillByID(id string) (*Bill, error) {
+	var bill Bill
+	if err := db.Where("bill_id = ?", id).First(&bill).Error; err != nil {
+		return nil, err
+	}
+	return &bill, nil
+}
+
+func GetBills(params InvoiceQueryParams) ([]Bill, error) {
+	var bills []Bill
+	err := db.Limit(params.Limit).Offset(params.Offset).Order("created_at desc").Find(&bills).Error
+	if err != nil {
+		return nil, err
+	}
+	return bills, nil
+}
+
+// GetBillsDueSoon lists the open bills due until the date, overdue ones first, with the totals per currency
+func GetBillsDueSoon(until time.Time) (*BillsDueSoon, error) {
+	dueSoon := BillsDueSoon{Until: until, Totals: []CurrencyTotal{}, Bills: []Bill{}}
+	openBills := db.Model(&Bill{}).
+		Where("status IN ? AND outstanding_amount > 0 AND due_date IS NOT NULL AND due_date <= ?", openBillStatuses, until).
+		Session(&gorm.Session{})
+
+	err := openBills.Order("due_date asc").Find(&dueSoon.Bills).Error
+	if err != nil {
+		return nil, err
+	}
+	err = openBills.
+		Select("currency, SUM(outstanding_amount) as amount, COUNT(*) as count").
+		Group("currency").
+		Order("currency").
+		Scan(&dueSoon.Totals).Error
+	if err != nil {
+		return nil, err
+	}
+	return &dueSoon, nil
+}
+
+// AddBillAttachment stores a file against the bill
+func AddBillAttachment(attachment *BillAttachment) error {
+	return db.Create(attachment).Error
+}
+
+// GetBillAttachments lists the files of a bill, without their content
+func GetBillAttachments(billID uuid.UUID) ([]BillAttachment, error) {
+	attachments := []BillAttachment{}
+	err := db.Omit("data").Where("bill_id = ?", billID).Order("created_at asc").Find(&attachments).Error
+	if err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// GetBillAttachment retrieves a file of the bill with its content
+func GetBillAttachment(billID uuid.UUID, attachmentID string) (*BillAttachment, error) {
+	var attachment BillAttachment
+	if err := db.Where("bill_id = ? AND attachment_id = ?", billID, attachmentID).First(&attachment).Error; err != nil {
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+// DeleteBillAttachment removes a file of the bill
+func DeleteBillAttachment(billID uuid.UUID, attachmentID string) error {
+	result := db.Where("bill_id = ? AND attachment_id = ?", billID, attachmentID).Delete(&BillAttachment{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// DecideBill approves a received bill for payment or rejects it, with the comment in the bill history
+func DecideBill(billID string, approve bool, decidedBy int, comment string) (*Bill, error) {
+	var bill Bill
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("bill_id = ?", billID).
+			First(&bill).Error
+		if err != nil {
+			return err
+		}
+		if bill.Status != BILLRECEIVED {
+			return fmt.Errorf("%w: the bill is %s", ErrBillNotReceived, bill.Status)
+		}
+
+		now := time.Now()
+		if approve {
+			bill.Status = BILLAPPROVED
+			bill.ApprovedAt = &now
+			bill.ApprovedBy = decidedBy
+		} else {
+			bill.Status = BILLREJECTED
+		}
+		AppendBillHistory(&bill, BillHistory{Action: bill.Status, ActionDate: now, Note: comment})
+		err = tx.Model(&bill).Select("status", "approved_at", "approved_by", "bill_history").Updates(&bill).Error
+		if err != nil || !approve {
+			return err
+		}
+		// What we owe the supplier is on the ledger once approved
+		return postBill(tx, &bill)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &bill, nil
+}
+
+// PayablesDueSoonDays is how far ahead the dashboard looks for bills coming due
+const PayablesDueSoonDays = 7
+
+func getPayablesDashboard(now time.Time) ([]PayablesDashboard, error) {
+	payables := []PayablesDashboard{}
+	dueSoon := now.AddDate(0, 0, PayablesDueSoonDays)
+	payable := []BillStatus{BILLAPPROVED, BILLPARTIALPAYMENT}
+	err := db.Model(&Bill{}).
+		Select(`currency,
+			COALESCE(SUM(outstanding_amount) FILTER (WHERE status = ?), 0) as total_awaiting_approval,
+			COUNT(*) FILTER (WHERE status = ?) as total_awaiting_approval_count,
+			COALESCE(SUM(outstanding_amount) FILTER (WHERE status IN ?), 0) as total_payable,
+			COUNT(*) FILTER (WHERE status IN ?) as total_payable_count,
+			COALESCE(SUM(outstanding_amount) FILTER (WHERE status IN ? AND due_date < ?), 0) as total_overdue,
+			COUNT(*) FILTER (WHERE status IN ? AND due_date < ?) as total_overdue_count,
+			COALESCE(SUM(outstanding_amount) FILTER (WHERE status IN ? AND due_date >= ? AND due_date <= ?), 0) as total_due_soon,
+			COUNT(*) FILTER (WHERE status IN ? AND due_date >= ? AND due_date <= ?) as total_due_soon_count,
+			COALESCE(SUM(amount) FILTER (WHERE status = ?), 0) as total_paid,
+			COUNT(*) FILTER (WHERE status = ?) as total_paid_count`,
+			BILLRECEIVED, BILLRECEIVED,
+			payable, payable,
+			openBillStatuses, now, openBillStatuses, now,
+			openBillStatuses, now, dueSoon, openBillStatuses, now, dueSoon,
+			BILLPAID, BILLPAID).
+		Group("currency").
+		Order("currency").
+		Scan(&payables).Error
+	if err != nil {
+		return nil, err
+	}
+	return payables, nil
+}
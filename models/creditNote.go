@@ -0,0 +1,116 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+var (
+	ErrCreditExceedsOutstanding = errors.New("credit note amount is greater than the outstanding amount")
+	ErrInvoiceNotCreditable     = errors.New("credit notes can only be issued against issued invoices")
+)
+
+// CREDIT NOTE
+type CreditNote struct {
+	gorm.Model
+	CreditNoteID     uuid.UUID       `gorm:"type:uuid;uniqueIndex;not null" json:"credit_note_id"`
+	CreditNoteNumber string          `gorm:"uniqueIndex;not null" json:"credit_note_number"` // Own numbering, eg: CN-000001
+	InvoiceID        uuid.UUID       `gorm:"type:uuid;index;not null" json:"invoice_id"`     // Invoice being adjusted
+	Reason           string          `gorm:"not null" json:"reason"`
+	Items            json.RawMessage `gorm:"type:jsonb;default:'[]';not null" json:"items"`
+	Amount           float64         `gorm:"not null" json:"amount"`
+	CustomerInfo     json.RawMessage `gorm:"type:jsonb;default:'{}';not null" json:"customer_info"` // Copied from the invoice at issue time
+	CreatedBy        int             `gorm:"not null" json:"created_by"`
+}
+
+// IssueCreditNote numbers and stores the credit note and reduces the invoice outstanding amount in one transaction.
+// Drafts, invoices waiting for approval, voided and written off invoices can not be credited.
+func IssueCreditNote(creditNote *CreditNote) (*Invoice, error) {
+	var invoice Invoice
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("invoice_id = ?", creditNote.InvoiceID).
+			First(&invoice).Error
+		if err != nil {
+			return err
+		}
+		switch invoice.Status {
+		case DRAFT, PENDINGAPPROVAL, CANCELED, WRITTENOFF:
+			return fmt.Errorf("%w: the invoice is %s", ErrInvoiceNotCreditable, invoice.Status)
+		}
+		if creditNote.Amount > invoice.OutstandingAmount {
+			return ErrCreditExceedsOutstanding
+		}
+
+		creditNote.CreditNoteNumber, err = nextDocumentNumber(tx, "credit_note", "CN")
+		if err != nil {
+			return err
+		}
+		creditNote.CustomerInfo = invoice.CustomerInfo
+		if err = tx.Create(creditNote).Error; err != nil {
+			return err
+		}
+
+		invoice.CreditedAmount += creditNote.Amount
+		invoice.OutstandingAmount -= creditNote.Amount
+		creditInstallments(&invoice, creditNote.Amount)
+		AppendInvoiceHistory(&invoice, InvoiceHistory{
+			Action:     CREDITNOTEISSUED,
+			ActionDate: time.Now(),
+			Reference:  creditNote.CreditNoteNumber,
+			Note:       creditNote.Reason,
+		})
+		if invoice.OutstandingAmount == 0 {
+			invoice.IsSettled = true
+			// Anything paid before the credit means the customer settled the rest
+			if NetPaid(&invoice) > 0 {
+				invoice.Status = FULLPAYMENT
+			} else {
+				invoice.Status = CREDITED
+			}
+			AppendInvoiceHistory(&invoice, InvoiceHistory{
+				Action:     invoice.Status,
+				ActionDate: time.Now(),
+			})
+		}
+		// Select so that zero values (outstanding amount) are written too
+		err = tx.Model(&invoice).
+			Select("credited_amount", "outstanding_amount", "installments", "invoice_history", "is_settled", "status").
+			Updates(&invoice).Error
+		if err != nil {
+			return err
+		}
+		if err = syncInvoiceLedger(tx, &invoice); err != nil {
+			return err
+		}
+		return recordRevision(tx, &invoice)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+// GetCreditNoteByID retrieves a credit note from the database by its CreditNoteID
+func GetCreditNoteByID(id string) (*CreditNote, error) {
+	var creditNote CreditNote
+	if err := db.Where("credit_note_id = ?", id).First(&creditNote).Error; err != nil {
+		return nil, err
+	}
+	return &creditNote, nil
+}
+
+// GetCreditNotesByInvoiceID lists the credit notes issued against an invoice, oldest first
+func GetCreditNotesByInvoiceID(invoiceID string) ([]CreditNote, error) {
+	var creditNotes []CreditNote
+	err := db.Where("invoice_id = ?", invoiceID).Order("created_at asc").Find(&creditNotes).Error
+	if err != nil {
+		return nil, err
+	}
+	return creditNotes, nil
+}
@@ -0,0 +1,734 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sort"
+	"text/template"
+	"time"
+)
+
+var (
+	ErrInvalidDunningTemplate = errors.New("dunning template not valid")
+	ErrInvoiceDisputed        = errors.New("invoice is already disputed")
+	ErrInvoiceNotDisputed     = errors.New("invoice is not disputed")
+	ErrInvoiceNotDisputable   = errors.New("only issued, unpaid invoices can be disputed")
+)
+
+// DunningAction is what a step of the dunning sequence does
+type DunningAction string
+
+const (
+	DUNNINGFRIENDLYREMINDER DunningAction = "FRIENDLY_REMINDER"
+	DUNNINGFIRMREMINDER     DunningAction = "FIRM_REMINDER"
+	DUNNINGFINALNOTICE      DunningAction = "FINAL_NOTICE"
+	DUNNINGLATEFEE          DunningAction = "LATE_FEE"  // Charges the step fee
+	DUNNINGHANDOVER         DunningAction = "HAND_OVER" // Flags the invoice for a collection agency or lawyer
+)
+
+// DunningChannel is how the customer is told about a step
+type DunningChannel string
+
+const (
+	DUNNINGEMAIL DunningChannel = "EMAIL"
+	DUNNINGSMS   DunningChannel = "SMS"
+)
+
+// DunningState is where an invoice stands in the sequence
+type DunningState string
+
+const (
+	DUNNINGNOTSTARTED DunningState = "NOT_STARTED" // No step is due yet
+	DUNNINGACTIVE     DunningState = "ACTIVE"
+	DUNNINGPAUSED     DunningState = "PAUSED"  // Disputed, picks up where it was once resolved
+	DUNNINGSTOPPED    DunningState = "STOPPED" // Paid, credited, written off or voided
+	DUNNINGCOMPLETED  DunningState = "COMPLETED"
+)
+
+// DunningStep is a step of the sequence, taken DaysAfterDue days after the due date
+type DunningStep struct {
+	Step         int              `json:"step"` // Position in the sequence, from 1
+	Name         string           `json:"name"`
+	Action       DunningAction    `json:"action"`
+	DaysAfterDue int              `json:"days_after_due"`
+	Channels     []DunningChannel `json:"channels"`
+	// Message to the customer, a text/template with the fields of DunningMessageData. The default of the action when empty.
+	Subject  string `json:"subject,omitempty"`
+	Template string `json:"template,omitempty"`
+	// Fee a LATE_FEE step charges, FLAT or PERCENTAGE of what is overdue
+	FeeType   LateFeeType `json:"fee_type,omitempty"`
+	FeeAmount float64     `json:"fee_amount,omitempty"`
+}
+
+// DUNNING SEQUENCE of the organization, the escalating steps taken once an invoice is past due.
+// The organization is the invoice creator until real accounts exist.
+type DunningSequence struct {
+	gorm.Model
+	SequenceID     uuid.UUID       `gorm:"type:uuid;uniqueIndex;not null" json:"sequence_id"`
+	OrganizationID int             `gorm:"uniqueIndex;not null" json:"organization_id"`
+	Name           string          `json:"name"`
+	Active         bool            `gorm:"not null" json:"active"` // No default so a sequence can be created turned off
+	Steps          json.RawMessage `gorm:"type:jsonb;default:'[]';not null" json:"steps"`
+}
+
+// DunningRecord is a step taken on an invoice
+type DunningRecord struct {
+	Step       int              `json:"step"`
+	Name       string           `json:"name"`
+	Action     DunningAction    `json:"action"`
+	TakenAt    time.Time        `json:"taken_at"`
+	SentBy     []DunningChannel `json:"sent_by,omitempty"`
+	Failed     []string         `json:"failed,omitempty"`     // Channels the message could not go out on, and why
+	Superseded bool             `json:"superseded,omitempty"` // Its message was skipped, a later step was due too
+	FeeID      *uuid.UUID       `json:"fee_id,omitempty"`
+}
+
+// INVOICE DUNNING, the progress of an invoice through the sequence
+type InvoiceDunning struct {
+	gorm.Model
+	InvoiceID     uuid.UUID       `gorm:"type:uuid;uniqueIndex;not null" json:"invoice_id"`
+	Step          int             `gorm:"default:0" json:"step"` // Last step taken, 0 before the first
+	LastStepAt    *time.Time      `json:"last_step_at"`
+	HandedOverAt  *time.Time      `json:"handed_over_at"`
+	DisputedAt    *time.Time      `json:"disputed_at"` // Set while disputed, dunning waits
+	DisputeReason string          `json:"dispute_reason"`
+	History       json.RawMessage `gorm:"type:jsonb;default:'[]';not null" json:"history"`
+	// Step whose message is going out, claimed before sending so a run at the same time leaves it alone
+	PendingStep int        `gorm:"default:0" json:"pending_step,omitempty"`
+	PendingAt   *time.Time `json:"pending_at,omitempty"`
+}
+
+// dunningPendingTimeout is how long a claimed step is left to the run sending its message. A run that did not
+// record the step by then is taken to have failed, and the step is taken again.
+const dunningPendingTimeout = time.Hour
+
+// DunningMessageData is what step templates get, eg: {{.CustomerName}} or {{.AmountDue}}
+type DunningMessageData struct {
+	CustomerName  string
+	InvoiceNumber string
+	AmountDue     string
+	Currency      string
+	DueDate       string
+	DaysOverdue   int
+	FeeAmount     string // Fee charged by the step, if any
+	SenderName    string
+	BankName      string
+	BankCode      string
+	AccountNumber string
+}
+
+// DunningMessage is a step message ready to go out
+type DunningMessage struct {
+	Subject string
+	Body    string
+}
+
+// DunningStatus is where an invoice stands in the sequence, for the overview of past due invoices
+type DunningStatus struct {
+	InvoiceID         uuid.UUID       `json:"invoice_id"`
+	InvoiceNumber     string          `json:"invoice_number"`
+	Customer          CustomerInfo    `json:"customer"`
+	Status            Status          `json:"status"`
+	DueDate           *time.Time      `json:"due_date"`
+	DaysOverdue       int             `json:"days_overdue"`
+	OutstandingAmount float64         `json:"outstanding_amount"`
+	State             DunningState    `json:"state"`
+	Step              int             `json:"step"`
+	StepName          string          `json:"step_name,omitempty"`
+	LastStepAt        *time.Time      `json:"last_step_at"`
+	NextStep          *DunningStep    `json:"next_step,omitempty"`
+	NextStepDate      *time.Time      `json:"next_step_date,omitempty"`
+	HandedOverAt      *time.Time      `json:"handed_over_at"`
+	DisputedAt        *time.Time      `json:"disputed_at"`
+	DisputeReason     string          `json:"dispute_reason,omitempty"`
+	History           []DunningRecord `json:"history,omitempty"`
+}
+
+// defaultDunningMessages are the messages of steps without a template of their own
+var defaultDunningMessages = map[DunningAction]DunningMessage{
+	DUNNINGFRIENDLYREMINDER: {
+		Subject: "Invoice {{.InvoiceNumber}} is past due",
+		Body:    "Hello {{.CustomerName}},\n\nJust a friendly reminder that invoice {{.InvoiceNumber}} was due on {{.DueDate}} and {{.AmountDue}} {{.Currency}} is still open. If you have already paid, please ignore this message.\n\nPay to {{.BankName}}, account number {{.AccountNumber}} ({{.BankCode}}).\n\nThank you,\n{{.SenderName}}",
+	},
+	DUNNINGFIRMREMINDER: {
+		Subject: "Second reminder: invoice {{.InvoiceNumber}} is {{.DaysOverdue}} days overdue",
+		Body:    "Hello {{.CustomerName}},\n\nInvoice {{.InvoiceNumber}} is now {{.DaysOverdue}} days overdue and {{.AmountDue}} {{.Currency}} is still open. Please pay it as soon as possible or let us know if something is wrong with it.\n\nPay to {{.BankName}}, account number {{.AccountNumber}} ({{.BankCode}}).\n\n{{.SenderName}}",
+	},
+	DUNNINGFINALNOTICE: {
+		Subject: "Final notice: invoice {{.InvoiceNumber}}",
+		Body:    "Hello {{.CustomerName}},\n\nDespite our reminders, invoice {{.InvoiceNumber}} remains unpaid {{.DaysOverdue}} days after its due date. Unless {{.AmountDue}} {{.Currency}} is paid promptly, we will take further steps to recover it.\n\nPay to {{.BankName}}, account number {{.AccountNumber}} ({{.BankCode}}).\n\n{{.SenderName}}",
+	},
+	DUNNINGLATEFEE: {
+		Subject: "Late payment fee on invoice {{.InvoiceNumber}}",
+		Body:    "Hello {{.CustomerName}},\n\nA late payment fee of {{.FeeAmount}} {{.Currency}} was added to invoice {{.InvoiceNumber}}, {{.DaysOverdue}} days overdue. The amount due is now {{.AmountDue}} {{.Currency}}.\n\nPay to {{.BankName}}, account number {{.AccountNumber}} ({{.BankCode}}).\n\n{{.SenderName}}",
+	},
+	DUNNINGHANDOVER: {
+		Subject: "Invoice {{.InvoiceNumber}} handed over for collection",
+		Body:    "Hello {{.CustomerName}},\n\nAs invoice {{.InvoiceNumber}} remains unpaid, {{.AmountDue}} {{.Currency}} has been handed over for collection.\n\n{{.SenderName}}",
+	},
+}
+
+// DunningSteps returns the steps of a sequence, in order
+func DunningSteps(sequence *DunningSequence) []DunningStep {
+	var steps []DunningStep
+	_ = json.Unmarshal(sequence.Steps, &steps)
+	return steps
+}
+
+// DunningHistory returns the steps taken on an invoice
+func DunningHistory(dunning *InvoiceDunning) []DunningRecord {
+	var history []DunningRecord
+	_ = json.Unmarshal(dunning.History, &history)
+	return history
+}
+
+// OrderDunningSteps sorts the steps by days after the due date and numbers them
+func OrderDunningSteps(steps []DunningStep) []DunningStep {
+	sort.SliceStable(steps, func(i, j int) bool { return steps[i].DaysAfterDue < steps[j].DaysAfterDue })
+	for i := range steps {
+		steps[i].Step = i + 1
+	}
+	return steps
+}
+
+// DunningStepMessage fills in the step message, its own template or the default of its action
+func DunningStepMessage(step DunningStep, data DunningMessageData) (DunningMessage, error) {
+	message := defaultDunningMessages[step.Action]
+	if step.Subject != "" {
+		message.Subject = step.Subject
+	}
+	if step.Template != "" {
+		message.Body = step.Template
+	}
+	var filled DunningMessage
+	for _, part := range []struct {
+		text string
+		out  *string
+	}{{message.Subject, &filled.Subject}, {message.Body, &filled.Body}} {
+		tmpl, err := template.New("dunning").Option("missingkey=error").Parse(part.text)
+		if err != nil {
+			return filled, fmt.Errorf("%w: step %d: %v", ErrInvalidDunningTemplate, step.Step, err)
+		}
+		var out bytes.Buffer
+		if err = tmpl.Execute(&out, data); err != nil {
+			return filled, fmt.Errorf("%w: step %d: %v", ErrInvalidDunningTemplate, step.Step, err)
+		}
+		*part.out = out.String()
+	}
+	return filled, nil
+}
+
+// ValidateDunningSteps checks the templates of the steps fill in
+func ValidateDunningSteps(steps []DunningStep) error {
+	sample := DunningMessageData{
+		CustomerName:  "Customer",
+		InvoiceNumber: "INV-000001",
+		AmountDue:     "100.00",
+		Currency:      InvoiceCurrency(),
+		DueDate:       "2006-01-02",
+		DaysOverdue:   1,
+		FeeAmount:     "10.00",
+	}
+	for _, step := range steps {
+		if _, err := DunningStepMessage(step, sample); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetDunningSequence returns the organization sequence, active or not
+func GetDunningSequence(organizationID int) (*DunningSequence, error) {
+	var sequence DunningSequence
+	err := db.Where("organization_id = ?", organizationID).First(&sequence).Error
+	if err != nil {
+		return nil, err
+	}
+	return &sequence, nil
+}
+
+// SaveDunningSequence creates or replaces a sequence. An active sequence charging fees is refused while the
+// organization has an active late fee policy, so an overdue invoice is not charged twice.
+func SaveDunningSequence(sequence *DunningSequence) error {
+	if sequenceChargesFees(sequence) {
+		var policies int64
+		err := db.Model(&LateFeePolicy{}).
+			Where("organization_id = ? AND active = ?", sequence.OrganizationID, true).
+			Count(&policies).Error
+		if err != nil {
+			return err
+		}
+		if policies > 0 {
+			return ErrLateFeeConflict
+		}
+	}
+	return db.Save(sequence).Error
+}
+
+// sequenceChargesFees tells whether the sequence is active with a LATE_FEE step
+func sequenceChargesFees(sequence *DunningSequence) bool {
+	if sequence == nil || !sequence.Active {
+		return false
+	}
+	for _, step := range DunningSteps(sequence) {
+		if step.Action == DUNNINGLATEFEE {
+			return true
+		}
+	}
+	return false
+}
+
+// invoiceDisputed tells whether the customer disputes the invoice, dunning, late fees and reminders wait until resolved
+func invoiceDisputed(tx *gorm.DB, invoiceID uuid.UUID) (bool, error) {
+	var disputes int64
+	err := tx.Model(&InvoiceDunning{}).
+		Where("invoice_id = ? AND disputed_at IS NOT NULL", invoiceID).
+		Count(&disputes).Error
+	return disputes > 0, err
+}
+
+// pastDueQuery selects the issued invoices still owing money past their due date
+func pastDueQuery(query *gorm.DB, now time.Time) *gorm.DB {
+	return query.Where("status IN ? AND due_date < ? AND outstanding_amount > 0", openStatuses, now)
+}
+
+// DunningInvoiceIDs returns the invoices the sequence may have a step due for
+func DunningInvoiceIDs(now time.Time) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := pastDueQuery(db.Model(&Invoice{}), now).Pluck("invoice_id", &ids).Error
+	return ids, err
+}
+
+func daysOverdue(invoice *Invoice, now time.Time) int {
+	if invoice.DueDate == nil || !now.After(*invoice.DueDate) {
+		return 0
+	}
+	return int(now.Sub(*invoice.DueDate).Hours() / 24)
+}
+
+// dunningMessageData is what the templates get for the invoice
+func dunningMessageData(invoice *Invoice, now time.Time) DunningMessageData {
+	var customerInfo CustomerInfo
+	_ = json.Unmarshal(invoice.CustomerInfo, &customerInfo)
+	user := PlaceHolderUser
+	data := DunningMessageData{
+		CustomerName:  customerInfo.Name,
+		InvoiceNumber: invoice.Number(),
+		AmountDue:     fmt.Sprintf("%.2f", invoice.OutstandingAmount),
+		Currency:      InvoiceCurrency(),
+		DaysOverdue:   daysOverdue(invoice, now),
+		SenderName:    user.Name,
+		BankName:      user.BankDetail.BankName,
+		BankCode:      user.BankDetail.BankCode,
+		AccountNumber: user.BankDetail.AccountNumber,
+	}
+	if invoice.DueDate != nil {
+		data.DueDate = invoice.DueDate.Format("2006-01-02")
+	}
+	return data
+}
+
+// dunningOnHold tells whether dunning waits on the invoice: disputed, or no longer owing money past its due date
+func dunningOnHold(invoice *Invoice, dunning *InvoiceDunning, now time.Time) bool {
+	return !isOpen(invoice.Status) || invoice.OutstandingAmount <= 0 || invoice.DueDate == nil || !now.After(*invoice.DueDate) ||
+		dunning.DisputedAt != nil
+}
+
+// dueDunningSteps returns the steps after the last one taken that are due days after the due date, and the index of
+// the one whose message goes out, -1 if none has a channel. Only the latest message goes out so a customer catching
+// up on several steps gets one message.
+func dueDunningSteps(steps []DunningStep, lastStep int, days int) ([]DunningStep, int) {
+	var due []DunningStep
+	for _, step := range steps {
+		if step.Step > lastStep && step.DaysAfterDue <= days {
+			due = append(due, step)
+		}
+	}
+	message := -1
+	for i, step := range due {
+		if len(step.Channels) > 0 {
+			message = i
+		}
+	}
+	return due, message
+}
+
+// AdvanceDunning takes the steps of the organization sequence that came due for the invoice since the last run.
+// Steps are taken in order: LATE_FEE charges the step fee, HAND_OVER flags the invoice, and only the message of the
+// latest step goes out, on each channel of the step through notify.
+// The steps before the message step are taken and the message step claimed in one transaction, the message is sent
+// once it is committed, and the step with the ones after it are taken in a second transaction, so nothing goes out
+// while the invoice is locked and a failed save does not send it again. When the message could not go out on any
+// channel the step is tried again on the next run. Nothing happens on a disputed invoice or one no longer owing
+// money past its due date.
+func AdvanceDunning(invoiceID uuid.UUID, now time.Time, notify func(invoice *Invoice, channel DunningChannel, message DunningMessage) error) ([]DunningRecord, error) {
+	var taken []DunningRecord
+	var sequence *DunningSequence
+	var invoice *Invoice
+	var due []DunningStep
+	var pending *DunningStep
+	var message DunningMessage
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var dunning *InvoiceDunning
+		var err error
+		invoice, dunning, err = lockInvoiceDunning(tx, invoiceID.String())
+		if err != nil {
+			return err
+		}
+		if dunningOnHold(invoice, dunning, now) {
+			return nil
+		}
+		if dunning.PendingAt != nil && now.Sub(*dunning.PendingAt) < dunningPendingTimeout {
+			return nil
+		}
+		sequence, err = GetDunningSequence(invoice.CreatedBy)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !sequence.Active {
+			return nil
+		}
+
+		var last int
+		due, last = dueDunningSteps(DunningSteps(sequence), dunning.Step, daysOverdue(invoice, now))
+		if len(due) == 0 {
+			return nil
+		}
+		before := due
+		if last >= 0 {
+			before, pending = due[:last], &due[last]
+			due = due[last+1:]
+		}
+		for _, step := range before {
+			record := DunningRecord{Step: step.Step, Name: step.Name, Action: step.Action, TakenAt: now}
+			// Its message is skipped, a later step was due too
+			record.Superseded = len(step.Channels) > 0
+			record, err = takeDunningStep(tx, invoice, dunning, sequence, step, record, now)
+			if err != nil {
+				return err
+			}
+			taken = append(taken, record)
+		}
+		if pending != nil {
+			data := dunningMessageData(invoice, now)
+			// The message goes out before the fee is charged, it tells what the fee will be
+			if pending.Action == DUNNINGLATEFEE {
+				fee := stepFee(*pending, invoice)
+				data.FeeAmount = fmt.Sprintf("%.2f", fee.Amount)
+				data.AmountDue = fmt.Sprintf("%.2f", invoice.OutstandingAmount+fee.Amount)
+			}
+			if message, err = DunningStepMessage(*pending, data); err != nil {
+				return err
+			}
+			dunning.PendingStep = pending.Step
+			dunning.PendingAt = &now
+		}
+
+		if err = tx.Save(dunning).Error; err != nil {
+			return err
+		}
+		return tx.Model(invoice).Select("invoice_history").Updates(invoice).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if pending == nil {
+		return taken, nil
+	}
+
+	sent := DunningRecord{Step: pending.Step, Name: pending.Name, Action: pending.Action, TakenAt: now}
+	for _, channel := range pending.Channels {
+		if err := notify(invoice, channel, message); err != nil {
+			sent.Failed = append(sent.Failed, fmt.Sprintf("%s: %v", channel, err))
+			continue
+		}
+		sent.SentBy = append(sent.SentBy, channel)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		invoice, dunning, err := lockInvoiceDunning(tx, invoiceID.String())
+		if err != nil {
+			return err
+		}
+		// Another run took the step over and recorded it
+		if dunning.Step >= pending.Step {
+			return nil
+		}
+		dunning.PendingStep = 0
+		dunning.PendingAt = nil
+		switch {
+		case len(sent.SentBy) == 0:
+			// Tried again on the next run
+		case dunningOnHold(invoice, dunning, now):
+			// Paid or disputed while the message went out, the step is recorded without charging or handing over
+			taken = append(taken, recordDunningStep(invoice, dunning, *pending, sent, now))
+		default:
+			for i, step := range append([]DunningStep{*pending}, due...) {
+				record := DunningRecord{Step: step.Step, Name: step.Name, Action: step.Action, TakenAt: now}
+				if i == 0 {
+					record = sent
+				}
+				record, err = takeDunningStep(tx, invoice, dunning, sequence, step, record, now)
+				if err != nil {
+					return err
+				}
+				taken = append(taken, record)
+			}
+		}
+		if err = tx.Save(dunning).Error; err != nil {
+			return err
+		}
+		return tx.Model(invoice).Select("invoice_history").Updates(invoice).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return taken, nil
+}
+
+// takeDunningStep does what the step does in the transaction, LATE_FEE charging the step fee and HAND_OVER flagging
+// the invoice, and records it
+func takeDunningStep(tx *gorm.DB, invoice *Invoice, dunning *InvoiceDunning, sequence *DunningSequence, step DunningStep, record DunningRecord, now time.Time) (DunningRecord, error) {
+	switch step.Action {
+	case DUNNINGLATEFEE:
+		fee := stepFee(step, invoice)
+		if fee.Amount > 0 {
+			fee.PolicyID = sequence.SequenceID
+			if err := chargeLateFee(tx, invoice, &fee, now); err != nil {
+				return record, err
+			}
+			record.FeeID = &fee.FeeID
+		}
+	case DUNNINGHANDOVER:
+		dunning.HandedOverAt = &now
+		AppendInvoiceHistory(invoice, InvoiceHistory{Action: HANDEDOVER, ActionDate: now, Note: step.Name})
+	}
+	return recordDunningStep(invoice, dunning, step, record, now), nil
+}
+
+// recordDunningStep moves the invoice on to the step, adding it to the dunning and invoice history
+func recordDunningStep(invoice *Invoice, dunning *InvoiceDunning, step DunningStep, record DunningRecord, now time.Time) DunningRecord {
+	AppendInvoiceHistory(invoice, InvoiceHistory{
+		Action:     DUNNINGSTEP,
+		ActionDate: now,
+		Reference:  fmt.Sprintf("%d", step.Step),
+		Note:       step.Name,
+	})
+	dunning.Step = step.Step
+	dunning.LastStepAt = &now
+	dunning.History, _ = json.Marshal(append(DunningHistory(dunning), record))
+	return record
+}
+
+// stepFee is the fee a LATE_FEE step charges on what is still owed, earlier fees left out
+func stepFee(step DunningStep, invoice *Invoice) LateFee {
+	fee := LateFee{Type: step.FeeType, Description: "Late payment fee"}
+	if step.Name != "" {
+		fee.Description = fmt.Sprintf("Late payment fee (%s)", step.Name)
+	}
+	switch step.FeeType {
+	case FLATFEE:
+		fee.Amount = step.FeeAmount
+	case PERCENTAGEFEE:
+		fee.Amount = (invoice.OutstandingAmount - invoice.FeesAmount) * step.FeeAmount / 100
+	}
+	fee.Amount = roundMoney(fee.Amount)
+	return fee
+}
+
+// GetInvoiceDunning returns the progress of the invoice through the sequence, a new one if it has none
+func GetInvoiceDunning(invoiceID uuid.UUID) (*InvoiceDunning, error) {
+	dunning := InvoiceDunning{InvoiceID: invoiceID, History: json.RawMessage("[]")}
+	err := db.Where("invoice_id = ?", invoiceID).First(&dunning).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	return &dunning, nil
+}
+
+// GetDunningStatus tells where the invoice stands in the sequence of its organization, with the steps taken
+func GetDunningStatus(invoice *Invoice, now time.Time) (*DunningStatus, error) {
+	dunning, err := GetInvoiceDunning(invoice.InvoiceID)
+	if err != nil {
+		return nil, err
+	}
+	sequence, err := GetDunningSequence(invoice.CreatedBy)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	status := dunningStatus(invoice, dunning, sequence, now)
+	status.History = DunningHistory(dunning)
+	return &status, nil
+}
+
+// GetDunningOverview lists the invoices past due, longest overdue first, and where each stands in the sequence
+func GetDunningOverview(params InvoiceQueryParams, now time.Time) ([]DunningStatus, error) {
+	var invoices []Invoice
+	err := pastDueQuery(db, now).
+		Limit(params.Limit).Offset(params.Offset).
+		Order("due_date asc").
+		Find(&invoices).Error
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uuid.UUID, 0, len(invoices))
+	for _, invoice := range invoices {
+		ids = append(ids, invoice.InvoiceID)
+	}
+	var dunnings []InvoiceDunning
+	if err = db.Where("invoice_id IN ?", ids).Find(&dunnings).Error; err != nil {
+		return nil, err
+	}
+	byInvoice := map[uuid.UUID]*InvoiceDunning{}
+	for i := range dunnings {
+		byInvoice[dunnings[i].InvoiceID] = &dunnings[i]
+	}
+
+	sequences := map[int]*DunningSequence{}
+	statuses := []DunningStatus{}
+	for i := range invoices {
+		invoice := &invoices[i]
+		sequence, ok := sequences[invoice.CreatedBy]
+		if !ok {
+			sequence, err = GetDunningSequence(invoice.CreatedBy)
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, err
+			}
+			sequences[invoice.CreatedBy] = sequence
+		}
+		dunning := byInvoice[invoice.InvoiceID]
+		if dunning == nil {
+			dunning = &InvoiceDunning{InvoiceID: invoice.InvoiceID}
+		}
+		statuses = append(statuses, dunningStatus(invoice, dunning, sequence, now))
+	}
+	return statuses, nil
+}
+
+// dunningStatus works out the state of the invoice in the sequence, a nil sequence being none set up
+func dunningStatus(invoice *Invoice, dunning *InvoiceDunning, sequence *DunningSequence, now time.Time) DunningStatus {
+	status := DunningStatus{
+		InvoiceID:         invoice.InvoiceID,
+		InvoiceNumber:     invoice.Number(),
+		Status:            invoice.Status,
+		DueDate:           invoice.DueDate,
+		DaysOverdue:       daysOverdue(invoice, now),
+		OutstandingAmount: invoice.OutstandingAmount,
+		State:             DUNNINGNOTSTARTED,
+		Step:              dunning.Step,
+		LastStepAt:        dunning.LastStepAt,
+		HandedOverAt:      dunning.HandedOverAt,
+		DisputedAt:        dunning.DisputedAt,
+		DisputeReason:     dunning.DisputeReason,
+	}
+	_ = json.Unmarshal(invoice.CustomerInfo, &status.Customer)
+	if dunning.Step > 0 {
+		status.State = DUNNINGACTIVE
+	}
+
+	var steps []DunningStep
+	if sequence != nil && sequence.Active {
+		steps = DunningSteps(sequence)
+	}
+	for i, step := range steps {
+		if step.Step == dunning.Step {
+			status.StepName = step.Name
+		}
+		if step.Step > dunning.Step && status.NextStep == nil {
+			status.NextStep = &steps[i]
+		}
+	}
+	if status.NextStep != nil && invoice.DueDate != nil {
+		nextStepDate := invoice.DueDate.AddDate(0, 0, status.NextStep.DaysAfterDue)
+		status.NextStepDate = &nextStepDate
+	}
+
+	switch {
+	case !isOpen(invoice.Status) || invoice.OutstandingAmount <= 0:
+		status.State = DUNNINGSTOPPED
+		status.NextStep, status.NextStepDate = nil, nil
+	case dunning.DisputedAt != nil:
+		status.State = DUNNINGPAUSED
+	case dunning.Step > 0 && status.NextStep == nil:
+		status.State = DUNNINGCOMPLETED
+	}
+	return status
+}
+
+// DisputeInvoice records the customer disputing the invoice, which pauses dunning until resolved
+func DisputeInvoice(invoiceID string, reason string) (*InvoiceDunning, error) {
+	return updateDispute(invoiceID, func(invoice *Invoice, dunning *InvoiceDunning, now time.Time) error {
+		if dunning.DisputedAt != nil {
+			return ErrInvoiceDisputed
+		}
+		if !isOpen(invoice.Status) || invoice.OutstandingAmount <= 0 {
+			return ErrInvoiceNotDisputable
+		}
+		dunning.DisputedAt = &now
+		dunning.DisputeReason = reason
+		AppendInvoiceHistory(invoice, InvoiceHistory{Action: DISPUTED, ActionDate: now, Note: reason})
+		return nil
+	})
+}
+
+// ResolveDispute ends the dispute, dunning picks up where it was
+func ResolveDispute(invoiceID string, note string) (*InvoiceDunning, error) {
+	return updateDispute(invoiceID, func(invoice *Invoice, dunning *InvoiceDunning, now time.Time) error {
+		if dunning.DisputedAt == nil {
+			return ErrInvoiceNotDisputed
+		}
+		dunning.DisputedAt = nil
+		dunning.DisputeReason = ""
+		AppendInvoiceHistory(invoice, InvoiceHistory{Action: DISPUTERESOLVED, ActionDate: now, Note: note})
+		return nil
+	})
+}
+
+func updateDispute(invoiceID string, change func(invoice *Invoice, dunning *InvoiceDunning, now time.Time) error) (*InvoiceDunning, error) {
+	var dunning *InvoiceDunning
+	err := db.Transaction(func(tx *gorm.DB) error {
+		invoice, locked, err := lockInvoiceDunning(tx, invoiceID)
+		if err != nil {
+			return err
+		}
+		dunning = locked
+		if err = change(invoice, dunning, time.Now()); err != nil {
+			return err
+		}
+		if err = tx.Save(dunning).Error; err != nil {
+			return err
+		}
+		return tx.Model(invoice).Select("invoice_history").Updates(invoice).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dunning, nil
+}
+
+// lockInvoiceDunning locks the invoice and its progress through the sequence in the transaction, a new one if it has none
+func lockInvoiceDunning(tx *gorm.DB, invoiceID string) (*Invoice, *InvoiceDunning, error) {
+	var invoice Invoice
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("invoice_id = ?", invoiceID).
+		First(&invoice).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	dunning := InvoiceDunning{InvoiceID: invoice.InvoiceID, History: json.RawMessage("[]")}
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("invoice_id = ?", invoice.InvoiceID).
+		First(&dunning).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, err
+	}
+	return &invoice, &dunning, nil
+}
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestDueDunningSteps(t *testing.T) {
+	email := []DunningChannel{DUNNINGEMAIL}
+	steps := OrderDunningSteps([]DunningStep{
+		{Name: "Reminder", Action: DUNNINGFRIENDLYREMINDER, DaysAfterDue: 1, Channels: email},
+		{Name: "Second reminder", Action: DUNNINGFIRMREMINDER, DaysAfterDue: 14, Channels: email},
+		{Name: "Fee", Action: DUNNINGLATEFEE, DaysAfterDue: 14, FeeType: FLATFEE, FeeAmount: 25},
+		{Name: "Final notice", Action: DUNNINGFINALNOTICE, DaysAfterDue: 30, Channels: email},
+		{Name: "Collection", Action: DUNNINGHANDOVER, DaysAfterDue: 60},
+	})
+
+	tests := []struct {
+		name     string
+		lastStep int
+		days     int
+		due      []int // Steps due
+		message  int   // Step whose message goes out, 0 for none
+	}{
+		{name: "not due yet", days: 0},
+		{name: "first step", days: 1, due: []int{1}, message: 1},
+		{name: "first step taken", lastStep: 1, days: 5},
+		{name: "message and silent fee due the same day", lastStep: 1, days: 14, due: []int{2, 3}, message: 2},
+		{name: "catching up sends the latest message only", days: 40, due: []int{1, 2, 3, 4}, message: 4},
+		{name: "silent step last", lastStep: 4, days: 60, due: []int{5}},
+		{name: "catching up with a silent step after the message", lastStep: 1, days: 90, due: []int{2, 3, 4, 5}, message: 4},
+		{name: "sequence done", lastStep: 5, days: 120},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			due, message := dueDunningSteps(steps, test.lastStep, test.days)
+			if len(due) != len(test.due) {
+				t.Fatalf("expected steps %v due, got %v", test.due, due)
+			}
+			for i, step := range due {
+				if step.Step != test.due[i] {
+					t.Errorf("expected step %d due, got %d", test.due[i], step.Step)
+				}
+			}
+			got := 0
+			if message >= 0 {
+				got = due[message].Step
+			}
+			if got != test.message {
+				t.Errorf("expected the message of step %d, got %d", test.message, got)
+			}
+		})
+	}
+}
+
+func TestStepFee(t *testing.T) {
+	tests := []struct {
+		name        string
+		step        DunningStep
+		outstanding float64
+		fees        float64
+		expected    float64
+	}{
+		{name: "flat", step: DunningStep{FeeType: FLATFEE, FeeAmount: 25}, outstanding: 1000, expected: 25},
+		{name: "flat on top of earlier fees", step: DunningStep{FeeType: FLATFEE, FeeAmount: 25}, outstanding: 1025, fees: 25, expected: 25},
+		{name: "percentage", step: DunningStep{FeeType: PERCENTAGEFEE, FeeAmount: 5}, outstanding: 1000, expected: 50},
+		{name: "percentage leaves earlier fees out", step: DunningStep{FeeType: PERCENTAGEFEE, FeeAmount: 5}, outstanding: 1040, fees: 40, expected: 50},
+		{name: "percentage rounded", step: DunningStep{FeeType: PERCENTAGEFEE, FeeAmount: 2.5}, outstanding: 333.33, expected: 8.33},
+		{name: "no fee type", step: DunningStep{FeeAmount: 25}, outstanding: 1000, expected: 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fee := stepFee(test.step, &Invoice{OutstandingAmount: test.outstanding, FeesAmount: test.fees})
+			if fee.Amount != test.expected {
+				t.Errorf("expected a fee of %.2f, got %.2f", test.expected, fee.Amount)
+			}
+		})
+	}
+}
+
+func TestSequenceChargesFees(t *testing.T) {
+	withFee := &DunningSequence{Active: true, Steps: []byte(`[{"step":1,"action":"FRIENDLY_REMINDER"},{"step":2,"action":"LATE_FEE"}]`)}
+	withoutFee := &DunningSequence{Active: true, Steps: []byte(`[{"step":1,"action":"FRIENDLY_REMINDER"}]`)}
+	inactive := &DunningSequence{Active: false, Steps: withFee.Steps}
+
+	tests := []struct {
+		name     string
+		sequence *DunningSequence
+		expected bool
+	}{
+		{name: "no sequence", sequence: nil, expected: false},
+		{name: "late fee step", sequence: withFee, expected: true},
+		{name: "no late fee step", sequence: withoutFee, expected: false},
+		{name: "turned off", sequence: inactive, expected: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := sequenceChargesFees(test.sequence); got != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+)
+
+// EarlyPaymentTerms is a discount for paying early, eg: 2/10 Net 30 is 2 percent off when paid within 10 days
+type EarlyPaymentTerms struct {
+	Percentage float64 `json:"percentage" validate:"gt=0,lte=100"`
+	Days       int     `json:"days" validate:"gt=0"`
+}
+
+// SetEarlyPaymentTerms stores the terms on the invoice and works out the deadline from the issue date.
+// Nil terms remove the discount.
+func SetEarlyPaymentTerms(invoice *Invoice, terms *EarlyPaymentTerms, issuedAt time.Time) {
+	if terms == nil {
+		invoice.EarlyPaymentDiscountPercentage = 0
+		invoice.EarlyPaymentDiscountDays = 0
+		invoice.EarlyPaymentDeadline = nil
+		return
+	}
+	deadline := time.Date(issuedAt.Year(), issuedAt.Month(), issuedAt.Day(), 23, 59, 59, 0, issuedAt.Location()).
+		AddDate(0, 0, terms.Days)
+	invoice.EarlyPaymentDiscountPercentage = terms.Percentage
+	invoice.EarlyPaymentDiscountDays = terms.Days
+	invoice.EarlyPaymentDeadline = &deadline
+}
+
+// EarlyPaymentDiscount is the discount a payment made at paidAt settling the invoice would get, 0 when none applies.
+// The discount is on the invoice total after credit notes, late fees never get a discount.
+func EarlyPaymentDiscount(invoice *Invoice, paidAt time.Time) float64 {
+	if invoice.EarlyPaymentDeadline == nil || invoice.EarlyPaymentDiscountTaken > 0 || paidAt.After(*invoice.EarlyPaymentDeadline) {
+		return 0
+	}
+	discount := roundMoney((invoice.Amount - invoice.CreditedAmount) * invoice.EarlyPaymentDiscountPercentage / 100)
+	if discount <= 0 || discount >= invoice.OutstandingAmount {
+		return 0
+	}
+	return discount
+}
+
+// EarlyPaymentAmount is what settles the invoice if paid at paidAt with the early payment discount.
+// ok is false when no discount applies anymore.
+func EarlyPaymentAmount(invoice *Invoice, paidAt time.Time) (amount float64, ok bool) {
+	discount := EarlyPaymentDiscount(invoice, paidAt)
+	if discount == 0 {
+		return invoice.OutstandingAmount, false
+	}
+	return roundMoney(invoice.OutstandingAmount - discount), true
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ExistingInvoiceNumbers returns which of the invoice numbers are taken already, deleted invoices included
+func ExistingInvoiceNumbers(numbers []string) (map[string]bool, error) {
+	existing := map[string]bool{}
+	if len(numbers) == 0 {
+		return existing, nil
+	}
+	var taken []string
+	err := db.Unscoped().Model(&Invoice{}).Where("invoice_number IN ?", numbers).Pluck("invoice_number", &taken).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, number := range taken {
+		existing[number] = true
+	}
+	return existing, nil
+}
+
+// ImportInvoices stores imported invoices in one transaction, all of them or none. Invoices without a number
+// get the next one, imported numbers from the invoice sequence move it along so new invoices don't reuse them.
+// Imported invoices skip approval, they went out before.
+func ImportInvoices(invoices []Invoice) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for i := range invoices {
+			if invoices[i].InvoiceNumber != nil {
+				if err := advanceDocumentNumber(tx, "invoice", "INV", *invoices[i].InvoiceNumber); err != nil {
+					return err
+				}
+			} else if err := assignInvoiceNumber(tx, &invoices[i]); err != nil {
+				return err
+			}
+			if err := tx.Create(&invoices[i]).Error; err != nil {
+				return err
+			}
+			if err := syncInvoiceLedger(tx, &invoices[i]); err != nil {
+				return err
+			}
+			// Revision 1 is the invoice as imported
+			if err := recordRevision(tx, &invoices[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// advanceDocumentNumber moves the sequence up to a number handed out outside of it, eg: INV-000120
+func advanceDocumentNumber(tx *gorm.DB, kind string, prefix string, number string) error {
+	var value int
+	// Numbers in another format can't collide with the sequence
+	if _, err := fmt.Sscanf(number, prefix+"-%d", &value); err != nil || fmt.Sprintf("%s-%06d", prefix, value) != number {
+		return nil
+	}
+	sequence := DocumentSequence{Kind: kind}
+	err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&sequence).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(&DocumentSequence{}).
+		Where("kind = ? AND last_value < ?", kind, value).
+		Update("last_value", value).Error
+}
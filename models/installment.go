@@ -0,0 +1,299 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"math"
+	"time"
+)
+
+var ErrInstallmentsTotal = errors.New("installment amounts must add up to the invoice total")
+
+// InstallmentStatus
+type InstallmentStatus string
+
+const (
+	INSTALLMENTPENDING InstallmentStatus = "PENDING"
+	INSTALLMENTPARTIAL InstallmentStatus = "PARTIALLY_PAID"
+	INSTALLMENTPAID    InstallmentStatus = "PAID"
+	INSTALLMENTOVERDUE InstallmentStatus = "OVERDUE"
+)
+
+// Installment is one agreed part of an invoice with its own due date
+type Installment struct {
+	InstallmentID     uuid.UUID         `json:"installment_id"`
+	Sequence          int               `json:"sequence"`
+	Amount            float64           `json:"amount"`
+	DueDate           time.Time         `json:"due_date"`
+	AmountPaid        float64           `json:"amount_paid"`
+	Status            InstallmentStatus `json:"status"`
+	RemindedAt        *time.Time        `json:"reminded_at,omitempty"`         // Reminder before the due date
+	OverdueNotifiedAt *time.Time        `json:"overdue_notified_at,omitempty"` // Notice once missed
+	NoticePendingAt   *time.Time        `json:"notice_pending_at,omitempty"`   // Claimed by a run sending its reminder or notice
+}
+
+// installmentNoticeTimeout is how long a claimed reminder or notice is left to the run sending it. A run that did not
+// record it by then is taken to have failed, and it is sent again.
+const installmentNoticeTimeout = time.Hour
+
+// installmentNotice is a reminder or, once the installment is missed, an overdue notice to send
+type installmentNotice struct {
+	installment Installment
+	overdue     bool
+}
+
+// Installments returns the installment schedule of an invoice, in order
+func Installments(invoice *Invoice) []Installment {
+	var installments []Installment
+	_ = json.Unmarshal(invoice.Installments, &installments)
+	return installments
+}
+
+// installmentsTotal is what the installments have to add up to: the invoice total after credit notes
+func installmentsTotal(invoice *Invoice) float64 {
+	return roundMoney(invoice.Amount - invoice.CreditedAmount)
+}
+
+// AllocateInstallments spreads what has been paid (and the early payment discount) over the installments
+// in order and works out their status. Late fees are paid after every installment.
+func AllocateInstallments(invoice *Invoice, now time.Time) {
+	if !invoice.HasInstallments {
+		return
+	}
+	installments := Installments(invoice)
+	pool := NetPaid(invoice) + invoice.EarlyPaymentDiscountTaken
+	for i := range installments {
+		paid := math.Min(pool, installments[i].Amount)
+		pool -= paid
+		installments[i].AmountPaid = roundMoney(paid)
+		switch {
+		case installments[i].AmountPaid >= installments[i].Amount:
+			installments[i].Status = INSTALLMENTPAID
+		case now.After(installments[i].DueDate):
+			installments[i].Status = INSTALLMENTOVERDUE
+		case installments[i].AmountPaid > 0:
+			installments[i].Status = INSTALLMENTPARTIAL
+		default:
+			installments[i].Status = INSTALLMENTPENDING
+		}
+	}
+	invoice.Installments, _ = json.Marshal(installments)
+}
+
+// creditInstallments takes a credit note off the last installments, the earliest ones stay as agreed
+func creditInstallments(invoice *Invoice, credit float64) {
+	if !invoice.HasInstallments {
+		return
+	}
+	installments := Installments(invoice)
+	for i := len(installments) - 1; i >= 0 && credit > 0; i-- {
+		reduction := math.Min(credit, installments[i].Amount-installments[i].AmountPaid)
+		if reduction <= 0 {
+			continue
+		}
+		installments[i].Amount = roundMoney(installments[i].Amount - reduction)
+		credit -= reduction
+	}
+	invoice.Installments, _ = json.Marshal(installments)
+	AllocateInstallments(invoice, time.Now())
+}
+
+// SetInstallments replaces the installment schedule of an invoice (or removes it when empty).
+// The invoice due date moves to the last installment.
+func SetInstallments(invoiceID string, installments []Installment) (*Invoice, error) {
+	var invoice Invoice
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("invoice_id = ?", invoiceID).
+			First(&invoice).Error
+		if err != nil {
+			return err
+		}
+
+		note := "Installment plan set"
+		if len(installments) == 0 {
+			note = "Installment plan removed"
+			invoice.HasInstallments = false
+			invoice.Installments = json.RawMessage("[]")
+		} else {
+			var sum float64
+			for i := range installments {
+				installments[i].InstallmentID = uuid.New()
+				installments[i].Sequence = i + 1
+				sum += installments[i].Amount
+			}
+			if math.Abs(sum-installmentsTotal(&invoice)) >= 0.01 {
+				return ErrInstallmentsTotal
+			}
+			invoice.HasInstallments = true
+			invoice.Installments, _ = json.Marshal(installments)
+			invoice.DueDate = &installments[len(installments)-1].DueDate
+			AllocateInstallments(&invoice, time.Now())
+		}
+		AppendInvoiceHistory(&invoice, InvoiceHistory{
+			Action:     INSTALLMENTSSCHEDULED,
+			ActionDate: time.Now(),
+			Note:       note,
+		})
+		// Missed installments make the invoice overdue, a new plan can take it out again
+		RefreshOverdueStatus(&invoice, time.Now())
+		err = tx.Model(&invoice).
+			Select("installments", "has_installments", "due_date", "status", "invoice_history").
+			Updates(&invoice).Error
+		if err != nil {
+			return err
+		}
+		return recordRevision(tx, &invoice)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+// InstallmentInvoiceIDs lists unpaid invoices paid in installments
+func InstallmentInvoiceIDs() ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := db.Model(&Invoice{}).
+		Where("has_installments = ? AND outstanding_amount > 0 AND status NOT IN ?", true, []Status{DRAFT, CANCELED}).
+		Pluck("invoice_id", &ids).Error
+	return ids, err
+}
+
+// missedInstallments adds up what is unpaid on installments past their due date and counts the invoices concerned
+func missedInstallments(now time.Time) (float64, int, error) {
+	var invoices []Invoice
+	err := db.Select("installments").
+		Where("has_installments = ? AND outstanding_amount > 0 AND status NOT IN ?", true, []Status{DRAFT, CANCELED, FULLPAYMENT, CREDITED, WRITTENOFF}).
+		Find(&invoices).Error
+	if err != nil {
+		return 0, 0, err
+	}
+	var amount float64
+	var count int
+	for i := range invoices {
+		var missed float64
+		for _, installment := range Installments(&invoices[i]) {
+			if !installment.DueDate.After(now) && installment.AmountPaid < installment.Amount {
+				missed += installment.Amount - installment.AmountPaid
+			}
+		}
+		if missed > 0 {
+			amount += missed
+			count++
+		}
+	}
+	return roundMoney(amount), count, nil
+}
+
+// installmentNotices returns the installments that need a reminder (due within remindBefore) or an overdue notice,
+// leaving out the ones another run is sending
+func installmentNotices(installments []Installment, now time.Time, remindBefore time.Duration) []installmentNotice {
+	var notices []installmentNotice
+	for _, installment := range installments {
+		if installment.Status == INSTALLMENTPAID {
+			continue
+		}
+		if installment.NoticePendingAt != nil && now.Sub(*installment.NoticePendingAt) < installmentNoticeTimeout {
+			continue
+		}
+		if installment.Status == INSTALLMENTOVERDUE && installment.OverdueNotifiedAt == nil {
+			notices = append(notices, installmentNotice{installment: installment, overdue: true})
+		} else if installment.Status != INSTALLMENTOVERDUE && installment.RemindedAt == nil && installment.DueDate.Sub(now) <= remindBefore {
+			notices = append(notices, installmentNotice{installment: installment})
+		}
+	}
+	return notices
+}
+
+// UpdateInstallmentTracking re-allocates payments for the day and hands the installments that need a reminder
+// (due within remindBefore) or an overdue notice to notify, recording the ones it managed to notify. Nothing is sent
+// while the invoice is disputed.
+// The allocation is saved and the notices claimed in one transaction, they are sent once it is committed, and the
+// ones sent are recorded in a second transaction, so nothing goes out while the invoice is locked and a failed save
+// does not send them again. Notices that could not go out are tried again on the next run.
+func UpdateInstallmentTracking(invoiceID uuid.UUID, now time.Time, remindBefore time.Duration, notify func(invoice *Invoice, installment Installment) error) error {
+	var invoice Invoice
+	var notices []installmentNotice
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("invoice_id = ?", invoiceID).
+			First(&invoice).Error
+		if err != nil {
+			return err
+		}
+		AllocateInstallments(&invoice, now)
+		disputed, err := invoiceDisputed(tx, invoice.InvoiceID)
+		if err != nil {
+			return err
+		}
+
+		installments := Installments(&invoice)
+		if !disputed {
+			notices = installmentNotices(installments, now, remindBefore)
+		}
+		for _, notice := range notices {
+			for i := range installments {
+				if installments[i].InstallmentID == notice.installment.InstallmentID {
+					installments[i].NoticePendingAt = &now
+				}
+			}
+		}
+		invoice.Installments, _ = json.Marshal(installments)
+		return tx.Model(&invoice).Select("installments").Updates(&invoice).Error
+	})
+	if err != nil {
+		return err
+	}
+	if len(notices) == 0 {
+		return nil
+	}
+
+	sent := map[uuid.UUID]bool{}
+	for _, notice := range notices {
+		if notify(&invoice, notice.installment) == nil {
+			sent[notice.installment.InstallmentID] = true
+		}
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var invoice Invoice
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("invoice_id = ?", invoiceID).
+			First(&invoice).Error
+		if err != nil {
+			return err
+		}
+		installments := Installments(&invoice)
+		for _, notice := range notices {
+			for i := range installments {
+				installment := &installments[i]
+				if installment.InstallmentID != notice.installment.InstallmentID {
+					continue
+				}
+				installment.NoticePendingAt = nil
+				if !sent[installment.InstallmentID] {
+					continue
+				}
+				// Left alone when another run recorded it meanwhile
+				if notice.overdue && installment.OverdueNotifiedAt == nil {
+					installment.OverdueNotifiedAt = &now
+					AppendInvoiceHistory(&invoice, InvoiceHistory{
+						Action:     INSTALLMENTMISSED,
+						ActionDate: now,
+						Reference:  installment.InstallmentID.String(),
+					})
+				} else if !notice.overdue && installment.RemindedAt == nil {
+					installment.RemindedAt = &now
+				}
+			}
+		}
+		invoice.Installments, _ = json.Marshal(installments)
+		return tx.Model(&invoice).
+			Select("installments", "invoice_history").
+			Updates(&invoice).Error
+	})
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func TestInstallmentNotices(t *testing.T) {
+	now := time.Date(2026, 5, 10, 8, 0, 0, 0, time.UTC)
+	window := 3 * 24 * time.Hour
+	earlier := now.AddDate(0, 0, -1)
+	claimed := now.Add(-10 * time.Minute)
+	abandoned := now.Add(-2 * time.Hour)
+
+	tests := []struct {
+		name        string
+		installment Installment
+		expected    bool // A notice is due
+		overdue     bool
+	}{
+		{name: "due within the window", installment: Installment{Status: INSTALLMENTPENDING, DueDate: now.AddDate(0, 0, 2)}, expected: true},
+		{name: "due after the window", installment: Installment{Status: INSTALLMENTPENDING, DueDate: now.AddDate(0, 0, 5)}},
+		{name: "partly paid within the window", installment: Installment{Status: INSTALLMENTPARTIAL, DueDate: now.AddDate(0, 0, 1)}, expected: true},
+		{name: "already reminded", installment: Installment{Status: INSTALLMENTPENDING, DueDate: now.AddDate(0, 0, 2), RemindedAt: &earlier}},
+		{name: "paid", installment: Installment{Status: INSTALLMENTPAID, DueDate: now.AddDate(0, 0, -5)}},
+		{name: "missed", installment: Installment{Status: INSTALLMENTOVERDUE, DueDate: now.AddDate(0, 0, -5), RemindedAt: &earlier}, expected: true, overdue: true},
+		{name: "missed and notified", installment: Installment{Status: INSTALLMENTOVERDUE, DueDate: now.AddDate(0, 0, -5), OverdueNotifiedAt: &earlier}},
+		{name: "being sent by another run", installment: Installment{Status: INSTALLMENTOVERDUE, DueDate: now.AddDate(0, 0, -5), NoticePendingAt: &claimed}},
+		{name: "left by a run that failed", installment: Installment{Status: INSTALLMENTOVERDUE, DueDate: now.AddDate(0, 0, -5), NoticePendingAt: &abandoned}, expected: true, overdue: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.installment.InstallmentID = uuid.New()
+			notices := installmentNotices([]Installment{test.installment}, now, window)
+			if (len(notices) == 1) != test.expected {
+				t.Fatalf("expected a notice %v, got %v", test.expected, notices)
+			}
+			if test.expected && notices[0].overdue != test.overdue {
+				t.Errorf("expected an overdue notice %v, got %v", test.overdue, notices[0].overdue)
+			}
+		})
+	}
+}
@@ -0,0 +1,306 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidTransition = errors.New("invalid status transition")
+	ErrFieldNotEditable  = errors.New("field not editable")
+	ErrIncompleteInvoice = errors.New("invoice is not complete")
+)
+
+// Transition is a status an invoice can move to next
+type Transition struct {
+	To Status `json:"to"`
+	// Manual transitions are made through the status field, the others happen through
+	// payments, credit notes and the overdue job
+	Manual bool   `json:"manual"`
+	Via    string `json:"via"`
+}
+
+// invoiceTransitions is the invoice state machine, every status change has to be listed here
+var invoiceTransitions = map[Status][]Transition{
+	DRAFT: {
+		{To: CREATED, Via: "finalize"},
+		{To: CANCELED, Via: "void"},
+	},
+	PENDINGAPPROVAL: {
+		{To: CREATED, Via: "approve"},
+		{To: DRAFT, Via: "reject"},
+		{To: CANCELED, Via: "void"},
+	},
+	CREATED: {
+		{To: SENT, Manual: true, Via: "status or send"},
+		{To: PENDINGAPPROVAL, Via: "approval rule"},
+		{To: CANCELED, Via: "void"},
+		{To: PARTIALPAYMENT, Via: "payment"},
+		{To: FULLPAYMENT, Via: "payment or late fee waiver"},
+		{To: OVERDUE, Via: "overdue job"},
+		{To: CREDITED, Via: "credit note"},
+		{To: WRITTENOFF, Via: "write-off"},
+	},
+	SENT: {
+		{To: CANCELED, Via: "void"},
+		{To: PARTIALPAYMENT, Via: "payment"},
+		{To: FULLPAYMENT, Via: "payment or late fee waiver"},
+		{To: OVERDUE, Via: "overdue job"},
+		{To: CREDITED, Via: "credit note"},
+		{To: WRITTENOFF, Via: "write-off"},
+	},
+	OVERDUE: {
+		{To: CANCELED, Via: "void"},
+		{To: PARTIALPAYMENT, Via: "payment or due date extension"},
+		{To: FULLPAYMENT, Via: "payment or late fee waiver"},
+		{To: CREATED, Via: "due date extension"},
+		{To: SENT, Via: "due date extension"},
+		{To: CREDITED, Via: "credit note"},
+		{To: WRITTENOFF, Via: "write-off"},
+	},
+	PARTIALPAYMENT: {
+		{To: FULLPAYMENT, Via: "payment, credit note or late fee waiver"},
+		{To: OVERDUE, Via: "overdue job"},
+		{To: CREATED, Via: "payment reversal"},
+		{To: SENT, Via: "payment reversal"},
+		{To: WRITTENOFF, Via: "write-off"},
+	},
+	FULLPAYMENT: {
+		{To: PARTIALPAYMENT, Via: "payment reversal"},
+		{To: OVERDUE, Via: "payment reversal"},
+		{To: CREATED, Via: "payment reversal"},
+		{To: SENT, Via: "payment reversal"},
+	},
+	CREDITED:   {},
+	CANCELED:   {},
+	WRITTENOFF: {},
+}
+
+// Invoice fields (by their JSON name) that can be changed in each status.
+// Items and prices are fixed once the invoice is sent, nothing changes after it is canceled.
+var editableFields = map[Status][]string{
+	DRAFT:           {"items", "amount", "is_discount", "discount_percentage", "due_date", "description", "customer_info", "reminders", "note", "early_payment_discount", "status"},
+	PENDINGAPPROVAL: {"note"},
+	CREATED:         {"items", "amount", "is_discount", "discount_percentage", "due_date", "description", "customer_info", "note", "early_payment_discount", "is_shared", "paid_amount", "is_settled", "status"},
+	SENT:            {"due_date", "description", "customer_info", "note", "early_payment_discount", "is_shared", "paid_amount", "is_settled", "status"},
+	OVERDUE:         {"due_date", "description", "customer_info", "note", "early_payment_discount", "is_shared", "paid_amount", "is_settled", "status"},
+	PARTIALPAYMENT:  {"due_date", "description", "customer_info", "note", "is_shared", "paid_amount", "is_settled"},
+	FULLPAYMENT:     {"note", "is_shared", "is_settled"},
+	CREDITED:        {"note", "is_shared"},
+	CANCELED:        {},
+	WRITTENOFF:      {"note"},
+}
+
+// InvoiceTransitions lists the statuses the invoice can move to next
+func InvoiceTransitions(invoice *Invoice) []Transition {
+	var transitions []Transition
+	for _, transition := range invoiceTransitions[invoice.Status] {
+		// Money received or credited is undone with reversals and credit notes, not by canceling
+		if transition.To == CANCELED && (NetPaid(invoice) > 0 || invoice.CreditedAmount > 0) {
+			continue
+		}
+		transitions = append(transitions, transition)
+	}
+	return transitions
+}
+
+// EditableFields lists the fields that can be changed on the invoice in its current state
+func EditableFields(invoice *Invoice) []string {
+	var fields []string
+	for _, field := range editableFields[invoice.Status] {
+		// Credited items and installment plans are based on the items as they are
+		if (invoice.CreditedAmount > 0 || invoice.HasInstallments) && isPricingField(field) {
+			continue
+		}
+		// The due date of an installment plan is its last installment
+		if invoice.HasInstallments && field == "due_date" {
+			continue
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+func isPricingField(field string) bool {
+	return field == "items" || field == "amount" || field == "is_discount" || field == "discount_percentage"
+}
+
+// CheckInvoiceEdit returns ErrFieldNotEditable when one of the fields can't be changed in the invoice state.
+// When the same update moves the invoice to another status, the fields have to be editable in that one too,
+// so items can't slip in alongside the status change that locks them.
+func CheckInvoiceEdit(invoice *Invoice, fields []string, to Status) error {
+	if err := checkEditable(invoice, fields); err != nil {
+		return err
+	}
+	if to == "" || to == invoice.Status {
+		return nil
+	}
+	target := *invoice
+	target.Status = to
+	var changed []string
+	for _, field := range fields {
+		if field != "status" {
+			changed = append(changed, field)
+		}
+	}
+	if err := checkEditable(&target, changed); err != nil {
+		return fmt.Errorf("%w, not together with the change to %s", err, to)
+	}
+	return nil
+}
+
+func checkEditable(invoice *Invoice, fields []string) error {
+	editable := EditableFields(invoice)
+	for _, field := range fields {
+		allowed := false
+		for _, editableField := range editable {
+			if field == editableField {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("%w: %s can not be changed on a %s invoice", ErrFieldNotEditable, field, invoice.Status)
+		}
+	}
+	return nil
+}
+
+// checkTransition returns ErrInvalidTransition unless the state machine has the transition, manual or not
+func checkTransition(invoice *Invoice, to Status) error {
+	for _, transition := range InvoiceTransitions(invoice) {
+		if transition.To == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: a %s invoice can not be moved to %s", ErrInvalidTransition, invoice.Status, to)
+}
+
+// TransitionInvoice makes a manual status change allowed by the state machine, recording it in the history.
+// The caller saves the invoice.
+func TransitionInvoice(invoice *Invoice, to Status) error {
+	if invoice.Status == to {
+		return nil
+	}
+	for _, transition := range InvoiceTransitions(invoice) {
+		if transition.To != to {
+			continue
+		}
+		if !transition.Manual {
+			return fmt.Errorf("%w: a %s invoice is moved to %s through %s", ErrInvalidTransition, invoice.Status, to, transition.Via)
+		}
+		now := time.Now()
+		invoice.Status = to
+		if to == SENT && invoice.SentAt == nil {
+			invoice.SentAt = &now
+		}
+		AppendInvoiceHistory(invoice, InvoiceHistory{
+			Action:     to,
+			ActionDate: now,
+		})
+		return nil
+	}
+	return fmt.Errorf("%w: a %s invoice can not be moved to %s", ErrInvalidTransition, invoice.Status, to)
+}
+
+// CheckIssuable returns ErrIncompleteInvoice listing what the invoice misses to be issued
+func CheckIssuable(invoice *Invoice, now time.Time) error {
+	if problems := issueProblems(invoice, now); len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrIncompleteInvoice, strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// issueProblems lists what a draft still misses to be issued, the checks CreateInvoice makes on a new invoice
+func issueProblems(invoice *Invoice, now time.Time) []string {
+	var problems []string
+	if invoice.DueDate == nil {
+		problems = append(problems, "due_date is required")
+	} else if !invoice.DueDate.After(now) {
+		problems = append(problems, "due_date can not be today or be in the past")
+	}
+
+	var items []Item
+	_ = json.Unmarshal(invoice.Items, &items)
+	if len(items) == 0 {
+		problems = append(problems, "items are required")
+	}
+	for i, item := range items {
+		if item.Name == "" || item.Quantity <= 0 || item.UnitPrice < 0 {
+			problems = append(problems, fmt.Sprintf("item %d needs a name, a quantity and a unit price", i+1))
+		}
+	}
+
+	var customerInfo CustomerInfo
+	_ = json.Unmarshal(invoice.CustomerInfo, &customerInfo)
+	if customerInfo.Name == "" {
+		problems = append(problems, "customer_info.name is required")
+	}
+	if customerInfo.Email == "" {
+		problems = append(problems, "customer_info.email is required")
+	}
+	if invoice.EarlyPaymentDeadline != nil && invoice.DueDate != nil && !invoice.EarlyPaymentDeadline.Before(*invoice.DueDate) {
+		problems = append(problems, "early_payment_discount days must end before the due_date")
+	}
+	return problems
+}
+
+// FinalizeInvoice issues a draft once it is complete: it gets its invoice number and issue date,
+// and the amount becomes outstanding. Drafts matching an approval rule go on to wait for approval.
+func FinalizeInvoice(invoiceID string) (*Invoice, error) {
+	var invoice Invoice
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("invoice_id = ?", invoiceID).
+			First(&invoice).Error
+		if err != nil {
+			return err
+		}
+		if invoice.Status != DRAFT {
+			return fmt.Errorf("%w: a %s invoice can not be finalized", ErrInvalidTransition, invoice.Status)
+		}
+
+		now := time.Now()
+		// Early payment days count from the issue date
+		if invoice.EarlyPaymentDeadline != nil {
+			SetEarlyPaymentTerms(&invoice, &EarlyPaymentTerms{
+				Percentage: invoice.EarlyPaymentDiscountPercentage,
+				Days:       invoice.EarlyPaymentDiscountDays,
+			}, now)
+		}
+		if err = CheckIssuable(&invoice, now); err != nil {
+			return err
+		}
+
+		invoice.Status = CREATED
+		invoice.IssuedAt = &now
+		if err = assignInvoiceNumber(tx, &invoice); err != nil {
+			return err
+		}
+		RecalculateOutstanding(&invoice)
+		AppendInvoiceHistory(&invoice, InvoiceHistory{
+			Action:     CREATED,
+			ActionDate: now,
+			Reference:  *invoice.InvoiceNumber,
+		})
+		if _, err = requestApproval(tx, &invoice); err != nil {
+			return err
+		}
+		err = tx.Model(&invoice).
+			Select("status", "issued_at", "invoice_number", "early_payment_deadline", "outstanding_amount", "invoice_history").
+			Updates(&invoice).Error
+		if err != nil {
+			return err
+		}
+		return syncInvoiceLedger(tx, &invoice)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckIssuable(t *testing.T) {
+	now := time.Date(2026, 6, 1, 9, 0, 0, 0, time.UTC)
+	dueDate := now.AddDate(0, 0, 30)
+	items, _ := json.Marshal([]Item{{Name: "Design", Quantity: 2, UnitPrice: 150}})
+	newInvoice := func(customerInfo CustomerInfo) *Invoice {
+		customer, _ := json.Marshal(customerInfo)
+		return &Invoice{DueDate: &dueDate, Items: items, CustomerInfo: customer}
+	}
+
+	tests := []struct {
+		name     string
+		invoice  *Invoice
+		complete bool
+	}{
+		{name: "complete", invoice: newInvoice(CustomerInfo{Name: "Acme", Email: "billing@acme.com"}), complete: true},
+		{name: "no customer", invoice: newInvoice(CustomerInfo{})},
+		{name: "customer without email", invoice: newInvoice(CustomerInfo{Name: "Acme"})},
+		{name: "customer without name", invoice: newInvoice(CustomerInfo{Email: "billing@acme.com"})},
+		{
+			name: "no items",
+			invoice: func() *Invoice {
+				invoice := newInvoice(CustomerInfo{Name: "Acme", Email: "billing@acme.com"})
+				invoice.Items = json.RawMessage("[]")
+				return invoice
+			}(),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := CheckIssuable(test.invoice, now)
+			if test.complete && err != nil {
+				t.Errorf("expected the invoice to be complete, got %v", err)
+			}
+			if !test.complete && !errors.Is(err, ErrIncompleteInvoice) {
+				t.Errorf("expected ErrIncompleteInvoice, got %v", err)
+			}
+		})
+	}
+}
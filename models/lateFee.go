@@ -0,0 +1,325 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"math"
+	"time"
+)
+
+var (
+	ErrLateFeeNotFound    = errors.New("late fee not found")
+	ErrLateFeeNotWaivable = errors.New("late fees can only be waived on open invoices")
+	ErrLateFeeConflict    = errors.New("late fees are charged by the late fee policy or by a LATE_FEE dunning step, not both")
+)
+
+// LateFeeType
+type LateFeeType string
+
+const (
+	FLATFEE         LateFeeType = "FLAT"             // Fixed amount, charged once
+	PERCENTAGEFEE   LateFeeType = "PERCENTAGE"       // Percentage of what is overdue, charged once
+	DAILYINTEREST   LateFeeType = "DAILY_INTEREST"   // Percentage of what is overdue per day
+	MONTHLYINTEREST LateFeeType = "MONTHLY_INTEREST" // Percentage of what is overdue per (30 day) month
+)
+
+// LATE FEE POLICY, for an organization or overriding it for a single invoice.
+// The organization is the invoice creator until real accounts exist.
+type LateFeePolicy struct {
+	gorm.Model
+	PolicyID       uuid.UUID   `gorm:"type:uuid;uniqueIndex;not null" json:"policy_id"`
+	OrganizationID int         `gorm:"index;not null" json:"organization_id"`
+	InvoiceID      *uuid.UUID  `gorm:"type:uuid;uniqueIndex" json:"invoice_id"` // Set for an invoice specific policy
+	Type           LateFeeType `gorm:"not null" json:"type"`
+	Amount         float64     `gorm:"not null" json:"amount"`      // Flat amount, or the rate in percent
+	Cap            float64     `gorm:"default:0" json:"cap"`        // Optional, most fees an invoice can get under the policy (0 is no cap)
+	GraceDays      int         `gorm:"default:0" json:"grace_days"` // Days after the due date before fees start
+	Active         bool        `gorm:"not null" json:"active"`      // No default so a policy can be created turned off
+}
+
+// LateFee is a fee line entry on the invoice, kept apart from the items
+type LateFee struct {
+	FeeID       uuid.UUID   `json:"fee_id"`
+	PolicyID    uuid.UUID   `json:"policy_id"`
+	Type        LateFeeType `json:"type"`
+	Amount      float64     `json:"amount"`
+	Periods     int         `json:"periods,omitempty"` // Days or months of interest the entry covers
+	Description string      `json:"description"`
+	AppliedAt   time.Time   `json:"applied_at"`
+	Waived      bool        `json:"waived"`
+	WaivedAt    *time.Time  `json:"waived_at,omitempty"`
+	// Only what was still unpaid is waived
+	AmountWaived float64 `json:"amount_waived,omitempty"`
+	WaivedBy     int     `json:"waived_by,omitempty"`
+	WaiveReason  string  `json:"waive_reason,omitempty"`
+}
+
+// LateFees returns the fee entries of an invoice
+func LateFees(invoice *Invoice) []LateFee {
+	var fees []LateFee
+	_ = json.Unmarshal(invoice.LateFees, &fees)
+	return fees
+}
+
+func roundMoney(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// GetLateFeePolicy returns the invoice policy, or the organization one if the invoice has none
+func GetLateFeePolicy(invoice *Invoice) (*LateFeePolicy, error) {
+	var policy LateFeePolicy
+	err := db.Where("invoice_id = ? AND active = ?", invoice.InvoiceID, true).First(&policy).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = db.Where("organization_id = ? AND invoice_id IS NULL AND active = ?", invoice.CreatedBy, true).First(&policy).Error
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// GetOrganizationLateFeePolicy returns the organization wide policy, active or not
+func GetOrganizationLateFeePolicy(organizationID int) (*LateFeePolicy, error) {
+	var policy LateFeePolicy
+	err := db.Where("organization_id = ? AND invoice_id IS NULL", organizationID).First(&policy).Error
+	if err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// GetInvoiceLateFeePolicy returns the policy set on one invoice, active or not
+func GetInvoiceLateFeePolicy(invoiceID uuid.UUID) (*LateFeePolicy, error) {
+	var policy LateFeePolicy
+	err := db.Where("invoice_id = ?", invoiceID).First(&policy).Error
+	if err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// SaveLateFeePolicy creates or replaces a policy. An active policy is refused while the organization dunning
+// sequence charges fees, so an overdue invoice is not charged twice.
+func SaveLateFeePolicy(policy *LateFeePolicy) error {
+	if policy.Active {
+		sequence, err := GetDunningSequence(policy.OrganizationID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if sequenceChargesFees(sequence) {
+			return ErrLateFeeConflict
+		}
+	}
+	return db.Save(policy).Error
+}
+
+// lateFeeDue works out the fee the policy adds now, on top of the fees it already charged
+func lateFeeDue(policy *LateFeePolicy, invoice *Invoice, now time.Time) LateFee {
+	fee := LateFee{PolicyID: policy.PolicyID, Type: policy.Type}
+	if invoice.DueDate == nil {
+		return fee
+	}
+	start := invoice.DueDate.AddDate(0, 0, policy.GraceDays)
+	if !now.After(start) {
+		return fee
+	}
+
+	var charged float64
+	var chargedPeriods int
+	for _, existing := range LateFees(invoice) {
+		if existing.PolicyID != policy.PolicyID {
+			continue
+		}
+		// Waived fees still count, waiving must not make the job charge again
+		charged += existing.Amount
+		chargedPeriods += existing.Periods
+		if policy.Type == FLATFEE || policy.Type == PERCENTAGEFEE {
+			return fee
+		}
+	}
+
+	// Fees apply to what the customer still owes, not to earlier fees
+	principal := invoice.OutstandingAmount - invoice.FeesAmount
+	days := int(now.Sub(start).Hours() / 24)
+	switch policy.Type {
+	case FLATFEE:
+		fee.Amount = policy.Amount
+		fee.Description = "Late payment fee"
+	case PERCENTAGEFEE:
+		fee.Amount = principal * policy.Amount / 100
+		fee.Description = "Late payment fee"
+	case DAILYINTEREST:
+		fee.Periods = days - chargedPeriods
+		fee.Amount = principal * policy.Amount / 100 * float64(fee.Periods)
+		fee.Description = "Late payment interest (daily)"
+	case MONTHLYINTEREST:
+		fee.Periods = days/30 - chargedPeriods
+		fee.Amount = principal * policy.Amount / 100 * float64(fee.Periods)
+		fee.Description = "Late payment interest (monthly)"
+	}
+	if policy.Cap > 0 && charged+fee.Amount > policy.Cap {
+		fee.Amount = policy.Cap - charged
+	}
+	fee.Amount = roundMoney(fee.Amount)
+	return fee
+}
+
+// ApplyLateFee charges whatever late fee the invoice policy says is due now. Disputed invoices are not charged, and
+// neither are invoices of an organization whose dunning sequence charges the fees.
+// It returns nil when there was nothing to charge.
+func ApplyLateFee(invoiceID uuid.UUID, now time.Time) (*LateFee, error) {
+	var applied *LateFee
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var invoice Invoice
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("invoice_id = ?", invoiceID).
+			First(&invoice).Error
+		if err != nil {
+			return err
+		}
+		if invoice.OutstandingAmount <= 0 {
+			return nil
+		}
+		disputed, err := invoiceDisputed(tx, invoice.InvoiceID)
+		if err != nil {
+			return err
+		}
+		if disputed {
+			return nil
+		}
+		sequence, err := GetDunningSequence(invoice.CreatedBy)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if sequenceChargesFees(sequence) {
+			return nil
+		}
+		policy, err := GetLateFeePolicy(&invoice)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		fee := lateFeeDue(policy, &invoice, now)
+		if fee.Amount <= 0 {
+			return nil
+		}
+		applied = &fee
+		return chargeLateFee(tx, &invoice, &fee, now)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// chargeLateFee adds the fee to the invoice and what the customer owes, and saves the invoice in the transaction
+func chargeLateFee(tx *gorm.DB, invoice *Invoice, fee *LateFee, now time.Time) error {
+	fee.FeeID = uuid.New()
+	fee.AppliedAt = now
+	invoice.LateFees, _ = json.Marshal(append(LateFees(invoice), *fee))
+	invoice.FeesAmount += fee.Amount
+	invoice.OutstandingAmount += fee.Amount
+	invoice.IsSettled = false
+	AppendInvoiceHistory(invoice, InvoiceHistory{
+		Action:     LATEFEEAPPLIED,
+		ActionDate: now,
+		Reference:  fee.FeeID.String(),
+		Note:       fee.Description,
+	})
+	err := tx.Model(invoice).
+		Select("late_fees", "fees_amount", "outstanding_amount", "is_settled", "invoice_history").
+		Updates(invoice).Error
+	if err != nil {
+		return err
+	}
+	if err = syncInvoiceLedger(tx, invoice); err != nil {
+		return err
+	}
+	return recordRevision(tx, invoice)
+}
+
+// WaiveLateFee takes a fee off what the customer owes, keeping the entry for the record. Only fees of open invoices
+// can be waived, the status follows through the state machine.
+func WaiveLateFee(invoiceID string, feeID uuid.UUID, waivedBy int, reason string) (*Invoice, error) {
+	var invoice Invoice
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("invoice_id = ?", invoiceID).
+			First(&invoice).Error
+		if err != nil {
+			return err
+		}
+
+		// Paid, credited, written off and voided invoices are settled, waiving must not move them
+		if !isOpen(invoice.Status) {
+			return fmt.Errorf("%w: the invoice is %s", ErrLateFeeNotWaivable, invoice.Status)
+		}
+
+		fees := LateFees(&invoice)
+		index := -1
+		for i, fee := range fees {
+			if fee.FeeID == feeID && !fee.Waived {
+				index = i
+			}
+		}
+		if index < 0 {
+			return ErrLateFeeNotFound
+		}
+		// Only what is still unpaid can be waived
+		waived := math.Min(fees[index].Amount, invoice.OutstandingAmount)
+
+		now := time.Now()
+		fees[index].Waived = true
+		fees[index].WaivedAt = &now
+		fees[index].AmountWaived = waived
+		fees[index].WaivedBy = waivedBy
+		fees[index].WaiveReason = reason
+		invoice.LateFees, _ = json.Marshal(fees)
+		invoice.FeesAmount -= waived
+		invoice.OutstandingAmount -= waived
+		AppendInvoiceHistory(&invoice, InvoiceHistory{
+			Action:     LATEFEEWAIVED,
+			ActionDate: now,
+			Reference:  feeID.String(),
+			Note:       reason,
+		})
+		waivedStatus := invoice
+		paymentStatus(&waivedStatus)
+		if waivedStatus.Status != invoice.Status {
+			if err = checkTransition(&invoice, waivedStatus.Status); err != nil {
+				return err
+			}
+		}
+		invoice = waivedStatus
+		err = tx.Model(&invoice).
+			Select("late_fees", "fees_amount", "outstanding_amount", "is_settled", "status", "invoice_history").
+			Updates(&invoice).Error
+		if err != nil {
+			return err
+		}
+		if err = syncInvoiceLedger(tx, &invoice); err != nil {
+			return err
+		}
+		return recordRevision(tx, &invoice)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+// OverdueInvoiceIDs lists unpaid issued invoices past their due date
+func OverdueInvoiceIDs(now time.Time) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := db.Model(&Invoice{}).
+		Where("due_date < ? AND outstanding_amount > 0 AND status IN ?", now, openStatuses).
+		Pluck("invoice_id", &ids).Error
+	return ids, err
+}
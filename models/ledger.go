@@ -0,0 +1,603 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrUnbalancedEntry = errors.New("journal entry debits and credits do not balance")
+
+// AccountType
+type AccountType string
+
+const (
+	ASSET     AccountType = "ASSET"
+	LIABILITY AccountType = "LIABILITY"
+	INCOME    AccountType = "INCOME"
+	EXPENSE   AccountType = "EXPENSE"
+)
+
+// Account codes of the chart of accounts
+const (
+	ACCOUNTBANK           = "1000"
+	ACCOUNTRECEIVABLE     = "1100"
+	ACCOUNTINPUTTAX       = "1400" // Tax paid on supplier bills, reclaimable
+	ACCOUNTPAYABLE        = "2000"
+	ACCOUNTSALES          = "4000"
+	ACCOUNTLATEFEES       = "4100"
+	ACCOUNTSALESRETURNS   = "4200" // Credit notes, reduces sales
+	ACCOUNTSALESDISCOUNTS = "4300" // Early payment discounts taken, reduces sales
+	ACCOUNTPURCHASES      = "5000"
+	ACCOUNTBADDEBTS       = "6000"
+)
+
+// LEDGER ACCOUNT
+type Account struct {
+	Code string      `json:"code"`
+	Name string      `json:"name"`
+	Type AccountType `json:"type"`
+}
+
+// ChartOfAccounts lists every account entries are posted to, in code order
+var ChartOfAccounts = []Account{
+	{Code: ACCOUNTBANK, Name: "Bank", Type: ASSET},
+	{Code: ACCOUNTRECEIVABLE, Name: "Accounts receivable", Type: ASSET},
+	{Code: ACCOUNTINPUTTAX, Name: "Input tax", Type: ASSET},
+	{Code: ACCOUNTPAYABLE, Name: "Accounts payable", Type: LIABILITY},
+	{Code: ACCOUNTSALES, Name: "Sales", Type: INCOME},
+	{Code: ACCOUNTLATEFEES, Name: "Late fee income", Type: INCOME},
+	{Code: ACCOUNTSALESRETURNS, Name: "Sales returns and allowances", Type: INCOME},
+	{Code: ACCOUNTSALESDISCOUNTS, Name: "Sales discounts", Type: INCOME},
+	{Code: ACCOUNTPURCHASES, Name: "Purchases", Type: EXPENSE},
+	{Code: ACCOUNTBADDEBTS, Name: "Bad debts", Type: EXPENSE},
+}
+
+// JournalSource is the event an entry was posted for
+type JournalSource string
+
+const (
+	JOURNALINVOICE             JournalSource = "INVOICE"
+	JOURNALINVOICEADJUSTMENT   JournalSource = "INVOICE_ADJUSTMENT"
+	JOURNALINVOICEVOID         JournalSource = "INVOICE_VOID"
+	JOURNALLATEFEE             JournalSource = "LATE_FEE"
+	JOURNALLATEFEEWAIVED       JournalSource = "LATE_FEE_WAIVED"
+	JOURNALCREDITNOTE          JournalSource = "CREDIT_NOTE"
+	JOURNALEARLYPAYMENTDISC    JournalSource = "EARLY_PAYMENT_DISCOUNT"
+	JOURNALEARLYPAYMENTDISCREV JournalSource = "EARLY_PAYMENT_DISCOUNT_REVERSED"
+	JOURNALWRITEOFF            JournalSource = "WRITE_OFF"
+	JOURNALPAYMENT             JournalSource = "PAYMENT"
+	JOURNALPAYMENTREVERSAL     JournalSource = "PAYMENT_REVERSAL"
+	JOURNALBILL                JournalSource = "BILL"
+	JOURNALBILLDELETED         JournalSource = "BILL_DELETED"
+	JOURNALBILLPAYMENT         JournalSource = "BILL_PAYMENT"
+)
+
+// JOURNAL ENTRY, entries are never changed or deleted: a mistake is corrected by posting another entry
+type JournalEntry struct {
+	ID          uint          `gorm:"primaryKey" json:"-"`
+	CreatedAt   time.Time     `json:"created_at"`
+	EntryID     uuid.UUID     `gorm:"type:uuid;uniqueIndex;not null" json:"entry_id"`
+	EntryNumber string        `gorm:"uniqueIndex;not null" json:"entry_number"` // eg: JE-000001
+	Date        time.Time     `gorm:"index;not null" json:"date"`
+	Source      JournalSource `gorm:"index;not null" json:"source"`
+	Reference   string        `json:"reference"` // The invoice, credit note, payment or fee behind the entry
+	Description string        `json:"description"`
+	Currency    string        `gorm:"not null" json:"currency"`
+	InvoiceID   *uuid.UUID    `gorm:"type:uuid;index" json:"invoice_id"`
+	BillID      *uuid.UUID    `gorm:"type:uuid;index" json:"bill_id"`
+	Lines       []JournalLine `gorm:"foreignKey:EntryID;references:EntryID" json:"lines"`
+}
+
+// JOURNAL LINE, one side of an entry: either a debit or a credit on an account
+type JournalLine struct {
+	ID      uint      `gorm:"primaryKey" json:"-"`
+	EntryID uuid.UUID `gorm:"type:uuid;index;not null" json:"-"`
+	Account string    `gorm:"index;not null" json:"account"`
+	Debit   float64   `gorm:"default:0" json:"debit"`
+	Credit  float64   `gorm:"default:0" json:"credit"`
+}
+
+// JournalQueryParams filters the journal
+type JournalQueryParams struct {
+	Limit     int
+	Offset    int
+	InvoiceID *uuid.UUID
+	BillID    *uuid.UUID
+	Source    JournalSource
+}
+
+// TRIAL BALANCE of one currency, amounts in different currencies are never added up
+type TrialBalance struct {
+	Currency    string                `json:"currency"`
+	AsOf        time.Time             `json:"as_of"`
+	Accounts    []TrialBalanceAccount `json:"accounts"`
+	TotalDebit  float64               `json:"total_debit"`
+	TotalCredit float64               `json:"total_credit"`
+	Balanced    bool                  `json:"balanced"`
+}
+
+// TrialBalanceAccount is the balance of an account on its debit or credit side
+type TrialBalanceAccount struct {
+	Account
+	Debit  float64 `json:"debit"`
+	Credit float64 `json:"credit"`
+}
+
+// InvoiceLedger is the ledger of an invoice and the balance the customer owes according to it,
+// the outstanding amount of the invoice is kept equal to it
+type InvoiceLedger struct {
+	InvoiceID         uuid.UUID      `json:"invoice_id"`
+	Currency          string         `json:"currency"`
+	ReceivableBalance float64        `json:"receivable_balance"`
+	Entries           []JournalEntry `json:"entries"`
+}
+
+// InvoiceCurrency of the invoice amounts, INVOICE_CURRENCY (ISO 4217), NGN by default
+func InvoiceCurrency() string {
+	if currency := os.Getenv("INVOICE_CURRENCY"); currency != "" {
+		return strings.ToUpper(currency)
+	}
+	return "NGN"
+}
+
+func debit(account string, amount float64) JournalLine {
+	return JournalLine{Account: account, Debit: amount}
+}
+
+func credit(account string, amount float64) JournalLine {
+	return JournalLine{Account: account, Credit: amount}
+}
+
+// postEntry numbers and stores a balanced entry, leaving out the lines of nothing
+func postEntry(tx *gorm.DB, entry *JournalEntry) error {
+	var lines []JournalLine
+	var debits, credits float64
+	for _, line := range entry.Lines {
+		line.Debit = roundMoney(line.Debit)
+		line.Credit = roundMoney(line.Credit)
+		if line.Debit == 0 && line.Credit == 0 {
+			continue
+		}
+		debits += line.Debit
+		credits += line.Credit
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	if math.Abs(debits-credits) >= 0.005 {
+		return fmt.Errorf("%w: %.2f debit, %.2f credit", ErrUnbalancedEntry, debits, credits)
+	}
+
+	number, err := nextDocumentNumber(tx, "journal_entry", "JE")
+	if err != nil {
+		return err
+	}
+	entry.EntryID = uuid.New()
+	entry.EntryNumber = number
+	entry.Lines = lines
+	return tx.Create(entry).Error
+}
+
+// ledgerEvent is a business event of an invoice posted as an entry of its own, moving the amount between
+// receivables and the account. Source and reference tell the events apart, so each is posted once.
+type ledgerEvent struct {
+	source     JournalSource
+	reference  string
+	date       time.Time
+	account    string
+	receivable float64 // What the event adds to receivables, negative when it takes off
+}
+
+// invoiceAccount is an account on the other side of receivables an invoice posts to, with what the invoice
+// amounts add up to on it in receivables
+type invoiceAccount struct {
+	code       string
+	receivable float64
+	// Sources of a correction that makes receivables grow or shrink
+	increase JournalSource
+	decrease JournalSource
+}
+
+// invoiceAccounts are the amounts of the invoice the ledger has to add up to, receivables being the balance
+func invoiceAccounts(invoice *Invoice) []invoiceAccount {
+	// Nothing is owed on a draft or a voided invoice
+	sales := invoice.Amount
+	if invoice.Status == DRAFT || invoice.Status == CANCELED {
+		sales = 0
+	}
+	salesDecrease := JOURNALINVOICEADJUSTMENT
+	if invoice.Status == CANCELED {
+		salesDecrease = JOURNALINVOICEVOID
+	}
+	// Invoices are outside the scope of tax, no output tax is posted
+	return []invoiceAccount{
+		{code: ACCOUNTSALES, receivable: sales, increase: JOURNALINVOICEADJUSTMENT, decrease: salesDecrease},
+		{code: ACCOUNTLATEFEES, receivable: invoice.FeesAmount, increase: JOURNALLATEFEE, decrease: JOURNALLATEFEEWAIVED},
+		{code: ACCOUNTSALESRETURNS, receivable: -invoice.CreditedAmount, increase: JOURNALCREDITNOTE, decrease: JOURNALCREDITNOTE},
+		{code: ACCOUNTSALESDISCOUNTS, receivable: -invoice.EarlyPaymentDiscountTaken, increase: JOURNALEARLYPAYMENTDISCREV, decrease: JOURNALEARLYPAYMENTDISC},
+		{code: ACCOUNTBADDEBTS, receivable: -invoice.WrittenOffAmount, increase: JOURNALWRITEOFF, decrease: JOURNALWRITEOFF},
+		{code: ACCOUNTBANK, receivable: -NetPaid(invoice), increase: JOURNALPAYMENTREVERSAL, decrease: JOURNALPAYMENT},
+	}
+}
+
+// invoiceEvents lists what happened to the invoice, each on the day it happened: the invoice issued, every late
+// fee and waiver, credit note, payment, reversal and early payment discount, and the write-off
+func invoiceEvents(invoice *Invoice, creditNotes []CreditNote) []ledgerEvent {
+	var events []ledgerEvent
+	if invoice.Status != DRAFT && invoice.Status != CANCELED {
+		events = append(events, ledgerEvent{JOURNALINVOICE, invoice.Number(), invoice.IssueDate(), ACCOUNTSALES, invoice.Amount})
+	}
+	for _, fee := range LateFees(invoice) {
+		events = append(events, ledgerEvent{JOURNALLATEFEE, fee.FeeID.String(), fee.AppliedAt, ACCOUNTLATEFEES, fee.Amount})
+		if fee.Waived && fee.WaivedAt != nil {
+			// Fees waived before the amount was kept were waived in full
+			waived := fee.AmountWaived
+			if waived == 0 {
+				waived = fee.Amount
+			}
+			events = append(events, ledgerEvent{JOURNALLATEFEEWAIVED, fee.FeeID.String(), *fee.WaivedAt, ACCOUNTLATEFEES, -waived})
+		}
+	}
+	for _, creditNote := range creditNotes {
+		events = append(events, ledgerEvent{JOURNALCREDITNOTE, creditNote.CreditNoteNumber, creditNote.CreatedAt, ACCOUNTSALESRETURNS, -creditNote.Amount})
+	}
+	for _, payment := range Payments(invoice) {
+		reference := payment.PaymentID.String()
+		if payment.Type == REVERSAL {
+			events = append(events, ledgerEvent{JOURNALPAYMENTREVERSAL, reference, payment.DatePaid, ACCOUNTBANK, payment.AmountPaid})
+			if payment.DiscountReversed > 0 {
+				events = append(events, ledgerEvent{JOURNALEARLYPAYMENTDISCREV, reference, payment.DatePaid, ACCOUNTSALESDISCOUNTS, payment.DiscountReversed})
+			}
+			continue
+		}
+		events = append(events, ledgerEvent{JOURNALPAYMENT, reference, payment.DatePaid, ACCOUNTBANK, -payment.AmountPaid})
+		if payment.DiscountApplied > 0 {
+			events = append(events, ledgerEvent{JOURNALEARLYPAYMENTDISC, reference, payment.DatePaid, ACCOUNTSALESDISCOUNTS, -payment.DiscountApplied})
+		}
+	}
+	if invoice.WrittenOffAt != nil {
+		events = append(events, ledgerEvent{JOURNALWRITEOFF, invoice.Number(), *invoice.WrittenOffAt, ACCOUNTBADDEBTS, -invoice.WrittenOffAmount})
+	}
+	return events
+}
+
+// invoiceEntryDescriptions describe the entries posted for an invoice, by source
+var invoiceEntryDescriptions = map[JournalSource]string{
+	JOURNALINVOICE:             "Invoice %s issued",
+	JOURNALINVOICEADJUSTMENT:   "Invoice %s total changed",
+	JOURNALINVOICEVOID:         "Invoice %s voided",
+	JOURNALLATEFEE:             "Late fee on invoice %s",
+	JOURNALLATEFEEWAIVED:       "Late fee waived on invoice %s",
+	JOURNALCREDITNOTE:          "Credit note against invoice %s",
+	JOURNALEARLYPAYMENTDISC:    "Early payment discount on invoice %s",
+	JOURNALEARLYPAYMENTDISCREV: "Early payment discount on invoice %s reversed",
+	JOURNALWRITEOFF:            "Invoice %s written off",
+	JOURNALPAYMENT:             "Payment of invoice %s",
+	JOURNALPAYMENTREVERSAL:     "Payment of invoice %s reversed",
+}
+
+// entry is the journal entry of the event on the invoice
+func (event ledgerEvent) entry(invoice *Invoice) JournalEntry {
+	entry := JournalEntry{
+		Date:        event.date,
+		Source:      event.source,
+		Reference:   event.reference,
+		Description: fmt.Sprintf(invoiceEntryDescriptions[event.source], invoice.Number()),
+		Currency:    InvoiceCurrency(),
+		InvoiceID:   &invoice.InvoiceID,
+	}
+	amount := math.Abs(event.receivable)
+	if event.receivable > 0 {
+		entry.Lines = []JournalLine{debit(ACCOUNTRECEIVABLE, amount), credit(event.account, amount)}
+	} else {
+		entry.Lines = []JournalLine{debit(event.account, amount), credit(ACCOUNTRECEIVABLE, amount)}
+	}
+	return entry
+}
+
+// receivableBalance is what the entries add up to in receivables
+func receivableBalance(entries []JournalEntry) float64 {
+	var balance float64
+	for _, entry := range entries {
+		for _, line := range entry.Lines {
+			if line.Account == ACCOUNTRECEIVABLE {
+				balance += line.Debit - line.Credit
+			}
+		}
+	}
+	return roundMoney(balance)
+}
+
+// invoiceEntries works out the entries that bring the ledger of the invoice up to date with the entries already
+// posted: one per event not posted yet, dated when it happened, oldest first. When the events of an account do not
+// add up to its amount (ledgers posted before every event had its own entry, totals changed before the invoice
+// went out), the difference is posted as a correction dated now instead.
+func invoiceEntries(invoice *Invoice, creditNotes []CreditNote, posted []JournalEntry, now time.Time) []JournalEntry {
+	postedKeys := map[string]bool{}
+	postedReceivable := map[string]float64{}
+	for _, entry := range posted {
+		postedKeys[string(entry.Source)+"/"+entry.Reference] = true
+		for _, line := range entry.Lines {
+			postedReceivable[line.Account] += line.Credit - line.Debit
+		}
+	}
+	events := invoiceEvents(invoice, creditNotes)
+	posts := func(event ledgerEvent) bool {
+		return roundMoney(event.receivable) != 0 && !postedKeys[string(event.source)+"/"+event.reference]
+	}
+
+	// The accounts whose missing events add up to what is missing on them
+	complete := map[string]bool{}
+	var corrections []JournalEntry
+	for _, account := range invoiceAccounts(invoice) {
+		var missingTotal float64
+		for _, event := range events {
+			if event.account == account.code && posts(event) {
+				missingTotal += roundMoney(event.receivable)
+			}
+		}
+		change := roundMoney(account.receivable - postedReceivable[account.code])
+		if roundMoney(missingTotal) == change {
+			complete[account.code] = true
+			continue
+		}
+		if change == 0 {
+			continue
+		}
+		correction := ledgerEvent{account.increase, invoice.Number(), now, account.code, change}
+		if change < 0 {
+			correction.source = account.decrease
+		}
+		corrections = append(corrections, correction.entry(invoice))
+	}
+
+	var entries []JournalEntry
+	for _, event := range events {
+		if complete[event.account] && posts(event) {
+			entries = append(entries, event.entry(invoice))
+		}
+	}
+	entries = append(entries, corrections...)
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Date.Before(entries[j].Date) })
+	return entries
+}
+
+// syncInvoiceLedger posts an entry for every event of the invoice not on the ledger yet (issued, late fees and
+// waivers, credit notes, payments, reversals, discounts and write-offs) and corrections for changed totals and
+// voids. What the customer owes is the receivable balance, the outstanding amount is set to it. It is called in
+// the transaction saving the invoice.
+func syncInvoiceLedger(tx *gorm.DB, invoice *Invoice) error {
+	var posted []JournalEntry
+	if err := tx.Preload("Lines").Where("invoice_id = ?", invoice.InvoiceID).Find(&posted).Error; err != nil {
+		return err
+	}
+	var creditNotes []CreditNote
+	if err := tx.Where("invoice_id = ?", invoice.InvoiceID).Order("id asc").Find(&creditNotes).Error; err != nil {
+		return err
+	}
+
+	entries := invoiceEntries(invoice, creditNotes, posted, time.Now())
+	for i := range entries {
+		if err := postEntry(tx, &entries[i]); err != nil {
+			return err
+		}
+	}
+	receivable := receivableBalance(append(posted, entries...))
+	if receivable == roundMoney(invoice.OutstandingAmount) {
+		return nil
+	}
+	invoice.OutstandingAmount = receivable
+	return tx.Model(invoice).UpdateColumn("outstanding_amount", receivable).Error
+}
+
+// postBill posts a bill once approved: the purchase and the tax we can reclaim against what we owe the supplier,
+// with what was prepaid before the bill came in already paid out
+func postBill(tx *gorm.DB, bill *Bill) error {
+	entry := JournalEntry{
+		Date:        bill.IssueDate,
+		Source:      JOURNALBILL,
+		Reference:   bill.SupplierBillNumber,
+		Description: "Bill " + bill.SupplierBillNumber + " approved",
+		Currency:    bill.Currency,
+		BillID:      &bill.BillID,
+		Lines: []JournalLine{
+			debit(ACCOUNTPURCHASES, bill.Amount-bill.TaxAmount),
+			debit(ACCOUNTINPUTTAX, bill.TaxAmount),
+			credit(ACCOUNTPAYABLE, bill.Amount),
+			debit(ACCOUNTPAYABLE, bill.PrepaidAmount),
+			credit(ACCOUNTBANK, bill.PrepaidAmount),
+		},
+	}
+	return postEntry(tx, &entry)
+}
+
+// reverseBill takes an approved bill that is deleted off the ledger again
+func reverseBill(tx *gorm.DB, bill *Bill) error {
+	entry := JournalEntry{
+		Date:        time.Now(),
+		Source:      JOURNALBILLDELETED,
+		Reference:   bill.SupplierBillNumber,
+		Description: "Bill " + bill.SupplierBillNumber + " deleted",
+		Currency:    bill.Currency,
+		BillID:      &bill.BillID,
+		Lines: []JournalLine{
+			credit(ACCOUNTPURCHASES, bill.Amount-bill.TaxAmount),
+			credit(ACCOUNTINPUTTAX, bill.TaxAmount),
+			debit(ACCOUNTPAYABLE, bill.Amount),
+			credit(ACCOUNTPAYABLE, bill.PrepaidAmount),
+			debit(ACCOUNTBANK, bill.PrepaidAmount),
+		},
+	}
+	return postEntry(tx, &entry)
+}
+
+// postBillPayment posts a payout of the bill
+func postBillPayment(tx *gorm.DB, bill *Bill, payment PaymentHistory) error {
+	entry := JournalEntry{
+		Date:        payment.DatePaid,
+		Source:      JOURNALBILLPAYMENT,
+		Reference:   payment.PaymentID.String(),
+		Description: "Payment of bill " + bill.SupplierBillNumber,
+		Currency:    bill.Currency,
+		BillID:      &bill.BillID,
+		Lines:       []JournalLine{debit(ACCOUNTPAYABLE, payment.AmountPaid), credit(ACCOUNTBANK, payment.AmountPaid)},
+	}
+	return postEntry(tx, &entry)
+}
+
+// GetJournalEntries lists the journal, newest first
+func GetJournalEntries(params JournalQueryParams) ([]JournalEntry, error) {
+	entries := []JournalEntry{}
+	query := db.Preload("Lines", func(tx *gorm.DB) *gorm.DB { return tx.Order("id asc") })
+	if params.InvoiceID != nil {
+		query = query.Where("invoice_id = ?", *params.InvoiceID)
+	}
+	if params.BillID != nil {
+		query = query.Where("bill_id = ?", *params.BillID)
+	}
+	if params.Source != "" {
+		query = query.Where("source = ?", params.Source)
+	}
+	err := query.Limit(params.Limit).Offset(params.Offset).Order("date desc, id desc").Find(&entries).Error
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// GetInvoiceLedger returns the entries of the invoice, oldest first, with the receivable balance they add up to
+func GetInvoiceLedger(invoice *Invoice) (*InvoiceLedger, error) {
+	ledger := InvoiceLedger{
+		InvoiceID: invoice.InvoiceID,
+		Currency:  InvoiceCurrency(),
+		Entries:   []JournalEntry{},
+	}
+	err := db.Preload("Lines", func(tx *gorm.DB) *gorm.DB { return tx.Order("id asc") }).
+		Where("invoice_id = ?", invoice.InvoiceID).
+		Order("id asc").
+		Find(&ledger.Entries).Error
+	if err != nil {
+		return nil, err
+	}
+	ledger.ReceivableBalance = receivableBalance(ledger.Entries)
+	return &ledger, nil
+}
+
+// receivableBalances is a query of the receivable balance of each invoice on the ledger
+func receivableBalances() *gorm.DB {
+	return db.Table("journal_lines").
+		Select("journal_entries.invoice_id, SUM(journal_lines.debit - journal_lines.credit) as balance").
+		Joins("JOIN journal_entries ON journal_entries.entry_id = journal_lines.entry_id").
+		Where("journal_lines.account = ? AND journal_entries.invoice_id IS NOT NULL", ACCOUNTRECEIVABLE).
+		Group("journal_entries.invoice_id")
+}
+
+// GetTrialBalance sums the debits and credits of every account up to the date, per currency
+func GetTrialBalance(asOf time.Time) ([]TrialBalance, error) {
+	var rows []struct {
+		Currency string
+		Account  string
+		Debit    float64
+		Credit   float64
+	}
+	err := db.Table("journal_lines").
+		Select("journal_entries.currency, journal_lines.account, SUM(journal_lines.debit) as debit, SUM(journal_lines.credit) as credit").
+		Joins("JOIN journal_entries ON journal_entries.entry_id = journal_lines.entry_id").
+		Where("journal_entries.date <= ?", asOf).
+		Group("journal_entries.currency, journal_lines.account").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	byCurrency := map[string]map[string]float64{}
+	for _, row := range rows {
+		if byCurrency[row.Currency] == nil {
+			byCurrency[row.Currency] = map[string]float64{}
+		}
+		byCurrency[row.Currency][row.Account] += row.Debit - row.Credit
+	}
+	trialBalances := []TrialBalance{}
+	for currency, balances := range byCurrency {
+		trialBalance := TrialBalance{Currency: currency, AsOf: asOf, Accounts: []TrialBalanceAccount{}}
+		for _, account := range ChartOfAccounts {
+			balance := roundMoney(balances[account.Code])
+			if balance == 0 {
+				continue
+			}
+			line := TrialBalanceAccount{Account: account}
+			if balance > 0 {
+				line.Debit = balance
+			} else {
+				line.Credit = -balance
+			}
+			trialBalance.Accounts = append(trialBalance.Accounts, line)
+			trialBalance.TotalDebit += line.Debit
+			trialBalance.TotalCredit += line.Credit
+		}
+		trialBalance.TotalDebit = roundMoney(trialBalance.TotalDebit)
+		trialBalance.TotalCredit = roundMoney(trialBalance.TotalCredit)
+		trialBalance.Balanced = trialBalance.TotalDebit == trialBalance.TotalCredit
+		trialBalances = append(trialBalances, trialBalance)
+	}
+	sort.Slice(trialBalances, func(i, j int) bool { return trialBalances[i].Currency < trialBalances[j].Currency })
+	return trialBalances, nil
+}
+
+// PostMissingLedgerEntries brings invoices and approved bills from before the ledger onto it
+func PostMissingLedgerEntries() error {
+	var invoiceIDs []uuid.UUID
+	err := db.Model(&Invoice{}).
+		Where("status NOT IN ?", []Status{DRAFT, CANCELED}).
+		Where("NOT EXISTS (SELECT 1 FROM journal_entries WHERE journal_entries.invoice_id = invoices.invoice_id)").
+		Pluck("invoice_id", &invoiceIDs).Error
+	if err != nil {
+		return err
+	}
+	for _, invoiceID := range invoiceIDs {
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var invoice Invoice
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("invoice_id = ?", invoiceID).First(&invoice).Error; err != nil {
+				return err
+			}
+			return syncInvoiceLedger(tx, &invoice)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var bills []Bill
+	err = db.Where("status IN ?", []BillStatus{BILLAPPROVED, BILLPARTIALPAYMENT, BILLPAID}).
+		Where("NOT EXISTS (SELECT 1 FROM journal_entries WHERE journal_entries.bill_id = bills.bill_id)").
+		Find(&bills).Error
+	if err != nil {
+		return err
+	}
+	for i := range bills {
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := postBill(tx, &bills[i]); err != nil {
+				return err
+			}
+			for _, payment := range BillPayments(&bills[i]) {
+				if err := postBillPayment(tx, &bills[i], payment); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
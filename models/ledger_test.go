@@ -0,0 +1,226 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+// postedEntry is an entry as the tests compare it: what it posts to receivables, for which event, on which day
+type postedEntry struct {
+	source     JournalSource
+	reference  string
+	date       time.Time
+	receivable float64
+}
+
+func summarize(entries []JournalEntry) []postedEntry {
+	var summary []postedEntry
+	for _, entry := range entries {
+		summary = append(summary, postedEntry{entry.Source, entry.Reference, entry.Date, receivableBalance([]JournalEntry{entry})})
+	}
+	return summary
+}
+
+func TestInvoiceEntries(t *testing.T) {
+	number := "INV-000007"
+	issued := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	firstPaid := time.Date(2026, 2, 10, 0, 0, 0, 0, time.UTC)
+	secondPaid := time.Date(2026, 2, 20, 0, 0, 0, 0, time.UTC)
+	reversed := time.Date(2026, 2, 25, 0, 0, 0, 0, time.UTC)
+	feeApplied := time.Date(2026, 3, 5, 0, 0, 0, 0, time.UTC)
+	feeWaived := time.Date(2026, 3, 8, 0, 0, 0, 0, time.UTC)
+	now := time.Date(2026, 4, 1, 12, 0, 0, 0, time.UTC)
+	firstPayment := uuid.MustParse("11111111-1111-1111-1111-111111111111")
+	secondPayment := uuid.MustParse("22222222-2222-2222-2222-222222222222")
+	reversal := uuid.MustParse("33333333-3333-3333-3333-333333333333")
+	feeID := uuid.MustParse("44444444-4444-4444-4444-444444444444")
+
+	newInvoice := func(status Status, payments []PaymentHistory, fees []LateFee) *Invoice {
+		invoice := &Invoice{
+			InvoiceID:     uuid.MustParse("99999999-9999-9999-9999-999999999999"),
+			InvoiceNumber: &number,
+			IssuedAt:      &issued,
+			Status:        status,
+			Amount:        1000,
+		}
+		invoice.PaymentHistory, _ = json.Marshal(payments)
+		invoice.LateFees, _ = json.Marshal(fees)
+		for _, fee := range fees {
+			invoice.FeesAmount += fee.Amount - fee.AmountWaived
+		}
+		for _, payment := range payments {
+			invoice.EarlyPaymentDiscountTaken += payment.DiscountApplied - payment.DiscountReversed
+		}
+		RecalculateOutstanding(invoice)
+		return invoice
+	}
+	twoPayments := []PaymentHistory{
+		{PaymentID: firstPayment, Type: PAYMENT, AmountPaid: 300, DatePaid: firstPaid},
+		{PaymentID: secondPayment, Type: PAYMENT, AmountPaid: 200, DatePaid: secondPaid},
+	}
+	issuedEntry := postedEntry{JOURNALINVOICE, number, issued, 1000}
+	posted := func(invoice *Invoice, entries ...postedEntry) []JournalEntry {
+		var journal []JournalEntry
+		for _, entry := range entries {
+			account := map[JournalSource]string{
+				JOURNALINVOICE: ACCOUNTSALES, JOURNALPAYMENT: ACCOUNTBANK, JOURNALLATEFEE: ACCOUNTLATEFEES,
+				JOURNALEARLYPAYMENTDISC: ACCOUNTSALESDISCOUNTS,
+			}[entry.source]
+			journal = append(journal, ledgerEvent{entry.source, entry.reference, entry.date, account, entry.receivable}.entry(invoice))
+		}
+		return journal
+	}
+
+	tests := []struct {
+		name        string
+		invoice     *Invoice
+		creditNotes []CreditNote
+		posted      []postedEntry
+		expected    []postedEntry
+	}{
+		{
+			name:    "draft posts nothing",
+			invoice: newInvoice(DRAFT, nil, nil),
+		},
+		{
+			name:     "issued",
+			invoice:  newInvoice(SENT, nil, nil),
+			expected: []postedEntry{issuedEntry},
+		},
+		{
+			name:     "nothing new",
+			invoice:  newInvoice(SENT, nil, nil),
+			posted:   []postedEntry{issuedEntry},
+			expected: nil,
+		},
+		{
+			name:    "invoice from before the ledger, each payment on its day",
+			invoice: newInvoice(PARTIALPAYMENT, twoPayments, nil),
+			expected: []postedEntry{
+				issuedEntry,
+				{JOURNALPAYMENT, firstPayment.String(), firstPaid, -300},
+				{JOURNALPAYMENT, secondPayment.String(), secondPaid, -200},
+			},
+		},
+		{
+			name:     "second payment",
+			invoice:  newInvoice(PARTIALPAYMENT, twoPayments, nil),
+			posted:   []postedEntry{issuedEntry, {JOURNALPAYMENT, firstPayment.String(), firstPaid, -300}},
+			expected: []postedEntry{{JOURNALPAYMENT, secondPayment.String(), secondPaid, -200}},
+		},
+		{
+			name: "reversal with the discount it takes back",
+			invoice: newInvoice(SENT, []PaymentHistory{
+				{PaymentID: firstPayment, Type: PAYMENT, AmountPaid: 950, DatePaid: firstPaid, DiscountApplied: 50, AmountReversed: 950},
+				{PaymentID: reversal, Type: REVERSAL, AmountPaid: 950, DatePaid: reversed, ReversalOf: &firstPayment, DiscountReversed: 50},
+			}, nil),
+			posted: []postedEntry{
+				issuedEntry,
+				{JOURNALPAYMENT, firstPayment.String(), firstPaid, -950},
+				{JOURNALEARLYPAYMENTDISC, firstPayment.String(), firstPaid, -50},
+			},
+			expected: []postedEntry{
+				{JOURNALPAYMENTREVERSAL, reversal.String(), reversed, 950},
+				{JOURNALEARLYPAYMENTDISCREV, reversal.String(), reversed, 50},
+			},
+		},
+		{
+			name: "late fee waived",
+			invoice: newInvoice(OVERDUE, nil, []LateFee{
+				{FeeID: feeID, Amount: 25, AppliedAt: feeApplied, Waived: true, WaivedAt: &feeWaived, AmountWaived: 25},
+			}),
+			posted:   []postedEntry{issuedEntry, {JOURNALLATEFEE, feeID.String(), feeApplied, 25}},
+			expected: []postedEntry{{JOURNALLATEFEEWAIVED, feeID.String(), feeWaived, -25}},
+		},
+		{
+			name: "credit note",
+			invoice: func() *Invoice {
+				invoice := newInvoice(SENT, nil, nil)
+				invoice.CreditedAmount = 100
+				RecalculateOutstanding(invoice)
+				return invoice
+			}(),
+			creditNotes: []CreditNote{{CreditNoteNumber: "CN-000003", Amount: 100, Model: gorm.Model{CreatedAt: secondPaid}}},
+			posted:      []postedEntry{issuedEntry},
+			expected:    []postedEntry{{JOURNALCREDITNOTE, "CN-000003", secondPaid, -100}},
+		},
+		{
+			name: "credit without a credit note is corrected",
+			invoice: func() *Invoice {
+				invoice := newInvoice(SENT, nil, nil)
+				invoice.CreditedAmount = 100
+				RecalculateOutstanding(invoice)
+				return invoice
+			}(),
+			posted:   []postedEntry{issuedEntry},
+			expected: []postedEntry{{JOURNALCREDITNOTE, number, now, -100}},
+		},
+		{
+			name: "total changed",
+			invoice: func() *Invoice {
+				invoice := newInvoice(CREATED, nil, nil)
+				invoice.Amount = 1200
+				RecalculateOutstanding(invoice)
+				return invoice
+			}(),
+			posted:   []postedEntry{issuedEntry},
+			expected: []postedEntry{{JOURNALINVOICEADJUSTMENT, number, now, 200}},
+		},
+		{
+			name: "written off after a payment",
+			invoice: func() *Invoice {
+				invoice := newInvoice(WRITTENOFF, twoPayments[:1], nil)
+				invoice.WrittenOffAmount = 700
+				invoice.WrittenOffAt = &reversed
+				RecalculateOutstanding(invoice)
+				return invoice
+			}(),
+			posted:   []postedEntry{issuedEntry, {JOURNALPAYMENT, firstPayment.String(), firstPaid, -300}},
+			expected: []postedEntry{{JOURNALWRITEOFF, number, reversed, -700}},
+		},
+		{
+			name:     "voided",
+			invoice:  newInvoice(CANCELED, nil, nil),
+			posted:   []postedEntry{issuedEntry},
+			expected: []postedEntry{{JOURNALINVOICEVOID, number, now, -1000}},
+		},
+		{
+			name:    "payments posted together before each had an entry are left as they are",
+			invoice: newInvoice(PARTIALPAYMENT, twoPayments, nil),
+			posted:  []postedEntry{issuedEntry, {JOURNALPAYMENT, secondPayment.String(), secondPaid, -500}},
+		},
+		{
+			name:     "payment missing next to payments posted together is corrected",
+			invoice:  newInvoice(PARTIALPAYMENT, twoPayments, nil),
+			posted:   []postedEntry{issuedEntry, {JOURNALPAYMENT, number, firstPaid, -300}},
+			expected: []postedEntry{{JOURNALPAYMENT, number, now, -200}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			journal := posted(test.invoice, test.posted...)
+			entries := invoiceEntries(test.invoice, test.creditNotes, journal, now)
+			got := summarize(entries)
+			if len(got) != len(test.expected) {
+				t.Fatalf("expected %d entries %v, got %v", len(test.expected), test.expected, got)
+			}
+			for i := range got {
+				if got[i] != test.expected[i] {
+					t.Errorf("entry %d: expected %v, got %v", i, test.expected[i], got[i])
+				}
+			}
+			for _, entry := range entries {
+				if len(entry.Lines) != 2 || entry.Lines[0].Debit != entry.Lines[1].Credit {
+					t.Errorf("entry %s %s does not balance: %v", entry.Source, entry.Reference, entry.Lines)
+				}
+			}
+			// Receivables on the ledger add up to what the customer owes
+			if balance := receivableBalance(append(journal, entries...)); balance != roundMoney(test.invoice.OutstandingAmount) {
+				t.Errorf("receivables are %.2f, the invoice has %.2f outstanding", balance, test.invoice.OutstandingAmount)
+			}
+		})
+	}
+}
@@ -1,13 +1,20 @@
 package models
 
 import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"github.com/google/uuid"
 	_ "github.com/lib/pq"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"log"
+	"math"
 	"os"
+	"reflect"
 	"time"
 )
 
@@ -16,6 +23,11 @@ var db *gorm.DB
 type InvoiceQueryParams struct {
 	Limit  int
 	Offset int
+	// Optional filters
+	Statuses   []Status
+	Customer   string // Customer email
+	IssuedFrom *time.Time
+	IssuedTo   *time.Time // Inclusive
 }
 
 type InvoiceDashboard struct {
@@ -27,6 +39,25 @@ type InvoiceDashboard struct {
 	TotalDraftCount   int     `json:"total_draft_count"`
 	TotalUnpaid       float64 `json:"total_unpaid"`
 	TotalUnpaidCount  int     `json:"total_unpaid_count"`
+	TotalCredited     float64 `json:"total_credited"`
+	TotalCreditCount  int     `json:"total_credit_count"`
+	// Money we owe on supplier bills, per currency
+	Payables []PayablesDashboard `json:"payables"`
+}
+
+// PayablesDashboard sums up the supplier bills of one currency
+type PayablesDashboard struct {
+	Currency                   string  `json:"currency"`
+	TotalAwaitingApproval      float64 `json:"total_awaiting_approval"`
+	TotalAwaitingApprovalCount int     `json:"total_awaiting_approval_count"`
+	TotalPayable               float64 `json:"total_payable"` // Approved and still owed
+	TotalPayableCount          int     `json:"total_payable_count"`
+	TotalOverdue               float64 `json:"total_overdue"`
+	TotalOverdueCount          int     `json:"total_overdue_count"`
+	TotalDueSoon               float64 `json:"total_due_soon"` // Due within PayablesDueSoonDays
+	TotalDueSoonCount          int     `json:"total_due_soon_count"`
+	TotalPaid                  float64 `json:"total_paid"`
+	TotalPaidCount             int     `json:"total_paid_count"`
 }
 
 // Status
@@ -39,6 +70,32 @@ const (
 	PARTIALPAYMENT Status = "PARTIAL_PAYMENT"
 	FULLPAYMENT    Status = "FULL_PAYMENT"
 	CANCELED       Status = "CANCELED"
+	CREDITED       Status = "CREDITED"
+	OVERDUE        Status = "OVERDUE" // Set and cleared by the overdue job
+	// Issued invoices matching an approval rule wait here until approved
+	PENDINGAPPROVAL Status = "PENDING_APPROVAL"
+	// What is left unpaid was given up on as a bad debt
+	WRITTENOFF Status = "WRITTEN_OFF"
+	// History only actions
+	CREDITNOTEISSUED      Status = "CREDIT_NOTE_ISSUED"
+	PAYMENTREVERSED       Status = "PAYMENT_REVERSED"
+	SHARED                Status = "SHARED"
+	SHAREREVOKED          Status = "SHARE_REVOKED"
+	VIEWED                Status = "VIEWED"
+	LATEFEEAPPLIED        Status = "LATE_FEE_APPLIED"
+	LATEFEEWAIVED         Status = "LATE_FEE_WAIVED"
+	EARLYPAYMENTDISCOUNT  Status = "EARLY_PAYMENT_DISCOUNT"
+	INSTALLMENTSSCHEDULED Status = "INSTALLMENTS_SCHEDULED"
+	INSTALLMENTMISSED     Status = "INSTALLMENT_OVERDUE"
+	DELETED               Status = "DELETED"
+	RESTORED              Status = "RESTORED"
+	APPROVED              Status = "APPROVED"
+	REJECTED              Status = "REJECTED"
+	IMPORTED              Status = "IMPORTED"
+	DUNNINGSTEP           Status = "DUNNING_STEP"
+	HANDEDOVER            Status = "HANDED_OVER"
+	DISPUTED              Status = "DISPUTED"
+	DISPUTERESOLVED       Status = "DISPUTE_RESOLVED"
 )
 
 // REMINDER
@@ -60,14 +117,25 @@ type Item struct {
 }
 
 type PaymentHistory struct {
-	AmountPaid    float64   `json:"amount_paid"`
-	AmountBalance float64   `json:"amount_balance"`
-	DatePaid      time.Time `json:"date_paid"`
+	PaymentID      uuid.UUID   `json:"payment_id"`
+	Type           PaymentType `json:"type"`
+	AmountPaid     float64     `json:"amount_paid"` // For a REVERSAL this is the amount given back
+	AmountBalance  float64     `json:"amount_balance"`
+	DatePaid       time.Time   `json:"date_paid"`
+	AmountReversed float64     `json:"amount_reversed,omitempty"` // How much of a PAYMENT has been reversed so far
+	ReversalOf     *uuid.UUID  `json:"reversal_of,omitempty"`     // The PAYMENT a REVERSAL undoes
+	Reason         string      `json:"reason,omitempty"`
+	// Early payment discount the PAYMENT settled the invoice with
+	DiscountApplied float64 `json:"discount_applied,omitempty"`
+	// Discount of the reversed PAYMENT the REVERSAL takes back
+	DiscountReversed float64 `json:"discount_reversed,omitempty"`
 }
 
 type InvoiceHistory struct {
 	Action     Status    `json:"action"`
 	ActionDate time.Time `json:"action_date"`
+	Reference  string    `json:"reference,omitempty"` // e.g. the credit note number behind the action
+	Note       string    `json:"note,omitempty"`
 }
 
 type CustomerInfo struct {
@@ -80,7 +148,8 @@ type CustomerInfo struct {
 type Invoice struct {
 	gorm.Model
 	InvoiceID          uuid.UUID       `gorm:"type:uuid;uniqueIndex;not null" json:"invoice_id"` // UUID as primary identifier
-	DueDate            time.Time       `gorm:"not null" json:"due_date"`                         // Compulsory (cannot be null)
+	InvoiceNumber      *string         `gorm:"uniqueIndex" json:"invoice_number"`                // Assigned when the invoice is issued, eg: INV-000001
+	DueDate            *time.Time      `json:"due_date"`                                         // Compulsory once issued, drafts can leave it out
 	Description        string          `json:"description"`                                      // Optional description (can be null)
 	Amount             float64         `gorm:"not null" json:"amount"`                           // Compulsory
 	Status             Status          `gorm:"not null" json:"status"`                           // Compulsory
@@ -96,6 +165,106 @@ type Invoice struct {
 	IsSettled          bool            `gorm:"default:false" json:"is_settled"`
 	IsShared           bool            `gorm:"default:false" json:"is_shared"`
 	CustomerInfo       json.RawMessage `gorm:"type:jsonb;default:'{}'; not null" json:"customer_info"`
+	CreditedAmount     float64         `gorm:"default:0" json:"credited_amount"` // Sum of credit notes issued against the invoice
+	PaymentTermsDays   int             `gorm:"default:0" json:"payment_terms_days"`
+	RecurringInvoiceID *uuid.UUID      `gorm:"type:uuid;index" json:"recurring_invoice_id"` // Set when generated from a recurring invoice
+	SentAt             *time.Time      `json:"sent_at"`                                     // Last time the invoice was sent to the customer
+	QuoteID            *uuid.UUID      `gorm:"type:uuid;index" json:"quote_id"`             // Set when converted from a quote
+	ShareToken         *string         `gorm:"uniqueIndex" json:"share_token"`              // Public link token, only while IsShared
+	ShareExpiresAt     *time.Time      `json:"share_expires_at"`                            // Optional, the public link stops working after it
+	LateFees           json.RawMessage `gorm:"type:jsonb;default:'[]';not null" json:"late_fees"`
+	FeesAmount         float64         `gorm:"default:0" json:"fees_amount"` // Late fees charged and not waived, part of the outstanding amount
+	// Early payment discount terms, eg: 2/10 is 2 percent off when settled within 10 days of issue
+	EarlyPaymentDiscountPercentage float64    `gorm:"default:0" json:"early_payment_discount_percentage"`
+	EarlyPaymentDiscountDays       int        `gorm:"default:0" json:"early_payment_discount_days"`
+	EarlyPaymentDeadline           *time.Time `json:"early_payment_deadline"`
+	EarlyPaymentDiscountTaken      float64    `gorm:"default:0" json:"early_payment_discount_taken"`
+	// Installment schedule, payments go to installments in order
+	Installments     json.RawMessage `gorm:"type:jsonb;default:'[]';not null" json:"installments"`
+	HasInstallments  bool            `gorm:"default:false;index" json:"has_installments"`
+	IssuedAt         *time.Time      `json:"issued_at"` // When the invoice stopped being a draft
+	VoidedAt         *time.Time      `json:"voided_at"`
+	VoidReason       string          `json:"void_reason"`
+	DuplicatedFrom   *uuid.UUID      `gorm:"type:uuid;index" json:"duplicated_from"` // Set when copied from another invoice
+	ApprovedAt       *time.Time      `json:"approved_at"`
+	ApprovedBy       int             `gorm:"default:0" json:"approved_by"`
+	ApprovedAmount   float64         `gorm:"default:0" json:"approved_amount"`    // Total when approved, going above it needs a new approval
+	ApprovedCustomer string          `json:"approved_customer"`                   // Customer email when approved, invoicing someone else needs a new approval
+	WrittenOffAmount float64         `gorm:"default:0" json:"written_off_amount"` // Unpaid balance given up on as a bad debt
+	WrittenOffAt     *time.Time      `json:"written_off_at"`
+}
+
+// IssueDate is when the invoice was issued, the creation date for invoices from before drafts existed
+func (invoice *Invoice) IssueDate() time.Time {
+	if invoice.IssuedAt != nil {
+		return *invoice.IssuedAt
+	}
+	return invoice.CreatedAt
+}
+
+// PaymentTerms is the number of days the customer has to pay: the payment terms when set, otherwise the days
+// from issue to due date
+func (invoice *Invoice) PaymentTerms() int {
+	if invoice.PaymentTermsDays > 0 || invoice.DueDate == nil {
+		return invoice.PaymentTermsDays
+	}
+	issued := invoice.IssueDate()
+	issueDay := time.Date(issued.Year(), issued.Month(), issued.Day(), 0, 0, 0, 0, invoice.DueDate.Location())
+	return int(math.Round(invoice.DueDate.Sub(issueDay).Hours() / 24))
+}
+
+// Duplicate makes a new draft with the customer, items, discount, notes, reminders and terms of the invoice.
+// The due date is the same number of days away as on the original.
+func (invoice *Invoice) Duplicate(now time.Time) Invoice {
+	sourceID := invoice.InvoiceID
+	terms := invoice.PaymentTerms()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	dueDate := today.AddDate(0, 0, terms)
+	invoiceHistoryJSON, _ := json.Marshal([]InvoiceHistory{{
+		Action:     DRAFT,
+		ActionDate: now,
+		Reference:  invoice.Number(),
+		Note:       "Duplicated",
+	}})
+
+	duplicate := Invoice{
+		InvoiceID:          uuid.New(),
+		DueDate:            &dueDate,
+		Description:        invoice.Description,
+		Amount:             invoice.Amount,
+		Status:             DRAFT,
+		InvoiceHistory:     invoiceHistoryJSON,
+		CreatedBy:          invoice.CreatedBy,
+		Items:              invoice.Items,
+		Reminders:          invoice.Reminders,
+		IsDiscount:         invoice.IsDiscount,
+		DiscountPercentage: invoice.DiscountPercentage,
+		Note:               invoice.Note,
+		CustomerInfo:       invoice.CustomerInfo,
+		PaymentTermsDays:   invoice.PaymentTermsDays,
+		DuplicatedFrom:     &sourceID,
+	}
+	if invoice.EarlyPaymentDiscountPercentage > 0 {
+		SetEarlyPaymentTerms(&duplicate, &EarlyPaymentTerms{
+			Percentage: invoice.EarlyPaymentDiscountPercentage,
+			Days:       invoice.EarlyPaymentDiscountDays,
+		}, now)
+	}
+	return duplicate
+}
+
+// Number is the invoice number, or the InvoiceID while the invoice is a draft
+func (invoice *Invoice) Number() string {
+	if invoice.InvoiceNumber != nil {
+		return *invoice.InvoiceNumber
+	}
+	return invoice.InvoiceID.String()
+}
+
+// DocumentSequence keeps the last number handed out per document kind (credit notes, ...)
+type DocumentSequence struct {
+	Kind      string `gorm:"primaryKey"`
+	LastValue int    `gorm:"not null;default:0"`
 }
 
 func Init() (*gorm.DB, error) {
@@ -103,16 +272,65 @@ func Init() (*gorm.DB, error) {
 	//POSTGRESQL DSN
 	postgresDsn := os.Getenv("POSTGRES_DSN")
 	log.Println(postgresDsn)
-	db, err = gorm.Open(postgres.Open(postgresDsn), &gorm.Config{})
+	db, err = gorm.Open(postgres.Open(postgresDsn), &gorm.Config{TranslateError: true})
 	log.Println(db, err)
 	if err != nil {
 		return nil, err
 	}
 
-	db.AutoMigrate(&Invoice{})
+	db.AutoMigrate(&Invoice{}, &DocumentSequence{}, &CreditNote{}, &RecurringInvoice{}, &Quote{}, &LateFeePolicy{}, &InvoiceRevision{}, &ApprovalRule{}, &Bill{}, &BillAttachment{}, &JournalEntry{}, &JournalLine{},
+		&AccountMapping{}, &AccountingExport{}, &ExportedItem{}, &DunningSequence{}, &InvoiceDunning{})
+	if err = db.Exec(billSupplierNumberIndex).Error; err != nil {
+		log.Println("Error creating the bill supplier number index:", err)
+	}
+	if err = backfillPaymentIDs(); err != nil {
+		log.Println("Error giving payments an id:", err)
+	}
 	return db, nil
 }
 
+// CalculateItemsTotal sums quantity * unit price and applies the discount if any
+func CalculateItemsTotal(items []Item, isDiscount bool, discountPercentage float64) float64 {
+	var totalAmount float64
+	for _, item := range items {
+		totalAmount += float64(item.Quantity) * item.UnitPrice
+	}
+	if isDiscount {
+		totalAmount -= ((totalAmount * discountPercentage) / 100)
+	}
+	return totalAmount
+}
+
+// AppendInvoiceHistory adds an entry to the invoice history JSON
+func AppendInvoiceHistory(invoice *Invoice, entry InvoiceHistory) {
+	var existingHistory []InvoiceHistory
+	_ = json.Unmarshal(invoice.InvoiceHistory, &existingHistory)
+
+	existingHistory = append(existingHistory, entry)
+
+	invoice.InvoiceHistory, _ = json.Marshal(existingHistory)
+}
+
+// nextDocumentNumber hands out the next number for a document kind, eg: CN-000001.
+// It must run inside a transaction so concurrent callers do not get the same number.
+func nextDocumentNumber(tx *gorm.DB, kind string, prefix string) (string, error) {
+	sequence := DocumentSequence{Kind: kind}
+	err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&sequence).Error
+	if err != nil {
+		return "", err
+	}
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("kind = ?", kind).First(&sequence).Error
+	if err != nil {
+		return "", err
+	}
+	sequence.LastValue++
+	err = tx.Model(&sequence).Update("last_value", sequence.LastValue).Error
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%06d", prefix, sequence.LastValue), nil
+}
+
 // GetInvoiceByID retrieves an invoice from the database by ID
 func GetInvoiceByID(id string) (*Invoice, error) {
 	var invoice Invoice
@@ -122,11 +340,29 @@ func GetInvoiceByID(id string) (*Invoice, error) {
 	return &invoice, nil
 }
 
+// filterInvoices applies the list filters to an invoice query
+func filterInvoices(query *gorm.DB, params InvoiceQueryParams) *gorm.DB {
+	if len(params.Statuses) > 0 {
+		query = query.Where("status IN ?", params.Statuses)
+	}
+	if params.Customer != "" {
+		query = query.Where("LOWER(customer_info->>'email') = LOWER(?)", params.Customer)
+	}
+	// Invoices from before drafts existed have no issue date, they were issued when created
+	if params.IssuedFrom != nil {
+		query = query.Where("COALESCE(issued_at, created_at) >= ?", *params.IssuedFrom)
+	}
+	if params.IssuedTo != nil {
+		query = query.Where("COALESCE(issued_at, created_at) < ?", params.IssuedTo.AddDate(0, 0, 1))
+	}
+	return query
+}
+
 // GETInvoices from the Database by ID
 func GetInvoices(params InvoiceQueryParams) ([]Invoice, error) {
 
 	var invoices []Invoice
-	err := db.Limit(params.Limit).Offset(params.Offset).Order("created_at desc").Find(&invoices).Error
+	err := filterInvoices(db, params).Limit(params.Limit).Offset(params.Offset).Order("created_at desc").Find(&invoices).Error
 
 	if err != nil {
 		return nil, err
@@ -134,27 +370,216 @@ func GetInvoices(params InvoiceQueryParams) ([]Invoice, error) {
 	return invoices, nil
 }
 
-func CreateInvoice(invoice Invoice) error {
+// EachInvoice hands the invoices of the list, in the same order, to fn one at a time as they are read from
+// the database so exports don't hold every invoice in memory. A negative limit is no limit.
+func EachInvoice(params InvoiceQueryParams, fn func(invoice *Invoice) error) error {
+	return eachInvoice(db, params, fn)
+}
+
+// SnapshotInvoices runs fn in a read-only REPEATABLE READ transaction. Every pass fn makes over the invoices of
+// the list through each sees them as they were when the first one started, so the sheets of an export agree.
+func SnapshotInvoices(params InvoiceQueryParams, fn func(each func(fn func(invoice *Invoice) error) error) error) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		return fn(func(each func(invoice *Invoice) error) error {
+			return eachInvoice(tx, params, each)
+		})
+	}, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+}
+
+func eachInvoice(tx *gorm.DB, params InvoiceQueryParams, fn func(invoice *Invoice) error) error {
+	rows, err := filterInvoices(tx.Model(&Invoice{}), params).
+		Limit(params.Limit).Offset(params.Offset).
+		Order("created_at desc").
+		Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var invoice Invoice
+		if err = tx.ScanRows(rows, &invoice); err != nil {
+			return err
+		}
+		if err = fn(&invoice); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// CreateInvoice stores a new invoice, numbering it unless it is a draft. Invoices matching an approval rule
+// start out waiting for approval.
+func CreateInvoice(invoice *Invoice) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := assignInvoiceNumber(tx, invoice); err != nil {
+			return err
+		}
+		if _, err := requestApproval(tx, invoice); err != nil {
+			return err
+		}
+		if err := tx.Create(invoice).Error; err != nil {
+			return err
+		}
+		return syncInvoiceLedger(tx, invoice)
+	})
+}
+
+// assignInvoiceNumber gives an issued invoice the next invoice number, drafts get theirs when finalized
+func assignInvoiceNumber(tx *gorm.DB, invoice *Invoice) error {
+	if invoice.Status == DRAFT || invoice.InvoiceNumber != nil {
+		return nil
+	}
+	number, err := nextDocumentNumber(tx, "invoice", "INV")
+	if err != nil {
+		return err
+	}
+	invoice.InvoiceNumber = &number
+	if invoice.IssuedAt == nil {
+		now := time.Now()
+		invoice.IssuedAt = &now
+	}
+	return nil
+}
+
+// UpdateInvoice makes the edits on the invoice locked for the update and saves the columns they changed,
+// keeping a revision once it has been sent. An error from edit leaves the invoice as it was.
+func UpdateInvoice(invoiceID string, edit func(invoice *Invoice) error) (*Invoice, error) {
+	var invoice Invoice
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("invoice_id = ?", invoiceID).
+			First(&invoice).Error
+		if err != nil {
+			return err
+		}
+		existingInvoice := invoice
+		if err = edit(&invoice); err != nil {
+			return err
+		}
+
+		columns, err := changedColumns(tx, &existingInvoice, &invoice)
+		if err != nil || len(columns) == 0 {
+			return err
+		}
+		err = tx.Model(&invoice).Select(columns).Updates(&invoice).Error
+		if err != nil {
+			return err
+		}
+		if err = syncInvoiceLedger(tx, &invoice); err != nil {
+			return err
+		}
+		return recordRevision(tx, &invoice)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+// changedColumns lists the columns of the invoice the edit changed, zero values included
+func changedColumns(tx *gorm.DB, before *Invoice, after *Invoice) ([]string, error) {
+	statement := &gorm.Statement{DB: tx}
+	if err := statement.Parse(after); err != nil {
+		return nil, err
+	}
+	var columns []string
+	for _, field := range statement.Schema.Fields {
+		if field.DBName == "" || field.PrimaryKey || field.AutoCreateTime > 0 || field.AutoUpdateTime > 0 {
+			continue
+		}
+		old, _ := field.ValueOf(tx.Statement.Context, reflect.ValueOf(before).Elem())
+		updated, _ := field.ValueOf(tx.Statement.Context, reflect.ValueOf(after).Elem())
+		if !reflect.DeepEqual(old, updated) {
+			columns = append(columns, field.DBName)
+		}
+	}
+	return columns, nil
+}
 
-	return db.Create(&invoice).Error
+// newShareToken makes an unguessable url safe token for public links
+func newShareToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
 }
 
-// UpdateInvoice updates an existing invoice in the database
-func UpdateInvoice(invoice Invoice) error {
-	// Find the existing invoice by its unique InvoiceID
-	var existingInvoice Invoice
-	err := db.Where("invoice_id = ?", invoice.InvoiceID).First(&existingInvoice).Error
+// ShareInvoice turns sharing on with a fresh token, so sharing again invalidates any older link.
+// The caller saves the invoice.
+func ShareInvoice(invoice *Invoice, expiresAt *time.Time) error {
+	token, err := newShareToken()
 	if err != nil {
-		return err // Return the error if the invoice is not found or another error occurs
+		return err
 	}
+	invoice.IsShared = true
+	invoice.ShareToken = &token
+	invoice.ShareExpiresAt = expiresAt
+	AppendInvoiceHistory(invoice, InvoiceHistory{
+		Action:     SHARED,
+		ActionDate: time.Now(),
+	})
+	return nil
+}
+
+// RevokeInvoiceShare turns sharing off, the public link stops working straight away.
+// The caller saves the invoice.
+func RevokeInvoiceShare(invoice *Invoice) {
+	invoice.IsShared = false
+	invoice.ShareToken = nil
+	invoice.ShareExpiresAt = nil
+	AppendInvoiceHistory(invoice, InvoiceHistory{
+		Action:     SHAREREVOKED,
+		ActionDate: time.Now(),
+	})
+}
 
-	// Update the fields of the existing invoice with the new values
-	err = db.Model(&existingInvoice).Updates(invoice).Error
+// GetSharedInvoice retrieves an invoice by its public link token while sharing is on and not expired
+func GetSharedInvoice(token string) (*Invoice, error) {
+	var invoice Invoice
+	err := db.Where("share_token = ? AND is_shared = ? AND (share_expires_at IS NULL OR share_expires_at > ?)", token, true, time.Now()).
+		First(&invoice).Error
 	if err != nil {
-		return err // Return the error if the update fails
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+// RecordInvoiceView appends a VIEWED entry to the history in the database, without a read-modify-write
+// so views can't overwrite a concurrent update of the invoice
+func RecordInvoiceView(invoice *Invoice) error {
+	entry := InvoiceHistory{
+		Action:     VIEWED,
+		ActionDate: time.Now(),
 	}
+	AppendInvoiceHistory(invoice, entry)
+	entryJSON, _ := json.Marshal([]InvoiceHistory{entry})
+	return db.Model(invoice).
+		UpdateColumn("invoice_history", gorm.Expr("invoice_history || ?::jsonb", string(entryJSON))).Error
+}
 
-	return nil // Return nil if the update is successful
+// MarkInvoiceSent records that the invoice went out to the customer
+func MarkInvoiceSent(invoice *Invoice) error {
+	now := time.Now()
+	invoice.SentAt = &now
+	// Resending a paid or partly paid invoice keeps its payment status
+	if invoice.Status == CREATED || invoice.Status == DRAFT {
+		invoice.Status = SENT
+	}
+	AppendInvoiceHistory(invoice, InvoiceHistory{
+		Action:     SENT,
+		ActionDate: now,
+	})
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(invoice).
+			Select("sent_at", "status", "invoice_history").
+			Updates(invoice).Error
+		if err != nil {
+			return err
+		}
+		// The first revision is the invoice as it was first sent
+		return recordRevision(tx, invoice)
+	})
 }
 
 // GetInvoiceDashboard returns statistics for invoices (paid, overdue, draft, unpaid)
@@ -164,7 +589,7 @@ func GetInvoiceDashboard() (*InvoiceDashboard, error) {
 
 	// Query for total paid invoices
 	err := db.Model(&Invoice{}).
-		Select("SUM(amount) as TotalPaid, COUNT(*) as TotalPaidCount").
+		Select("SUM(amount) as total_paid, COUNT(*) as total_paid_count").
 		Where("status = ?", FULLPAYMENT).
 		Scan(&dashboard).Error
 	if err != nil {
@@ -172,19 +597,27 @@ func GetInvoiceDashboard() (*InvoiceDashboard, error) {
 		return nil, err
 	}
 
-	// Query for total overdue invoices
+	// Query for what is owed on overdue invoices, installment plans are only overdue for the installments missed
 	err = db.Model(&Invoice{}).
-		Select("SUM(amount) as TotalOverdue, COUNT(*) as TotalOverdueCount").
-		Where("due_date <= ? AND status != ?", now, FULLPAYMENT).
+		Select("COALESCE(SUM(receivables.balance), 0) as total_overdue, COUNT(*) as total_overdue_count").
+		Joins("LEFT JOIN (?) AS receivables ON receivables.invoice_id = invoices.invoice_id", receivableBalances()).
+		Where("status = ? AND has_installments = ?", OVERDUE, false).
 		Scan(&dashboard).Error
 	if err != nil {
 		log.Println("Error fetching overdue invoice statistics:", err)
 		return nil, err
 	}
+	missedAmount, missedCount, err := missedInstallments(now)
+	if err != nil {
+		log.Println("Error fetching missed installment statistics:", err)
+		return nil, err
+	}
+	dashboard.TotalOverdue += missedAmount
+	dashboard.TotalOverdueCount += missedCount
 
 	// Query for total draft invoices
 	err = db.Model(&Invoice{}).
-		Select("SUM(amount) as TotalDraft, COUNT(*) as TotalDraftCount").
+		Select("SUM(amount) as total_draft, COUNT(*) as total_draft_count").
 		Where("status = ?", DRAFT).
 		Scan(&dashboard).Error
 	if err != nil {
@@ -192,15 +625,32 @@ func GetInvoiceDashboard() (*InvoiceDashboard, error) {
 		return nil, err
 	}
 
-	// Query for total unpaid invoices (excluding paid)
+	// Query for what is owed on unpaid invoices (excluding drafts, paid, fully credited and voided), read from receivables
 	err = db.Model(&Invoice{}).
-		Select("SUM(amount) as TotalUnpaid, COUNT(*) as TotalUnpaidCount").
-		Where("status != ?", FULLPAYMENT).
+		Select("COALESCE(SUM(receivables.balance), 0) as total_unpaid, COUNT(*) as total_unpaid_count").
+		Joins("LEFT JOIN (?) AS receivables ON receivables.invoice_id = invoices.invoice_id", receivableBalances()).
+		Where("status NOT IN ?", []Status{DRAFT, FULLPAYMENT, CREDITED, CANCELED, WRITTENOFF}).
 		Scan(&dashboard).Error
 	if err != nil {
 		log.Println("Error fetching unpaid invoice statistics:", err)
 		return nil, err
 	}
 
+	// Query for credit notes issued
+	err = db.Model(&CreditNote{}).
+		Select("SUM(amount) as total_credited, COUNT(*) as total_credit_count").
+		Scan(&dashboard).Error
+	if err != nil {
+		log.Println("Error fetching credit note statistics:", err)
+		return nil, err
+	}
+
+	// Query for supplier bills, amounts in different currencies are never added up
+	dashboard.Payables, err = getPayablesDashboard(now)
+	if err != nil {
+		log.Println("Error fetching payables statistics:", err)
+		return nil, err
+	}
+
 	return &dashboard, nil
 }
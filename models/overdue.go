@@ -0,0 +1,122 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"numerisTask/events"
+	"time"
+)
+
+// openStatuses are the statuses of issued invoices still waiting to be paid
+var openStatuses = []Status{CREATED, SENT, PARTIALPAYMENT, OVERDUE}
+
+func isOpen(status Status) bool {
+	for _, open := range openStatuses {
+		if status == open {
+			return true
+		}
+	}
+	return false
+}
+
+// pastDue tells if the invoice is unpaid past its due date. Installment plans are past due once an installment is missed.
+func pastDue(invoice *Invoice, now time.Time) bool {
+	if invoice.OutstandingAmount <= 0 {
+		return false
+	}
+	if !invoice.HasInstallments {
+		return invoice.DueDate != nil && now.After(*invoice.DueDate)
+	}
+	for _, installment := range Installments(invoice) {
+		if now.After(installment.DueDate) && installment.AmountPaid < installment.Amount {
+			return true
+		}
+	}
+	return false
+}
+
+// RefreshOverdueStatus moves an open invoice into OVERDUE once it is past due, and back out of it when it no
+// longer is (the due date was extended), recording the change in the history. The caller saves the invoice.
+// It returns the status the invoice had and whether it changed.
+func RefreshOverdueStatus(invoice *Invoice, now time.Time) (Status, bool) {
+	previous := invoice.Status
+	if !isOpen(previous) {
+		return previous, false
+	}
+	AllocateInstallments(invoice, now)
+
+	status := previous
+	if pastDue(invoice, now) {
+		status = OVERDUE
+	} else if previous == OVERDUE {
+		if NetPaid(invoice) > 0 {
+			status = PARTIALPAYMENT
+		} else {
+			status = issuedStatus(invoice)
+		}
+	}
+	if status == previous {
+		return previous, false
+	}
+	invoice.Status = status
+	AppendInvoiceHistory(invoice, InvoiceHistory{
+		Action:     status,
+		ActionDate: now,
+	})
+	return previous, true
+}
+
+// OverdueEvent is the event for an invoice RefreshOverdueStatus moved in or out of OVERDUE
+func OverdueEvent(invoice *Invoice, previous Status) events.Event {
+	eventType := events.InvoiceOverdue
+	if invoice.Status != OVERDUE {
+		eventType = events.InvoiceOverdueCleared
+	}
+	return events.Event{
+		Type:    eventType,
+		Subject: invoice.InvoiceID.String(),
+		Data: map[string]interface{}{
+			"from":               previous,
+			"to":                 invoice.Status,
+			"due_date":           invoice.DueDate,
+			"outstanding_amount": invoice.OutstandingAmount,
+		},
+	}
+}
+
+// UpdateOverdueStatus runs RefreshOverdueStatus on an invoice and saves the change
+func UpdateOverdueStatus(invoiceID uuid.UUID, now time.Time) (*Invoice, Status, bool, error) {
+	var invoice Invoice
+	var previous Status
+	var changed bool
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("invoice_id = ?", invoiceID).
+			First(&invoice).Error
+		if err != nil {
+			return err
+		}
+		previous, changed = RefreshOverdueStatus(&invoice, now)
+		if !changed {
+			return nil
+		}
+		return tx.Model(&invoice).
+			Select("status", "installments", "invoice_history").
+			Updates(&invoice).Error
+	})
+	if err != nil {
+		return nil, "", false, err
+	}
+	return &invoice, previous, changed, nil
+}
+
+// OverdueCheckInvoiceIDs lists the open invoices whose overdue status may have to change
+func OverdueCheckInvoiceIDs(now time.Time) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := db.Model(&Invoice{}).
+		Where("outstanding_amount > 0 AND status IN ?", openStatuses).
+		Where("due_date < ? OR has_installments = ? OR status = ?", now, true, OVERDUE).
+		Pluck("invoice_id", &ids).Error
+	return ids, err
+}
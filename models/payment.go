@@ -0,0 +1,285 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+var (
+	ErrOverpayment         = errors.New("paid_amount is greater than the outstanding amount")
+	ErrPaymentNotFound     = errors.New("payment not found")
+	ErrReversalExceedsPaid = errors.New("reversal amount is greater than what is left of the payment")
+)
+
+// PaymentType tells payments and their reversals apart in the payment history
+type PaymentType string
+
+const (
+	PAYMENT  PaymentType = "PAYMENT"
+	REVERSAL PaymentType = "REVERSAL"
+)
+
+// Payments returns the payment history
+func Payments(invoice *Invoice) []PaymentHistory {
+	var payments []PaymentHistory
+	_ = json.Unmarshal(invoice.PaymentHistory, &payments)
+	return payments
+}
+
+// backfillPaymentIDs gives the payments recorded before payment ids and types existed an id and the PAYMENT
+// type, once and for good. Such payments were exported to accounting under their invoice and payment date,
+// those exports are moved over to the new id so they are not exported again.
+func backfillPaymentIDs() error {
+	var invoiceIDs []uuid.UUID
+	err := db.Unscoped().Model(&Invoice{}).
+		Where(`EXISTS (SELECT 1 FROM jsonb_array_elements(payment_history) AS payment
+			WHERE COALESCE(payment->>'payment_id', ?) = ? OR COALESCE(payment->>'type', '') = '')`, uuid.Nil.String(), uuid.Nil.String()).
+		Pluck("invoice_id", &invoiceIDs).Error
+	if err != nil {
+		return err
+	}
+	for _, invoiceID := range invoiceIDs {
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var invoice Invoice
+			err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("invoice_id = ?", invoiceID).
+				First(&invoice).Error
+			if err != nil {
+				return err
+			}
+			payments := Payments(&invoice)
+			for i := range payments {
+				if payments[i].Type == "" {
+					payments[i].Type = PAYMENT
+				}
+				if payments[i].PaymentID != uuid.Nil {
+					continue
+				}
+				payments[i].PaymentID = uuid.New()
+				exportedKey := invoice.InvoiceID.String() + "/" + payments[i].DatePaid.UTC().Format(time.RFC3339Nano)
+				err = tx.Model(&ExportedItem{}).
+					Where("item_type = ? AND item_key = ?", EXPORTEDPAYMENT, exportedKey).
+					Update("item_key", payments[i].PaymentID.String()).Error
+				if err != nil {
+					return err
+				}
+			}
+			invoice.PaymentHistory, _ = json.Marshal(payments)
+			return tx.Unscoped().Model(&invoice).UpdateColumn("payment_history", invoice.PaymentHistory).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// discountTakenBack tells whether a reversal of the payment already took back its early payment discount
+func discountTakenBack(payments []PaymentHistory, paymentID uuid.UUID) bool {
+	for _, payment := range payments {
+		if payment.Type == REVERSAL && payment.ReversalOf != nil && *payment.ReversalOf == paymentID && payment.DiscountReversed > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// NetPaid is what has been paid on the invoice minus what was reversed
+func NetPaid(invoice *Invoice) float64 {
+	var netPaid float64
+	for _, payment := range Payments(invoice) {
+		if payment.Type == PAYMENT {
+			netPaid += payment.AmountPaid
+		} else {
+			netPaid -= payment.AmountPaid
+		}
+	}
+	return netPaid
+}
+
+// RecalculateOutstanding derives the outstanding amount from the total, late fees, credits, payments,
+// early payment discount and write-off
+func RecalculateOutstanding(invoice *Invoice) {
+	// Nothing is owed on a draft or a voided invoice
+	if invoice.Status == DRAFT || invoice.Status == CANCELED {
+		invoice.OutstandingAmount = 0
+		return
+	}
+	invoice.OutstandingAmount = invoice.Amount + invoice.FeesAmount - invoice.CreditedAmount - NetPaid(invoice) -
+		invoice.EarlyPaymentDiscountTaken - invoice.WrittenOffAmount
+}
+
+// issuedStatus is the status an invoice goes back to once nothing is paid on it anymore
+func issuedStatus(invoice *Invoice) Status {
+	var existingHistory []InvoiceHistory
+	_ = json.Unmarshal(invoice.InvoiceHistory, &existingHistory)
+	for _, entry := range existingHistory {
+		if entry.Action == SENT {
+			return SENT
+		}
+	}
+	return CREATED
+}
+
+// paymentStatus works out the status from the outstanding amount and payments, recording a change in the history
+func paymentStatus(invoice *Invoice) {
+	var status Status
+	if invoice.OutstandingAmount == 0 {
+		status = FULLPAYMENT
+	} else if pastDue(invoice, time.Now()) {
+		status = OVERDUE
+	} else if NetPaid(invoice) > 0 {
+		status = PARTIALPAYMENT
+	} else {
+		status = issuedStatus(invoice)
+	}
+	invoice.IsSettled = invoice.OutstandingAmount == 0
+	if status == invoice.Status {
+		return
+	}
+	invoice.Status = status
+	AppendInvoiceHistory(invoice, InvoiceHistory{
+		Action:     status,
+		ActionDate: time.Now(),
+	})
+}
+
+// ApplyPayment records a payment on the invoice and moves it to PARTIAL_PAYMENT or FULL_PAYMENT.
+// A payment settling the invoice before the early payment deadline gets the early payment discount.
+// The caller saves the invoice.
+func ApplyPayment(invoice *Invoice, amount float64, paidAt time.Time) error {
+	if amount > invoice.OutstandingAmount {
+		return ErrOverpayment
+	}
+	var discount float64
+	if payable, ok := EarlyPaymentAmount(invoice, paidAt); ok && amount >= payable {
+		discount = roundMoney(invoice.OutstandingAmount - amount)
+	}
+
+	payments := Payments(invoice)
+	invoice.OutstandingAmount -= amount + discount
+	payments = append(payments, PaymentHistory{
+		PaymentID:       uuid.New(),
+		Type:            PAYMENT,
+		AmountPaid:      amount,
+		AmountBalance:   invoice.OutstandingAmount,
+		DatePaid:        paidAt,
+		DiscountApplied: discount,
+	})
+	invoice.PaymentHistory, _ = json.Marshal(payments)
+
+	if discount > 0 {
+		invoice.EarlyPaymentDiscountTaken += discount
+		AppendInvoiceHistory(invoice, InvoiceHistory{
+			Action:     EARLYPAYMENTDISCOUNT,
+			ActionDate: paidAt,
+			Note:       fmt.Sprintf("%.2f%% early payment discount of %.2f", invoice.EarlyPaymentDiscountPercentage, discount),
+		})
+	}
+
+	// A payment is always worth a history entry, even when the status stays PARTIAL_PAYMENT
+	action := PARTIALPAYMENT
+	if invoice.OutstandingAmount == 0 {
+		action = FULLPAYMENT
+	}
+	AllocateInstallments(invoice, paidAt)
+	invoice.Status = action
+	invoice.IsSettled = invoice.OutstandingAmount == 0
+	AppendInvoiceHistory(invoice, InvoiceHistory{
+		Action:     action,
+		ActionDate: paidAt,
+	})
+	// Paying part of what is past due leaves the invoice overdue
+	RefreshOverdueStatus(invoice, paidAt)
+	return nil
+}
+
+// ReversePayment undoes all or part of a payment (a bounced transfer, a refund) and recalculates
+// the outstanding amount and payment status
+func ReversePayment(invoiceID string, paymentID uuid.UUID, amount *float64, reason string) (*Invoice, error) {
+	var invoice Invoice
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("invoice_id = ?", invoiceID).
+			First(&invoice).Error
+		if err != nil {
+			return err
+		}
+		// The balance was given up on, taking money back does not make the customer owe it again
+		if invoice.Status == WRITTENOFF {
+			return fmt.Errorf("%w: payments of a written off invoice can not be reversed", ErrInvalidTransition)
+		}
+
+		payments := Payments(&invoice)
+		index := -1
+		for i, payment := range payments {
+			if payment.PaymentID == paymentID && payment.Type == PAYMENT {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return ErrPaymentNotFound
+		}
+
+		remaining := payments[index].AmountPaid - payments[index].AmountReversed
+		reversed := remaining
+		if amount != nil {
+			reversed = *amount
+		}
+		if reversed <= 0 || reversed > remaining {
+			return ErrReversalExceedsPaid
+		}
+
+		now := time.Now()
+		payments[index].AmountReversed += reversed
+		invoice.OutstandingAmount += reversed
+		// The payment no longer settles the invoice early, so its discount goes too, with the first reversal
+		var discountReversed float64
+		if payments[index].DiscountApplied > 0 && !discountTakenBack(payments, paymentID) {
+			discountReversed = payments[index].DiscountApplied
+			invoice.OutstandingAmount += discountReversed
+			invoice.EarlyPaymentDiscountTaken -= discountReversed
+		}
+		payments = append(payments, PaymentHistory{
+			PaymentID:        uuid.New(),
+			Type:             REVERSAL,
+			AmountPaid:       reversed,
+			AmountBalance:    invoice.OutstandingAmount,
+			DatePaid:         now,
+			ReversalOf:       &paymentID,
+			Reason:           reason,
+			DiscountReversed: discountReversed,
+		})
+		invoice.PaymentHistory, _ = json.Marshal(payments)
+
+		AppendInvoiceHistory(&invoice, InvoiceHistory{
+			Action:     PAYMENTREVERSED,
+			ActionDate: now,
+			Reference:  paymentID.String(),
+			Note:       reason,
+		})
+		AllocateInstallments(&invoice, now)
+		paymentStatus(&invoice)
+
+		err = tx.Model(&invoice).
+			Select("outstanding_amount", "early_payment_discount_taken", "payment_history", "installments", "invoice_history", "is_settled", "status").
+			Updates(&invoice).Error
+		if err != nil {
+			return err
+		}
+		if err = syncInvoiceLedger(tx, &invoice); err != nil {
+			return err
+		}
+		return recordRevision(tx, &invoice)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
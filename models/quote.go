@@ -0,0 +1,196 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+var (
+	ErrQuoteNotAccepted      = errors.New("only accepted quotes can be converted to an invoice")
+	ErrQuoteAlreadyConverted = errors.New("quote has already been converted to an invoice")
+)
+
+// QuoteStatus
+type QuoteStatus string
+
+const (
+	QUOTEDRAFT    QuoteStatus = "DRAFT"
+	QUOTESENT     QuoteStatus = "SENT"
+	QUOTEACCEPTED QuoteStatus = "ACCEPTED"
+	QUOTEDECLINED QuoteStatus = "DECLINED"
+	QUOTEEXPIRED  QuoteStatus = "EXPIRED"
+	// History only action
+	QUOTECONVERTED QuoteStatus = "CONVERTED"
+)
+
+type QuoteHistory struct {
+	Action     QuoteStatus `json:"action"`
+	ActionDate time.Time   `json:"action_date"`
+	Reference  string      `json:"reference,omitempty"` // eg: the invoice the quote was converted to
+}
+
+// QUOTE, an estimate sent before invoicing. Items and discount work as on an invoice
+type Quote struct {
+	gorm.Model
+	QuoteID            uuid.UUID       `gorm:"type:uuid;uniqueIndex;not null" json:"quote_id"`
+	QuoteNumber        string          `gorm:"uniqueIndex;not null" json:"quote_number"` // eg: QT-000001
+	ExpiryDate         time.Time       `gorm:"not null" json:"expiry_date"`
+	Description        string          `json:"description"`
+	Amount             float64         `gorm:"not null" json:"amount"`
+	Status             QuoteStatus     `gorm:"not null" json:"status"`
+	Items              json.RawMessage `gorm:"type:jsonb;default:'[]';not null" json:"items"`
+	CustomerInfo       json.RawMessage `gorm:"type:jsonb;default:'{}';not null" json:"customer_info"`
+	IsDiscount         bool            `gorm:"default:false" json:"is_discount"`
+	DiscountPercentage float64         `json:"discount_percentage"`
+	Note               string          `json:"note"`
+	QuoteHistory       json.RawMessage `gorm:"type:jsonb;default:'[]';not null" json:"quote_history"`
+	InvoiceID          *uuid.UUID      `gorm:"type:uuid" json:"invoice_id"` // Set once converted
+	CreatedBy          int             `gorm:"not null" json:"created_by"`
+}
+
+// AppendQuoteHistory adds an entry to the quote history JSON
+func AppendQuoteHistory(quote *Quote, entry QuoteHistory) {
+	var existingHistory []QuoteHistory
+	_ = json.Unmarshal(quote.QuoteHistory, &existingHistory)
+
+	existingHistory = append(existingHistory, entry)
+
+	quote.QuoteHistory, _ = json.Marshal(existingHistory)
+}
+
+// IsExpired tells whether a quote still waiting on the customer is past its expiry date
+func (q *Quote) IsExpired(now time.Time) bool {
+	return (q.Status == QUOTEDRAFT || q.Status == QUOTESENT) && now.After(q.ExpiryDate)
+}
+
+// CreateQuote numbers and stores a new quote
+func CreateQuote(quote *Quote) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		quote.QuoteNumber, err = nextDocumentNumber(tx, "quote", "QT")
+		if err != nil {
+			return err
+		}
+		return tx.Create(quote).Error
+	})
+}
+
+// GetQuoteByID retrieves a quote by its QuoteID
+func GetQuoteByID(id string) (*Quote, error) {
+	var quote Quote
+	if err := db.Where("quote_id = ?", id).First(&quote).Error; err != nil {
+		return nil, err
+	}
+	return &quote, nil
+}
+
+func GetQuotes(params InvoiceQueryParams) ([]Quote, error) {
+	var quotes []Quote
+	err := db.Limit(params.Limit).Offset(params.Offset).Order("created_at desc").Find(&quotes).Error
+	if err != nil {
+		return nil, err
+	}
+	return quotes, nil
+}
+
+// UpdateQuote saves every field of the quote
+func UpdateQuote(quote *Quote) error {
+	return db.Model(quote).Select("*").Updates(quote).Error
+}
+
+// ExpireQuotes moves draft and sent quotes past their expiry date to EXPIRED
+func ExpireQuotes(now time.Time) (int, error) {
+	var quotes []Quote
+	err := db.Where("status IN ? AND expiry_date < ?", []QuoteStatus{QUOTEDRAFT, QUOTESENT}, now).Find(&quotes).Error
+	if err != nil {
+		return 0, err
+	}
+	for i := range quotes {
+		quotes[i].Status = QUOTEEXPIRED
+		AppendQuoteHistory(&quotes[i], QuoteHistory{Action: QUOTEEXPIRED, ActionDate: now})
+		err = db.Model(&quotes[i]).Select("status", "quote_history").Updates(&quotes[i]).Error
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(quotes), nil
+}
+
+// BuildInvoice makes the invoice an accepted quote converts into
+func (q *Quote) BuildInvoice(dueDate time.Time, reminders json.RawMessage, now time.Time) Invoice {
+	quoteID := q.QuoteID
+	invoiceHistoryJSON, _ := json.Marshal([]InvoiceHistory{{
+		Action:     CREATED,
+		ActionDate: now,
+		Reference:  q.QuoteNumber,
+	}})
+	return Invoice{
+		InvoiceID:          uuid.New(),
+		DueDate:            &dueDate,
+		Description:        q.Description,
+		Amount:             q.Amount,
+		Status:             CREATED,
+		Items:              q.Items,
+		Reminders:          reminders,
+		CustomerInfo:       q.CustomerInfo,
+		IsDiscount:         q.IsDiscount,
+		DiscountPercentage: q.DiscountPercentage,
+		Note:               q.Note,
+		CreatedBy:          q.CreatedBy,
+		OutstandingAmount:  q.Amount,
+		InvoiceHistory:     invoiceHistoryJSON,
+		QuoteID:            &quoteID,
+	}
+}
+
+// ConvertQuote creates an invoice from an accepted quote and links both records. Invoices matching an approval rule
+// wait for approval before they can be sent.
+func ConvertQuote(quoteID string, dueDate time.Time, reminders json.RawMessage) (*Invoice, *Quote, error) {
+	var quote Quote
+	var invoice Invoice
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("quote_id = ?", quoteID).
+			First(&quote).Error
+		if err != nil {
+			return err
+		}
+		if quote.InvoiceID != nil {
+			return ErrQuoteAlreadyConverted
+		}
+		if quote.Status != QUOTEACCEPTED {
+			return ErrQuoteNotAccepted
+		}
+
+		now := time.Now()
+		invoice = quote.BuildInvoice(dueDate, reminders, now)
+		if err = assignInvoiceNumber(tx, &invoice); err != nil {
+			return err
+		}
+		if _, err = requestApproval(tx, &invoice); err != nil {
+			return err
+		}
+		if err = tx.Create(&invoice).Error; err != nil {
+			return err
+		}
+		if err = syncInvoiceLedger(tx, &invoice); err != nil {
+			return err
+		}
+
+		quote.InvoiceID = &invoice.InvoiceID
+		AppendQuoteHistory(&quote, QuoteHistory{
+			Action:     QUOTECONVERTED,
+			ActionDate: now,
+			Reference:  invoice.InvoiceID.String(),
+		})
+		return tx.Model(&quote).Select("invoice_id", "quote_history").Updates(&quote).Error
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &invoice, &quote, nil
+}
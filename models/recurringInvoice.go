@@ -0,0 +1,253 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// Frequency of a recurring invoice
+type Frequency string
+
+const (
+	WEEKLY    Frequency = "WEEKLY"
+	MONTHLY   Frequency = "MONTHLY"
+	QUARTERLY Frequency = "QUARTERLY"
+)
+
+// RecurringStatus of a recurring invoice schedule
+type RecurringStatus string
+
+const (
+	RECURRINGACTIVE    RecurringStatus = "ACTIVE"
+	RECURRINGPAUSED    RecurringStatus = "PAUSED"
+	RECURRINGCANCELED  RecurringStatus = "CANCELED"
+	RECURRINGCOMPLETED RecurringStatus = "COMPLETED"
+)
+
+// RECURRING INVOICE, a template plus the schedule invoices are generated on
+type RecurringInvoice struct {
+	gorm.Model
+	RecurringInvoiceID uuid.UUID       `gorm:"type:uuid;uniqueIndex;not null" json:"recurring_invoice_id"`
+	Description        string          `json:"description"`
+	Items              json.RawMessage `gorm:"type:jsonb;default:'[]';not null" json:"items"`
+	CustomerInfo       json.RawMessage `gorm:"type:jsonb;default:'{}';not null" json:"customer_info"`
+	IsDiscount         bool            `gorm:"default:false" json:"is_discount"`
+	DiscountPercentage float64         `json:"discount_percentage"`
+	Reminders          json.RawMessage `gorm:"type:jsonb;default:'[]'" json:"reminders"`
+	Note               string          `json:"note"`
+	Frequency          Frequency       `gorm:"not null" json:"frequency"`
+	Interval           int             `gorm:"not null;default:1" json:"interval"` // Every n weeks/months/quarters
+	DayOfMonth         int             `gorm:"default:0" json:"day_of_month"`      // Optional, monthly and quarterly only. Clamped to the month length
+	StartDate          time.Time       `gorm:"not null" json:"start_date"`         // Compulsory
+	EndDate            *time.Time      `json:"end_date"`                           // Optional, no invoices after this date
+	MaxOccurrences     *int            `json:"max_occurrences"`                    // Optional, stop after this many invoices
+	OccurrenceCount    int             `gorm:"not null;default:0" json:"occurrence_count"`
+	NextRunAt          time.Time       `gorm:"index;not null" json:"next_run_at"`
+	LastRunAt          *time.Time      `json:"last_run_at"`
+	PaymentTermsDays   int             `gorm:"not null;default:0" json:"payment_terms_days"` // Due date = issue date + terms
+	AutoSend           bool            `gorm:"default:false" json:"auto_send"`
+	Status             RecurringStatus `gorm:"not null" json:"status"`
+	CreatedBy          int             `gorm:"not null" json:"created_by"`
+}
+
+// addMonths moves date by months keeping the day of month, clamped to the length of the target month
+func addMonths(date time.Time, months int, day int) time.Time {
+	firstOfMonth := time.Date(date.Year(), date.Month()+time.Month(months), 1, 0, 0, 0, 0, date.Location())
+	lastDay := firstOfMonth.AddDate(0, 1, -1).Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return time.Date(firstOfMonth.Year(), firstOfMonth.Month(), day, 0, 0, 0, 0, date.Location())
+}
+
+// day is the day of month runs land on
+func (r *RecurringInvoice) day() int {
+	if r.DayOfMonth > 0 {
+		return r.DayOfMonth
+	}
+	return r.StartDate.Day()
+}
+
+// FirstRun is the start date, or the first chosen day of month on or after it
+func (r *RecurringInvoice) FirstRun() time.Time {
+	if r.Frequency == WEEKLY || r.DayOfMonth == 0 {
+		return r.StartDate
+	}
+	run := addMonths(r.StartDate, 0, r.day())
+	if run.Before(r.StartDate) {
+		run = addMonths(r.StartDate, 1, r.day())
+	}
+	return run
+}
+
+// NextRun is the run following the given one
+func (r *RecurringInvoice) NextRun(run time.Time) time.Time {
+	interval := r.Interval
+	if interval < 1 {
+		interval = 1
+	}
+	switch r.Frequency {
+	case WEEKLY:
+		return run.AddDate(0, 0, 7*interval)
+	case QUARTERLY:
+		return addMonths(run, 3*interval, r.day())
+	default:
+		return addMonths(run, interval, r.day())
+	}
+}
+
+// finished tells whether the schedule has nothing left to generate
+func (r *RecurringInvoice) finished() bool {
+	if r.MaxOccurrences != nil && r.OccurrenceCount >= *r.MaxOccurrences {
+		return true
+	}
+	return r.EndDate != nil && r.NextRunAt.After(*r.EndDate)
+}
+
+// BuildInvoice makes the invoice for a run of the schedule
+func (r *RecurringInvoice) BuildInvoice(run time.Time) Invoice {
+	var items []Item
+	_ = json.Unmarshal(r.Items, &items)
+	totalAmount := CalculateItemsTotal(items, r.IsDiscount, r.DiscountPercentage)
+	recurringInvoiceID := r.RecurringInvoiceID
+	dueDate := run.AddDate(0, 0, r.PaymentTermsDays)
+
+	invoiceHistoryJSON, _ := json.Marshal([]InvoiceHistory{{
+		Action:     CREATED,
+		ActionDate: time.Now(),
+		Reference:  recurringInvoiceID.String(),
+	}})
+	return Invoice{
+		InvoiceID:          uuid.New(),
+		DueDate:            &dueDate,
+		Description:        r.Description,
+		Amount:             totalAmount,
+		Status:             CREATED,
+		Items:              r.Items,
+		Reminders:          r.Reminders,
+		CustomerInfo:       r.CustomerInfo,
+		IsDiscount:         r.IsDiscount,
+		DiscountPercentage: r.DiscountPercentage,
+		Note:               r.Note,
+		CreatedBy:          r.CreatedBy,
+		OutstandingAmount:  totalAmount,
+		InvoiceHistory:     invoiceHistoryJSON,
+		PaymentTermsDays:   r.PaymentTermsDays,
+		RecurringInvoiceID: &recurringInvoiceID,
+	}
+}
+
+func CreateRecurringInvoice(recurringInvoice *RecurringInvoice) error {
+	return db.Create(recurringInvoice).Error
+}
+
+// GetRecurringInvoiceByID retrieves a recurring invoice by its RecurringInvoiceID
+func GetRecurringInvoiceByID(id string) (*RecurringInvoice, error) {
+	var recurringInvoice RecurringInvoice
+	if err := db.Where("recurring_invoice_id = ?", id).First(&recurringInvoice).Error; err != nil {
+		return nil, err
+	}
+	return &recurringInvoice, nil
+}
+
+func GetRecurringInvoices(params InvoiceQueryParams) ([]RecurringInvoice, error) {
+	var recurringInvoices []RecurringInvoice
+	err := db.Limit(params.Limit).Offset(params.Offset).Order("created_at desc").Find(&recurringInvoices).Error
+	if err != nil {
+		return nil, err
+	}
+	return recurringInvoices, nil
+}
+
+// GetInvoicesByRecurringInvoiceID lists the invoices a schedule generated, latest first
+func GetInvoicesByRecurringInvoiceID(id string) ([]Invoice, error) {
+	var invoices []Invoice
+	err := db.Where("recurring_invoice_id = ?", id).Order("created_at desc").Find(&invoices).Error
+	if err != nil {
+		return nil, err
+	}
+	return invoices, nil
+}
+
+// UpdateRecurringInvoiceStatus pauses, resumes or cancels a schedule.
+// Resuming does not catch up on runs missed while paused.
+func UpdateRecurringInvoiceStatus(recurringInvoice *RecurringInvoice, status RecurringStatus) error {
+	recurringInvoice.Status = status
+	if status == RECURRINGACTIVE {
+		today := time.Now().Truncate(24 * time.Hour)
+		for recurringInvoice.NextRunAt.Before(today) {
+			recurringInvoice.NextRunAt = recurringInvoice.NextRun(recurringInvoice.NextRunAt)
+		}
+		if recurringInvoice.finished() {
+			recurringInvoice.Status = RECURRINGCOMPLETED
+		}
+	}
+	return db.Model(recurringInvoice).
+		Select("status", "next_run_at").
+		Updates(recurringInvoice).Error
+}
+
+// DueRecurringInvoiceIDs lists active schedules with a run due
+func DueRecurringInvoiceIDs(now time.Time) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := db.Model(&RecurringInvoice{}).
+		Where("status = ? AND next_run_at <= ?", RECURRINGACTIVE, now).
+		Pluck("recurring_invoice_id", &ids).Error
+	return ids, err
+}
+
+// GenerateRecurringInvoice creates the invoice for the next due run of a schedule and moves the schedule on.
+// Invoices matching an approval rule wait for approval before they can be sent.
+// It returns nil when nothing was due (eg: another worker got there first).
+func GenerateRecurringInvoice(id uuid.UUID, now time.Time) (*Invoice, *RecurringInvoice, error) {
+	var invoice *Invoice
+	var recurringInvoice RecurringInvoice
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("recurring_invoice_id = ?", id).
+			First(&recurringInvoice).Error
+		if err != nil {
+			return err
+		}
+		if recurringInvoice.Status != RECURRINGACTIVE || recurringInvoice.NextRunAt.After(now) {
+			return nil
+		}
+		if recurringInvoice.finished() {
+			recurringInvoice.Status = RECURRINGCOMPLETED
+			return tx.Model(&recurringInvoice).Update("status", RECURRINGCOMPLETED).Error
+		}
+
+		run := recurringInvoice.NextRunAt
+		generated := recurringInvoice.BuildInvoice(run)
+		if err = assignInvoiceNumber(tx, &generated); err != nil {
+			return err
+		}
+		if _, err = requestApproval(tx, &generated); err != nil {
+			return err
+		}
+		if err = tx.Create(&generated).Error; err != nil {
+			return err
+		}
+		if err = syncInvoiceLedger(tx, &generated); err != nil {
+			return err
+		}
+		invoice = &generated
+
+		recurringInvoice.OccurrenceCount++
+		recurringInvoice.LastRunAt = &now
+		recurringInvoice.NextRunAt = recurringInvoice.NextRun(run)
+		if recurringInvoice.finished() {
+			recurringInvoice.Status = RECURRINGCOMPLETED
+		}
+		return tx.Model(&recurringInvoice).
+			Select("occurrence_count", "last_run_at", "next_run_at", "status").
+			Updates(&recurringInvoice).Error
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return invoice, &recurringInvoice, nil
+}
@@ -0,0 +1,165 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"sort"
+)
+
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// INVOICE REVISION, an immutable snapshot of a sent invoice taken every time it changes.
+// Revision 1 is the invoice as it was first sent.
+type InvoiceRevision struct {
+	gorm.Model
+	RevisionID    uuid.UUID       `gorm:"type:uuid;uniqueIndex;not null" json:"revision_id"`
+	InvoiceID     uuid.UUID       `gorm:"type:uuid;uniqueIndex:idx_invoice_revision;not null" json:"invoice_id"`
+	Revision      int             `gorm:"uniqueIndex:idx_invoice_revision;not null" json:"revision"`
+	ChangedFields json.RawMessage `gorm:"type:jsonb;default:'[]';not null" json:"changed_fields"` // Compared with the revision before
+	Snapshot      json.RawMessage `gorm:"type:jsonb;not null" json:"-"`
+}
+
+// RevisionChange is a field that differs between two revisions
+type RevisionChange struct {
+	Field string          `json:"field"`
+	From  json.RawMessage `json:"from"`
+	To    json.RawMessage `json:"to"`
+}
+
+// Bookkeeping fields that change without the document changing, left out of snapshots
+var unrevisedFields = []string{"ID", "UpdatedAt", "DeletedAt", "invoice_history", "sent_at", "is_shared", "share_token", "share_expires_at"}
+
+// revisionFields is the invoice as a map of its JSON fields, without bookkeeping fields
+func revisionFields(invoice *Invoice) map[string]json.RawMessage {
+	invoiceJSON, _ := json.Marshal(invoice)
+	var fields map[string]json.RawMessage
+	_ = json.Unmarshal(invoiceJSON, &fields)
+	for _, field := range unrevisedFields {
+		delete(fields, field)
+	}
+	return fields
+}
+
+// sameJSON compares two JSON values whatever their formatting, the database reformats stored JSON
+func sameJSON(a json.RawMessage, b json.RawMessage) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	var aValue, bValue interface{}
+	if json.Unmarshal(a, &aValue) != nil || json.Unmarshal(b, &bValue) != nil {
+		return false
+	}
+	aJSON, _ := json.Marshal(aValue)
+	bJSON, _ := json.Marshal(bValue)
+	return bytes.Equal(aJSON, bJSON)
+}
+
+// changedFields lists the fields that differ between two snapshots, sorted
+func changedFields(from map[string]json.RawMessage, to map[string]json.RawMessage) []RevisionChange {
+	var changes []RevisionChange
+	for field, value := range to {
+		if !sameJSON(from[field], value) {
+			changes = append(changes, RevisionChange{Field: field, From: from[field], To: value})
+		}
+	}
+	for field, value := range from {
+		if _, ok := to[field]; !ok {
+			changes = append(changes, RevisionChange{Field: field, From: value})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// recordRevision stores a new revision of a sent invoice if it changed since the last one
+func recordRevision(tx *gorm.DB, invoice *Invoice) error {
+	if invoice.SentAt == nil {
+		return nil
+	}
+	// Snapshot what was saved, as the database stores it
+	var saved Invoice
+	if err := tx.Where("invoice_id = ?", invoice.InvoiceID).First(&saved).Error; err != nil {
+		return err
+	}
+	fields := revisionFields(&saved)
+	revision := InvoiceRevision{
+		RevisionID: uuid.New(),
+		InvoiceID:  invoice.InvoiceID,
+		Revision:   1,
+	}
+
+	var last InvoiceRevision
+	err := tx.Where("invoice_id = ?", invoice.InvoiceID).Order("revision desc").First(&last).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	names := []string{}
+	if err == nil {
+		var lastFields map[string]json.RawMessage
+		_ = json.Unmarshal(last.Snapshot, &lastFields)
+		changes := changedFields(lastFields, fields)
+		if len(changes) == 0 {
+			return nil
+		}
+		for _, change := range changes {
+			names = append(names, change.Field)
+		}
+		revision.Revision = last.Revision + 1
+	}
+	revision.ChangedFields, _ = json.Marshal(names)
+	revision.Snapshot, _ = json.Marshal(fields)
+	return tx.Create(&revision).Error
+}
+
+// GetInvoiceRevisions lists the revisions of an invoice, oldest first
+func GetInvoiceRevisions(invoiceID string) ([]InvoiceRevision, error) {
+	var revisions []InvoiceRevision
+	err := db.Where("invoice_id = ?", invoiceID).Order("revision asc").Find(&revisions).Error
+	if err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// GetInvoiceRevision returns the invoice as it was at a revision
+func GetInvoiceRevision(invoiceID string, number int) (*Invoice, error) {
+	var revision InvoiceRevision
+	err := db.Where("invoice_id = ? AND revision = ?", invoiceID, number).First(&revision).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRevisionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var invoice Invoice
+	if err = json.Unmarshal(revision.Snapshot, &invoice); err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+// DiffInvoiceRevisions lists the fields that changed from one revision to another
+func DiffInvoiceRevisions(invoiceID string, from int, to int) ([]RevisionChange, error) {
+	var revisions []InvoiceRevision
+	err := db.Where("invoice_id = ? AND revision IN ?", invoiceID, []int{from, to}).Find(&revisions).Error
+	if err != nil {
+		return nil, err
+	}
+	snapshots := map[int]map[string]json.RawMessage{}
+	for _, revision := range revisions {
+		var fields map[string]json.RawMessage
+		_ = json.Unmarshal(revision.Snapshot, &fields)
+		snapshots[revision.Revision] = fields
+	}
+	if snapshots[from] == nil || snapshots[to] == nil {
+		return nil, ErrRevisionNotFound
+	}
+	changes := changedFields(snapshots[from], snapshots[to])
+	if changes == nil {
+		changes = []RevisionChange{}
+	}
+	return changes, nil
+}
@@ -0,0 +1,195 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"math"
+	"sort"
+	"time"
+)
+
+var ErrCustomerNotFound = errors.New("no invoices for the customer")
+
+// StatementLine is an invoice, credit or payment on the statement and the balance after it
+type StatementLine struct {
+	Date          time.Time     `json:"date"`
+	Type          JournalSource `json:"type"`
+	InvoiceID     uuid.UUID     `json:"invoice_id"`
+	InvoiceNumber string        `json:"invoice_number"`
+	Reference     string        `json:"reference"`
+	Description   string        `json:"description"`
+	Debit         float64       `json:"debit"`  // Added to what the customer owes
+	Credit        float64       `json:"credit"` // Taken off what the customer owes
+	Balance       float64       `json:"balance"`
+}
+
+// StatementAging splits the closing balance by how long it is past due at the end of the period
+type StatementAging struct {
+	Current    float64 `json:"current"`
+	Days1To30  float64 `json:"days_1_30"`
+	Days31To60 float64 `json:"days_31_60"`
+	Days61To90 float64 `json:"days_61_90"`
+	Over90     float64 `json:"over_90"`
+}
+
+// StatementInvoice is an invoice still open at the end of the period
+type StatementInvoice struct {
+	InvoiceID     uuid.UUID `json:"invoice_id"`
+	InvoiceNumber string    `json:"invoice_number"`
+	IssueDate     time.Time `json:"issue_date"`
+	DueDate       time.Time `json:"due_date"`
+	Balance       float64   `json:"balance"`
+	DaysOverdue   int       `json:"days_overdue"`
+}
+
+// STATEMENT OF ACCOUNT of a customer for a period: what they owed at the start, every invoice, credit and payment
+// in between, and what they owe at the end. It is read from the receivables on the ledger.
+type Statement struct {
+	Customer       CustomerInfo       `json:"customer"`
+	Currency       string             `json:"currency"`
+	From           time.Time          `json:"from"`
+	To             time.Time          `json:"to"`
+	OpeningBalance float64            `json:"opening_balance"`
+	Lines          []StatementLine    `json:"lines"`
+	TotalDebit     float64            `json:"total_debit"`
+	TotalCredit    float64            `json:"total_credit"`
+	ClosingBalance float64            `json:"closing_balance"`
+	Aging          StatementAging     `json:"aging"`
+	OpenInvoices   []StatementInvoice `json:"open_invoices"`
+	GeneratedAt    time.Time          `json:"generated_at"`
+}
+
+// GetCustomerStatement builds the statement of the customer (by email) from the start of from to the end of to
+func GetCustomerStatement(customerEmail string, from time.Time, to time.Time) (*Statement, error) {
+	var invoices []Invoice
+	err := db.Where("LOWER(customer_info->>'email') = LOWER(?)", customerEmail).
+		Order("created_at asc").
+		Find(&invoices).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(invoices) == 0 {
+		return nil, ErrCustomerNotFound
+	}
+
+	invoiceIDs := make([]uuid.UUID, 0, len(invoices))
+	for i := range invoices {
+		invoiceIDs = append(invoiceIDs, invoices[i].InvoiceID)
+	}
+	var entries []JournalEntry
+	err = db.Preload("Lines").
+		Where("invoice_id IN ? AND date < ?", invoiceIDs, to.AddDate(0, 0, 1)).
+		Order("date asc, id asc").
+		Find(&entries).Error
+	if err != nil {
+		return nil, err
+	}
+	statement := buildStatement(invoices, entries, from, to)
+	statement.GeneratedAt = time.Now()
+	return &statement, nil
+}
+
+// buildStatement works the statement out from the invoices of the customer, oldest first, and the entries posted
+// for them up to the end of the period, in date order. Balances are what the entries move in receivables.
+func buildStatement(invoices []Invoice, entries []JournalEntry, from time.Time, to time.Time) Statement {
+	statement := Statement{
+		Currency:     InvoiceCurrency(),
+		From:         from,
+		To:           to,
+		Lines:        []StatementLine{},
+		OpenInvoices: []StatementInvoice{},
+	}
+	// The latest details the customer was invoiced under
+	_ = json.Unmarshal(invoices[len(invoices)-1].CustomerInfo, &statement.Customer)
+
+	byID := map[uuid.UUID]*Invoice{}
+	for i := range invoices {
+		byID[invoices[i].InvoiceID] = &invoices[i]
+	}
+	end := to.AddDate(0, 0, 1)
+
+	balances := map[uuid.UUID]float64{}
+	balance := 0.0
+	for _, entry := range entries {
+		amount := receivableBalance([]JournalEntry{entry})
+		invoice, ok := byID[*entry.InvoiceID]
+		if amount == 0 || !ok || !entry.Date.Before(end) {
+			continue
+		}
+		balances[invoice.InvoiceID] += amount
+		balance = roundMoney(balance + amount)
+		if entry.Date.Before(from) {
+			statement.OpeningBalance = balance
+			continue
+		}
+
+		line := StatementLine{
+			Date:          entry.Date,
+			Type:          entry.Source,
+			InvoiceID:     invoice.InvoiceID,
+			InvoiceNumber: invoice.Number(),
+			Reference:     entry.Reference,
+			Description:   entry.Description,
+			Balance:       balance,
+		}
+		if amount > 0 {
+			line.Debit = amount
+			statement.TotalDebit += amount
+		} else {
+			line.Credit = -amount
+			statement.TotalCredit -= amount
+		}
+		statement.Lines = append(statement.Lines, line)
+	}
+	statement.TotalDebit = roundMoney(statement.TotalDebit)
+	statement.TotalCredit = roundMoney(statement.TotalCredit)
+	statement.ClosingBalance = balance
+
+	for invoiceID, invoiceBalance := range balances {
+		invoiceBalance = roundMoney(invoiceBalance)
+		if invoiceBalance == 0 {
+			continue
+		}
+		invoice := byID[invoiceID]
+		dueDate := invoice.IssueDate()
+		if invoice.DueDate != nil {
+			dueDate = *invoice.DueDate
+		}
+		daysOverdue := 0
+		if end.After(dueDate) {
+			daysOverdue = int(math.Ceil(end.Sub(dueDate).Hours()/24)) - 1
+		}
+		statement.OpenInvoices = append(statement.OpenInvoices, StatementInvoice{
+			InvoiceID:     invoiceID,
+			InvoiceNumber: invoice.Number(),
+			IssueDate:     invoice.IssueDate(),
+			DueDate:       dueDate,
+			Balance:       invoiceBalance,
+			DaysOverdue:   daysOverdue,
+		})
+		switch {
+		case daysOverdue <= 0:
+			statement.Aging.Current += invoiceBalance
+		case daysOverdue <= 30:
+			statement.Aging.Days1To30 += invoiceBalance
+		case daysOverdue <= 60:
+			statement.Aging.Days31To60 += invoiceBalance
+		case daysOverdue <= 90:
+			statement.Aging.Days61To90 += invoiceBalance
+		default:
+			statement.Aging.Over90 += invoiceBalance
+		}
+	}
+	statement.Aging = StatementAging{
+		Current:    roundMoney(statement.Aging.Current),
+		Days1To30:  roundMoney(statement.Aging.Days1To30),
+		Days31To60: roundMoney(statement.Aging.Days31To60),
+		Days61To90: roundMoney(statement.Aging.Days61To90),
+		Over90:     roundMoney(statement.Aging.Over90),
+	}
+	sort.Slice(statement.OpenInvoices, func(i, j int) bool {
+		return statement.OpenInvoices[i].DueDate.Before(statement.OpenInvoices[j].DueDate)
+	})
+	return statement
+}
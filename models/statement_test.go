@@ -0,0 +1,98 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+// statementInvoice is an invoice of the customer issued on the day, due on the other, with its entry
+func statementInvoice(number string, issued time.Time, due time.Time, amount float64) (Invoice, JournalEntry) {
+	invoice := Invoice{InvoiceID: uuid.New(), InvoiceNumber: &number, IssuedAt: &issued, DueDate: &due, Status: SENT, Amount: amount}
+	invoice.CustomerInfo, _ = json.Marshal(CustomerInfo{Name: "Globex", Email: "ap@globex.test"})
+	return invoice, ledgerEvent{JOURNALINVOICE, number, issued, ACCOUNTSALES, amount}.entry(&invoice)
+}
+
+func TestStatementAging(t *testing.T) {
+	from := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 6, 30, 0, 0, 0, 0, time.UTC)
+	issued := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+	day := func(month time.Month, day int) time.Time { return time.Date(2026, month, day, 0, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name        string
+		due         time.Time
+		daysOverdue int
+		bucket      func(aging StatementAging) float64
+	}{
+		{"due after the period", day(time.July, 15), 0, func(aging StatementAging) float64 { return aging.Current }},
+		{"due on the last day", day(time.June, 30), 0, func(aging StatementAging) float64 { return aging.Current }},
+		{"a day past due", day(time.June, 29), 1, func(aging StatementAging) float64 { return aging.Days1To30 }},
+		{"30 days past due", day(time.May, 31), 30, func(aging StatementAging) float64 { return aging.Days1To30 }},
+		{"31 days past due", day(time.May, 30), 31, func(aging StatementAging) float64 { return aging.Days31To60 }},
+		{"60 days past due", day(time.May, 1), 60, func(aging StatementAging) float64 { return aging.Days31To60 }},
+		{"61 days past due", day(time.April, 30), 61, func(aging StatementAging) float64 { return aging.Days61To90 }},
+		{"90 days past due", day(time.April, 1), 90, func(aging StatementAging) float64 { return aging.Days61To90 }},
+		{"91 days past due", day(time.March, 31), 91, func(aging StatementAging) float64 { return aging.Over90 }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			invoice, issuedEntry := statementInvoice("INV-000001", issued, test.due, 400)
+			payment := ledgerEvent{JOURNALPAYMENT, uuid.NewString(), day(time.February, 1), ACCOUNTBANK, -150}.entry(&invoice)
+			statement := buildStatement([]Invoice{invoice}, []JournalEntry{issuedEntry, payment}, from, to)
+
+			if len(statement.OpenInvoices) != 1 {
+				t.Fatalf("expected the invoice open, got %v", statement.OpenInvoices)
+			}
+			if open := statement.OpenInvoices[0]; open.Balance != 250 || open.DaysOverdue != test.daysOverdue {
+				t.Errorf("expected 250.00 open %d days past due, got %.2f %d days", test.daysOverdue, open.Balance, open.DaysOverdue)
+			}
+			aging := statement.Aging
+			total := aging.Current + aging.Days1To30 + aging.Days31To60 + aging.Days61To90 + aging.Over90
+			if test.bucket(aging) != 250 || total != 250 {
+				t.Errorf("expected 250.00 in its bucket alone, got %+v", aging)
+			}
+		})
+	}
+}
+
+func TestStatementBalances(t *testing.T) {
+	from := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 6, 30, 0, 0, 0, 0, time.UTC)
+	earlier, earlierIssued := statementInvoice("INV-000001", time.Date(2026, 5, 2, 0, 0, 0, 0, time.UTC), time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC), 300)
+	paid, paidIssued := statementInvoice("INV-000002", time.Date(2026, 6, 3, 0, 0, 0, 0, time.UTC), time.Date(2026, 7, 3, 0, 0, 0, 0, time.UTC), 200)
+	later, laterIssued := statementInvoice("INV-000003", time.Date(2026, 7, 2, 0, 0, 0, 0, time.UTC), time.Date(2026, 8, 1, 0, 0, 0, 0, time.UTC), 900)
+	entries := []JournalEntry{
+		earlierIssued,
+		ledgerEvent{JOURNALLATEFEE, uuid.NewString(), time.Date(2026, 5, 20, 0, 0, 0, 0, time.UTC), ACCOUNTLATEFEES, 10}.entry(&earlier),
+		paidIssued,
+		ledgerEvent{JOURNALCREDITNOTE, "CN-000001", time.Date(2026, 6, 10, 0, 0, 0, 0, time.UTC), ACCOUNTSALESRETURNS, -50}.entry(&paid),
+		ledgerEvent{JOURNALPAYMENT, uuid.NewString(), time.Date(2026, 6, 30, 18, 0, 0, 0, time.UTC), ACCOUNTBANK, -150}.entry(&paid),
+		laterIssued,
+	}
+	statement := buildStatement([]Invoice{earlier, paid, later}, entries, from, to)
+
+	if statement.OpeningBalance != 310 || statement.ClosingBalance != 310 {
+		t.Errorf("expected 310.00 opening and closing, got %.2f and %.2f", statement.OpeningBalance, statement.ClosingBalance)
+	}
+	if statement.TotalDebit != 200 || statement.TotalCredit != 200 {
+		t.Errorf("expected 200.00 debited and credited in the period, got %.2f and %.2f", statement.TotalDebit, statement.TotalCredit)
+	}
+	var types []JournalSource
+	for _, line := range statement.Lines {
+		types = append(types, line.Type)
+	}
+	if len(types) != 3 || types[0] != JOURNALINVOICE || types[1] != JOURNALCREDITNOTE || types[2] != JOURNALPAYMENT {
+		t.Errorf("expected the invoice, credit note and payment of the period, got %v", types)
+	}
+	if last := statement.Lines[len(statement.Lines)-1]; last.Balance != statement.ClosingBalance {
+		t.Errorf("expected the last line at the closing balance, got %.2f", last.Balance)
+	}
+	if len(statement.OpenInvoices) != 1 || statement.OpenInvoices[0].InvoiceNumber != "INV-000001" || statement.OpenInvoices[0].Balance != 310 {
+		t.Errorf("expected only INV-000001 open with 310.00, got %+v", statement.OpenInvoices)
+	}
+	if statement.Aging.Days1To30 != 310 {
+		t.Errorf("expected 310.00 1-30 days past due, got %+v", statement.Aging)
+	}
+}
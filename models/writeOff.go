@@ -0,0 +1,51 @@
+package models
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// WriteOffInvoice gives up on what is left unpaid on an issued invoice as a bad debt, keeping the reason.
+// Payments and credit notes stay as they are, only the balance is written off.
+func WriteOffInvoice(invoiceID string, reason string) (*Invoice, error) {
+	var invoice Invoice
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("invoice_id = ?", invoiceID).
+			First(&invoice).Error
+		if err != nil {
+			return err
+		}
+		if err = checkTransition(&invoice, WRITTENOFF); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		writtenOff := invoice.OutstandingAmount
+		invoice.WrittenOffAmount += writtenOff
+		invoice.WrittenOffAt = &now
+		invoice.Status = WRITTENOFF
+		RecalculateOutstanding(&invoice)
+		AppendInvoiceHistory(&invoice, InvoiceHistory{
+			Action:     WRITTENOFF,
+			ActionDate: now,
+			Note:       fmt.Sprintf("%.2f written off: %s", writtenOff, reason),
+		})
+		err = tx.Model(&invoice).
+			Select("written_off_amount", "written_off_at", "outstanding_amount", "status", "invoice_history").
+			Updates(&invoice).Error
+		if err != nil {
+			return err
+		}
+		if err = syncInvoiceLedger(tx, &invoice); err != nil {
+			return err
+		}
+		return recordRevision(tx, &invoice)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
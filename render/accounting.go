@@ -0,0 +1,460 @@
+package render
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"numerisTask/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Accounting export formats
+const (
+	AccountingIIF = "iif"
+	AccountingCSV = "csv"
+)
+
+// AccountingBatch is what goes to the accounting software in one export
+type AccountingBatch struct {
+	Mapping     *models.AccountMapping
+	Currency    string
+	Invoices    []*models.Invoice
+	Customers   []models.CustomerInfo
+	Payments    []models.AccountingPayment
+	Reversals   []models.AccountingPayment // Go with the payments
+	CreditNotes []models.AccountingCreditNote
+	WriteOffs   []models.AccountingWriteOff
+	// The tables asked for, an empty one still gets its file
+	IncludeInvoices    bool
+	IncludeCustomers   bool
+	IncludePayments    bool
+	IncludeCreditNotes bool
+	IncludeWriteOffs   bool
+}
+
+// hasTransactions tells whether the batch has anything booked against receivables
+func (batch AccountingBatch) hasTransactions() bool {
+	return (batch.IncludeInvoices && len(batch.Invoices) > 0) ||
+		(batch.IncludePayments && len(batch.Payments)+len(batch.Reversals) > 0) ||
+		(batch.IncludeCreditNotes && len(batch.CreditNotes) > 0) ||
+		(batch.IncludeWriteOffs && len(batch.WriteOffs) > 0)
+}
+
+// AccountingFile is a file of an accounting export
+type AccountingFile struct {
+	Name string
+	Data []byte
+}
+
+// accountingLine is a line of an invoice as the accounting software sees it: the items, the late fees
+// and the discount taken off them
+type accountingLine struct {
+	name      string
+	quantity  float64
+	unitPrice float64
+	amount    float64
+	account   string
+	discount  bool
+}
+
+// accountingLines breaks the invoice down into lines adding up to what the customer was billed
+// (the total and the late fees, credit notes are left out). The discount is a negative line, worked out
+// from the rounded lines so they add up to the total to the cent.
+func accountingLines(invoice *models.Invoice, mapping *models.AccountMapping) []accountingLine {
+	var items []models.Item
+	_ = json.Unmarshal(invoice.Items, &items)
+	var lines []accountingLine
+	var itemsTotal float64
+	for _, item := range items {
+		amount := roundAmount(float64(item.Quantity) * item.UnitPrice)
+		itemsTotal += amount
+		lines = append(lines, accountingLine{
+			name:      item.Name,
+			quantity:  float64(item.Quantity),
+			unitPrice: item.UnitPrice,
+			amount:    amount,
+			account:   mapping.SalesAccount,
+		})
+	}
+	if discount := roundAmount(itemsTotal - invoice.Amount); discount != 0 {
+		lines = append(lines, accountingLine{
+			name:      fmt.Sprintf("Discount %s%%", strconv.FormatFloat(invoice.DiscountPercentage, 'f', -1, 64)),
+			quantity:  1,
+			unitPrice: -discount,
+			amount:    -discount,
+			account:   mapping.DiscountAccount,
+			discount:  true,
+		})
+	}
+	if fees := roundAmount(invoice.FeesAmount); fees != 0 {
+		lines = append(lines, accountingLine{
+			name:      "Late fees",
+			quantity:  1,
+			unitPrice: fees,
+			amount:    fees,
+			account:   mapping.LateFeeAccount,
+		})
+	}
+	return lines
+}
+
+func roundAmount(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+func customerOf(invoice *models.Invoice) models.CustomerInfo {
+	var customer models.CustomerInfo
+	_ = json.Unmarshal(invoice.CustomerInfo, &customer)
+	return customer
+}
+
+// customerName is how the customer is known in the accounting software, the email for those without a name
+func customerName(customer models.CustomerInfo) string {
+	if strings.TrimSpace(customer.Name) != "" {
+		return strings.TrimSpace(customer.Name)
+	}
+	return customer.Email
+}
+
+func dueDateOf(invoice *models.Invoice) time.Time {
+	if invoice.DueDate != nil {
+		return *invoice.DueDate
+	}
+	return invoice.IssueDate()
+}
+
+func reversalMemo(reversal models.AccountingPayment) string {
+	return "Payment of invoice " + reversal.Invoice.Number() + " reversed"
+}
+
+func creditMemo(credit models.AccountingCreditNote) string {
+	return "Credit against invoice " + credit.Invoice.Number()
+}
+
+func writeOffMemo(writeOff models.AccountingWriteOff) string {
+	return "Invoice " + writeOff.Invoice.Number() + " written off"
+}
+
+// iifText keeps a value on its field: IIF is tab separated, one record per line
+func iifText(text string) string {
+	return strings.NewReplacer("\t", " ", "\r", " ", "\n", " ", `"`, "'").Replace(text)
+}
+
+// QuickBooksIIF writes the batch as a QuickBooks Desktop IIF file: the customer list first, then the invoices
+// (receivables against the mapped income accounts), credit memos, payments (into the mapped bank account), and
+// reversals and write-offs as general journal entries
+func QuickBooksIIF(batch AccountingBatch) []AccountingFile {
+	var out bytes.Buffer
+	record := func(fields ...string) {
+		for i := range fields {
+			fields[i] = iifText(fields[i])
+		}
+		out.WriteString(strings.Join(fields, "\t") + "\r\n")
+	}
+	iifDate := func(date time.Time) string { return date.Format("01/02/2006") }
+	mapping := batch.Mapping
+
+	if batch.IncludeCustomers {
+		record("!CUST", "NAME", "EMAIL", "PHONE1")
+		for _, customer := range batch.Customers {
+			record("CUST", customerName(customer), customer.Email, customer.PhoneNumber)
+		}
+	}
+	if batch.hasTransactions() {
+		record("!TRNS", "TRNSID", "TRNSTYPE", "DATE", "ACCNT", "NAME", "AMOUNT", "DOCNUM", "MEMO", "DUEDATE", "NAMEISTAXABLE")
+		record("!SPL", "SPLID", "TRNSTYPE", "DATE", "ACCNT", "NAME", "AMOUNT", "DOCNUM", "MEMO", "PRICE", "QNTY", "INVITEM", "TAXABLE")
+		record("!ENDTRNS")
+	}
+	if batch.IncludeInvoices {
+		for _, invoice := range batch.Invoices {
+			name := customerName(customerOf(invoice))
+			date := iifDate(invoice.IssueDate())
+			lines := accountingLines(invoice, mapping)
+			var total float64
+			for _, line := range lines {
+				total += line.amount
+			}
+			record("TRNS", "", "INVOICE", date, mapping.ReceivableAccount, name, money(total), invoice.Number(),
+				invoice.Description, iifDate(dueDateOf(invoice)), "N")
+			// Splits carry the other side, income is negative
+			for _, line := range lines {
+				item := line.name
+				if line.account != mapping.SalesAccount {
+					item = ""
+				}
+				record("SPL", "", "INVOICE", date, line.account, name, money(-line.amount), invoice.Number(), line.name,
+					money(line.unitPrice), strconv.FormatFloat(-line.quantity, 'f', -1, 64), item, "N")
+			}
+			// Invoices carry no tax, the tax line says so
+			record("SPL", "", "INVOICE", date, mapping.TaxAccount, "", "0.00", invoice.Number(), mapping.TaxType, "0.00", "", "", "N")
+			record("ENDTRNS")
+		}
+	}
+	if batch.IncludePayments {
+		for _, payment := range batch.Payments {
+			name := customerName(customerOf(payment.Invoice))
+			date := iifDate(payment.Payment.DatePaid)
+			memo := "Payment of invoice " + payment.Invoice.Number()
+			record("TRNS", "", "PAYMENT", date, mapping.BankAccount, name, money(payment.Amount), payment.Invoice.Number(), memo, "", "N")
+			record("SPL", "", "PAYMENT", date, mapping.ReceivableAccount, name, money(-payment.Amount), payment.Invoice.Number(), memo, "", "", "", "N")
+			record("ENDTRNS")
+		}
+		for _, reversal := range batch.Reversals {
+			name := customerName(customerOf(reversal.Invoice))
+			date := iifDate(reversal.Payment.DatePaid)
+			memo := reversalMemo(reversal)
+			record("TRNS", "", "GENERAL JOURNAL", date, mapping.ReceivableAccount, name, money(reversal.Amount), reversal.Invoice.Number(), memo, "", "N")
+			record("SPL", "", "GENERAL JOURNAL", date, mapping.BankAccount, "", money(-reversal.Amount), reversal.Invoice.Number(), memo, "", "", "", "N")
+			record("ENDTRNS")
+		}
+	}
+	if batch.IncludeCreditNotes {
+		for _, credit := range batch.CreditNotes {
+			name := customerName(customerOf(credit.Invoice))
+			date := iifDate(credit.CreditNote.CreatedAt)
+			number := credit.CreditNote.CreditNoteNumber
+			record("TRNS", "", "CREDIT MEMO", date, mapping.ReceivableAccount, name, money(-credit.CreditNote.Amount), number,
+				credit.CreditNote.Reason, "", "N")
+			record("SPL", "", "CREDIT MEMO", date, mapping.SalesAccount, name, money(credit.CreditNote.Amount), number, creditMemo(credit),
+				money(credit.CreditNote.Amount), "1", "", "N")
+			record("SPL", "", "CREDIT MEMO", date, mapping.TaxAccount, "", "0.00", number, mapping.TaxType, "0.00", "", "", "N")
+			record("ENDTRNS")
+		}
+	}
+	if batch.IncludeWriteOffs {
+		for _, writeOff := range batch.WriteOffs {
+			name := customerName(customerOf(writeOff.Invoice))
+			date := iifDate(writeOff.Date)
+			memo := writeOffMemo(writeOff)
+			record("TRNS", "", "GENERAL JOURNAL", date, mapping.BadDebtAccount, "", money(writeOff.Amount), writeOff.Invoice.Number(), memo, "", "N")
+			record("SPL", "", "GENERAL JOURNAL", date, mapping.ReceivableAccount, name, money(-writeOff.Amount), writeOff.Invoice.Number(), memo, "", "", "", "N")
+			record("ENDTRNS")
+		}
+	}
+	return []AccountingFile{{Name: "quickbooks.iif", Data: out.Bytes()}}
+}
+
+// accountingCSV writes a CSV table, text cells guarded against spreadsheet formulas (amounts are left as they are)
+func accountingCSV(header []string, rows [][]string) []byte {
+	var out bytes.Buffer
+	file := csv.NewWriter(&out)
+	_ = file.Write(header)
+	for _, row := range rows {
+		for i := range row {
+			if _, err := strconv.ParseFloat(row[i], 64); err != nil {
+				row[i] = csvText(row[i])
+			}
+		}
+		_ = file.Write(row)
+	}
+	file.Flush()
+	return out.Bytes()
+}
+
+// QuickBooksCSV writes the batch as QuickBooks Online import files: invoices (one row per line), credit memos,
+// customers and payments, deposited into the mapped bank account. Reversals and write-offs are journal entries.
+func QuickBooksCSV(batch AccountingBatch) []AccountingFile {
+	qboDate := func(date time.Time) string { return date.Format("01/02/2006") }
+	var files []AccountingFile
+	if batch.IncludeInvoices {
+		var rows [][]string
+		for _, invoice := range batch.Invoices {
+			customer := customerName(customerOf(invoice))
+			for _, line := range accountingLines(invoice, batch.Mapping) {
+				rows = append(rows, []string{
+					invoice.Number(), customer, qboDate(invoice.IssueDate()), qboDate(dueDateOf(invoice)),
+					invoice.Description, line.name, line.name, strconv.FormatFloat(line.quantity, 'f', -1, 64),
+					money(line.unitPrice), money(line.amount), "N", batch.Mapping.TaxType,
+				})
+			}
+		}
+		files = append(files, AccountingFile{Name: "invoices.csv", Data: accountingCSV([]string{
+			"InvoiceNo", "Customer", "InvoiceDate", "DueDate", "Memo", "Item(Product/Service)", "ItemDescription",
+			"ItemQuantity", "ItemRate", "ItemAmount", "Taxable", "TaxCode",
+		}, rows)})
+	}
+	if batch.IncludeCustomers {
+		var rows [][]string
+		for _, customer := range batch.Customers {
+			rows = append(rows, []string{customerName(customer), customer.Email, customer.PhoneNumber})
+		}
+		files = append(files, AccountingFile{Name: "customers.csv", Data: accountingCSV([]string{"Name", "Email", "Phone"}, rows)})
+	}
+	if batch.IncludePayments {
+		var rows [][]string
+		for _, payment := range batch.Payments {
+			rows = append(rows, []string{
+				qboDate(payment.Payment.DatePaid), customerName(customerOf(payment.Invoice)), payment.Invoice.Number(),
+				money(payment.Amount), payment.Key, batch.Mapping.BankAccount,
+			})
+		}
+		files = append(files, AccountingFile{Name: "payments.csv", Data: accountingCSV([]string{
+			"PaymentDate", "Customer", "InvoiceNo", "Amount", "ReferenceNo", "DepositToAccount",
+		}, rows)})
+	}
+	if batch.IncludeCreditNotes {
+		var rows [][]string
+		for _, credit := range batch.CreditNotes {
+			amount := money(credit.CreditNote.Amount)
+			rows = append(rows, []string{
+				credit.CreditNote.CreditNoteNumber, customerName(customerOf(credit.Invoice)), qboDate(credit.CreditNote.CreatedAt),
+				credit.CreditNote.Reason, creditMemo(credit), creditMemo(credit), "1", amount, amount, "N", batch.Mapping.TaxType,
+			})
+		}
+		files = append(files, AccountingFile{Name: "creditmemos.csv", Data: accountingCSV([]string{
+			"CreditMemoNo", "Customer", "CreditMemoDate", "Memo", "Item(Product/Service)", "ItemDescription",
+			"ItemQuantity", "ItemRate", "ItemAmount", "Taxable", "TaxCode",
+		}, rows)})
+	}
+	if (batch.IncludePayments && len(batch.Reversals) > 0) || batch.IncludeWriteOffs {
+		// Rows of one journal entry share its number, debits first
+		var rows [][]string
+		journal := func(number string, date time.Time, debitAccount string, creditAccount string, amount float64, memo string, customer string) {
+			rows = append(rows,
+				[]string{number, qboDate(date), debitAccount, money(amount), "", memo, customer},
+				[]string{number, qboDate(date), creditAccount, "", money(amount), memo, customer},
+			)
+		}
+		if batch.IncludePayments {
+			for _, reversal := range batch.Reversals {
+				journal(reversal.Key, reversal.Payment.DatePaid, batch.Mapping.ReceivableAccount, batch.Mapping.BankAccount,
+					reversal.Amount, reversalMemo(reversal), customerName(customerOf(reversal.Invoice)))
+			}
+		}
+		if batch.IncludeWriteOffs {
+			for _, writeOff := range batch.WriteOffs {
+				journal("WO-"+writeOff.Invoice.Number(), writeOff.Date, batch.Mapping.BadDebtAccount, batch.Mapping.ReceivableAccount,
+					writeOff.Amount, writeOffMemo(writeOff), customerName(customerOf(writeOff.Invoice)))
+			}
+		}
+		files = append(files, AccountingFile{Name: "journal.csv", Data: accountingCSV([]string{
+			"JournalNo", "JournalDate", "AccountName", "Debits", "Credits", "Description", "Name",
+		}, rows)})
+	}
+	return files
+}
+
+// XeroCSV writes the batch as Xero import files: sales invoices, credit notes and contacts in Xero's templates,
+// and the payments and reversals as a bank statement of the mapped bank account to reconcile against the invoices.
+// Xero takes no manual journals on receivables, write-offs are credit notes to the bad debt account.
+// Dates are day first, as Xero expects them outside the US.
+func XeroCSV(batch AccountingBatch) []AccountingFile {
+	xeroDate := func(date time.Time) string { return date.Format("02/01/2006") }
+	var files []AccountingFile
+	if batch.IncludeInvoices {
+		var rows [][]string
+		for _, invoice := range batch.Invoices {
+			customer := customerOf(invoice)
+			discount := ""
+			if invoice.IsDiscount && invoice.DiscountPercentage > 0 {
+				discount = strconv.FormatFloat(invoice.DiscountPercentage, 'f', -1, 64)
+			}
+			for _, line := range accountingLines(invoice, batch.Mapping) {
+				// Xero takes the discount off each line as a percentage
+				if line.discount && discount != "" {
+					continue
+				}
+				lineDiscount := discount
+				if line.account != batch.Mapping.SalesAccount {
+					lineDiscount = ""
+				}
+				rows = append(rows, []string{
+					customerName(customer), customer.Email, invoice.Number(), invoice.Description,
+					xeroDate(invoice.IssueDate()), xeroDate(dueDateOf(invoice)), line.name,
+					strconv.FormatFloat(line.quantity, 'f', -1, 64), money(line.unitPrice), lineDiscount, line.account,
+					batch.Mapping.TaxType, batch.Currency,
+				})
+			}
+		}
+		files = append(files, AccountingFile{Name: "invoices.csv", Data: accountingCSV([]string{
+			"*ContactName", "EmailAddress", "*InvoiceNumber", "Reference", "*InvoiceDate", "*DueDate", "*Description",
+			"*Quantity", "*UnitAmount", "Discount", "*AccountCode", "*TaxType", "Currency",
+		}, rows)})
+	}
+	if batch.IncludeCustomers {
+		var rows [][]string
+		for _, customer := range batch.Customers {
+			rows = append(rows, []string{customerName(customer), customer.Email, customer.PhoneNumber})
+		}
+		files = append(files, AccountingFile{Name: "contacts.csv", Data: accountingCSV([]string{"*ContactName", "EmailAddress", "PhoneNumber"}, rows)})
+	}
+	if batch.IncludePayments {
+		var rows [][]string
+		for _, payment := range batch.Payments {
+			rows = append(rows, []string{
+				xeroDate(payment.Payment.DatePaid), money(payment.Amount), customerName(customerOf(payment.Invoice)),
+				"Payment of invoice " + payment.Invoice.Number(), payment.Invoice.Number(),
+			})
+		}
+		// Money paid back leaves the bank
+		for _, reversal := range batch.Reversals {
+			rows = append(rows, []string{
+				xeroDate(reversal.Payment.DatePaid), money(-reversal.Amount), customerName(customerOf(reversal.Invoice)),
+				reversalMemo(reversal), reversal.Invoice.Number(),
+			})
+		}
+		files = append(files, AccountingFile{
+			Name: "payments-" + batch.Mapping.BankAccount + ".csv",
+			Data: accountingCSV([]string{"*Date", "*Amount", "Payee", "Description", "Reference"}, rows),
+		})
+	}
+	if batch.IncludeCreditNotes || batch.IncludeWriteOffs {
+		var rows [][]string
+		creditNote := func(invoice *models.Invoice, number string, date time.Time, description string, amount float64, account string) {
+			customer := customerOf(invoice)
+			rows = append(rows, []string{
+				customerName(customer), customer.Email, number, invoice.Number(), xeroDate(date), description, "1",
+				money(amount), account, batch.Mapping.TaxType, batch.Currency,
+			})
+		}
+		if batch.IncludeCreditNotes {
+			for _, credit := range batch.CreditNotes {
+				creditNote(credit.Invoice, credit.CreditNote.CreditNoteNumber, credit.CreditNote.CreatedAt, creditMemo(credit),
+					credit.CreditNote.Amount, batch.Mapping.SalesAccount)
+			}
+		}
+		if batch.IncludeWriteOffs {
+			for _, writeOff := range batch.WriteOffs {
+				creditNote(writeOff.Invoice, "WO-"+writeOff.Invoice.Number(), writeOff.Date, writeOffMemo(writeOff),
+					writeOff.Amount, batch.Mapping.BadDebtAccount)
+			}
+		}
+		files = append(files, AccountingFile{Name: "creditnotes.csv", Data: accountingCSV([]string{
+			"*ContactName", "EmailAddress", "*CreditNoteNumber", "Reference", "*CreditNoteDate", "*Description",
+			"*Quantity", "*UnitAmount", "*AccountCode", "*TaxType", "Currency",
+		}, rows)})
+	}
+	return files
+}
+
+// AccountingArchive puts the files of an export together, zipped when there are several
+func AccountingArchive(target models.AccountingTarget, files []AccountingFile, now time.Time) ([]byte, string, string, error) {
+	prefix := strings.ToLower(string(target)) + "-" + now.Format("20060102") + "-"
+	if len(files) == 1 {
+		contentType := "text/csv"
+		if strings.HasSuffix(files[0].Name, "."+AccountingIIF) {
+			contentType = "text/plain"
+		}
+		return files[0].Data, prefix + files[0].Name, contentType, nil
+	}
+	var out bytes.Buffer
+	archive := zip.NewWriter(&out)
+	for _, file := range files {
+		w, err := archive.Create(file.Name)
+		if err != nil {
+			return nil, "", "", err
+		}
+		if _, err = w.Write(file.Data); err != nil {
+			return nil, "", "", err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, "", "", err
+	}
+	return out.Bytes(), strings.ToLower(string(target)) + "-" + now.Format("20060102") + ".zip", "application/zip", nil
+}
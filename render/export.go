@@ -0,0 +1,216 @@
+package render
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"numerisTask/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export formats
+const (
+	ExportCSV  = "csv"
+	ExportXLSX = "xlsx"
+)
+
+// InvoiceSource hands every exported invoice to fn in turn, one pass of models.SnapshotInvoices with the list filters
+type InvoiceSource func(fn func(invoice *models.Invoice) error) error
+
+// exportSheet is one table of the export: a sheet of the workbook, or a CSV file
+type exportSheet struct {
+	name   string
+	header []string
+	rows   func(invoice *models.Invoice) [][]interface{}
+}
+
+func exportDate(date *time.Time) string {
+	if date == nil {
+		return ""
+	}
+	return date.Format("2006-01-02")
+}
+
+// exportNumber is the invoice number, empty for drafts
+func exportNumber(invoice *models.Invoice) string {
+	if invoice.InvoiceNumber == nil {
+		return ""
+	}
+	return *invoice.InvoiceNumber
+}
+
+// csvText guards text cells against spreadsheet formulas
+func csvText(text string) string {
+	if text != "" && strings.ContainsRune("=+-@\t\r", rune(text[0])) {
+		return "'" + text
+	}
+	return text
+}
+
+var invoiceSheet = exportSheet{
+	name: "invoices",
+	header: []string{"invoice_id", "invoice_number", "status", "customer_name", "customer_email", "issue_date", "due_date",
+		"amount", "discount_percentage", "credited_amount", "fees_amount", "paid_amount", "outstanding_amount", "description"},
+	rows: func(invoice *models.Invoice) [][]interface{} {
+		var customerInfo models.CustomerInfo
+		_ = json.Unmarshal(invoice.CustomerInfo, &customerInfo)
+		issueDate := ""
+		if invoice.Status != models.DRAFT {
+			issueDate = invoice.IssueDate().Format("2006-01-02")
+		}
+		return [][]interface{}{{
+			invoice.InvoiceID.String(), exportNumber(invoice), string(invoice.Status), customerInfo.Name, customerInfo.Email,
+			issueDate, exportDate(invoice.DueDate), invoice.Amount, invoice.DiscountPercentage, invoice.CreditedAmount,
+			invoice.FeesAmount, models.NetPaid(invoice), invoice.OutstandingAmount, invoice.Description,
+		}}
+	},
+}
+
+var itemSheet = exportSheet{
+	name:   "items",
+	header: []string{"invoice_id", "invoice_number", "line", "name", "quantity", "unit_price", "total"},
+	rows: func(invoice *models.Invoice) [][]interface{} {
+		var items []models.Item
+		_ = json.Unmarshal(invoice.Items, &items)
+		var rows [][]interface{}
+		for i, item := range items {
+			rows = append(rows, []interface{}{
+				invoice.InvoiceID.String(), exportNumber(invoice), i + 1, item.Name, item.Quantity, item.UnitPrice,
+				float64(item.Quantity) * item.UnitPrice,
+			})
+		}
+		return rows
+	},
+}
+
+var paymentSheet = exportSheet{
+	name: "payments",
+	header: []string{"invoice_id", "invoice_number", "payment_id", "type", "amount_paid", "date_paid", "amount_reversed",
+		"reversal_of", "discount_applied", "reason"},
+	rows: func(invoice *models.Invoice) [][]interface{} {
+		var rows [][]interface{}
+		for _, payment := range models.Payments(invoice) {
+			reversalOf := ""
+			if payment.ReversalOf != nil {
+				reversalOf = payment.ReversalOf.String()
+			}
+			rows = append(rows, []interface{}{
+				invoice.InvoiceID.String(), exportNumber(invoice), payment.PaymentID.String(), string(payment.Type),
+				payment.AmountPaid, payment.DatePaid.Format(time.RFC3339), payment.AmountReversed, reversalOf,
+				payment.DiscountApplied, payment.Reason,
+			})
+		}
+		return rows
+	},
+}
+
+// exportSheets lists the tables of an export, the invoices first
+func exportSheets(includeItems bool, includePayments bool) []exportSheet {
+	sheets := []exportSheet{invoiceSheet}
+	if includeItems {
+		sheets = append(sheets, itemSheet)
+	}
+	if includePayments {
+		sheets = append(sheets, paymentSheet)
+	}
+	return sheets
+}
+
+// ExportInvoices writes the invoices as an XLSX workbook or as CSV. Line items and payments, when included,
+// are flattened into sheets of their own, for CSV into files of their own zipped together with the invoices.
+// Each table is a pass over the invoices so nothing is kept in memory.
+func ExportInvoices(w io.Writer, format string, includeItems bool, includePayments bool, source InvoiceSource) error {
+	sheets := exportSheets(includeItems, includePayments)
+	if format == ExportXLSX {
+		workbook := newXLSXWriter(w)
+		for _, sheet := range sheets {
+			if err := workbook.addSheet(sheet.name); err != nil {
+				return err
+			}
+			header := make([]interface{}, len(sheet.header))
+			for i, name := range sheet.header {
+				header[i] = name
+			}
+			if err := workbook.writeRow(true, header...); err != nil {
+				return err
+			}
+			err := source(func(invoice *models.Invoice) error {
+				for _, row := range sheet.rows(invoice) {
+					if err := workbook.writeRow(false, row...); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return workbook.Close()
+	}
+
+	if len(sheets) == 1 {
+		return writeCSV(w, sheets[0], source)
+	}
+	archive := zip.NewWriter(w)
+	for _, sheet := range sheets {
+		file, err := archive.Create(sheet.name + ".csv")
+		if err != nil {
+			return err
+		}
+		if err = writeCSV(file, sheet, source); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// ExportFileName is the download name of an export, a zip when CSV files go together
+func ExportFileName(format string, includeItems bool, includePayments bool, now time.Time) (string, string) {
+	name := "invoices-" + now.Format("20060102")
+	switch {
+	case format == ExportXLSX:
+		return name + ".xlsx", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case includeItems || includePayments:
+		return name + ".zip", "application/zip"
+	default:
+		return name + ".csv", "text/csv"
+	}
+}
+
+func writeCSV(w io.Writer, sheet exportSheet, source InvoiceSource) error {
+	file := csv.NewWriter(w)
+	if err := file.Write(sheet.header); err != nil {
+		return err
+	}
+	err := source(func(invoice *models.Invoice) error {
+		for _, row := range sheet.rows(invoice) {
+			record := make([]string, len(row))
+			for i, value := range row {
+				switch value := value.(type) {
+				case float64:
+					record[i] = strconv.FormatFloat(value, 'f', -1, 64)
+				case string:
+					record[i] = csvText(value)
+				default:
+					record[i] = fmt.Sprint(value)
+				}
+			}
+			if err := file.Write(record); err != nil {
+				return err
+			}
+		}
+		// Flush as we go, the response is streamed
+		file.Flush()
+		return file.Error()
+	})
+	if err != nil {
+		return err
+	}
+	file.Flush()
+	return file.Error()
+}
@@ -0,0 +1,59 @@
+package render
+
+import (
+	"numerisTask/models"
+	"time"
+)
+
+const (
+	facturXFileName    = "factur-x.xml"
+	facturXNamespace   = "urn:factur-x:pdfa:CrossIndustryDocument:invoice:1p0#"
+	facturXConformance = "EN 16931"
+)
+
+// facturXMetadata declares the Factur-X properties and, since PDF/A only knows the standard schemas,
+// describes the fx schema itself in a PDF/A extension schema
+const facturXMetadata = `<rdf:Description rdf:about="" xmlns:fx="` + facturXNamespace + `">
+<fx:DocumentType>INVOICE</fx:DocumentType>
+<fx:DocumentFileName>` + facturXFileName + `</fx:DocumentFileName>
+<fx:Version>1.0</fx:Version>
+<fx:ConformanceLevel>` + facturXConformance + `</fx:ConformanceLevel>
+</rdf:Description>
+<rdf:Description rdf:about="" xmlns:pdfaExtension="http://www.aiim.org/pdfa/ns/extension/" xmlns:pdfaSchema="http://www.aiim.org/pdfa/ns/schema#" xmlns:pdfaProperty="http://www.aiim.org/pdfa/ns/property#">
+<pdfaExtension:schemas><rdf:Bag><rdf:li rdf:parseType="Resource">
+<pdfaSchema:schema>Factur-X PDFA Extension Schema</pdfaSchema:schema>
+<pdfaSchema:namespaceURI>` + facturXNamespace + `</pdfaSchema:namespaceURI>
+<pdfaSchema:prefix>fx</pdfaSchema:prefix>
+<pdfaSchema:property><rdf:Seq>
+<rdf:li rdf:parseType="Resource"><pdfaProperty:name>DocumentFileName</pdfaProperty:name><pdfaProperty:valueType>Text</pdfaProperty:valueType><pdfaProperty:category>external</pdfaProperty:category><pdfaProperty:description>Name of the embedded XML invoice file</pdfaProperty:description></rdf:li>
+<rdf:li rdf:parseType="Resource"><pdfaProperty:name>DocumentType</pdfaProperty:name><pdfaProperty:valueType>Text</pdfaProperty:valueType><pdfaProperty:category>external</pdfaProperty:category><pdfaProperty:description>INVOICE</pdfaProperty:description></rdf:li>
+<rdf:li rdf:parseType="Resource"><pdfaProperty:name>Version</pdfaProperty:name><pdfaProperty:valueType>Text</pdfaProperty:valueType><pdfaProperty:category>external</pdfaProperty:category><pdfaProperty:description>Version of the Factur-X XML schema</pdfaProperty:description></rdf:li>
+<rdf:li rdf:parseType="Resource"><pdfaProperty:name>ConformanceLevel</pdfaProperty:name><pdfaProperty:valueType>Text</pdfaProperty:valueType><pdfaProperty:category>external</pdfaProperty:category><pdfaProperty:description>Profile of the embedded XML invoice</pdfaProperty:description></rdf:li>
+</rdf:Seq></pdfaSchema:property>
+</rdf:li></rdf:Bag></pdfaExtension:schemas>
+</rdf:Description>
+`
+
+// FacturXPDF renders the invoice as a Factur-X / ZUGFeRD hybrid: the same PDF as InvoicePDF, written as
+// PDF/A-3 with the Cross Industry Invoice XML (EN 16931 profile) attached for accounts payable software
+func FacturXPDF(invoice *models.Invoice, cii []byte, created time.Time) ([]byte, error) {
+	archive, err := newPDFArchive(created)
+	if err != nil {
+		return nil, err
+	}
+	archive.metadata = facturXMetadata
+	archive.attachments = []pdfAttachment{{
+		name:         facturXFileName,
+		mimeType:     "text/xml",
+		description:  "Factur-X invoice",
+		relationship: "Alternative", // The XML is the same invoice, in the structured form
+		data:         cii,
+	}}
+
+	document := newPDFDocument(invoiceTitle(invoice))
+	document.archive = archive
+	if err := writeInvoice(document, invoice); err != nil {
+		return nil, err
+	}
+	return document.bytes(), nil
+}
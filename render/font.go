@@ -0,0 +1,130 @@
+package render
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+	"math"
+	"strings"
+)
+
+// winAnsiHigh are the characters WinAnsiEncoding puts at 0x80-0x9F, the rest of the codes are latin-1
+var winAnsiHigh = map[byte]rune{
+	0x80: '€', 0x82: '‚', 0x83: 'ƒ', 0x84: '„', 0x85: '…', 0x86: '†', 0x87: '‡', 0x88: 'ˆ', 0x89: '‰',
+	0x8A: 'Š', 0x8B: '‹', 0x8C: 'Œ', 0x8E: 'Ž', 0x91: '‘', 0x92: '’', 0x93: '“', 0x94: '”', 0x95: '•',
+	0x96: '–', 0x97: '—', 0x98: '˜', 0x99: '™', 0x9A: 'š', 0x9B: '›', 0x9C: 'œ', 0x9E: 'ž', 0x9F: 'Ÿ',
+}
+
+const (
+	firstChar = 32
+	lastChar  = 255
+)
+
+// pdfFont is a TrueType font embedded in the document. PDF/A does not allow the standard fonts,
+// every glyph drawn has to come with the file. The Go fonts are used, they ship with golang.org/x/image.
+type pdfFont struct {
+	name      string
+	program   []byte
+	widths    [lastChar + 1]int // Per WinAnsi code, in thousandths of the font size
+	bbox      [4]int
+	ascent    int
+	descent   int
+	capHeight int
+}
+
+func loadFont(program []byte) (*pdfFont, error) {
+	parsed, err := sfnt.Parse(program)
+	if err != nil {
+		return nil, err
+	}
+	var buffer sfnt.Buffer
+	unitsPerEm := parsed.UnitsPerEm()
+	// At a ppem of the units per em, the metrics come back in font units
+	ppem := fixed.I(int(unitsPerEm))
+	scale := func(value fixed.Int26_6) int {
+		return int(math.Round(float64(value) / 64 * 1000 / float64(unitsPerEm)))
+	}
+
+	name, err := parsed.Name(&buffer, sfnt.NameIDPostScript)
+	if err != nil {
+		return nil, err
+	}
+	loaded := &pdfFont{name: strings.ReplaceAll(name, " ", ""), program: program}
+	for code := firstChar; code <= lastChar; code++ {
+		r, ok := winAnsiHigh[byte(code)]
+		if !ok {
+			r = rune(code)
+		}
+		glyph, err := parsed.GlyphIndex(&buffer, r)
+		if err != nil {
+			return nil, err
+		}
+		advance, err := parsed.GlyphAdvance(&buffer, glyph, ppem, font.HintingNone)
+		if err != nil {
+			return nil, err
+		}
+		loaded.widths[code] = scale(advance)
+	}
+	bounds, err := parsed.Bounds(&buffer, ppem, font.HintingNone)
+	if err != nil {
+		return nil, err
+	}
+	// sfnt has y growing down, PDF up
+	loaded.bbox = [4]int{scale(bounds.Min.X), scale(-bounds.Max.Y), scale(bounds.Max.X), scale(-bounds.Min.Y)}
+	metrics, err := parsed.Metrics(&buffer, ppem, font.HintingNone)
+	if err != nil {
+		return nil, err
+	}
+	loaded.ascent = scale(metrics.Ascent)
+	loaded.descent = -scale(metrics.Descent)
+	loaded.capHeight = scale(metrics.CapHeight)
+	return loaded, nil
+}
+
+// embeddedFonts loads the regular and bold fonts
+func embeddedFonts() (*pdfFont, *pdfFont, error) {
+	regular, err := loadFont(goregular.TTF)
+	if err != nil {
+		return nil, nil, err
+	}
+	bold, err := loadFont(gobold.TTF)
+	if err != nil {
+		return nil, nil, err
+	}
+	return regular, bold, nil
+}
+
+// width is the advance of WinAnsi text at the size
+func (f *pdfFont) width(text string, size float64) float64 {
+	var units int
+	for i := 0; i < len(text); i++ {
+		units += f.widths[text[i]]
+	}
+	return float64(units) * size / 1000
+}
+
+// objects adds the font program, its descriptor and the font, returning the font's object number
+func (f *pdfFont) objects(addObject func(body string) int) int {
+	var compressed bytes.Buffer
+	writer := zlib.NewWriter(&compressed)
+	writer.Write(f.program)
+	writer.Close()
+	program := addObject(fmt.Sprintf("<< /Length %d /Length1 %d /Filter /FlateDecode >>\nstream\n%s\nendstream",
+		compressed.Len(), len(f.program), compressed.Bytes()))
+	// Flags 32: nonsymbolic, the glyphs are looked up by their unicode through the WinAnsi names
+	descriptor := addObject(fmt.Sprintf(
+		"<< /Type /FontDescriptor /FontName /%s /Flags 32 /FontBBox [%d %d %d %d] /ItalicAngle 0 /Ascent %d /Descent %d /CapHeight %d /StemV 80 /FontFile2 %d 0 R >>",
+		f.name, f.bbox[0], f.bbox[1], f.bbox[2], f.bbox[3], f.ascent, f.descent, f.capHeight, program))
+	widths := make([]string, 0, lastChar-firstChar+1)
+	for code := firstChar; code <= lastChar; code++ {
+		widths = append(widths, fmt.Sprint(f.widths[code]))
+	}
+	return addObject(fmt.Sprintf(
+		"<< /Type /Font /Subtype /TrueType /BaseFont /%s /FirstChar %d /LastChar %d /Widths [%s] /Encoding /WinAnsiEncoding /FontDescriptor %d 0 R >>",
+		f.name, firstChar, lastChar, strings.Join(widths, " "), descriptor))
+}
@@ -0,0 +1,139 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"numerisTask/models"
+	"time"
+)
+
+type htmlItem struct {
+	models.Item
+	Total float64
+}
+
+// invoiceView is what the HTML template gets, plain values only
+type invoiceView struct {
+	Invoice      *models.Invoice
+	Items        []htmlItem
+	LateFees     []models.LateFee
+	Installments []models.Installment
+	Customer     models.CustomerInfo
+	Sender       models.User
+	Subtotal     float64
+	Discount     float64
+	Paid         float64
+	IssuedDate   string
+	DueDate      string
+	IsSettled    bool
+	HasDiscount  bool
+	HasCredit    bool
+	HasPayments  bool
+	CreditAmount float64
+	// Set while the early payment discount can still be had
+	EarlyPaymentAmount   float64
+	EarlyPaymentDeadline string
+	HasEarlyPayment      bool
+}
+
+var templateFunctions = template.FuncMap{"money": money}
+
+var invoiceTemplate = template.Must(template.New("invoice").Funcs(templateFunctions).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Invoice from {{.Sender.Name}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; color: #222; max-width: 760px; margin: 40px auto; padding: 0 16px; }
+table { width: 100%; border-collapse: collapse; margin: 24px 0; }
+th, td { padding: 8px; border-bottom: 1px solid #ddd; text-align: left; }
+.amount { text-align: right; }
+.totals td { border: none; }
+.status { display: inline-block; padding: 2px 8px; border-radius: 4px; background: #eee; font-size: 0.9em; }
+.muted { color: #666; }
+</style>
+</head>
+<body>
+<h1>Invoice</h1>
+<p><strong>{{.Sender.Name}}</strong><br>{{.Sender.Email}}</p>
+<p class="muted">
+Invoice {{.Invoice.Number}}<br>
+Issued {{.IssuedDate}}<br>
+{{if .DueDate}}Due {{.DueDate}}<br>{{end}}
+<span class="status">{{.Invoice.Status}}</span>
+</p>
+<h3>Bill to</h3>
+<p>{{.Customer.Name}}<br>{{.Customer.Email}}<br>{{.Customer.PhoneNumber}}</p>
+{{if .Invoice.Description}}<p>{{.Invoice.Description}}</p>{{end}}
+<table>
+<thead><tr><th>Item</th><th class="amount">Qty</th><th class="amount">Unit price</th><th class="amount">Amount</th></tr></thead>
+<tbody>
+{{range .Items}}<tr><td>{{.Name}}</td><td class="amount">{{.Quantity}}</td><td class="amount">{{money .UnitPrice}}</td><td class="amount">{{money .Total}}</td></tr>
+{{end}}</tbody>
+</table>
+<table class="totals">
+<tr><td class="amount">Subtotal</td><td class="amount">{{money .Subtotal}}</td></tr>
+{{if .HasDiscount}}<tr><td class="amount">Discount ({{.Invoice.DiscountPercentage}}%)</td><td class="amount">-{{money .Discount}}</td></tr>{{end}}
+<tr><td class="amount"><strong>Total</strong></td><td class="amount"><strong>{{money .Invoice.Amount}}</strong></td></tr>
+{{range .LateFees}}{{if not .Waived}}<tr><td class="amount">{{.Description}} ({{.AppliedAt.Format "2006-01-02"}})</td><td class="amount">{{money .Amount}}</td></tr>{{end}}
+{{end}}{{if .HasCredit}}<tr><td class="amount">Credited</td><td class="amount">-{{money .CreditAmount}}</td></tr>{{end}}
+{{if .HasPayments}}<tr><td class="amount">Paid</td><td class="amount">-{{money .Paid}}</td></tr>{{end}}
+<tr><td class="amount"><strong>Amount due</strong></td><td class="amount"><strong>{{money .Invoice.OutstandingAmount}}</strong></td></tr>
+</table>
+{{if .HasEarlyPayment}}<p><strong>Pay {{money .EarlyPaymentAmount}} by {{.EarlyPaymentDeadline}} to get {{.Invoice.EarlyPaymentDiscountPercentage}}% early payment discount.</strong></p>{{end}}
+{{if .Installments}}<h3>Installments</h3>
+<table class="totals">
+{{range .Installments}}<tr><td>{{.Sequence}}. Due {{.DueDate.Format "2006-01-02"}}</td><td>{{.Status}}</td><td class="amount">{{money .Amount}}</td></tr>
+{{end}}</table>{{end}}
+{{if .Invoice.Note}}<p class="muted">{{.Invoice.Note}}</p>{{end}}
+{{if not .IsSettled}}<h3>Payment details</h3>
+<p>Bank: {{.Sender.BankDetail.BankName}} ({{.Sender.BankDetail.BankCode}})<br>Account number: {{.Sender.BankDetail.AccountNumber}}</p>{{end}}
+</body>
+</html>
+`))
+
+// InvoiceHTML renders the read-only page customers see through a shared link
+func InvoiceHTML(invoice *models.Invoice) ([]byte, error) {
+	view := invoiceView{
+		Invoice:      invoice,
+		Sender:       models.PlaceHolderUser,
+		IssuedDate:   invoice.IssueDate().Format("2006-01-02"),
+		IsSettled:    invoice.OutstandingAmount == 0,
+		HasDiscount:  invoice.IsDiscount,
+		LateFees:     models.LateFees(invoice),
+		Installments: models.Installments(invoice),
+		HasCredit:    invoice.CreditedAmount > 0,
+		CreditAmount: invoice.CreditedAmount,
+	}
+	var items []models.Item
+	if err := json.Unmarshal(invoice.Items, &items); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(invoice.CustomerInfo, &view.Customer); err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		lineTotal := float64(item.Quantity) * item.UnitPrice
+		view.Subtotal += lineTotal
+		view.Items = append(view.Items, htmlItem{Item: item, Total: lineTotal})
+	}
+	if invoice.DueDate != nil {
+		view.DueDate = invoice.DueDate.Format("2006-01-02")
+	}
+	view.Discount = view.Subtotal - invoice.Amount
+	view.Paid = models.NetPaid(invoice)
+	view.HasPayments = view.Paid > 0
+	view.EarlyPaymentAmount, view.HasEarlyPayment = models.EarlyPaymentAmount(invoice, time.Now())
+	if view.HasEarlyPayment {
+		view.EarlyPaymentDeadline = invoice.EarlyPaymentDeadline.Format("2006-01-02")
+	}
+
+	var out bytes.Buffer
+	if err := invoiceTemplate.Execute(&out, view); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
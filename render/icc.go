@@ -0,0 +1,93 @@
+package render
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+// srgbProfile builds an ICC v2 display profile for sRGB, the colour space of the PDF/A output intent.
+// PDF/A needs one for the black text and rules to have a defined colour.
+func srgbProfile() []byte {
+	s15Fixed16 := func(value float64) uint32 {
+		return uint32(int32(math.Round(value * 65536)))
+	}
+	xyz := func(x, y, z float64) []byte {
+		var tag bytes.Buffer
+		tag.WriteString("XYZ ")
+		binary.Write(&tag, binary.BigEndian, [4]uint32{0, s15Fixed16(x), s15Fixed16(y), s15Fixed16(z)})
+		return tag.Bytes()
+	}
+	// The sRGB transfer function, sampled
+	var curve bytes.Buffer
+	curve.WriteString("curv")
+	binary.Write(&curve, binary.BigEndian, [2]uint32{0, 1024})
+	for i := 0; i < 1024; i++ {
+		value := float64(i) / 1023
+		if value <= 0.04045 {
+			value /= 12.92
+		} else {
+			value = math.Pow((value+0.055)/1.055, 2.4)
+		}
+		binary.Write(&curve, binary.BigEndian, uint16(math.Round(value*65535)))
+	}
+	description := "sRGB IEC61966-2.1"
+	var desc bytes.Buffer
+	desc.WriteString("desc")
+	binary.Write(&desc, binary.BigEndian, [2]uint32{0, uint32(len(description) + 1)})
+	desc.WriteString(description)
+	// The terminating zero, then empty unicode and script code descriptions
+	desc.Write(make([]byte, 1+4+4+2+1+67))
+	var copyright bytes.Buffer
+	copyright.WriteString("text")
+	copyright.Write(make([]byte, 4))
+	copyright.WriteString("No copyright, use freely\x00")
+
+	// Primaries adapted to the D50 illuminant of the profile connection space
+	tags := []struct {
+		signature string
+		data      []byte
+	}{
+		{"desc", desc.Bytes()},
+		{"cprt", copyright.Bytes()},
+		{"wtpt", xyz(0.9642, 1.0, 0.8249)},
+		{"rXYZ", xyz(0.4361, 0.2225, 0.0139)},
+		{"gXYZ", xyz(0.3851, 0.7169, 0.0971)},
+		{"bXYZ", xyz(0.1431, 0.0606, 0.7141)},
+		{"rTRC", curve.Bytes()},
+		{"gTRC", curve.Bytes()},
+		{"bTRC", curve.Bytes()},
+	}
+
+	var table, data bytes.Buffer
+	offset := 128 + 4 + 12*len(tags)
+	binary.Write(&table, binary.BigEndian, uint32(len(tags)))
+	shared := map[string]int{}
+	for _, tag := range tags {
+		// The three curves are the same data, written once
+		position, ok := shared[string(tag.data)]
+		if !ok {
+			position = offset + data.Len()
+			shared[string(tag.data)] = position
+			data.Write(tag.data)
+			for data.Len()%4 != 0 {
+				data.WriteByte(0)
+			}
+		}
+		table.WriteString(tag.signature)
+		binary.Write(&table, binary.BigEndian, [2]uint32{uint32(position), uint32(len(tag.data))})
+	}
+
+	var header bytes.Buffer
+	binary.Write(&header, binary.BigEndian, [2]uint32{uint32(offset + data.Len()), 0})
+	binary.Write(&header, binary.BigEndian, uint32(0x02100000)) // Version 2.1
+	header.WriteString("mntrRGB XYZ ")
+	binary.Write(&header, binary.BigEndian, [6]uint16{2024, 1, 1, 0, 0, 0})
+	header.WriteString("acsp")
+	header.Write(make([]byte, 68-header.Len()))
+	binary.Write(&header, binary.BigEndian, [3]uint32{s15Fixed16(0.9642), s15Fixed16(1.0), s15Fixed16(0.8249)})
+	header.Write(make([]byte, 128-header.Len()))
+
+	profile := append(header.Bytes(), table.Bytes()...)
+	return append(profile, data.Bytes()...)
+}
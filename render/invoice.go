@@ -0,0 +1,189 @@
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"numerisTask/models"
+	"time"
+)
+
+// column positions of the items table
+const (
+	itemNameX     = pageMargin
+	itemQuantityX = 360.0
+	itemPriceX    = 450.0
+)
+
+func money(amount float64) string {
+	return fmt.Sprintf("%.2f", amount)
+}
+
+// sender writes the (placeholder) account holder and their bank details
+func sender(document *pdfDocument) {
+	user := models.PlaceHolderUser
+	document.text(defaultSize, true, user.Name)
+	document.text(defaultSize, false, user.Email)
+}
+
+func bankDetails(document *pdfDocument) {
+	bank := models.PlaceHolderUser.BankDetail
+	document.space(12)
+	document.text(defaultSize, true, "Payment details")
+	document.text(defaultSize, false, fmt.Sprintf("Bank: %s (%s)", bank.BankName, bank.BankCode))
+	document.text(defaultSize, false, fmt.Sprintf("Account number: %s", bank.AccountNumber))
+}
+
+func customer(document *pdfDocument, customerInfo models.CustomerInfo) {
+	document.space(12)
+	document.text(defaultSize, true, "Bill to")
+	document.text(defaultSize, false, customerInfo.Name)
+	document.text(defaultSize, false, customerInfo.Email)
+	document.text(defaultSize, false, customerInfo.PhoneNumber)
+}
+
+// itemsTable writes the line items and returns their undiscounted subtotal
+func itemsTable(document *pdfDocument, items []models.Item) float64 {
+	document.space(12)
+	document.row(defaultSize, true,
+		pdfCell{X: itemNameX, Text: "Item"},
+		pdfCell{X: itemQuantityX, Text: "Qty", AlignRight: true},
+		pdfCell{X: itemPriceX, Text: "Unit price", AlignRight: true},
+		pdfCell{X: rightColumnX, Text: "Amount", AlignRight: true},
+	)
+	document.rule()
+	var subtotal float64
+	for _, item := range items {
+		lineTotal := float64(item.Quantity) * item.UnitPrice
+		subtotal += lineTotal
+		document.row(defaultSize, false,
+			pdfCell{X: itemNameX, Text: item.Name},
+			pdfCell{X: itemQuantityX, Text: fmt.Sprintf("%d", item.Quantity), AlignRight: true},
+			pdfCell{X: itemPriceX, Text: money(item.UnitPrice), AlignRight: true},
+			pdfCell{X: rightColumnX, Text: money(lineTotal), AlignRight: true},
+		)
+	}
+	document.rule()
+	return subtotal
+}
+
+func total(document *pdfDocument, bold bool, label string, amount float64) {
+	document.row(defaultSize, bold,
+		pdfCell{X: itemPriceX, Text: label, AlignRight: true},
+		pdfCell{X: rightColumnX, Text: money(amount), AlignRight: true},
+	)
+}
+
+// InvoicePDF renders an invoice as a PDF document
+func InvoicePDF(invoice *models.Invoice) ([]byte, error) {
+	document := newPDFDocument(invoiceTitle(invoice))
+	if err := writeInvoice(document, invoice); err != nil {
+		return nil, err
+	}
+	return document.bytes(), nil
+}
+
+func invoiceTitle(invoice *models.Invoice) string {
+	return fmt.Sprintf("Invoice %s", invoice.Number())
+}
+
+// writeInvoice lays the invoice out on the document
+func writeInvoice(document *pdfDocument, invoice *models.Invoice) error {
+	var items []models.Item
+	var customerInfo models.CustomerInfo
+	if err := json.Unmarshal(invoice.Items, &items); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(invoice.CustomerInfo, &customerInfo); err != nil {
+		return err
+	}
+
+	document.text(headingSize, true, "INVOICE")
+	document.space(6)
+	sender(document)
+	document.space(12)
+	document.text(defaultSize, false, fmt.Sprintf("Invoice: %s", invoice.Number()))
+	document.text(defaultSize, false, fmt.Sprintf("Issued: %s", invoice.IssueDate().Format("2006-01-02")))
+	if invoice.DueDate != nil {
+		document.text(defaultSize, false, fmt.Sprintf("Due: %s", invoice.DueDate.Format("2006-01-02")))
+	}
+	document.text(defaultSize, false, fmt.Sprintf("Status: %s", invoice.Status))
+	customer(document, customerInfo)
+	if invoice.Description != "" {
+		document.space(12)
+		document.text(defaultSize, false, invoice.Description)
+	}
+
+	subtotal := itemsTable(document, items)
+	total(document, false, "Subtotal", subtotal)
+	if invoice.IsDiscount {
+		total(document, false, fmt.Sprintf("Discount (%.2f%%)", invoice.DiscountPercentage), invoice.Amount-subtotal)
+	}
+	total(document, true, "Total", invoice.Amount)
+	for _, fee := range models.LateFees(invoice) {
+		if !fee.Waived {
+			total(document, false, fmt.Sprintf("%s (%s)", fee.Description, fee.AppliedAt.Format("2006-01-02")), fee.Amount)
+		}
+	}
+	if invoice.CreditedAmount > 0 {
+		total(document, false, "Credited", -invoice.CreditedAmount)
+	}
+	if paid := models.NetPaid(invoice); paid > 0 {
+		total(document, false, "Paid", -paid)
+	}
+	total(document, true, "Amount due", invoice.OutstandingAmount)
+	if amount, ok := models.EarlyPaymentAmount(invoice, time.Now()); ok {
+		document.space(6)
+		document.text(defaultSize, true, fmt.Sprintf("Pay %s by %s to get %.2f%% early payment discount",
+			money(amount), invoice.EarlyPaymentDeadline.Format("2006-01-02"), invoice.EarlyPaymentDiscountPercentage))
+	}
+	if installments := models.Installments(invoice); len(installments) > 0 {
+		document.space(12)
+		document.text(defaultSize, true, "Installments")
+		for _, installment := range installments {
+			document.row(defaultSize, false,
+				pdfCell{X: itemNameX, Text: fmt.Sprintf("%d. Due %s", installment.Sequence, installment.DueDate.Format("2006-01-02"))},
+				pdfCell{X: itemPriceX, Text: string(installment.Status), AlignRight: true},
+				pdfCell{X: rightColumnX, Text: money(installment.Amount), AlignRight: true},
+			)
+		}
+	}
+
+	if invoice.Note != "" {
+		document.space(12)
+		document.text(smallSize, false, invoice.Note)
+	}
+	bankDetails(document)
+	return nil
+}
+
+// CreditNotePDF renders a credit note against the invoice it adjusts
+func CreditNotePDF(creditNote *models.CreditNote) ([]byte, error) {
+	var items []models.Item
+	var customerInfo models.CustomerInfo
+	if err := json.Unmarshal(creditNote.Items, &items); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(creditNote.CustomerInfo, &customerInfo); err != nil {
+		return nil, err
+	}
+
+	document := newPDFDocument(fmt.Sprintf("Credit note %s", creditNote.CreditNoteNumber))
+	document.text(headingSize, true, "CREDIT NOTE")
+	document.space(6)
+	sender(document)
+	document.space(12)
+	document.text(defaultSize, false, fmt.Sprintf("Credit note: %s", creditNote.CreditNoteNumber))
+	document.text(defaultSize, false, fmt.Sprintf("Issued: %s", creditNote.CreatedAt.Format("2006-01-02")))
+	document.text(defaultSize, false, fmt.Sprintf("Against invoice: %s", creditNote.InvoiceID))
+	customer(document, customerInfo)
+	document.space(12)
+	document.text(defaultSize, true, "Reason")
+	document.text(defaultSize, false, creditNote.Reason)
+
+	subtotal := itemsTable(document, items)
+	if subtotal != creditNote.Amount {
+		total(document, false, "Invoice discount", creditNote.Amount-subtotal)
+	}
+	total(document, true, "Total credited", creditNote.Amount)
+	return document.bytes(), nil
+}
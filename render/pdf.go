@@ -0,0 +1,202 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// A4 in PDF points
+const (
+	pageWidth    = 595.0
+	pageHeight   = 842.0
+	pageMargin   = 50.0
+	lineSpacing  = 1.5
+	regularFont  = "F1"
+	boldFont     = "F2"
+	defaultSize  = 10.0
+	headingSize  = 18.0
+	smallSize    = 8.0
+	rightColumnX = pageWidth - pageMargin
+)
+
+// pdfCell is a piece of text placed at X on the current line.
+// Right aligned cells end at X instead of starting there.
+type pdfCell struct {
+	X          float64
+	Text       string
+	AlignRight bool
+}
+
+// pdfDocument is a small text-only PDF writer, enough for invoices and statements
+type pdfDocument struct {
+	pages   []*bytes.Buffer
+	y       float64
+	title   string
+	archive *pdfArchive // Set for a PDF/A-3 document
+}
+
+func newPDFDocument(title string) *pdfDocument {
+	document := &pdfDocument{title: title}
+	document.addPage()
+	return document
+}
+
+func (d *pdfDocument) addPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+	d.y = pageHeight - pageMargin
+}
+
+func (d *pdfDocument) current() *bytes.Buffer {
+	return d.pages[len(d.pages)-1]
+}
+
+// row writes cells on one line and moves down, breaking the page when full
+func (d *pdfDocument) row(size float64, bold bool, cells ...pdfCell) {
+	if d.y-size*lineSpacing < pageMargin {
+		d.addPage()
+	}
+	d.y -= size * lineSpacing
+	font := regularFont
+	if bold {
+		font = boldFont
+	}
+	for _, cell := range cells {
+		text := toWinAnsi(cell.Text)
+		x := cell.X
+		if cell.AlignRight {
+			x -= d.textWidth(text, size, bold)
+		}
+		fmt.Fprintf(d.current(), "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, d.y, escapePDFString(text))
+	}
+}
+
+// text writes a single left aligned line
+func (d *pdfDocument) text(size float64, bold bool, text string) {
+	d.row(size, bold, pdfCell{X: pageMargin, Text: text})
+}
+
+// textWidth is the width of WinAnsi text in the document fonts
+func (d *pdfDocument) textWidth(text string, size float64, bold bool) float64 {
+	if d.archive == nil {
+		return textWidth(text, size, bold)
+	}
+	if bold {
+		return d.archive.bold.width(text, size)
+	}
+	return d.archive.regular.width(text, size)
+}
+
+// rule draws a horizontal line across the page
+func (d *pdfDocument) rule() {
+	d.y -= 4
+	fmt.Fprintf(d.current(), "0.5 w %.2f %.2f m %.2f %.2f l S\n", pageMargin, d.y, rightColumnX, d.y)
+	d.y -= 4
+}
+
+func (d *pdfDocument) space(points float64) {
+	d.y -= points
+}
+
+// bytes assembles the objects, cross reference table and trailer
+func (d *pdfDocument) bytes() []byte {
+	var objects []string
+	addObject := func(body string) int {
+		objects = append(objects, body)
+		return len(objects)
+	}
+
+	catalog := addObject("")
+	pagesObject := addObject("")
+	var regular, bold, info int
+	if d.archive == nil {
+		regular = addObject("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+		bold = addObject("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+		info = addObject(fmt.Sprintf("<< /Title (%s) /Producer (numerisTask) >>", escapePDFString(toWinAnsi(d.title))))
+	} else {
+		regular = d.archive.regular.objects(addObject)
+		bold = d.archive.bold.objects(addObject)
+		info = addObject(fmt.Sprintf("<< /Title (%s) /Producer (numerisTask) /CreationDate (%s) /ModDate (%s) >>",
+			escapePDFString(toWinAnsi(d.title)), pdfDate(d.archive.created), pdfDate(d.archive.created)))
+	}
+
+	var kids []string
+	for _, page := range d.pages {
+		content := page.Bytes()
+		stream := addObject(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content))
+		pageObject := addObject(fmt.Sprintf(
+			"<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /%s %d 0 R /%s %d 0 R >> >> /Contents %d 0 R >>",
+			pagesObject, pageWidth, pageHeight, regularFont, regular, boldFont, bold, stream))
+		kids = append(kids, fmt.Sprintf("%d 0 R", pageObject))
+	}
+	var catalogEntries string
+	if d.archive != nil {
+		catalogEntries = d.archive.objects(addObject, d.title)
+	}
+	objects[catalog-1] = fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R%s >>", pagesObject, catalogEntries)
+	objects[pagesObject-1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids))
+
+	var out bytes.Buffer
+	version := "1.4"
+	if d.archive != nil {
+		version = "1.7"
+	}
+	out.WriteString("%PDF-" + version + "\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(objects))
+	for i, body := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, body)
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	var id string
+	if d.archive != nil {
+		documentID := fileID(out.Bytes())
+		id = fmt.Sprintf(" /ID [<%s> <%s>]", documentID, documentID)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R%s >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, catalog, info, id, xref)
+	return out.Bytes()
+}
+
+// toWinAnsi keeps latin-1 characters and replaces everything else, Helvetica cannot draw it anyway
+func toWinAnsi(text string) string {
+	var builder strings.Builder
+	for _, r := range text {
+		if r < 256 {
+			builder.WriteByte(byte(r))
+		} else {
+			builder.WriteByte('?')
+		}
+	}
+	return builder.String()
+}
+
+func escapePDFString(text string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `(`, `\(`, `)`, `\)`, "\r", " ", "\n", " ")
+	return replacer.Replace(text)
+}
+
+// textWidth approximates the Helvetica advance width, good enough for right aligning numbers
+func textWidth(text string, size float64, bold bool) float64 {
+	var units float64
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c >= '0' && c <= '9':
+			units += 556
+		case c == '.' || c == ',' || c == ' ':
+			units += 278
+		case c >= 'A' && c <= 'Z':
+			units += 667
+		default:
+			units += 556
+		}
+	}
+	if bold {
+		units *= 1.05
+	}
+	return units * size / 1000
+}
@@ -0,0 +1,111 @@
+package render
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/md5"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// pdfArchive turns the document into a PDF/A-3b: fonts embedded, an sRGB output intent, XMP metadata
+// and files attached to the document itself (associated files), eg: the XML of an e-invoice.
+type pdfArchive struct {
+	regular     *pdfFont
+	bold        *pdfFont
+	created     time.Time
+	attachments []pdfAttachment
+	// Extra rdf:Description elements for the XMP metadata, for the schemas of the attachments
+	metadata string
+}
+
+type pdfAttachment struct {
+	name         string
+	mimeType     string
+	description  string
+	relationship string // AFRelationship, eg: Alternative, Data, Source
+	data         []byte
+}
+
+func newPDFArchive(created time.Time) (*pdfArchive, error) {
+	regular, bold, err := embeddedFonts()
+	if err != nil {
+		return nil, err
+	}
+	return &pdfArchive{regular: regular, bold: bold, created: created.UTC()}, nil
+}
+
+// pdfDate is the date format of the info dictionary, eg: D:20060102150405Z
+func pdfDate(date time.Time) string {
+	return date.UTC().Format("D:20060102150405Z")
+}
+
+// xmpText escapes text for the XMP metadata
+func xmpText(text string) string {
+	var escaped bytes.Buffer
+	xml.EscapeText(&escaped, []byte(text))
+	return escaped.String()
+}
+
+// xmp is the metadata packet, the title, producer and dates have to match the info dictionary
+func (a *pdfArchive) xmp(title string) string {
+	created := a.created.Format("2006-01-02T15:04:05Z")
+	return `<?xpacket begin="` + "\ufeff" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description rdf:about="" xmlns:pdfaid="http://www.aiim.org/pdfa/ns/id/">
+<pdfaid:part>3</pdfaid:part>
+<pdfaid:conformance>B</pdfaid:conformance>
+</rdf:Description>
+<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/">
+<dc:title><rdf:Alt><rdf:li xml:lang="x-default">` + xmpText(title) + `</rdf:li></rdf:Alt></dc:title>
+</rdf:Description>
+<rdf:Description rdf:about="" xmlns:pdf="http://ns.adobe.com/pdf/1.3/">
+<pdf:Producer>numerisTask</pdf:Producer>
+</rdf:Description>
+<rdf:Description rdf:about="" xmlns:xmp="http://ns.adobe.com/xap/1.0/">
+<xmp:CreateDate>` + created + `</xmp:CreateDate>
+<xmp:ModifyDate>` + created + `</xmp:ModifyDate>
+</rdf:Description>
+` + a.metadata + `</rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+}
+
+// objects adds the metadata, output intent and attachments, returning the entries they need in the catalog
+func (a *pdfArchive) objects(addObject func(body string) int, title string) string {
+	metadata := a.xmp(title)
+	metadataObject := addObject(fmt.Sprintf("<< /Type /Metadata /Subtype /XML /Length %d >>\nstream\n%s\nendstream", len(metadata), metadata))
+	profile := srgbProfile()
+	profileObject := addObject(fmt.Sprintf("<< /N 3 /Length %d >>\nstream\n%s\nendstream", len(profile), profile))
+	entries := fmt.Sprintf(" /Metadata %d 0 R /OutputIntents [<< /Type /OutputIntent /S /GTS_PDFA1 /OutputConditionIdentifier (sRGB IEC61966-2.1) /Info (sRGB IEC61966-2.1) /DestOutputProfile %d 0 R >>]",
+		metadataObject, profileObject)
+	if len(a.attachments) == 0 {
+		return entries
+	}
+
+	var names, files []string
+	for _, attachment := range a.attachments {
+		var compressed bytes.Buffer
+		writer := zlib.NewWriter(&compressed)
+		writer.Write(attachment.data)
+		writer.Close()
+		// The mime type is a name, its slash written as #2F
+		file := addObject(fmt.Sprintf("<< /Type /EmbeddedFile /Subtype /%s /Filter /FlateDecode /Params << /Size %d /ModDate (%s) >> /Length %d >>\nstream\n%s\nendstream",
+			strings.ReplaceAll(attachment.mimeType, "/", "#2F"), len(attachment.data), pdfDate(a.created), compressed.Len(), compressed.Bytes()))
+		name := escapePDFString(attachment.name)
+		spec := addObject(fmt.Sprintf("<< /Type /Filespec /F (%s) /UF (%s) /Desc (%s) /AFRelationship /%s /EF << /F %d 0 R /UF %d 0 R >> >>",
+			name, name, escapePDFString(toWinAnsi(attachment.description)), attachment.relationship, file, file))
+		names = append(names, fmt.Sprintf("(%s) %d 0 R", name, spec))
+		files = append(files, fmt.Sprintf("%d 0 R", spec))
+	}
+	return entries + fmt.Sprintf(" /Names << /EmbeddedFiles << /Names [%s] >> >> /AF [%s] /PageMode /UseAttachments",
+		strings.Join(names, " "), strings.Join(files, " "))
+}
+
+// fileID is the document identifier PDF/A wants in the trailer
+func fileID(content []byte) string {
+	return fmt.Sprintf("%x", md5.Sum(content))
+}
@@ -0,0 +1,180 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"numerisTask/models"
+)
+
+// column positions of the statement lines
+const (
+	statementInvoiceX = 115.0
+	statementDetailX  = 190.0
+	statementDebitX   = 395.0
+	statementCreditX  = 470.0
+)
+
+// statementLabels name the lines of the PDF statement, the invoice number being in a column of its own
+var statementLabels = map[models.JournalSource]string{
+	models.JOURNALINVOICE:             "Invoice",
+	models.JOURNALINVOICEADJUSTMENT:   "Invoice total changed",
+	models.JOURNALINVOICEVOID:         "Invoice voided",
+	models.JOURNALLATEFEE:             "Late fee",
+	models.JOURNALLATEFEEWAIVED:       "Late fee waived",
+	models.JOURNALCREDITNOTE:          "Credit note",
+	models.JOURNALEARLYPAYMENTDISC:    "Early payment discount",
+	models.JOURNALEARLYPAYMENTDISCREV: "Discount reversed",
+	models.JOURNALWRITEOFF:            "Written off",
+	models.JOURNALPAYMENT:             "Payment",
+	models.JOURNALPAYMENTREVERSAL:     "Payment reversed",
+}
+
+// statementView is what the HTML template gets
+type statementView struct {
+	*models.Statement
+	Sender models.User
+}
+
+var statementTemplate = template.Must(template.New("statement").Funcs(templateFunctions).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Statement of account from {{.Sender.Name}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; color: #222; max-width: 860px; margin: 40px auto; padding: 0 16px; }
+table { width: 100%; border-collapse: collapse; margin: 24px 0; }
+th, td { padding: 8px; border-bottom: 1px solid #ddd; text-align: left; }
+.amount { text-align: right; }
+.totals td { border: none; }
+.muted { color: #666; }
+</style>
+</head>
+<body>
+<h1>Statement of account</h1>
+<p><strong>{{.Sender.Name}}</strong><br>{{.Sender.Email}}</p>
+<p class="muted">{{.From.Format "2006-01-02"}} to {{.To.Format "2006-01-02"}}<br>Amounts in {{.Currency}}</p>
+<h3>Customer</h3>
+<p>{{.Customer.Name}}<br>{{.Customer.Email}}<br>{{.Customer.PhoneNumber}}</p>
+<table>
+<thead><tr><th>Date</th><th>Invoice</th><th>Details</th><th class="amount">Charges</th><th class="amount">Credits</th><th class="amount">Balance</th></tr></thead>
+<tbody>
+<tr><td>{{.From.Format "2006-01-02"}}</td><td></td><td>Opening balance</td><td></td><td></td><td class="amount">{{money .OpeningBalance}}</td></tr>
+{{range .Lines}}<tr><td>{{.Date.Format "2006-01-02"}}</td><td>{{.InvoiceNumber}}</td><td>{{.Description}}</td><td class="amount">{{if .Debit}}{{money .Debit}}{{end}}</td><td class="amount">{{if .Credit}}{{money .Credit}}{{end}}</td><td class="amount">{{money .Balance}}</td></tr>
+{{end}}<tr><td>{{.To.Format "2006-01-02"}}</td><td></td><td><strong>Closing balance</strong></td><td class="amount">{{money .TotalDebit}}</td><td class="amount">{{money .TotalCredit}}</td><td class="amount"><strong>{{money .ClosingBalance}}</strong></td></tr>
+</tbody>
+</table>
+<h3>Aging</h3>
+<table>
+<thead><tr><th class="amount">Current</th><th class="amount">1-30 days</th><th class="amount">31-60 days</th><th class="amount">61-90 days</th><th class="amount">Over 90 days</th></tr></thead>
+<tbody><tr><td class="amount">{{money .Aging.Current}}</td><td class="amount">{{money .Aging.Days1To30}}</td><td class="amount">{{money .Aging.Days31To60}}</td><td class="amount">{{money .Aging.Days61To90}}</td><td class="amount">{{money .Aging.Over90}}</td></tr></tbody>
+</table>
+{{if .OpenInvoices}}<h3>Open invoices</h3>
+<table class="totals">
+{{range .OpenInvoices}}<tr><td>{{.InvoiceNumber}}</td><td>Due {{.DueDate.Format "2006-01-02"}}{{if gt .DaysOverdue 0}} ({{.DaysOverdue}} days overdue){{end}}</td><td class="amount">{{money .Balance}}</td></tr>
+{{end}}</table>{{end}}
+{{if gt .ClosingBalance 0.0}}<h3>Payment details</h3>
+<p>Bank: {{.Sender.BankDetail.BankName}} ({{.Sender.BankDetail.BankCode}})<br>Account number: {{.Sender.BankDetail.AccountNumber}}</p>{{end}}
+</body>
+</html>
+`))
+
+// StatementHTML renders the statement of account as a page
+func StatementHTML(statement *models.Statement) ([]byte, error) {
+	var out bytes.Buffer
+	view := statementView{Statement: statement, Sender: models.PlaceHolderUser}
+	if err := statementTemplate.Execute(&out, view); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func statementRow(document *pdfDocument, bold bool, date string, invoice string, detail string, debit string, credit string, balance float64) {
+	document.row(defaultSize, bold,
+		pdfCell{X: pageMargin, Text: date},
+		pdfCell{X: statementInvoiceX, Text: invoice},
+		pdfCell{X: statementDetailX, Text: detail},
+		pdfCell{X: statementDebitX, Text: debit, AlignRight: true},
+		pdfCell{X: statementCreditX, Text: credit, AlignRight: true},
+		pdfCell{X: rightColumnX, Text: money(balance), AlignRight: true},
+	)
+}
+
+// StatementPDF renders the statement of account as a PDF document
+func StatementPDF(statement *models.Statement) ([]byte, error) {
+	document := newPDFDocument(fmt.Sprintf("Statement of account %s", statement.Customer.Name))
+	document.text(headingSize, true, "STATEMENT OF ACCOUNT")
+	document.space(6)
+	sender(document)
+	document.space(12)
+	document.text(defaultSize, false, fmt.Sprintf("Period: %s to %s", statement.From.Format("2006-01-02"), statement.To.Format("2006-01-02")))
+	document.text(defaultSize, false, fmt.Sprintf("Amounts in %s", statement.Currency))
+	customer(document, statement.Customer)
+
+	document.space(12)
+	document.row(defaultSize, true,
+		pdfCell{X: pageMargin, Text: "Date"},
+		pdfCell{X: statementInvoiceX, Text: "Invoice"},
+		pdfCell{X: statementDetailX, Text: "Details"},
+		pdfCell{X: statementDebitX, Text: "Charges", AlignRight: true},
+		pdfCell{X: statementCreditX, Text: "Credits", AlignRight: true},
+		pdfCell{X: rightColumnX, Text: "Balance", AlignRight: true},
+	)
+	document.rule()
+	statementRow(document, false, statement.From.Format("2006-01-02"), "", "Opening balance", "", "", statement.OpeningBalance)
+	for _, line := range statement.Lines {
+		var debit, credit string
+		if line.Debit != 0 {
+			debit = money(line.Debit)
+		}
+		if line.Credit != 0 {
+			credit = money(line.Credit)
+		}
+		detail := statementLabels[line.Type]
+		if line.Type == models.JOURNALCREDITNOTE && line.Reference != "" {
+			detail += " " + line.Reference
+		}
+		statementRow(document, false, line.Date.Format("2006-01-02"), line.InvoiceNumber, detail, debit, credit, line.Balance)
+	}
+	document.rule()
+	statementRow(document, true, statement.To.Format("2006-01-02"), "", "Closing balance",
+		money(statement.TotalDebit), money(statement.TotalCredit), statement.ClosingBalance)
+
+	document.space(12)
+	document.text(defaultSize, true, "Aging")
+	aging := statement.Aging
+	for _, bucket := range []struct {
+		label  string
+		amount float64
+	}{
+		{"Current", aging.Current},
+		{"1-30 days", aging.Days1To30},
+		{"31-60 days", aging.Days31To60},
+		{"61-90 days", aging.Days61To90},
+		{"Over 90 days", aging.Over90},
+	} {
+		total(document, false, bucket.label, bucket.amount)
+	}
+
+	if len(statement.OpenInvoices) > 0 {
+		document.space(12)
+		document.text(defaultSize, true, "Open invoices")
+		for _, invoice := range statement.OpenInvoices {
+			due := fmt.Sprintf("Due %s", invoice.DueDate.Format("2006-01-02"))
+			if invoice.DaysOverdue > 0 {
+				due = fmt.Sprintf("%s (%d days overdue)", due, invoice.DaysOverdue)
+			}
+			document.row(defaultSize, false,
+				pdfCell{X: itemNameX, Text: invoice.InvoiceNumber},
+				pdfCell{X: statementDetailX, Text: due},
+				pdfCell{X: rightColumnX, Text: money(invoice.Balance), AlignRight: true},
+			)
+		}
+	}
+	if statement.ClosingBalance > 0 {
+		bankDetails(document)
+	}
+	return document.bytes(), nil
+}
@@ -0,0 +1,156 @@
+package render
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const (
+	spreadsheetNamespace   = "http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+	relationshipsNamespace = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+	xmlHeader              = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n"
+)
+
+// xlsxWriter is a small streaming XLSX writer: sheets are written row by row straight into the zip,
+// the workbook parts listing them are added when it is closed. Strings are stored inline so nothing
+// has to be kept in memory.
+type xlsxWriter struct {
+	zip    *zip.Writer
+	sheets []string
+	sheet  io.Writer
+	row    int
+}
+
+func newXLSXWriter(w io.Writer) *xlsxWriter {
+	return &xlsxWriter{zip: zip.NewWriter(w)}
+}
+
+// addSheet starts a new sheet, ending the one before
+func (x *xlsxWriter) addSheet(name string) error {
+	if err := x.endSheet(); err != nil {
+		return err
+	}
+	x.sheets = append(x.sheets, name)
+	sheet, err := x.zip.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", len(x.sheets)))
+	if err != nil {
+		return err
+	}
+	x.sheet = sheet
+	x.row = 0
+	_, err = fmt.Fprintf(sheet, `%s<worksheet xmlns="%s"><sheetData>`, xmlHeader, spreadsheetNamespace)
+	return err
+}
+
+func (x *xlsxWriter) endSheet() error {
+	if x.sheet == nil {
+		return nil
+	}
+	_, err := io.WriteString(x.sheet, `</sheetData></worksheet>`)
+	x.sheet = nil
+	return err
+}
+
+// writeRow adds a row to the current sheet. Numbers are stored as numbers, anything else as text.
+func (x *xlsxWriter) writeRow(bold bool, values ...interface{}) error {
+	x.row++
+	var row bytes.Buffer
+	fmt.Fprintf(&row, `<row r="%d">`, x.row)
+	for i, value := range values {
+		reference := columnName(i) + strconv.Itoa(x.row)
+		style := ""
+		if bold {
+			style = ` s="1"`
+		}
+		switch value := value.(type) {
+		case int:
+			fmt.Fprintf(&row, `<c r="%s"%s><v>%d</v></c>`, reference, style, value)
+		case float64:
+			fmt.Fprintf(&row, `<c r="%s"%s><v>%s</v></c>`, reference, style, strconv.FormatFloat(value, 'f', -1, 64))
+		default:
+			text := fmt.Sprint(value)
+			if text == "" {
+				continue
+			}
+			fmt.Fprintf(&row, `<c r="%s" t="inlineStr"%s><is><t xml:space="preserve">`, reference, style)
+			xml.EscapeText(&row, []byte(text))
+			row.WriteString(`</t></is></c>`)
+		}
+	}
+	row.WriteString(`</row>`)
+	_, err := x.sheet.Write(row.Bytes())
+	return err
+}
+
+// columnName turns a zero based column index into its letters: A, B, ... Z, AA
+func columnName(index int) string {
+	name := ""
+	for index >= 0 {
+		name = string(rune('A'+index%26)) + name
+		index = index/26 - 1
+	}
+	return name
+}
+
+// Close ends the last sheet and writes the workbook parts
+func (x *xlsxWriter) Close() error {
+	if err := x.endSheet(); err != nil {
+		return err
+	}
+
+	var contentTypes, workbook, workbookRels bytes.Buffer
+	contentTypes.WriteString(xmlHeader + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+	fmt.Fprintf(&workbook, `%s<workbook xmlns="%s" xmlns:r="%s"><sheets>`, xmlHeader, spreadsheetNamespace, relationshipsNamespace)
+	workbookRels.WriteString(xmlHeader + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	for i, name := range x.sheets {
+		number := i + 1
+		fmt.Fprintf(&contentTypes, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, number)
+		workbook.WriteString(`<sheet name="`)
+		xml.EscapeText(&workbook, []byte(name))
+		fmt.Fprintf(&workbook, `" sheetId="%d" r:id="rId%d"/>`, number, number)
+		fmt.Fprintf(&workbookRels, `<Relationship Id="rId%d" Type="%s/worksheet" Target="worksheets/sheet%d.xml"/>`, number, relationshipsNamespace, number)
+	}
+	contentTypes.WriteString(`</Types>`)
+	workbook.WriteString(`</sheets></workbook>`)
+	fmt.Fprintf(&workbookRels, `<Relationship Id="rId%d" Type="%s/styles" Target="styles.xml"/></Relationships>`, len(x.sheets)+1, relationshipsNamespace)
+
+	// Style 1 is the bold header row
+	styles := xmlHeader + `<styleSheet xmlns="` + spreadsheetNamespace + `">` +
+		`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+		`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+		`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+		`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+		`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+		`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>` +
+		`</styleSheet>`
+	rootRels := xmlHeader + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="` + relationshipsNamespace + `/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+
+	parts := []struct {
+		name    string
+		content []byte
+	}{
+		{"[Content_Types].xml", contentTypes.Bytes()},
+		{"_rels/.rels", []byte(rootRels)},
+		{"xl/workbook.xml", workbook.Bytes()},
+		{"xl/_rels/workbook.xml.rels", workbookRels.Bytes()},
+		{"xl/styles.xml", []byte(styles)},
+	}
+	for _, part := range parts {
+		file, err := x.zip.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err = file.Write(part.content); err != nil {
+			return err
+		}
+	}
+	return x.zip.Close()
+}